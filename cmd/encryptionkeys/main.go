@@ -0,0 +1,24 @@
+// Command encryptionkeys creates the key file of the field encryption at ENCRYPTION_KEY_FILE, see encryption.KeyFile.
+// It is run once per deployment: the API refuses to start without the file, and never creates one itself
+package main
+
+import (
+	"log"
+
+	"github.com/Massad/gin-boilerplate/encryption"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	//The .env file is optional here, the environment may be set by hand
+	godotenv.Load(".env")
+
+	path := utils.GetEnvString("ENCRYPTION_KEY_FILE", "./encryption-keys/keys.json")
+	id, err := encryption.GenerateKeyFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("generated key file %s with master key %s, back it up: the data can't be read without it", path, id)
+}
@@ -0,0 +1,32 @@
+// Command fakeprovider runs the fake payment provider of local development, see payments.FakeServer.
+// It signs its callbacks with PAYMENT_PROVIDER_SECRET, like the API verifies them
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/Massad/gin-boilerplate/payments"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	//The .env file is optional here, the environment may be set by hand
+	godotenv.Load(".env")
+
+	secret := os.Getenv("PAYMENT_PROVIDER_SECRET")
+	if secret == "" {
+		log.Fatal("error: PAYMENT_PROVIDER_SECRET is not set")
+	}
+
+	addr := utils.GetEnvString("FAKE_PROVIDER_ADDR", ":9100")
+	server := &payments.FakeServer{
+		BaseURL: utils.GetEnvString("PAYMENT_PROVIDER_URL", "http://localhost:9100"),
+		Secret:  secret,
+	}
+
+	log.Printf("fake provider listening on %s", addr)
+	log.Fatal(http.ListenAndServe(addr, server))
+}
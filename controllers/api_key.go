@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKeyController ...
+type APIKeyController struct{}
+
+var apiKeyModel = new(models.APIKeyModel)
+var apiKeyForm = new(forms.APIKeyForm)
+
+// @Summary Create API key api
+// @Schemes
+// @Description Create a personal API key, the key is only shown in this response
+// @Tags API keys
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/user/api-keys [post]
+// @Param name body string true "Name of the key"
+// @Param scopes body []string true "Scopes, e.g. transactions:read, transfers:write"
+func (ctrl APIKeyController) Create(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.CreateAPIKeyForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := apiKeyForm.Create(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	apiKey, plainKey, err := apiKeyModel.Create(ctx, userID, form)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&apiKey)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+	result["key"] = plainKey
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "API key created successfully, store it now as it won't be shown again", Data: result})
+}
+
+// @Summary API keys api
+// @Schemes
+// @Description List my active API keys
+// @Tags API keys
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/user/api-keys [get]
+func (ctrl APIKeyController) All(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	apiKeys, err := apiKeyModel.List(ctx, userID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	data := make([]interface{}, len(apiKeys))
+	for i, v := range apiKeys {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve API keys successfully", Data: data})
+}
+
+// @Summary Revoke API key api
+// @Schemes
+// @Description Revoke one of my API keys
+// @Tags API keys
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/user/api-keys/{id} [delete]
+// @Param id path string true "API key ID"
+func (ctrl APIKeyController) Delete(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	apiKeyID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: models.ErrAPIKeyNotFound.Error()})
+		return
+	}
+
+	err = apiKeyModel.Revoke(ctx, userID, apiKeyID)
+	if err == models.ErrAPIKeyNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, utils.Response{Status: http.StatusInternalServerError, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "API key revoked successfully"})
+}
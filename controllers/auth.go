@@ -1,10 +1,15 @@
 package controllers
 
 import (
+	"context"
 	"net/http"
+	"time"
 
+	"github.com/Massad/gin-boilerplate/keys"
 	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 //AuthController ...
@@ -12,24 +17,76 @@ type AuthController struct{}
 
 var authModel = new(models.AuthModel)
 
-//TokenValid ...
-func (ctl AuthController) TokenValid(c *gin.Context) {
+//TokenValid authenticates a user login, a personal API key or a service client token.
+//Machine credentials (API keys, client tokens) are only accepted when the route lists scopes,
+//and they must hold every one of them; routes without scopes are for logged-in users only
+func (ctl AuthController) TokenValid(c *gin.Context, scopes ...string) {
+	var userID primitive.ObjectID
+	var granted []string
+	machine := false
 
-	tokenAuth, err := authModel.ExtractTokenMetadata(c.Request)
+	if plainKey := authModel.ExtractAPIKey(c.Request); plainKey != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
 
-	if err != nil {
-		//Token either expired or not valid
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Please login first"})
-		return
+		apiKey, err := apiKeyModel.Authenticate(ctx, plainKey)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Invalid API key"})
+			return
+		}
+
+		userID, granted, machine = apiKey.UserID, apiKey.Scopes, true
+		c.Set("apiKeyID", apiKey.ID)
+	} else {
+		tokenAuth, err := authModel.ExtractTokenMetadata(c.Request)
+
+		if err != nil {
+			//Token either expired or not valid
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Please login first"})
+			return
+		}
+
+		userID, err = authModel.FetchAuth(tokenAuth)
+		if err != nil {
+			//Session does not exist anymore (User logged out or revoked) or the client was revoked
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Please login first"})
+			return
+		}
+
+		if tokenAuth.ClientID != "" {
+			granted, machine = tokenAuth.Scopes, true
+			c.Set("clientID", tokenAuth.ClientID)
+		} else {
+			c.Set("accessUUID", tokenAuth.AccessUUID)
+		}
 	}
 
-	userID, err := authModel.FetchAuth(tokenAuth)
-	if err != nil {
-		//Token does not exists in Redis (User logged out or expired)
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Please login first"})
-		return
+	if machine {
+		if len(scopes) == 0 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "This endpoint requires a user login"})
+			return
+		}
+		for _, scope := range scopes {
+			if !utils.HasScope(granted, scope) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Missing scope " + scope})
+				return
+			}
+		}
 	}
 
 	//To be called from GetUserID()
 	c.Set("userID", userID)
 }
+
+//JWKS ...
+// @Summary JWKS api
+// @Schemes
+// @Description Public keys to verify access tokens with, selected by the "kid" header
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} keys.JWKSet "Success"
+// @Router /.well-known/jwks.json [get]
+func (ctl AuthController) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, keys.GetKeyStore().JWKS())
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// BalanceController ...
+type BalanceController struct{}
+
+var balanceModel = new(models.BalanceModel)
+var balanceForm = new(forms.BalanceForm)
+
+// @Summary Point-in-time balance api
+// @Schemes
+// @Description Get my main balance at a time in the past, after the transactions created until then
+// @Tags Balance
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/user/balance [get]
+// @Param at query string false "Unix timestamp or RFC 3339 time, now by default"
+func (ctrl BalanceController) At(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.BalanceAtForm
+	if validationErr := c.ShouldBindQuery(&form); validationErr != nil {
+		message := balanceForm.At(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	at := time.Now().Unix()
+	if form.At != "" {
+		if seconds, err := strconv.ParseInt(form.At, 10, 64); err == nil {
+			at = seconds
+		} else if t, err := time.Parse(time.RFC3339, form.At); err == nil {
+			at = t.Unix()
+		} else {
+			c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: "The time must be a unix timestamp or an RFC 3339 time"})
+			return
+		}
+	}
+
+	balance, err := balanceModel.At(ctx, userID, at)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&balance)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Retrieve balance successfully", Data: result})
+}
+
+// @Summary Balance history api
+// @Schemes
+// @Description Get my main balance at the end of every day (UTC), for charts. Today has the balance so far
+// @Tags Balance
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/user/balance/history [get]
+// @Param from query string false "First day, YYYY-MM-DD, 29 days before the last one by default"
+// @Param to query string false "Last day, YYYY-MM-DD, today by default"
+func (ctrl BalanceController) History(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.BalanceHistoryForm
+	if validationErr := c.ShouldBindQuery(&form); validationErr != nil {
+		message := balanceForm.History(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	points, err := balanceModel.History(ctx, userID, form.From, form.To)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	data := make([]interface{}, len(points))
+	for i, v := range points {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve balance history successfully", Data: data})
+}
@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BatchTransferController ...
+type BatchTransferController struct{}
+
+var batchTransferModel = new(models.BatchTransferModel)
+var batchTransferForm = new(forms.BatchTransferForm)
+
+// @Summary Batch transfer api
+// @Schemes
+// @Description Pay many recipients at once. The body is JSON {mode, items: [{to, amount, reference}]} or a CSV (Content-Type text/csv) with a "to,amount,reference" header and the mode as query parameter. Every item is validated before anything runs. BEST_EFFORT batches run in the background, follow them with GET /v1/transfers/batch/{id}. ALL_OR_NOTHING batches run in one transaction before the response. Retrying with the same Idempotency-Key header returns the first batch
+// @Tags Transfers
+// @Accept json
+// @Accept text/csv
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/transfers/batch [post]
+// @Param Idempotency-Key header string false "Unique key of the batch"
+// @Param mode query string false "BEST_EFFORT (default) or ALL_OR_NOTHING, for CSV payloads"
+// @Param mode body string false "BEST_EFFORT (default) or ALL_OR_NOTHING"
+// @Param items body []forms.BatchTransferItemForm true "Recipients"
+func (ctrl BatchTransferController) Create(c *gin.Context) {
+	userID := getUserID(c)
+
+	//An ALL_OR_NOTHING batch of 1000 items runs within the request
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	var form forms.CreateBatchTransferForm
+	if strings.HasPrefix(c.ContentType(), "text/csv") {
+		items, err := forms.ParseBatchTransferCSV(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+			return
+		}
+
+		form = forms.CreateBatchTransferForm{Mode: c.Query("mode"), Items: items}
+		if validationErr := binding.Validator.ValidateStruct(&form); validationErr != nil {
+			message := batchTransferForm.Create(validationErr)
+			c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+			return
+		}
+	} else if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := batchTransferForm.Create(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	batch, err := batchTransferModel.Create(ctx, userID, c.GetHeader("Idempotency-Key"), form)
+	if validationErr, ok := err.(*models.BatchValidationError); ok {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, utils.Response{Status: http.StatusUnprocessableEntity, Message: err.Error(), Data: map[string]interface{}{"errors": validationErr.Items}})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&batch)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Batch created successfully", Data: result})
+}
+
+// @Summary Batch transfers api
+// @Schemes
+// @Description List my batches with their progress, newest first
+// @Tags Transfers
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/transfers/batch [get]
+// @Param page query int false "Page, starting at 1"
+// @Param limit query int false "Batches per page"
+func (ctrl BatchTransferController) All(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	page, _ := utils.QueryParamInt(c, "page", 1)
+	limit, _ := utils.QueryParamInt(c, "limit", 20)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	batches, err := batchTransferModel.List(ctx, userID, models.Query{Page: page, Limit: limit})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	data := make([]interface{}, len(batches))
+	for i, v := range batches {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve batches successfully", Data: data})
+}
+
+// findBatch loads the batch of the path, it writes the error response when it fails
+func findBatch(c *gin.Context, withItems bool) (batch models.BatchTransfer, ok bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: models.ErrBatchNotFound.Error()})
+		return batch, false
+	}
+
+	batch, err = batchTransferModel.Find(ctx, getUserID(c), id, withItems)
+	if err == models.ErrBatchNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+		return batch, false
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, utils.Response{Status: http.StatusInternalServerError, Message: "Something went wrong, please try again later"})
+		return batch, false
+	}
+
+	return batch, true
+}
+
+// @Summary Batch transfer api
+// @Schemes
+// @Description Progress of one of my batches, add items=true to get the status of every item
+// @Tags Transfers
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/transfers/batch/{id} [get]
+// @Param id path string true "Batch ID"
+// @Param items query bool false "Include the items"
+func (ctrl BatchTransferController) One(c *gin.Context) {
+	batch, ok := findBatch(c, c.Query("items") == "true")
+	if !ok {
+		return
+	}
+
+	temp, _ := json.Marshal(&batch)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	processed := batch.Succeeded + batch.Failed
+	result["processed"] = processed
+	result["progress"] = 0
+	if batch.Count > 0 {
+		result["progress"] = processed * 100 / batch.Count
+	}
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Retrieve batch successfully", Data: result})
+}
+
+// @Summary Batch transfer report api
+// @Schemes
+// @Description Download the result of every item of one of my batches as CSV
+// @Tags Transfers
+// @Produce text/csv
+// @Success 200 {string} string "CSV report"
+// @Router /v1/transfers/batch/{id}/report [get]
+// @Param id path string true "Batch ID"
+func (ctrl BatchTransferController) Report(c *gin.Context) {
+	batch, ok := findBatch(c, true)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", "attachment; filename=batch-"+batch.ID.Hex()+".csv")
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"index", "to", "amount", "reference", "status", "error", "transaction_id", "processed_at"})
+	for _, item := range batch.Items {
+		transactionID := ""
+		if !item.TransactionID.IsZero() {
+			transactionID = item.TransactionID.Hex()
+		}
+		processedAt := ""
+		if item.ProcessedAt > 0 {
+			processedAt = time.Unix(item.ProcessedAt, 0).UTC().Format(time.RFC3339)
+		}
+
+		writer.Write([]string{
+			strconv.Itoa(item.Index),
+			item.To,
+			strconv.FormatInt(item.Amount, 10),
+			item.Reference,
+			item.Status,
+			item.Error,
+			transactionID,
+			processedAt,
+		})
+	}
+	writer.Flush()
+}
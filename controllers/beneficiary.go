@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BeneficiaryController ...
+type BeneficiaryController struct{}
+
+var beneficiaryModel = new(models.BeneficiaryModel)
+var beneficiaryForm = new(forms.BeneficiaryForm)
+
+// @Summary Payee lookup api
+// @Schemes
+// @Description Get the masked name of a user, to confirm the recipient before sending money
+// @Tags Beneficiaries
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/users/lookup [get]
+// @Param username query string true "Username"
+func (ctrl BeneficiaryController) Lookup(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.LookupForm
+	if validationErr := c.ShouldBindQuery(&form); validationErr != nil {
+		message := beneficiaryForm.Lookup(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	payee, err := beneficiaryModel.Lookup(ctx, userID, form.Username)
+	if err == models.ErrPayeeNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&payee)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Retrieve user successfully", Data: result})
+}
+
+// @Summary Create beneficiary api
+// @Schemes
+// @Description Save a beneficiary. When my transfers are restricted to beneficiaries, a new one can receive money after the cooling-off period
+// @Tags Beneficiaries
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/beneficiaries [post]
+// @Param username body string true "Username"
+// @Param nickname body string false "Nickname"
+func (ctrl BeneficiaryController) Create(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.CreateBeneficiaryForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := beneficiaryForm.Create(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	beneficiary, err := beneficiaryModel.Create(ctx, userID, form)
+	if err == models.ErrPayeeNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&beneficiary)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Beneficiary saved successfully", Data: result})
+}
+
+// @Summary Beneficiaries api
+// @Schemes
+// @Description List my beneficiaries, the most recently used first
+// @Tags Beneficiaries
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/beneficiaries [get]
+func (ctrl BeneficiaryController) All(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	beneficiaries, err := beneficiaryModel.List(ctx, userID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	data := make([]interface{}, len(beneficiaries))
+	for i, v := range beneficiaries {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve beneficiaries successfully", Data: data})
+}
+
+// @Summary Delete beneficiary api
+// @Schemes
+// @Description Delete one of my beneficiaries
+// @Tags Beneficiaries
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/beneficiaries/{id} [delete]
+// @Param id path string true "Beneficiary ID"
+func (ctrl BeneficiaryController) Delete(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err == nil {
+		err = beneficiaryModel.Delete(ctx, userID, id)
+	} else {
+		err = models.ErrBeneficiaryNotFound
+	}
+	if err == models.ErrBeneficiaryNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Beneficiary deleted successfully"})
+}
+
+// @Summary Beneficiary settings api
+// @Schemes
+// @Description Get whether my transfers are restricted to my beneficiaries
+// @Tags Beneficiaries
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/beneficiaries/settings [get]
+func (ctrl BeneficiaryController) Settings(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	settings, err := beneficiaryModel.Settings(ctx, userID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&settings)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Retrieve settings successfully", Data: result})
+}
+
+// @Summary Update beneficiary settings api
+// @Schemes
+// @Description Restrict my transfers to my beneficiaries. The restriction starts at once, lifting it takes the cooling-off period
+// @Tags Beneficiaries
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/beneficiaries/settings [put]
+// @Param beneficiaries_only body bool true "Restrict transfers to beneficiaries"
+func (ctrl BeneficiaryController) UpdateSettings(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.BeneficiarySettingsForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := beneficiaryForm.Settings(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	settings, err := beneficiaryModel.UpdateSettings(ctx, userID, *form.BeneficiariesOnly)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&settings)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Settings updated successfully", Data: result})
+}
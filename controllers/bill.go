@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BillController ...
+type BillController struct{}
+
+var billModel = new(models.BillModel)
+var billForm = new(forms.BillForm)
+
+// @Summary Split bill api
+// @Schemes
+// @Description Split a bill among participants: EQUAL shares, PERCENTAGE (percentage per participant) or EXACT (amount per participant). Every participant receives a payment request for their share, list yourself to take a share too
+// @Tags Bills
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/bills [post]
+// @Param title body string true "Title"
+// @Param total body int true "Total amount"
+// @Param split body string true "EQUAL, PERCENTAGE or EXACT"
+// @Param participants body []forms.BillParticipantForm true "Participants"
+// @Param expires_in_hours body int false "Hours before the payment requests expire"
+func (ctrl BillController) Create(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.CreateBillForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := billForm.Create(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	bill, err := billModel.Create(ctx, userID, form)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&bill)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Bill created successfully", Data: result})
+}
+
+// @Summary Bills api
+// @Schemes
+// @Description List the bills I created or take part in, newest first
+// @Tags Bills
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/bills [get]
+// @Param page query int false "Page, starting at 1"
+// @Param limit query int false "Bills per page"
+func (ctrl BillController) All(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	page, _ := utils.QueryParamInt(c, "page", 1)
+	limit, _ := utils.QueryParamInt(c, "limit", 20)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	bills, err := billModel.List(ctx, userID, models.Query{Page: page, Limit: limit})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	data := make([]interface{}, len(bills))
+	for i, v := range bills {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve bills successfully", Data: data})
+}
+
+// billAction runs fn on the bill of the path and writes the bill or the error
+func billAction(c *gin.Context, fn func(ctx context.Context, userID primitive.ObjectID, id primitive.ObjectID) (models.Bill, error), message string) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: models.ErrBillNotFound.Error()})
+		return
+	}
+
+	bill, err := fn(ctx, userID, id)
+	if err == models.ErrBillNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&bill)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: message, Data: result})
+}
+
+// @Summary Bill api
+// @Schemes
+// @Description One of my bills with the status of every share
+// @Tags Bills
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/bills/{id} [get]
+// @Param id path string true "Bill ID"
+func (ctrl BillController) One(c *gin.Context) {
+	billAction(c, billModel.Find, "Retrieve bill successfully")
+}
+
+// @Summary Cancel bill api
+// @Schemes
+// @Description Cancel a bill I created, the pending payment requests are cancelled and the paid shares are kept
+// @Tags Bills
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/bills/{id}/cancel [post]
+// @Param id path string true "Bill ID"
+func (ctrl BillController) Cancel(c *gin.Context) {
+	billAction(c, billModel.Cancel, "Bill cancelled successfully")
+}
@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GDPRController ...
+type GDPRController struct{}
+
+var gdprModel = new(models.GDPRModel)
+
+// gdprError ...
+func gdprError(c *gin.Context, err error) {
+	switch err {
+	case models.ErrExportNotFound:
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+	case models.ErrExportNotReady:
+		c.AbortWithStatusJSON(http.StatusConflict, utils.Response{Status: http.StatusConflict, Message: err.Error()})
+	default:
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+	}
+}
+
+// @Summary Create data export api
+// @Schemes
+// @Description Ask for a ZIP file of my personal data: profile, sessions, events and transactions in JSON. It is built in the background, see the list of exports
+// @Tags User
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/user/exports [post]
+func (ctrl GDPRController) CreateExport(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	export, err := gdprModel.CreateExport(ctx, userID)
+	if err != nil {
+		gdprError(c, err)
+		return
+	}
+
+	temp, _ := json.Marshal(&export)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Export requested successfully", Data: result})
+}
+
+// @Summary Data exports api
+// @Schemes
+// @Description List my last data exports, newest first
+// @Tags User
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/user/exports [get]
+func (ctrl GDPRController) Exports(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	exports, err := gdprModel.Exports(ctx, userID)
+	if err != nil {
+		gdprError(c, err)
+		return
+	}
+
+	data := make([]interface{}, len(exports))
+	for i, v := range exports {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve exports successfully", Data: data})
+}
+
+// @Summary Download data export api
+// @Schemes
+// @Description Download the ZIP file of one of my data exports once it is READY, until it expires
+// @Tags User
+// @Produce application/zip
+// @Success 200 {file} file "Export"
+// @Router /v1/user/exports/{id}/download [get]
+// @Param id path string true "Export ID"
+func (ctrl GDPRController) Download(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		gdprError(c, models.ErrExportNotFound)
+		return
+	}
+
+	export, file, err := gdprModel.OpenExport(ctx, userID, id)
+	if err != nil {
+		gdprError(c, err)
+		return
+	}
+	defer file.Close()
+
+	c.DataFromReader(http.StatusOK, export.Size, "application/zip", file, map[string]string{
+		"Content-Disposition":    "attachment; filename=\"export-" + export.ID.Hex() + ".zip\"",
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          "no-store",
+	})
+}
+
+// @Summary Erase account api
+// @Schemes
+// @Description Erase my personal data and close my account, confirmed with my password. The balance, pockets and merchants must be empty and nothing in flight. Transactions are kept for financial record retention under a pseudonym
+// @Tags User
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/user/erasure [post]
+// @Param password body string true "Password"
+func (ctrl GDPRController) Erase(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.EraseAccountForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := userForm.EraseAccount(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	erasure, err := gdprModel.RequestErasure(ctx, userID, form.Password)
+	if err != nil {
+		gdprError(c, err)
+		return
+	}
+
+	temp, _ := json.Marshal(&erasure)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Account erased successfully", Data: result})
+}
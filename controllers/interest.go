@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// InterestController ...
+type InterestController struct{}
+
+var interestModel = new(models.InterestModel)
+
+// @Summary Interest api
+// @Schemes
+// @Description Get the annual interest rates by balance tier, in basis points, and the interest my balance and pockets accrued since the last monthly posting
+// @Tags Interest
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/interest [get]
+func (ctrl InterestController) Summary(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	summary, err := interestModel.Summary(ctx, userID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&summary)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Retrieve interest successfully", Data: result})
+}
@@ -0,0 +1,381 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// KYCController manages the identity verification of users, and the queue their documents are reviewed in
+type KYCController struct{}
+
+var kycModel = new(models.KYCModel)
+var kycForm = new(forms.KYCForm)
+
+func kycError(c *gin.Context, err error) {
+	switch err {
+	case models.ErrNotReviewer:
+		c.AbortWithStatusJSON(http.StatusForbidden, utils.Response{Status: http.StatusForbidden, Message: err.Error()})
+	case models.ErrKYCDocumentNotFound, models.ErrKYCReviewNotFound:
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+	case models.ErrDocumentType:
+		c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, utils.Response{Status: http.StatusUnsupportedMediaType, Message: err.Error()})
+	default:
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+	}
+}
+
+// @Summary KYC status api
+// @Schemes
+// @Description Get my verification level with its limits, the limits of every level, my last review and my documents
+// @Tags KYC
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/kyc [get]
+func (ctrl KYCController) Status(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	status, err := kycModel.Status(ctx, userID)
+	if err != nil {
+		kycError(c, err)
+		return
+	}
+
+	temp, _ := json.Marshal(&status)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Retrieve verification successfully", Data: result})
+}
+
+// @Summary Upload KYC document api
+// @Schemes
+// @Description Upload an identity document as multipart/form-data. The file must be a JPEG, PNG or PDF, its type is read from its content, and at most KYC_MAX_DOCUMENT_SIZE bytes
+// @Tags KYC
+// @Accept mpfd
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/kyc/documents [post]
+// @Param type formData string true "id_front, id_back, selfie or proof_of_address"
+// @Param file formData file true "Document"
+func (ctrl KYCController) Upload(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	maxSize := int64(utils.GetEnvInt("KYC_MAX_DOCUMENT_SIZE", 5<<20))
+	//Room for the other parts of the multipart body
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+64<<10)
+
+	var form forms.UploadDocumentForm
+	if validationErr := c.ShouldBind(&form); validationErr != nil {
+		message := kycForm.Upload(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: "Please attach the document in the file field, at most the maximum size"})
+		return
+	}
+	if header.Size > maxSize {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, utils.Response{Status: http.StatusRequestEntityTooLarge, Message: "The document is too large"})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: "Invalid request"})
+		return
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: "Invalid request"})
+		return
+	}
+	if len(data) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: "The document is empty"})
+		return
+	}
+
+	document, err := kycModel.Upload(ctx, userID, form.Type, data)
+	if err != nil {
+		kycError(c, err)
+		return
+	}
+
+	temp, _ := json.Marshal(&document)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Document uploaded successfully", Data: result})
+}
+
+// @Summary KYC documents api
+// @Schemes
+// @Description List my identity documents, newest first
+// @Tags KYC
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/kyc/documents [get]
+func (ctrl KYCController) Documents(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	documents, err := kycModel.Documents(ctx, userID)
+	if err != nil {
+		kycError(c, err)
+		return
+	}
+
+	data := make([]interface{}, len(documents))
+	for i, v := range documents {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve documents successfully", Data: data})
+}
+
+// @Summary KYC document file api
+// @Schemes
+// @Description Download one of my identity documents. Reviewers can download the documents of every user
+// @Tags KYC
+// @Produce octet-stream
+// @Success 200 {file} file "Document"
+// @Router /v1/kyc/documents/{id}/file [get]
+// @Param id path string true "Document ID"
+func (ctrl KYCController) File(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		kycError(c, models.ErrKYCDocumentNotFound)
+		return
+	}
+
+	document, file, err := kycModel.Open(ctx, userID, id)
+	if err != nil {
+		kycError(c, err)
+		return
+	}
+	defer file.Close()
+
+	c.DataFromReader(http.StatusOK, document.Size, document.ContentType, file, map[string]string{
+		"Content-Disposition":    "attachment; filename=\"" + document.Type + "-" + document.ID.Hex() + "\"",
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          "no-store",
+	})
+}
+
+// @Summary Submit KYC api
+// @Schemes
+// @Description Send my documents for review to reach a level. Level 1 needs id_front and selfie, level 2 needs proof_of_address as well
+// @Tags KYC
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/kyc/submissions [post]
+// @Param level body int true "Level, 1 or 2"
+func (ctrl KYCController) Submit(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.SubmitKYCForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := kycForm.Submit(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	review, err := kycModel.Submit(ctx, userID, form.Level)
+	if err != nil {
+		kycError(c, err)
+		return
+	}
+
+	temp, _ := json.Marshal(&review)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Documents sent for review successfully", Data: result})
+}
+
+// @Summary KYC review queue api
+// @Schemes
+// @Description List the reviews with a status, the oldest first. Reviewers only
+// @Tags KYC
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/kyc/reviews [get]
+// @Param status query string false "PENDING by default, APPROVED or REJECTED"
+// @Param page query int false "Page, starting at 1"
+// @Param limit query int false "Reviews per page"
+func (ctrl KYCController) Reviews(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	status := c.DefaultQuery("status", utils.KYC_PENDING)
+	if status != utils.KYC_PENDING && status != utils.KYC_APPROVED && status != utils.KYC_REJECTED {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: "The status must be PENDING, APPROVED or REJECTED"})
+		return
+	}
+
+	page, _ := utils.QueryParamInt(c, "page", 1)
+	limit, _ := utils.QueryParamInt(c, "limit", 20)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	reviews, err := kycModel.Reviews(ctx, userID, status, models.Query{Page: page, Limit: limit})
+	if err != nil {
+		kycError(c, err)
+		return
+	}
+
+	data := make([]interface{}, len(reviews))
+	for i, v := range reviews {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve reviews successfully", Data: data})
+}
+
+// @Summary KYC review api
+// @Schemes
+// @Description Get a review with its documents. Reviewers only
+// @Tags KYC
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/kyc/reviews/{id} [get]
+// @Param id path string true "Review ID"
+func (ctrl KYCController) Review(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		kycError(c, models.ErrKYCReviewNotFound)
+		return
+	}
+
+	review, err := kycModel.Review(ctx, userID, id)
+	if err != nil {
+		kycError(c, err)
+		return
+	}
+
+	temp, _ := json.Marshal(&review)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Retrieve review successfully", Data: result})
+}
+
+// @Summary Approve KYC review api
+// @Schemes
+// @Description Approve a pending review, its user reaches its level. Reviewers only, not on their own review
+// @Tags KYC
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/kyc/reviews/{id}/approve [post]
+// @Param id path string true "Review ID"
+func (ctrl KYCController) Approve(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		kycError(c, models.ErrKYCReviewNotFound)
+		return
+	}
+
+	review, err := kycModel.Approve(ctx, userID, id)
+	if err != nil {
+		kycError(c, err)
+		return
+	}
+
+	temp, _ := json.Marshal(&review)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Review approved successfully", Data: result})
+}
+
+// @Summary Reject KYC review api
+// @Schemes
+// @Description Reject a pending review, its user sees the reason. Reviewers only, not on their own review
+// @Tags KYC
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/kyc/reviews/{id}/reject [post]
+// @Param id path string true "Review ID"
+// @Param reason body string true "Reason shown to the user"
+func (ctrl KYCController) Reject(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.RejectKYCForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := kycForm.Reject(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		kycError(c, models.ErrKYCReviewNotFound)
+		return
+	}
+
+	review, err := kycModel.Reject(ctx, userID, id, form.Reason)
+	if err != nil {
+		kycError(c, err)
+		return
+	}
+
+	temp, _ := json.Marshal(&review)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Review rejected successfully", Data: result})
+}
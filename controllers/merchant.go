@@ -0,0 +1,424 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MerchantController ...
+type MerchantController struct{}
+
+var merchantModel = new(models.MerchantModel)
+var invoiceModel = new(models.InvoiceModel)
+var merchantForm = new(forms.MerchantForm)
+
+// paymentLink is the URL customers open to pay an invoice
+func paymentLink(invoice models.Invoice) string {
+	return utils.GetEnvString("PAYMENT_LINK_BASE_URL", "/v1/payment-links/") + invoice.Code
+}
+
+// invoiceResult converts an invoice for a response, with its payment link
+func invoiceResult(invoice models.Invoice) map[string]interface{} {
+	temp, _ := json.Marshal(&invoice)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+	result["payment_link"] = paymentLink(invoice)
+
+	return result
+}
+
+// merchantParams reads the merchant id, and the invoice id when the route has one, from the path.
+// It writes the not found response when an id is malformed
+func merchantParams(c *gin.Context) (merchantID primitive.ObjectID, invoiceID primitive.ObjectID, ok bool) {
+	merchantID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: models.ErrMerchantNotFound.Error()})
+		return merchantID, invoiceID, false
+	}
+
+	if c.Param("invoice_id") != "" {
+		invoiceID, err = primitive.ObjectIDFromHex(c.Param("invoice_id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: models.ErrInvoiceNotFound.Error()})
+			return merchantID, invoiceID, false
+		}
+	}
+
+	return merchantID, invoiceID, true
+}
+
+// merchantError writes the response of a merchant or invoice model error
+func merchantError(c *gin.Context, err error) {
+	if err == models.ErrMerchantNotFound || err == models.ErrInvoiceNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+}
+
+// @Summary Create merchant api
+// @Schemes
+// @Description Open a merchant account. It has its own balance, credited by the invoices it is paid
+// @Tags Merchants
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/merchants [post]
+// @Param name body string true "Name of the business"
+func (ctrl MerchantController) Create(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.CreateMerchantForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := merchantForm.Create(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	merchant, err := merchantModel.Create(ctx, userID, form)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&merchant)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Merchant created successfully", Data: result})
+}
+
+// @Summary Merchants api
+// @Schemes
+// @Description List my merchant accounts
+// @Tags Merchants
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/merchants [get]
+func (ctrl MerchantController) All(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	merchants, err := merchantModel.List(ctx, userID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	data := make([]interface{}, len(merchants))
+	for i, v := range merchants {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve merchants successfully", Data: data})
+}
+
+// @Summary Merchant payout api
+// @Schemes
+// @Description Move the whole balance of one of my merchants to my wallet
+// @Tags Merchants
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/merchants/{id}/payout [post]
+// @Param id path string true "Merchant ID"
+func (ctrl MerchantController) Payout(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	merchantID, _, ok := merchantParams(c)
+	if !ok {
+		return
+	}
+
+	transaction, err := merchantModel.Payout(ctx, userID, merchantID)
+	if err != nil {
+		merchantError(c, err)
+		return
+	}
+
+	temp, _ := json.Marshal(&transaction)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Payout made successfully", Data: result})
+}
+
+// @Summary Merchant dashboard api
+// @Schemes
+// @Description Sales of one of my merchants per day (UTC): paid invoices, gross, refunds and net. The range defaults to the last 30 days
+// @Tags Merchants
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/merchants/{id}/dashboard [get]
+// @Param id path string true "Merchant ID"
+// @Param from query string false "First day, YYYY-MM-DD"
+// @Param to query string false "Last day, YYYY-MM-DD"
+func (ctrl MerchantController) Dashboard(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	merchantID, _, ok := merchantParams(c)
+	if !ok {
+		return
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from, to := today.AddDate(0, 0, -29), today
+	var err error
+	if c.Query("from") != "" {
+		if from, err = time.Parse("2006-01-02", c.Query("from")); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: "The from date must be YYYY-MM-DD"})
+			return
+		}
+	}
+	if c.Query("to") != "" {
+		if to, err = time.Parse("2006-01-02", c.Query("to")); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: "The to date must be YYYY-MM-DD"})
+			return
+		}
+	}
+	if to.Before(from) || to.Sub(from) > 366*24*time.Hour {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: "The range must be at most one year, from before to"})
+		return
+	}
+
+	days, err := merchantModel.Dashboard(ctx, userID, merchantID, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		merchantError(c, err)
+		return
+	}
+
+	var paid, gross, refunded int64
+	for _, day := range days {
+		paid += day.Paid
+		gross += day.Gross
+		refunded += day.Refunded
+	}
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Retrieve dashboard successfully", Data: map[string]interface{}{
+		"from":     from.Format("2006-01-02"),
+		"to":       to.Format("2006-01-02"),
+		"paid":     paid,
+		"gross":    gross,
+		"refunded": refunded,
+		"net":      gross - refunded,
+		"days":     days,
+	}})
+}
+
+// @Summary Create invoice api
+// @Schemes
+// @Description Issue an invoice from one of my merchants. Share the returned payment_link (or code) with the customer
+// @Tags Merchants
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/merchants/{id}/invoices [post]
+// @Param id path string true "Merchant ID"
+// @Param items body []forms.InvoiceItemForm true "Line items"
+// @Param memo body string false "Memo"
+// @Param expires_in_hours body int false "Hours before the invoice expires, 30 days by default"
+func (ctrl MerchantController) CreateInvoice(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	merchantID, _, ok := merchantParams(c)
+	if !ok {
+		return
+	}
+
+	var form forms.CreateInvoiceForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := merchantForm.CreateInvoice(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	invoice, err := invoiceModel.Create(ctx, userID, merchantID, form)
+	if err != nil {
+		merchantError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Invoice created successfully", Data: invoiceResult(invoice)})
+}
+
+// @Summary Invoices api
+// @Schemes
+// @Description List the invoices of one of my merchants, newest first
+// @Tags Merchants
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/merchants/{id}/invoices [get]
+// @Param id path string true "Merchant ID"
+// @Param status query string false "OPEN, PAID, EXPIRED or REFUNDED"
+// @Param page query int false "Page, starting at 1"
+// @Param limit query int false "Invoices per page"
+func (ctrl MerchantController) Invoices(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	merchantID, _, ok := merchantParams(c)
+	if !ok {
+		return
+	}
+
+	page, _ := utils.QueryParamInt(c, "page", 1)
+	limit, _ := utils.QueryParamInt(c, "limit", 20)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	invoices, err := invoiceModel.List(ctx, userID, merchantID, c.Query("status"), models.Query{Page: page, Limit: limit})
+	if err != nil {
+		merchantError(c, err)
+		return
+	}
+
+	data := make([]interface{}, len(invoices))
+	for i, v := range invoices {
+		data[i] = invoiceResult(v)
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve invoices successfully", Data: data})
+}
+
+// @Summary Invoice api
+// @Schemes
+// @Description One invoice of one of my merchants
+// @Tags Merchants
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/merchants/{id}/invoices/{invoice_id} [get]
+// @Param id path string true "Merchant ID"
+// @Param invoice_id path string true "Invoice ID"
+func (ctrl MerchantController) Invoice(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	merchantID, invoiceID, ok := merchantParams(c)
+	if !ok {
+		return
+	}
+
+	invoice, err := invoiceModel.Find(ctx, userID, merchantID, invoiceID)
+	if err != nil {
+		merchantError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Retrieve invoice successfully", Data: invoiceResult(invoice)})
+}
+
+// @Summary Refund invoice api
+// @Schemes
+// @Description Give the amount of a paid invoice back to its payer, from the merchant balance
+// @Tags Merchants
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/merchants/{id}/invoices/{invoice_id}/refund [post]
+// @Param id path string true "Merchant ID"
+// @Param invoice_id path string true "Invoice ID"
+func (ctrl MerchantController) Refund(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	merchantID, invoiceID, ok := merchantParams(c)
+	if !ok {
+		return
+	}
+
+	invoice, err := invoiceModel.Refund(ctx, userID, merchantID, invoiceID)
+	if err != nil {
+		merchantError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Invoice refunded successfully", Data: invoiceResult(invoice)})
+}
+
+// @Summary Payment link api
+// @Schemes
+// @Description The invoice behind a payment link: merchant, items, total and status
+// @Tags Payment links
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/payment-links/{code} [get]
+// @Param code path string true "Invoice code"
+func (ctrl MerchantController) PaymentLink(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	invoice, err := invoiceModel.FindByCode(ctx, c.Param("code"))
+	if err != nil {
+		merchantError(c, err)
+		return
+	}
+
+	//The payer only needs what is on the invoice
+	result := invoiceResult(invoice)
+	delete(result, "paid_by")
+	delete(result, "transaction_id")
+	delete(result, "refund_transaction_id")
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Retrieve invoice successfully", Data: result})
+}
+
+// @Summary Pay payment link api
+// @Schemes
+// @Description Pay the invoice behind a payment link from my wallet
+// @Tags Payment links
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/payment-links/{code}/pay [post]
+// @Param code path string true "Invoice code"
+func (ctrl MerchantController) Pay(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	invoice, err := invoiceModel.Pay(ctx, userID, c.Param("code"))
+	if err != nil {
+		merchantError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Invoice paid successfully", Data: invoiceResult(invoice)})
+}
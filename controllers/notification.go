@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// NotificationController ...
+type NotificationController struct{}
+
+var notificationModel = new(models.NotificationModel)
+
+var notificationForm = new(forms.NotificationForm)
+
+// @Summary Notifications api
+// @Schemes
+// @Description Delivery log of my notifications, newest first: one entry per channel, with its status, attempts and last error
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/notifications [get]
+// @Param status query string false "PENDING, SENT or FAILED"
+// @Param page query int false "Page, starting at 1"
+// @Param limit query int false "Notifications per page"
+func (ctrl NotificationController) All(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	page, _ := utils.QueryParamInt(c, "page", 1)
+	limit, _ := utils.QueryParamInt(c, "limit", 20)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	notifications, err := notificationModel.List(ctx, userID, c.Query("status"), models.Query{Page: page, Limit: limit})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	data := make([]interface{}, len(notifications))
+	for i, v := range notifications {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve notifications successfully", Data: data})
+}
+
+// @Summary Notification preferences api
+// @Schemes
+// @Description The channels (email, sms, push) I get each type of notification on. Email and SMS are only sent to a verified address
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/notifications/preferences [get]
+func (ctrl NotificationController) Preferences(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	preferences, err := notificationModel.Preferences(ctx, userID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&preferences)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Retrieve notification preferences successfully", Data: result})
+}
+
+// @Summary Update notification preferences api
+// @Schemes
+// @Description Turn channels on or off by notification type (transfer_received, withdrawal_completed, new_device), e.g. {"types": {"transfer_received": {"sms": true}}}. The ones left out keep their setting
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/notifications/preferences [put]
+// @Param types body object true "Channels by notification type"
+func (ctrl NotificationController) UpdatePreferences(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.UpdateNotificationPreferencesForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := notificationForm.UpdatePreferences(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	preferences, err := notificationModel.UpdatePreferences(ctx, userID, form)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&preferences)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Notification preferences updated successfully", Data: result})
+}
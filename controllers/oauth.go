@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OAuthController ...
+type OAuthController struct{}
+
+var oauthClientModel = new(models.OAuthClientModel)
+
+// @Summary OAuth2 token api
+// @Schemes
+// @Description Client-credentials grant for registered service clients (RFC 6749 section 4.4)
+// @Tags OAuth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Success 200 {object} models.ClientToken "Success"
+// @Router /v1/oauth/token [post]
+// @Param grant_type formData string true "client_credentials"
+// @Param client_id formData string false "Client ID, or use HTTP Basic"
+// @Param client_secret formData string false "Client secret, or use HTTP Basic"
+// @Param scope formData string false "Space separated subset of the client scopes"
+func (ctrl OAuthController) Token(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	//Token responses must never be cached
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	var form forms.ClientCredentialsForm
+	if validationErr := c.ShouldBind(&form); validationErr != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	if form.GrantType != "client_credentials" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unsupported_grant_type"})
+		return
+	}
+
+	if clientID, clientSecret, ok := c.Request.BasicAuth(); ok {
+		form.ClientID, form.ClientSecret = clientID, clientSecret
+	}
+
+	token, err := oauthClientModel.IssueToken(ctx, form)
+	switch err {
+	case nil:
+		c.JSON(http.StatusOK, token)
+	case models.ErrInvalidClient:
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+	case models.ErrInvalidScope:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid_scope"})
+	default:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+	}
+}
+
+// @Summary Register OAuth client api
+// @Schemes
+// @Description Register a service client acting on my account, the secret is only shown in this response
+// @Tags OAuth
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/user/oauth-clients [post]
+// @Param name body string true "Name of the client"
+// @Param scopes body []string true "Scopes the client may request"
+func (ctrl OAuthController) CreateClient(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.CreateOAuthClientForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := apiKeyForm.Create(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	client, secret, err := oauthClientModel.Create(ctx, userID, form)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&client)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+	result["client_secret"] = secret
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "OAuth client registered successfully, store the secret now as it won't be shown again", Data: result})
+}
+
+// @Summary OAuth clients api
+// @Schemes
+// @Description List my service clients
+// @Tags OAuth
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/user/oauth-clients [get]
+func (ctrl OAuthController) Clients(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clients, err := oauthClientModel.List(ctx, userID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	data := make([]interface{}, len(clients))
+	for i, v := range clients {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve OAuth clients successfully", Data: data})
+}
+
+// @Summary Revoke OAuth client api
+// @Schemes
+// @Description Revoke one of my service clients and every token issued to it
+// @Tags OAuth
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/user/oauth-clients/{id} [delete]
+// @Param id path string true "Client record ID"
+func (ctrl OAuthController) DeleteClient(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: models.ErrOAuthClientNotFound.Error()})
+		return
+	}
+
+	err = oauthClientModel.Revoke(ctx, userID, id)
+	if err == models.ErrOAuthClientNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, utils.Response{Status: http.StatusInternalServerError, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "OAuth client revoked successfully"})
+}
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PaymentRequestController ...
+type PaymentRequestController struct{}
+
+var paymentRequestModel = new(models.PaymentRequestModel)
+var paymentRequestForm = new(forms.PaymentRequestForm)
+
+// @Summary Create payment request api
+// @Schemes
+// @Description Request money from another user, the request expires after expires_in_hours (7 days by default)
+// @Tags Payment requests
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/payment-requests [post]
+// @Param to body string true "Username of the payer"
+// @Param amount body int true "Amount"
+// @Param memo body string false "Memo"
+// @Param expires_in_hours body int false "Hours before the request expires"
+func (ctrl PaymentRequestController) Create(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.CreatePaymentRequestForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := paymentRequestForm.Create(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	request, err := paymentRequestModel.Create(ctx, userID, form)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&request)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Payment request created successfully", Data: result})
+}
+
+// @Summary Payment requests api
+// @Schemes
+// @Description List the payment requests I sent (outgoing) or have to pay (incoming), newest first
+// @Tags Payment requests
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/payment-requests [get]
+// @Param role query string false "incoming or outgoing, both by default"
+// @Param status query string false "PENDING, ACCEPTED, DECLINED, CANCELLED or EXPIRED"
+func (ctrl PaymentRequestController) All(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	requests, err := paymentRequestModel.List(ctx, userID, c.Query("role"), c.Query("status"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	data := make([]interface{}, len(requests))
+	for i, v := range requests {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve payment requests successfully", Data: data})
+}
+
+// paymentRequestAction runs one of the lifecycle actions on the request of the path
+func paymentRequestAction(c *gin.Context, action func(ctx context.Context, userID primitive.ObjectID, id primitive.ObjectID) (models.PaymentRequest, error), message string) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: models.ErrPaymentRequestNotFound.Error()})
+		return
+	}
+
+	request, err := action(ctx, userID, id)
+	if err == models.ErrPaymentRequestNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&request)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: message, Data: result})
+}
+
+// @Summary Accept payment request api
+// @Schemes
+// @Description Pay a pending request I received, the transfer is linked as transaction_id
+// @Tags Payment requests
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/payment-requests/{id}/accept [post]
+// @Param id path string true "Payment request ID"
+func (ctrl PaymentRequestController) Accept(c *gin.Context) {
+	paymentRequestAction(c, paymentRequestModel.Accept, "Payment request accepted successfully")
+}
+
+// @Summary Decline payment request api
+// @Schemes
+// @Description Decline a pending request I received
+// @Tags Payment requests
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/payment-requests/{id}/decline [post]
+// @Param id path string true "Payment request ID"
+func (ctrl PaymentRequestController) Decline(c *gin.Context) {
+	paymentRequestAction(c, paymentRequestModel.Decline, "Payment request declined successfully")
+}
+
+// @Summary Cancel payment request api
+// @Schemes
+// @Description Cancel a pending request I sent
+// @Tags Payment requests
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/payment-requests/{id}/cancel [post]
+// @Param id path string true "Payment request ID"
+func (ctrl PaymentRequestController) Cancel(c *gin.Context) {
+	paymentRequestAction(c, paymentRequestModel.Cancel, "Payment request cancelled successfully")
+}
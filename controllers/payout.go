@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/payments"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PayoutController manages the bank accounts withdrawals go to, and the payouts sending them
+type PayoutController struct{}
+
+var bankAccountModel = new(models.BankAccountModel)
+var payoutModel = new(models.PayoutModel)
+var bankAccountForm = new(forms.BankAccountForm)
+
+// @Summary Create bank account api
+// @Schemes
+// @Description Save a bank account to withdraw to
+// @Tags Payouts
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/bank-accounts [post]
+// @Param holder_name body string true "Name of the account holder"
+// @Param bank_code body string true "BIC or routing number"
+// @Param account_number body string true "Account number or IBAN"
+func (ctrl PayoutController) CreateBankAccount(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.CreateBankAccountForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := bankAccountForm.Create(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	account, err := bankAccountModel.Create(ctx, userID, form)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&account)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Bank account saved successfully", Data: result})
+}
+
+// @Summary Bank accounts api
+// @Schemes
+// @Description List my bank accounts
+// @Tags Payouts
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/bank-accounts [get]
+func (ctrl PayoutController) BankAccounts(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	accounts, err := bankAccountModel.List(ctx, userID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	data := make([]interface{}, len(accounts))
+	for i, v := range accounts {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve bank accounts successfully", Data: data})
+}
+
+// @Summary Delete bank account api
+// @Schemes
+// @Description Delete one of my bank accounts
+// @Tags Payouts
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/bank-accounts/{id} [delete]
+// @Param id path string true "Bank account ID"
+func (ctrl PayoutController) DeleteBankAccount(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := bankAccountModel.Delete(ctx, userID, c.Param("id"))
+	if err == models.ErrBankAccountNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Bank account deleted successfully"})
+}
+
+// @Summary Payouts api
+// @Schemes
+// @Description List my withdrawals with their settlement status, newest first
+// @Tags Payouts
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/payouts [get]
+// @Param page query int false "Page, starting at 1"
+// @Param limit query int false "Payouts per page"
+func (ctrl PayoutController) All(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	page, _ := utils.QueryParamInt(c, "page", 1)
+	limit, _ := utils.QueryParamInt(c, "limit", 20)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	payouts, err := payoutModel.List(ctx, userID, models.Query{Page: page, Limit: limit})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	data := make([]interface{}, len(payouts))
+	for i, v := range payouts {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve payouts successfully", Data: data})
+}
+
+// @Summary Payout api
+// @Schemes
+// @Description Get one of my withdrawals with its status history
+// @Tags Payouts
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/payouts/{id} [get]
+// @Param id path string true "Payout ID"
+func (ctrl PayoutController) One(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: models.ErrPayoutNotFound.Error()})
+		return
+	}
+
+	payout, err := payoutModel.Find(ctx, userID, id)
+	if err == models.ErrPayoutNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&payout)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Retrieve payout successfully", Data: result})
+}
+
+// @Summary Payout provider callback api
+// @Schemes
+// @Description Called by the payout provider when a payout settles, fails or is returned. The body must be signed in the X-Provider-Signature header, updates arriving twice or late change nothing
+// @Tags Payouts
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/payouts/callback [post]
+func (ctrl PayoutController) Callback(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	body, err := c.GetRawData()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: "Invalid request"})
+		return
+	}
+
+	update, err := payments.GetPayoutProvider().VerifyCallback(c.Request.Header, body)
+	if err == payments.ErrInvalidSignature {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, utils.Response{Status: http.StatusUnauthorized, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	err = payoutModel.Callback(ctx, update)
+	if err == models.ErrPayoutNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		//The provider retries on errors
+		c.AbortWithStatusJSON(http.StatusInternalServerError, utils.Response{Status: http.StatusInternalServerError, Message: "Something went wrong, please try again later"})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Callback received"})
+}
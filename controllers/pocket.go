@@ -0,0 +1,240 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PocketController ...
+type PocketController struct{}
+
+var pocketModel = new(models.PocketModel)
+var pocketForm = new(forms.PocketForm)
+
+// pocketError writes the response of a pocket model error
+func pocketError(c *gin.Context, err error) {
+	if err == models.ErrPocketNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+}
+
+// @Summary Create pocket api
+// @Schemes
+// @Description Create a pocket to set money aside, with an optional goal and target date
+// @Tags Pockets
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/pockets [post]
+// @Param name body string true "Name"
+// @Param goal body int false "Goal amount"
+// @Param target_date body string false "Target date, YYYY-MM-DD"
+func (ctrl PocketController) Create(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.CreatePocketForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := pocketForm.Create(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	pocket, err := pocketModel.Create(ctx, userID, form)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&pocket)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Pocket created successfully", Data: result})
+}
+
+// @Summary Pockets api
+// @Schemes
+// @Description List my pockets
+// @Tags Pockets
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/pockets [get]
+func (ctrl PocketController) All(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pockets, err := pocketModel.List(ctx, userID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	data := make([]interface{}, len(pockets))
+	for i, v := range pockets {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve pockets successfully", Data: data})
+}
+
+// @Summary Pocket api
+// @Schemes
+// @Description Get one of my pockets
+// @Tags Pockets
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/pockets/{id} [get]
+// @Param id path string true "Pocket ID"
+func (ctrl PocketController) One(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pocket, err := pocketModel.Find(ctx, userID, c.Param("id"))
+	if err != nil {
+		pocketError(c, err)
+		return
+	}
+
+	temp, _ := json.Marshal(&pocket)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Retrieve pocket successfully", Data: result})
+}
+
+// @Summary Delete pocket api
+// @Schemes
+// @Description Delete one of my pockets, it must be empty
+// @Tags Pockets
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/pockets/{id} [delete]
+// @Param id path string true "Pocket ID"
+func (ctrl PocketController) Delete(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := pocketModel.Delete(ctx, userID, c.Param("id")); err != nil {
+		pocketError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Pocket deleted successfully"})
+}
+
+// pocketMove runs a move between the main balance and the pocket of the path
+func pocketMove(c *gin.Context, move func(ctx context.Context, userID primitive.ObjectID, id string, amount int64) (models.Transaction, error), message string) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.PocketMoveForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := pocketForm.Move(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	transaction, err := move(ctx, userID, c.Param("id"), form.Amount)
+	if err != nil {
+		pocketError(c, err)
+		return
+	}
+
+	temp, _ := json.Marshal(&transaction)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: message, Data: result})
+}
+
+// @Summary Pocket deposit api
+// @Schemes
+// @Description Move money from my main balance to one of my pockets
+// @Tags Pockets
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/pockets/{id}/deposit [post]
+// @Param id path string true "Pocket ID"
+// @Param amount body int true "Amount"
+func (ctrl PocketController) Deposit(c *gin.Context) {
+	pocketMove(c, pocketModel.Deposit, "Money moved to the pocket successfully")
+}
+
+// @Summary Pocket withdraw api
+// @Schemes
+// @Description Move money from one of my pockets back to my main balance
+// @Tags Pockets
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/pockets/{id}/withdraw [post]
+// @Param id path string true "Pocket ID"
+// @Param amount body int true "Amount"
+func (ctrl PocketController) Withdraw(c *gin.Context) {
+	pocketMove(c, pocketModel.Withdraw, "Money moved to the main balance successfully")
+}
+
+// @Summary Pocket transactions api
+// @Schemes
+// @Description History of one of my pockets, newest first
+// @Tags Pockets
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/pockets/{id}/transactions [get]
+// @Param id path string true "Pocket ID"
+// @Param page query int false "Page, starting at 1"
+// @Param limit query int false "Transactions per page"
+func (ctrl PocketController) Transactions(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	page, _ := utils.QueryParamInt(c, "page", 1)
+	limit, _ := utils.QueryParamInt(c, "limit", 20)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	transactions, err := pocketModel.Transactions(ctx, userID, c.Param("id"), models.Query{Page: page, Limit: limit})
+	if err != nil {
+		pocketError(c, err)
+		return
+	}
+
+	data := make([]interface{}, len(transactions))
+	for i, v := range transactions {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve pocket transactions successfully", Data: data})
+}
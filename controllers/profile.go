@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// ProfileController ...
+type ProfileController struct{}
+
+var profileModel = new(models.ProfileModel)
+
+// @Summary Profile api
+// @Schemes
+// @Description Get my profile and main balance
+// @Tags User
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/user/me [get]
+func (ctrl ProfileController) Me(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	profile, err := profileModel.Get(ctx, userID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&profile)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Retrieve profile successfully", Data: result})
+}
+
+// @Summary Update profile api
+// @Schemes
+// @Description Update my profile, the fields left out are kept. A new email or phone has to be verified again
+// @Tags User
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/user/me [patch]
+// @Param name body string false "Display name"
+// @Param email body string false "Email"
+// @Param phone body string false "Phone number, E.164 e.g. +14155550123"
+// @Param avatar_url body string false "https URL of the avatar"
+// @Param locale body string false "Language tag, e.g. en-US"
+// @Param timezone body string false "IANA time zone, e.g. Europe/Paris"
+func (ctrl ProfileController) Update(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.UpdateProfileForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := userForm.UpdateProfile(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	profile, err := profileModel.Update(ctx, userID, form)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&profile)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Profile updated successfully", Data: result})
+}
+
+// @Summary Start verification api
+// @Schemes
+// @Description Send a code of 6 digits to the email or the phone of my profile
+// @Tags User
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/user/me/verifications [post]
+// @Param channel body string true "email or phone"
+func (ctrl ProfileController) StartVerification(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.StartVerificationForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := userForm.Verification(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	err := profileModel.StartVerification(ctx, userID, form.Channel)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Verification code sent successfully"})
+}
+
+// @Summary Confirm verification api
+// @Schemes
+// @Description Verify the email or the phone of my profile with the code sent to it
+// @Tags User
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/user/me/verifications/confirm [post]
+// @Param channel body string true "email or phone"
+// @Param code body string true "Code of 6 digits"
+func (ctrl ProfileController) ConfirmVerification(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.ConfirmVerificationForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := userForm.Verification(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	profile, err := profileModel.ConfirmVerification(ctx, userID, form.Channel, form.Code)
+	if err == models.ErrInvalidVerificationCode {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&profile)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Verified successfully", Data: result})
+}
@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PromotionController manages vouchers and cashback rules, which only the marketing account funding them can create
+type PromotionController struct{}
+
+var voucherModel = new(models.VoucherModel)
+var cashbackModel = new(models.CashbackModel)
+var promotionForm = new(forms.PromotionForm)
+
+// promotionError writes the response of a voucher or cashback model error
+func promotionError(c *gin.Context, err error) {
+	switch err {
+	case models.ErrNotMarketing:
+		c.AbortWithStatusJSON(http.StatusForbidden, utils.Response{Status: http.StatusForbidden, Message: err.Error()})
+	case models.ErrVoucherNotFound, models.ErrCashbackRuleNotFound:
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+	default:
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+	}
+}
+
+// @Summary Create voucher api
+// @Schemes
+// @Description Create a voucher credited from the marketing account, marketing account only
+// @Tags Promotions
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/vouchers [post]
+// @Param code body string false "Code, generated when empty"
+// @Param amount body int true "Amount credited"
+// @Param max_redemptions body int false "Redemptions in total, 1 by default"
+// @Param per_user_limit body int false "Redemptions per user, 1 by default"
+// @Param expires_in_hours body int false "Hours before the voucher expires, 720 by default"
+func (ctrl PromotionController) CreateVoucher(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.CreateVoucherForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := promotionForm.CreateVoucher(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	voucher, err := voucherModel.Create(ctx, userID, form)
+	if err != nil {
+		promotionError(c, err)
+		return
+	}
+
+	temp, _ := json.Marshal(&voucher)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Voucher created successfully", Data: result})
+}
+
+// @Summary Vouchers api
+// @Schemes
+// @Description List the vouchers with their redemptions, marketing account only
+// @Tags Promotions
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/vouchers [get]
+// @Param page query int false "Page, starting at 1"
+// @Param limit query int false "Vouchers per page"
+func (ctrl PromotionController) Vouchers(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	page, _ := utils.QueryParamInt(c, "page", 1)
+	limit, _ := utils.QueryParamInt(c, "limit", 20)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	vouchers, err := voucherModel.List(ctx, userID, models.Query{Page: page, Limit: limit})
+	if err != nil {
+		promotionError(c, err)
+		return
+	}
+
+	data := make([]interface{}, len(vouchers))
+	for i, v := range vouchers {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve vouchers successfully", Data: data})
+}
+
+// @Summary Redeem voucher api
+// @Schemes
+// @Description Redeem a voucher code, its amount is credited to my balance
+// @Tags Promotions
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/vouchers/redeem [post]
+// @Param code body string true "Voucher code"
+func (ctrl PromotionController) Redeem(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.RedeemVoucherForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := promotionForm.Redeem(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	transaction, err := voucherModel.Redeem(ctx, userID, form.Code)
+	if err != nil {
+		promotionError(c, err)
+		return
+	}
+
+	temp, _ := json.Marshal(&transaction)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Voucher redeemed successfully", Data: result})
+}
+
+// @Summary Create cashback rule api
+// @Schemes
+// @Description Give back a percentage of qualifying transfers or merchant payments from the marketing account, marketing account only
+// @Tags Promotions
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/cashback-rules [post]
+// @Param name body string true "Name"
+// @Param type body string true "TRANSFER or INVOICE_PAYMENT"
+// @Param percentage body number true "Percentage given back"
+// @Param min_amount body int false "Minimum amount of a qualifying payment"
+// @Param max_cashback body int false "Maximum cashback of a payment"
+// @Param max_per_user body int false "Maximum cashback a user gets from the rule"
+// @Param max_per_user_daily body int false "Maximum cashback a user gets from the rule in 24 hours"
+// @Param expires_in_hours body int false "Hours before the rule expires, never by default"
+func (ctrl PromotionController) CreateCashbackRule(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.CreateCashbackRuleForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := promotionForm.CreateCashbackRule(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	rule, err := cashbackModel.Create(ctx, userID, form)
+	if err != nil {
+		promotionError(c, err)
+		return
+	}
+
+	temp, _ := json.Marshal(&rule)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Cashback rule created successfully", Data: result})
+}
+
+// @Summary Cashback rules api
+// @Schemes
+// @Description List the cashback rules, marketing account only
+// @Tags Promotions
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/cashback-rules [get]
+func (ctrl PromotionController) CashbackRules(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rules, err := cashbackModel.List(ctx, userID)
+	if err != nil {
+		promotionError(c, err)
+		return
+	}
+
+	data := make([]interface{}, len(rules))
+	for i, v := range rules {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve cashback rules successfully", Data: data})
+}
+
+// @Summary Deactivate cashback rule api
+// @Schemes
+// @Description Stop a cashback rule, marketing account only
+// @Tags Promotions
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/cashback-rules/{id} [delete]
+// @Param id path string true "Cashback rule ID"
+func (ctrl PromotionController) DeactivateCashbackRule(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		promotionError(c, models.ErrCashbackRuleNotFound)
+		return
+	}
+
+	if err = cashbackModel.Deactivate(ctx, userID, id); err != nil {
+		promotionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Cashback rule deactivated successfully"})
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-gonic/gin"
+	"rsc.io/qr"
+)
+
+// QRController ...
+type QRController struct{}
+
+var qrModel = new(models.QRModel)
+var qrForm = new(forms.QRForm)
+
+// @Summary Create QR code api
+// @Schemes
+// @Description Sign a single-use QR payload asking to be paid to me, with an optional amount and reference. Render it with GET /v1/qr/image
+// @Tags QR payments
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/qr [post]
+// @Param amount body int false "Amount, the payer chooses it when empty"
+// @Param reference body string false "Reference"
+// @Param expires_in_minutes body int false "Minutes before the code expires, 15 by default"
+func (ctrl QRController) Create(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.CreateQRForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := qrForm.Create(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	code, err := qrModel.Create(ctx, userID, form)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&code)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "QR code created successfully", Data: result})
+}
+
+// @Summary QR code image api
+// @Schemes
+// @Description Render a payload created by POST /v1/qr as a PNG image
+// @Tags QR payments
+// @Produce png
+// @Success 200 {file} file "PNG image"
+// @Router /v1/qr/image [get]
+// @Param payload query string true "Payload"
+// @Param scale query int false "Pixels per module, 8 by default"
+func (ctrl QRController) Image(c *gin.Context) {
+	payload := c.Query("payload")
+
+	//Only our own codes are rendered
+	if _, err := models.DecodeQR(payload); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	scale, _ := utils.QueryParamInt(c, "scale", 8)
+	if scale < 1 || scale > 32 {
+		scale = 8
+	}
+
+	code, err := qr.Encode(payload, qr.M)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, utils.Response{Status: http.StatusInternalServerError, Message: "Something went wrong, please try again later"})
+		return
+	}
+	code.Scale = scale
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/png", code.PNG())
+}
+
+// @Summary Pay QR code api
+// @Schemes
+// @Description Pay a scanned QR code from my wallet. Tampered, expired and already paid codes are rejected
+// @Tags QR payments
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/pay/qr [post]
+// @Param payload body string true "Scanned payload"
+// @Param amount body int false "Amount, required when the code has none"
+func (ctrl QRController) Pay(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.PayQRForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := qrForm.Pay(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	transaction, err := qrModel.Pay(ctx, userID, form)
+	if err == models.ErrQRUsed {
+		c.AbortWithStatusJSON(http.StatusConflict, utils.Response{Status: http.StatusConflict, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&transaction)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Transaction created successfully", Data: result})
+}
@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// RealtimeController ...
+type RealtimeController struct{}
+
+var realtimeModel = new(models.RealtimeModel)
+
+// realtimeKeepAlive keeps proxies from closing idle connections
+const realtimeKeepAlive = 25 * time.Second
+
+// watch subscribes to the events of the user and pumps them into a channel until ctx is done
+func watch(ctx context.Context, c *gin.Context, resumeToken string) (<-chan models.RealtimeEvent, <-chan error, error) {
+	stream, err := realtimeModel.Watch(ctx, getUserID(c), resumeToken)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	events := make(chan models.RealtimeEvent)
+	errs := make(chan error, 1)
+	go func() {
+		defer stream.Close(context.Background())
+		for {
+			event, err := stream.Next(ctx)
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, errs, nil
+}
+
+// @Summary Realtime events api (SSE)
+// @Schemes
+// @Description Server-Sent Events stream of my new transactions, balance and pocket balance changes. Each event id is a resume token, reconnect with the Last-Event-ID header (or resume_token) to receive missed events. Browsers can pass the token as access_token
+// @Tags User
+// @Produce text/event-stream
+// @Success 200 {object} models.RealtimeEvent "Stream of events"
+// @Router /v1/user/events [get]
+// @Param resume_token query string false "Resume after this event id"
+func (ctrl RealtimeController) Events(c *gin.Context) {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	resumeToken := c.GetHeader("Last-Event-ID")
+	if resumeToken == "" {
+		resumeToken = c.Query("resume_token")
+	}
+
+	events, errs, err := watch(ctx, c, resumeToken)
+	if err == models.ErrResumeTokenExpired {
+		c.AbortWithStatusJSON(http.StatusGone, utils.Response{Status: http.StatusGone, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, utils.Response{Status: http.StatusInternalServerError, Message: "Something went wrong, please try again later"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	keepAlive := time.NewTicker(realtimeKeepAlive)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-events:
+			c.Render(-1, sse.Event{Id: event.ID, Event: event.Type, Data: event.Data})
+			return true
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+			return true
+		case <-errs:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	})
+}
+
+// @Summary Realtime events api (WebSocket)
+// @Schemes
+// @Description WebSocket stream of my new transactions, balance and pocket balance changes as JSON messages {id, type, data}. Reconnect with resume_token set to the last id to receive missed events. Browsers can pass the token as access_token
+// @Tags User
+// @Success 101 {object} models.RealtimeEvent "Stream of events"
+// @Router /v1/user/ws [get]
+// @Param resume_token query string false "Resume after this event id"
+func (ctrl RealtimeController) WebSocket(c *gin.Context) {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	events, errs, err := watch(ctx, c, c.Query("resume_token"))
+	if err == models.ErrResumeTokenExpired {
+		c.AbortWithStatusJSON(http.StatusGone, utils.Response{Status: http.StatusGone, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, utils.Response{Status: http.StatusInternalServerError, Message: "Something went wrong, please try again later"})
+		return
+	}
+
+	websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+
+		//The client only sends close frames, reading detects the disconnect
+		go func() {
+			var discard string
+			for websocket.Message.Receive(ws, &discard) == nil {
+			}
+			cancel()
+		}()
+
+		keepAlive := time.NewTicker(realtimeKeepAlive)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case event := <-events:
+				if websocket.JSON.Send(ws, event) != nil {
+					return
+				}
+			case <-keepAlive.C:
+				if websocket.JSON.Send(ws, models.RealtimeEvent{Type: "keep-alive"}) != nil {
+					return
+				}
+			case <-errs:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}).ServeHTTP(c.Writer, c.Request)
+}
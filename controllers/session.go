@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SessionController ...
+type SessionController struct{}
+
+var sessionModel = new(models.SessionModel)
+
+// @Summary Sessions api
+// @Schemes
+// @Description List the devices I'm logged in from
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/user/sessions [get]
+func (ctrl SessionController) All(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sessions, err := sessionModel.List(ctx, userID, getAccessUUID(c))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	data := make([]interface{}, len(sessions))
+	for i, v := range sessions {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve sessions successfully", Data: data})
+}
+
+// @Summary Revoke session api
+// @Schemes
+// @Description Log out one of my sessions
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/user/sessions/{id} [delete]
+// @Param id path string true "Session ID"
+func (ctrl SessionController) Delete(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sessionID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: models.ErrSessionNotFound.Error()})
+		return
+	}
+
+	err = sessionModel.Revoke(ctx, userID, sessionID)
+	if err == models.ErrSessionNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, utils.Response{Status: http.StatusInternalServerError, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Session logged out successfully"})
+}
+
+// @Summary Logout everywhere api
+// @Schemes
+// @Description Log out all of my sessions, including this one
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/user/sessions [delete]
+func (ctrl SessionController) DeleteAll(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := sessionModel.RevokeAll(ctx, userID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, utils.Response{Status: http.StatusInternalServerError, Message: "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Logged out everywhere successfully"})
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/payments"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TopUpController ...
+type TopUpController struct{}
+
+var topUpModel = new(models.TopUpModel)
+
+// @Summary Top-ups api
+// @Schemes
+// @Description List my top-ups with their payment status, newest first
+// @Tags Top-ups
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/top-ups [get]
+// @Param page query int false "Page, starting at 1"
+// @Param limit query int false "Top-ups per page"
+func (ctrl TopUpController) All(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	page, _ := utils.QueryParamInt(c, "page", 1)
+	limit, _ := utils.QueryParamInt(c, "limit", 20)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	topUps, err := topUpModel.List(ctx, userID, models.Query{Page: page, Limit: limit})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	data := make([]interface{}, len(topUps))
+	for i, v := range topUps {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve top-ups successfully", Data: data})
+}
+
+// @Summary Top-up api
+// @Schemes
+// @Description Get one of my top-ups, to follow its payment status
+// @Tags Top-ups
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/top-ups/{id} [get]
+// @Param id path string true "Top-up ID"
+func (ctrl TopUpController) One(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: models.ErrTopUpNotFound.Error()})
+		return
+	}
+
+	topUp, err := topUpModel.Find(ctx, userID, id)
+	if err == models.ErrTopUpNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&topUp)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Retrieve top-up successfully", Data: result})
+}
+
+// @Summary Payment provider callback api
+// @Schemes
+// @Description Called by the payment provider when a checkout is paid or declined. The body must be signed in the X-Provider-Signature header, a callback received twice is applied once
+// @Tags Top-ups
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/top-ups/callback [post]
+func (ctrl TopUpController) Callback(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	body, err := c.GetRawData()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: "Invalid request"})
+		return
+	}
+
+	callback, err := payments.GetProvider().VerifyCallback(c.Request.Header, body)
+	if err == payments.ErrInvalidSignature {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, utils.Response{Status: http.StatusUnauthorized, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	topUp, err := topUpModel.Complete(ctx, callback)
+	switch err {
+	case nil:
+	case models.ErrTopUpNotFound:
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+		return
+	case models.ErrCallbackMismatch:
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	default:
+		//The provider retries on errors
+		c.AbortWithStatusJSON(http.StatusInternalServerError, utils.Response{Status: http.StatusInternalServerError, Message: "Something went wrong, please try again later"})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Callback received", Data: map[string]interface{}{"id": topUp.ID.Hex(), "status": topUp.Status}})
+}
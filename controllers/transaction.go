@@ -1,3 +1,103 @@
 package controllers
 
-type TransactionController struct{}
\ No newline at end of file
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TransactionController struct{}
+
+var transactionModel = new(models.TransactionModel)
+
+// @Summary Update transaction api
+// @Schemes
+// @Description Edit the memo, category and tags of one of my transactions. My category and tags are only seen by me, the memo can only be edited by the sender
+// @Tags Transactions
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/transactions/{id} [patch]
+// @Param id path string true "Transaction ID"
+// @Param memo body string false "Memo"
+// @Param category body string false "Category"
+// @Param tags body []string false "Tags, an empty list removes them"
+func (ctrl TransactionController) Update(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.UpdateTransactionForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := transactionForm.Update(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: models.ErrTransactionNotFound.Error()})
+		return
+	}
+
+	transaction, err := transactionModel.Update(ctx, userID, id, form)
+	if err == models.ErrTransactionNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&transaction)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Transaction updated successfully", Data: result})
+}
+
+// @Summary Transaction analytics api
+// @Schemes
+// @Description Get my spending and income per category and per month. Moves between my balance and my pockets are left out
+// @Tags Transactions
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/transactions/analytics [get]
+// @Param from query string false "First month, YYYY-MM, 5 months before the last one by default"
+// @Param to query string false "Last month, YYYY-MM, the current month by default"
+// @Param tag query string false "Only count the transactions with this tag"
+func (ctrl TransactionController) Analytics(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.AnalyticsForm
+	if validationErr := c.ShouldBindQuery(&form); validationErr != nil {
+		message := transactionForm.Analytics(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	analytics, err := transactionModel.Analytics(ctx, userID, form)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&analytics)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Retrieve analytics successfully", Data: result})
+}
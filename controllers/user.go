@@ -3,6 +3,9 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
 	"time"
 
 	"github.com/Massad/gin-boilerplate/forms"
@@ -19,6 +22,7 @@ import (
 type UserController struct{}
 
 var userModel = new(models.UserModel)
+var passwordResetModel = new(models.PasswordResetModel)
 var userForm = new(forms.UserForm)
 var transactionForm = new(forms.TransactionForm)
 
@@ -27,6 +31,11 @@ func getUserID(c *gin.Context) (userID primitive.ObjectID) {
 	return c.MustGet("userID").(primitive.ObjectID)
 }
 
+// getAccessUUID ...
+func getAccessUUID(c *gin.Context) (accessUUID string) {
+	return c.MustGet("accessUUID").(string)
+}
+
 // Login ...
 
 // @Summary Login api
@@ -36,9 +45,11 @@ func getUserID(c *gin.Context) (userID primitive.ObjectID) {
 // @Accept json
 // @Produce json
 // @Success 200 {object} utils.Response "Success"
+// @Failure 429 {object} utils.Response "Too many failed login attempts"
 // @Router /v1/user/login [post]
 // @Param username body string true "username" SchemaExample(Subject: longn)
 // @Param password body string true "password" SchemaExample(Subject: malongnhan)
+// @Param device_name body string false "Name of the device, shown in the sessions list"
 func (ctrl UserController) Login(c *gin.Context) {
 	var loginForm forms.LoginForm
 
@@ -48,11 +59,23 @@ func (ctrl UserController) Login(c *gin.Context) {
 		return
 	}
 
-	user, token, err := userModel.Login(loginForm)
-	if err != nil {
+	user, token, err := userModel.Login(loginForm, models.SessionDevice{UserAgent: c.Request.UserAgent(), IP: c.ClientIP()})
+
+	var throttled *models.LoginThrottledError
+	if errors.As(err, &throttled) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, utils.Response{Status: http.StatusTooManyRequests, Message: "Too many failed login attempts, please try again later"})
+		return
+	}
+
+	if err == models.ErrInvalidCredentials {
 		c.AbortWithStatusJSON(http.StatusUnauthorized, utils.Response{Status: http.StatusUnauthorized, Message: "The username or password is incorrect"})
 		return
 	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, utils.Response{Status: http.StatusInternalServerError, Message: "Something went wrong, please try again later"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Successfully logged in", "user": user, "token": token})
 }
@@ -97,7 +120,7 @@ func (ctrl UserController) Register(c *gin.Context) {
 
 // @Summary Top-up api
 // @Schemes
-// @Description Top-up to my account
+// @Description Start a top-up of my account. The top-up is PENDING until it is paid at the returned checkout_url, my balance is credited once the payment provider confirms it
 // @Tags User
 // @Accept json
 // @Produce json
@@ -117,27 +140,29 @@ func (ctrl UserController) TopUp(c *gin.Context) {
 		return
 	}
 
-	transaction, err := userModel.TopUp(ctx, userID, form)
+	topUp, err := topUpModel.Create(ctx, userID, form)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
 		return
 	}
 
-	temp, _ := json.Marshal(&transaction)
+	temp, _ := json.Marshal(&topUp)
 	var result map[string]interface{}
 	json.Unmarshal(temp, &result)
-	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Top-up successfully", Data: result})
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Top-up created, please complete the payment", Data: result})
 }
 
 // @Summary Withdraw api
 // @Schemes
-// @Description Withdraw from my account
+// @Description Withdraw from my account to one of my bank accounts. The amount is held until the payout settles, follow it with GET /v1/payouts/{id}. It comes back to my balance if the payout fails or is returned
 // @Tags User
 // @Accept json
 // @Produce json
 // @Success 200 {object} utils.Response "Success"
 // @Router /v1/user/withdraw [post]
 // @Param amount body int true "username of target account" SchemaExample(Subject: 5000)
+// @Param bank_account_id body string true "ID of the bank account to withdraw to"
+// @Param pocket body string false "ID of the pocket to withdraw from, the main balance by default"
 func (ctrl UserController) WithDraw(c *gin.Context) {
 	userID := getUserID(c)
 
@@ -151,21 +176,21 @@ func (ctrl UserController) WithDraw(c *gin.Context) {
 		return
 	}
 
-	transaction, err := userModel.WithDraw(ctx, userID, form)
+	payout, err := userModel.WithDraw(ctx, userID, form)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
 		return
 	}
 
-	temp, _ := json.Marshal(&transaction)
+	temp, _ := json.Marshal(&payout)
 	var result map[string]interface{}
 	json.Unmarshal(temp, &result)
-	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Withdraw successfully", Data: result})
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Withdraw successfully, the payout is on its way", Data: result})
 }
 
 // @Summary Details api
 // @Schemes
-// @Description Get details transactions from my account
+// @Description Get my main balance, my pockets, their total and my transactions
 // @Tags User
 // @Accept json
 // @Produce json
@@ -178,19 +203,18 @@ func (ctrl UserController) Details(c *gin.Context) {
 	defer cancel()
 		
 	// transactions, err := userModel.Details(userID, ctx, query)
-	transactions, err := userModel.Details(userID, ctx)
+	details, err := userModel.Details(userID, ctx)
 
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error(), Data: nil})
 		return
 	}
 
-	data := make([]interface{}, len(transactions))
-	for i, v := range transactions {
-		data[i] = v
-	}
+	temp, _ := json.Marshal(&details)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
 
-	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve user details successfully", Data: data})
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Retrieve user details successfully", Data: result})
 }
 
 // @Summary Transfer api
@@ -203,6 +227,8 @@ func (ctrl UserController) Details(c *gin.Context) {
 // @Router /v1/user/transfer [post]
 // @Param to body string true "Target account" SchemaExample(longn)
 // @Param amount body int true "Amount of money" SchemaExample(5000)
+// @Param pocket body string false "ID of the pocket to transfer from, the main balance by default"
+// @Param memo body string false "Note for the recipient, at most 140 characters"
 func (ctrl UserController) Transfer(c *gin.Context) {
 	userID := getUserID(c)
 
@@ -230,3 +256,95 @@ func (ctrl UserController) Transfer(c *gin.Context) {
 
 	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Transaction created successfully", Data: result})
 }
+
+// @Summary Change password api
+// @Schemes
+// @Description Change my password, every other session is logged out
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/user/password/change [post]
+// @Param old_password body string true "Current password"
+// @Param new_password body string true "New password"
+func (ctrl UserController) ChangePassword(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.ChangePasswordForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := userForm.ChangePassword(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	err := userModel.ChangePassword(ctx, userID, getAccessUUID(c), form)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Password changed successfully"})
+}
+
+// @Summary Forgot password api
+// @Schemes
+// @Description Send a password reset token to the user
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/user/password/forgot [post]
+// @Param username body string true "username" SchemaExample(Subject: longn)
+func (ctrl UserController) ForgotPassword(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.ForgotPasswordForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := userForm.ForgotPassword(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	err := passwordResetModel.Request(ctx, form)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, utils.Response{Status: http.StatusInternalServerError, Message: "Something went wrong, please try again later"})
+		return
+	}
+
+	//Same answer whether or not the username exists
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "If the account exists, a reset token has been sent"})
+}
+
+// @Summary Reset password api
+// @Schemes
+// @Description Set a new password with a reset token
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/user/password/reset [post]
+// @Param token body string true "Reset token"
+// @Param new_password body string true "New password"
+func (ctrl UserController) ResetPassword(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.ResetPasswordForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := userForm.ResetPassword(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	err := passwordResetModel.Reset(ctx, form)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Password reset successfully, please login again"})
+}
@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WebhookController ...
+type WebhookController struct{}
+
+var webhookModel = new(models.WebhookModel)
+var webhookForm = new(forms.WebhookForm)
+
+// @Summary Create webhook api
+// @Schemes
+// @Description Register an endpoint receiving my wallet events. Deliveries are signed with the returned secret: X-Webhook-Signature is "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">"
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/webhooks [post]
+// @Param url body string true "https URL of the endpoint"
+// @Param events body []string true "wallet.top_up, wallet.withdraw, wallet.transfer, invoice.paid, invoice.refunded, merchant.payout, wallet.interest, wallet.reward, wallet.withdraw_reversed"
+func (ctrl WebhookController) Create(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var form forms.CreateWebhookForm
+	if validationErr := c.ShouldBindJSON(&form); validationErr != nil {
+		message := webhookForm.Create(validationErr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: message})
+		return
+	}
+
+	endpoint, err := webhookModel.Create(ctx, userID, form)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, utils.Response{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	temp, _ := json.Marshal(&endpoint)
+	var result map[string]interface{}
+	json.Unmarshal(temp, &result)
+	result["secret"] = endpoint.Secret
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Webhook created successfully, store the secret now as it won't be shown again", Data: result})
+}
+
+// @Summary Webhooks api
+// @Schemes
+// @Description List my webhook endpoints
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/webhooks [get]
+func (ctrl WebhookController) All(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	endpoints, err := webhookModel.List(ctx, userID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	data := make([]interface{}, len(endpoints))
+	for i, v := range endpoints {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve webhooks successfully", Data: data})
+}
+
+// @Summary Delete webhook api
+// @Schemes
+// @Description Delete one of my webhook endpoints
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/webhooks/{id} [delete]
+// @Param id path string true "Webhook ID"
+func (ctrl WebhookController) Delete(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	endpointID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: models.ErrWebhookNotFound.Error()})
+		return
+	}
+
+	err = webhookModel.Delete(ctx, userID, endpointID)
+	if err == models.ErrWebhookNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, utils.Response{Status: http.StatusInternalServerError, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Webhook deleted successfully"})
+}
+
+// @Summary Webhook deliveries api
+// @Schemes
+// @Description Delivery log of one of my webhook endpoints, use status=DEAD for the dead letters
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.RetrieveResponse "Success"
+// @Router /v1/webhooks/{id}/deliveries [get]
+// @Param id path string true "Webhook ID"
+// @Param status query string false "PENDING, SUCCEEDED or DEAD"
+// @Param page query int false "Page, starting at 1"
+// @Param limit query int false "Deliveries per page"
+func (ctrl WebhookController) Deliveries(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	endpointID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: models.ErrWebhookNotFound.Error()})
+		return
+	}
+
+	page, _ := utils.QueryParamInt(c, "page", 1)
+	limit, _ := utils.QueryParamInt(c, "limit", 20)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	deliveries, err := webhookModel.Deliveries(ctx, userID, endpointID, c.Query("status"), models.Query{Page: page, Limit: limit})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotAcceptable, utils.Response{Status: http.StatusNotAcceptable, Message: err.Error()})
+		return
+	}
+
+	data := make([]interface{}, len(deliveries))
+	for i, v := range deliveries {
+		data[i] = v
+	}
+
+	c.JSON(http.StatusOK, utils.RetrieveResponse{Status: http.StatusOK, Message: "Retrieve deliveries successfully", Data: data})
+}
+
+// @Summary Replay webhook delivery api
+// @Schemes
+// @Description Send a delivery again, including dead letters
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Success 200 {object} utils.Response "Success"
+// @Router /v1/webhooks/{id}/deliveries/{delivery_id}/replay [post]
+// @Param id path string true "Webhook ID"
+// @Param delivery_id path string true "Delivery ID"
+func (ctrl WebhookController) Replay(c *gin.Context) {
+	userID := getUserID(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	endpointID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: models.ErrDeliveryNotFound.Error()})
+		return
+	}
+	deliveryID, err := primitive.ObjectIDFromHex(c.Param("delivery_id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: models.ErrDeliveryNotFound.Error()})
+		return
+	}
+
+	err = webhookModel.Replay(ctx, userID, endpointID, deliveryID)
+	if err == models.ErrDeliveryNotFound {
+		c.AbortWithStatusJSON(http.StatusNotFound, utils.Response{Status: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, utils.Response{Status: http.StatusInternalServerError, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.Response{Status: http.StatusOK, Message: "Delivery scheduled for replay"})
+}
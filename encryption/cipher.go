@@ -0,0 +1,124 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// prefix versions the format of encrypted values: "enc1:<data key ID>:<base64 nonce and AES-256-GCM ciphertext>"
+const prefix = "enc1:"
+
+// ErrInvalidCiphertext is returned for values that are malformed, tampered with or bound to another field
+var ErrInvalidCiphertext = errors.New("encryption: invalid ciphertext")
+
+// DataKey encrypts field values. It is stored wrapped by a master key, see KeyFile.Wrap
+type DataKey struct {
+	ID  string
+	Key []byte
+}
+
+// NewDataKey returns a random AES-256 key, its ID is sortable by creation time
+func NewDataKey() (DataKey, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return DataKey{}, err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return DataKey{}, err
+	}
+
+	return DataKey{ID: time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix), Key: key}, nil
+}
+
+// IsEncrypted tells whether value was returned by Seal. Values written before the encryption are plain
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// KeyID returns the ID of the data key value was encrypted with
+func KeyID(value string) (string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(value, prefix), ":", 2)
+	if !IsEncrypted(value) || len(parts) != 2 || parts[0] == "" {
+		return "", ErrInvalidCiphertext
+	}
+	return parts[0], nil
+}
+
+// Seal encrypts plaintext with the data key. The additional data, like the field and the document it belongs to,
+// is authenticated but not stored: the value can only be opened with the same one, so it can't be moved elsewhere
+func Seal(key DataKey, additionalData string, plaintext string) (string, error) {
+	sealed, err := seal(key.Key, []byte(additionalData), []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return prefix + key.ID + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value returned by Seal with the same data key and additional data
+func Open(key DataKey, additionalData string, value string) (string, error) {
+	id, err := KeyID(value)
+	if err != nil {
+		return "", err
+	}
+	if id != key.ID {
+		return "", ErrInvalidCiphertext
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(value, prefix+id+":"))
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+
+	plaintext, err := open(key.Key, []byte(additionalData), sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// seal returns the random nonce followed by the AES-GCM ciphertext
+func seal(key []byte, additionalData []byte, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key []byte, additionalData []byte, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return plaintext, nil
+}
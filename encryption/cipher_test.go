@@ -0,0 +1,75 @@
+//go:build all
+// +build all
+
+package encryption
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, err := NewDataKey()
+	assert.NoError(t, err)
+	other, err := NewDataKey()
+	assert.NoError(t, err)
+	other.ID = key.ID
+
+	sealed, err := Seal(key, "users:1:name", "John Smith")
+	assert.NoError(t, err)
+	assert.True(t, IsEncrypted(sealed))
+
+	id, err := KeyID(sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, key.ID, id)
+
+	//Flip the last character of the ciphertext
+	last := sealed[len(sealed)-1:]
+	flipped := "A"
+	if last == "A" {
+		flipped = "B"
+	}
+	tampered := sealed[:len(sealed)-1] + flipped
+
+	tests := []struct {
+		name           string
+		key            DataKey
+		additionalData string
+		value          string
+		plaintext      string
+		err            error
+	}{
+		{"same key and additional data", key, "users:1:name", sealed, "John Smith", nil},
+		{"other field", key, "users:1:email", sealed, "", ErrInvalidCiphertext},
+		{"other document", key, "users:2:name", sealed, "", ErrInvalidCiphertext},
+		{"other key with the same ID", other, "users:1:name", sealed, "", ErrInvalidCiphertext},
+		{"other key ID", DataKey{ID: "other", Key: key.Key}, "users:1:name", sealed, "", ErrInvalidCiphertext},
+		{"tampered ciphertext", key, "users:1:name", tampered, "", ErrInvalidCiphertext},
+		{"truncated", key, "users:1:name", prefix + key.ID + ":AAAA", "", ErrInvalidCiphertext},
+		{"not base64", key, "users:1:name", prefix + key.ID + ":!!!", "", ErrInvalidCiphertext},
+		{"plain value", key, "users:1:name", "John Smith", "", ErrInvalidCiphertext},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plaintext, err := Open(test.key, test.additionalData, test.value)
+			assert.Equal(t, test.err, err)
+			assert.Equal(t, test.plaintext, plaintext)
+		})
+	}
+}
+
+func TestSealIsRandomized(t *testing.T) {
+	key, err := NewDataKey()
+	assert.NoError(t, err)
+
+	first, err := Seal(key, "users:1:name", "John Smith")
+	assert.NoError(t, err)
+	second, err := Seal(key, "users:1:name", "John Smith")
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, first, second)
+	assert.False(t, strings.Contains(first, "John"))
+}
@@ -0,0 +1,182 @@
+package encryption
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/utils"
+)
+
+// KeyFile holds the master keys, which wrap the data keys, and the key of the blind indexes.
+// New data keys are wrapped with the Active master key. To rotate it, add a new key to the file and make it active:
+// the data keys are wrapped again by the key rotation job, then the old key can be removed from the file.
+// The index key is never rotated, every blind index would have to be computed again
+type KeyFile struct {
+	Path string
+
+	mu         sync.RWMutex
+	active     string
+	masterKeys map[string][]byte
+	indexKey   []byte
+}
+
+// keyFileContent is the JSON document of the file, keys are base64 encoded
+type keyFileContent struct {
+	Active     string            `json:"active"`
+	MasterKeys map[string][]byte `json:"master_keys"`
+	IndexKey   []byte            `json:"index_key"`
+}
+
+// NewKeyFile ...
+func NewKeyFile(path string) *KeyFile {
+	return &KeyFile{Path: path}
+}
+
+// Load reads the key file. A missing file is an error, never a reason to create one: new keys would leave
+// every encrypted value unreadable and every blind index different. Create it once with GenerateKeyFile
+func (f *KeyFile) Load() error {
+	data, err := ioutil.ReadFile(f.Path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("encryption: the key file %s does not exist, create it once with go run ./cmd/encryptionkeys", f.Path)
+	}
+	if err != nil {
+		return err
+	}
+
+	var content keyFileContent
+	if err = json.Unmarshal(data, &content); err != nil {
+		return fmt.Errorf("encryption: %s: %w", f.Path, err)
+	}
+	if _, ok := content.MasterKeys[content.Active]; !ok {
+		return fmt.Errorf("encryption: %s: the active master key %q is not in the file", f.Path, content.Active)
+	}
+	for id, key := range content.MasterKeys {
+		if len(key) != 32 {
+			return fmt.Errorf("encryption: %s: the master key %q must be 32 bytes", f.Path, id)
+		}
+	}
+	if len(content.IndexKey) != 32 {
+		return fmt.Errorf("encryption: %s: the index key must be 32 bytes", f.Path)
+	}
+
+	f.mu.Lock()
+	f.active = content.Active
+	f.masterKeys = content.MasterKeys
+	f.indexKey = content.IndexKey
+	f.mu.Unlock()
+
+	return nil
+}
+
+// GenerateKeyFile writes a new key file with a master key and an index key, it fails when the file exists
+func GenerateKeyFile(path string) (masterKeyID string, err error) {
+	masterKey := make([]byte, 32)
+	indexKey := make([]byte, 32)
+	suffix := make([]byte, 4)
+	for _, b := range [][]byte{masterKey, indexKey, suffix} {
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+	}
+
+	id := time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+	data, err := json.MarshalIndent(keyFileContent{
+		Active:     id,
+		MasterKeys: map[string][]byte{id: masterKey},
+		IndexKey:   indexKey,
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return "", fmt.Errorf("encryption: the key file %s already exists", path)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err = file.Write(data); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// ActiveMasterKey returns the ID of the master key new data keys are wrapped with
+func (f *KeyFile) ActiveMasterKey() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.active
+}
+
+// Wrap encrypts the data key with the active master key, bound to the ID of the data key
+func (f *KeyFile) Wrap(key DataKey) (masterKeyID string, wrapped []byte, err error) {
+	f.mu.RLock()
+	masterKeyID, masterKey := f.active, f.masterKeys[f.active]
+	f.mu.RUnlock()
+
+	wrapped, err = seal(masterKey, []byte(key.ID), key.Key)
+	return masterKeyID, wrapped, err
+}
+
+// Unwrap decrypts a data key wrapped with the master key masterKeyID
+func (f *KeyFile) Unwrap(masterKeyID string, id string, wrapped []byte) (DataKey, error) {
+	f.mu.RLock()
+	masterKey, ok := f.masterKeys[masterKeyID]
+	f.mu.RUnlock()
+	if !ok {
+		return DataKey{}, fmt.Errorf("encryption: the master key %q of the data key %q is not in the key file", masterKeyID, id)
+	}
+
+	key, err := open(masterKey, []byte(id), wrapped)
+	if err != nil {
+		return DataKey{}, err
+	}
+	return DataKey{ID: id, Key: key}, nil
+}
+
+// BlindIndex returns a deterministic HMAC-SHA256 of the value of a field, so equal values can be looked up
+// without storing them in plain. Empty values have no index
+func (f *KeyFile) BlindIndex(field string, value string) string {
+	if value == "" {
+		return ""
+	}
+
+	f.mu.RLock()
+	mac := hmac.New(sha256.New, f.indexKey)
+	f.mu.RUnlock()
+
+	mac.Write([]byte(field + ":" + value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+var keyFile *KeyFile
+var keyFileOnce sync.Once
+
+// GetKeyFile returns the key file configured from the environment, loading it on first use
+func GetKeyFile() *KeyFile {
+	//The environment is read on first use, once the .env file is loaded
+	keyFileOnce.Do(func() {
+		keyFile = NewKeyFile(utils.GetEnvString("ENCRYPTION_KEY_FILE", "./encryption-keys/keys.json"))
+		if err := keyFile.Load(); err != nil {
+			log.Fatal(err)
+		}
+	})
+	return keyFile
+}
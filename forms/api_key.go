@@ -0,0 +1,83 @@
+package forms
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// APIKeyForm ...
+type APIKeyForm struct{}
+
+// CreateAPIKeyForm ...
+type CreateAPIKeyForm struct {
+	Name   string   `form:"name" json:"name" binding:"required,min=3,max=50"`
+	Scopes []string `form:"scopes" json:"scopes" binding:"required,min=1,dive,scope"` //scope rule is in validator.go
+}
+
+// CreateOAuthClientForm ...
+type CreateOAuthClientForm struct {
+	Name   string   `form:"name" json:"name" binding:"required,min=3,max=50"`
+	Scopes []string `form:"scopes" json:"scopes" binding:"required,min=1,dive,scope"`
+}
+
+// ClientCredentialsForm is the token request of the client-credentials grant, sent form-encoded.
+// The client may authenticate with HTTP Basic instead of client_id/client_secret
+type ClientCredentialsForm struct {
+	GrantType    string `form:"grant_type" binding:"required"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+	Scope        string `form:"scope"`
+}
+
+// Name ...
+func (f APIKeyForm) Name(tag string) (message string) {
+	switch tag {
+	case "required":
+		return "Please enter a name"
+	case "min", "max":
+		return "The name should be between 3 and 50 characters"
+	default:
+		return "Something went wrong, please try again later"
+	}
+}
+
+// Scopes ...
+func (f APIKeyForm) Scopes(tag string) (message string) {
+	switch tag {
+	case "required", "min":
+		return "Please choose at least one scope"
+	case "scope":
+		return "Unknown scope"
+	default:
+		return "Something went wrong, please try again later"
+	}
+}
+
+// Create ...
+func (f APIKeyForm) Create(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			if err.Field() == "Name" {
+				return f.Name(err.Tag())
+			}
+
+			//Errors of the items are reported on "Scopes[i]"
+			if strings.HasPrefix(err.Field(), "Scopes") {
+				return f.Scopes(err.Tag())
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
@@ -0,0 +1,54 @@
+package forms
+
+import (
+	"github.com/go-playground/validator/v10"
+)
+
+// BalanceForm ...
+type BalanceForm struct{}
+
+// BalanceAtForm is read from the query string, At is a unix timestamp or an RFC 3339 time
+type BalanceAtForm struct {
+	At string `form:"at" binding:"omitempty,max=40"`
+}
+
+// BalanceHistoryForm is read from the query string, days are YYYY-MM-DD
+type BalanceHistoryForm struct {
+	From string `form:"from" binding:"omitempty,datetime=2006-01-02"`
+	To   string `form:"to" binding:"omitempty,datetime=2006-01-02"`
+}
+
+// At ...
+func (f BalanceForm) At(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+		for _, err := range err.(validator.ValidationErrors) {
+			if err.Field() == "At" {
+				return "The time must be a unix timestamp or an RFC 3339 time"
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
+
+// History ...
+func (f BalanceForm) History(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+		for _, err := range err.(validator.ValidationErrors) {
+			switch err.Field() {
+			case "From", "To":
+				return "Days must be YYYY-MM-DD"
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
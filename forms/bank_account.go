@@ -0,0 +1,54 @@
+package forms
+
+import (
+	"encoding/json"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// BankAccountForm ...
+type BankAccountForm struct{}
+
+// CreateBankAccountForm saves a bank account to withdraw to. BankCode is the bank identifier (BIC or routing number)
+// and AccountNumber the account number or IBAN
+type CreateBankAccountForm struct {
+	HolderName    string `form:"holder_name" json:"holder_name" binding:"required,max=100"`
+	BankCode      string `form:"bank_code" json:"bank_code" binding:"required,min=3,max=11,alphanum"`
+	AccountNumber string `form:"account_number" json:"account_number" binding:"required,min=6,max=34,alphanum"`
+}
+
+// Create ...
+func (f BankAccountForm) Create(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			switch err.Field() {
+			case "HolderName":
+				if err.Tag() == "required" {
+					return "Please enter the name of the account holder"
+				}
+				return "The name of the account holder should be at most 100 characters"
+			case "BankCode":
+				if err.Tag() == "required" {
+					return "Please enter the bank code"
+				}
+				return "The bank code should be 3 to 11 letters and digits"
+			case "AccountNumber":
+				if err.Tag() == "required" {
+					return "Please enter the account number"
+				}
+				return "The account number should be 6 to 34 letters and digits"
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
@@ -0,0 +1,129 @@
+package forms
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// BatchTransferForm ...
+type BatchTransferForm struct{}
+
+// BatchTransferItemForm is one recipient of a batch, Reference must be unique within the batch
+type BatchTransferItemForm struct {
+	To        string `form:"to" json:"to" binding:"required,min=5,max=5"`
+	Amount    int64  `form:"amount" json:"amount" binding:"required,min=1"`
+	Reference string `form:"reference" json:"reference" binding:"max=64"`
+}
+
+// CreateBatchTransferForm ...
+type CreateBatchTransferForm struct {
+	Mode  string                  `form:"mode" json:"mode" binding:"omitempty,oneof=BEST_EFFORT ALL_OR_NOTHING"`
+	Items []BatchTransferItemForm `form:"items" json:"items" binding:"required,min=1,max=1000,dive"`
+}
+
+// ParseBatchTransferCSV reads the items of a batch from a CSV with a "to,amount,reference" header,
+// the reference column is optional
+func ParseBatchTransferCSV(r io.Reader) (items []BatchTransferItemForm, err error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return items, errors.New("The CSV is empty")
+	}
+	if err != nil {
+		return items, errors.New("The CSV is malformed")
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	toColumn, hasTo := columns["to"]
+	amountColumn, hasAmount := columns["amount"]
+	referenceColumn, hasReference := columns["reference"]
+	if !hasTo || !hasAmount {
+		return items, errors.New("The CSV header must contain the to and amount columns")
+	}
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return items, fmt.Errorf("The CSV is malformed at line %d", line)
+		}
+
+		amount, err := strconv.ParseInt(strings.TrimSpace(record[amountColumn]), 10, 64)
+		if err != nil {
+			return items, fmt.Errorf("The amount at line %d is not a number", line)
+		}
+
+		item := BatchTransferItemForm{To: strings.TrimSpace(record[toColumn]), Amount: amount}
+		if hasReference {
+			item.Reference = strings.TrimSpace(record[referenceColumn])
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// Items ...
+func (f BatchTransferForm) Items(tag string) (message string) {
+	switch tag {
+	case "required", "min":
+		return "Please enter at least one item"
+	case "max":
+		return "A batch can have at most 1000 items"
+	default:
+		return "Something went wrong, please try again later"
+	}
+}
+
+// Create ...
+func (f BatchTransferForm) Create(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			//Item errors are reported as "Items[3].To", tell which item is wrong
+			item := ""
+			if start := strings.Index(err.Namespace(), "Items["); start >= 0 {
+				if end := strings.Index(err.Namespace()[start:], "]"); end >= 0 {
+					item = "Item " + err.Namespace()[start+len("Items["):start+end] + ": "
+				}
+			}
+
+			switch err.Field() {
+			case "Mode":
+				return "The mode must be BEST_EFFORT or ALL_OR_NOTHING"
+			case "Items":
+				return f.Items(err.Tag())
+			case "To":
+				return item + transactionForm.To(err.Tag())
+			case "Amount":
+				return item + transactionForm.Amount(err.Tag())
+			case "Reference":
+				return item + "The reference should be at most 64 characters"
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
@@ -0,0 +1,90 @@
+package forms
+
+import (
+	"encoding/json"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// BeneficiaryForm ...
+type BeneficiaryForm struct{}
+
+// CreateBeneficiaryForm ...
+type CreateBeneficiaryForm struct {
+	Username string `form:"username" json:"username" binding:"required,min=5,max=5"`
+	Nickname string `form:"nickname" json:"nickname" binding:"omitempty,max=50"`
+}
+
+// BeneficiarySettingsForm turns the restriction of transfers to saved beneficiaries on or off
+type BeneficiarySettingsForm struct {
+	BeneficiariesOnly *bool `form:"beneficiaries_only" json:"beneficiaries_only" binding:"required"`
+}
+
+// LookupForm is read from the query string
+type LookupForm struct {
+	Username string `form:"username" binding:"required,min=5,max=5"`
+}
+
+// Create ...
+func (f BeneficiaryForm) Create(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			switch err.Field() {
+			case "Username":
+				return transactionForm.To(err.Tag(), "Please enter the username of the beneficiary")
+			case "Nickname":
+				return "The nickname should be at most 50 characters"
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
+
+// Settings ...
+func (f BeneficiaryForm) Settings(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			if err.Field() == "BeneficiariesOnly" {
+				return "Please choose whether transfers are restricted to your beneficiaries"
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
+
+// Lookup ...
+func (f BeneficiaryForm) Lookup(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+		for _, err := range err.(validator.ValidationErrors) {
+			if err.Field() == "Username" {
+				return transactionForm.To(err.Tag(), "Please enter the username to look up")
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
@@ -0,0 +1,88 @@
+package forms
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// BillForm ...
+type BillForm struct{}
+
+// BillParticipantForm is one share of a bill: Percentage is used by PERCENTAGE splits, Amount by EXACT splits.
+// The creator may list themselves to take a share, it is paid from the start
+type BillParticipantForm struct {
+	Username   string  `form:"username" json:"username" binding:"required,min=5,max=5"`
+	Percentage float64 `form:"percentage" json:"percentage" binding:"omitempty,gt=0,lte=100"`
+	Amount     int64   `form:"amount" json:"amount" binding:"omitempty,min=1"`
+}
+
+// CreateBillForm ...
+type CreateBillForm struct {
+	Title          string                `form:"title" json:"title" binding:"required,max=100"`
+	Total          int64                 `form:"total" json:"total" binding:"required,min=1,max=1000000000000"`
+	Split          string                `form:"split" json:"split" binding:"required,oneof=EQUAL PERCENTAGE EXACT"`
+	Participants   []BillParticipantForm `form:"participants" json:"participants" binding:"required,min=1,max=50,dive"`
+	ExpiresInHours int                   `form:"expires_in_hours" json:"expires_in_hours" binding:"omitempty,min=1,max=720"`
+}
+
+// Participants ...
+func (f BillForm) Participants(field string, tag string) (message string) {
+	switch field {
+	case "Username":
+		return transactionForm.To(tag, "Please enter the username of every participant")
+	case "Percentage":
+		return "The percentage of a participant must be between 0 and 100"
+	case "Amount":
+		return "The amount of a participant must be greater than 0"
+	}
+
+	switch tag {
+	case "required", "min":
+		return "Please enter at least one participant"
+	case "max":
+		return "A bill can have at most 50 participants"
+	default:
+		return "Something went wrong, please try again later"
+	}
+}
+
+// Create ...
+func (f BillForm) Create(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			if strings.Contains(err.Namespace(), "Participants") {
+				return f.Participants(err.Field(), err.Tag())
+			}
+
+			switch err.Field() {
+			case "Title":
+				if err.Tag() == "required" {
+					return "Please enter the title of the bill"
+				}
+				return "The title should be at most 100 characters"
+			case "Total":
+				if err.Tag() == "max" {
+					return "The total of the bill should be at most 1000000000000"
+				}
+				return transactionForm.Amount(err.Tag())
+			case "Split":
+				return "The split must be EQUAL, PERCENTAGE or EXACT"
+			case "ExpiresInHours":
+				return "The expiry should be between 1 and 720 hours"
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
@@ -0,0 +1,89 @@
+package forms
+
+import (
+	"encoding/json"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// KYCForm ...
+type KYCForm struct{}
+
+// UploadDocumentForm is sent as multipart/form-data with the document in the file field
+type UploadDocumentForm struct {
+	Type string `form:"type" binding:"required,oneof=id_front id_back selfie proof_of_address"`
+}
+
+// SubmitKYCForm asks for the review of the documents needed for Level
+type SubmitKYCForm struct {
+	Level int `form:"level" json:"level" binding:"required,min=1,max=2"`
+}
+
+// RejectKYCForm ...
+type RejectKYCForm struct {
+	Reason string `form:"reason" json:"reason" binding:"required,min=3,max=500"`
+}
+
+// Upload ...
+func (f KYCForm) Upload(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+		for _, err := range err.(validator.ValidationErrors) {
+			if err.Field() == "Type" {
+				return "The type must be one of id_front, id_back, selfie or proof_of_address"
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
+
+// Submit ...
+func (f KYCForm) Submit(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			if err.Field() == "Level" {
+				return "The level must be 1 or 2"
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
+
+// Reject ...
+func (f KYCForm) Reject(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			if err.Field() == "Reason" {
+				if err.Tag() == "required" {
+					return "Please enter the reason of the rejection"
+				}
+				return "The reason should have between 3 and 500 characters"
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
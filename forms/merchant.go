@@ -0,0 +1,117 @@
+package forms
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// MerchantForm ...
+type MerchantForm struct{}
+
+// CreateMerchantForm ...
+type CreateMerchantForm struct {
+	Name string `form:"name" json:"name" binding:"required,min=2,max=100"`
+}
+
+// InvoiceItemForm is one line of an invoice, its amount is Quantity * UnitPrice
+type InvoiceItemForm struct {
+	Description string `form:"description" json:"description" binding:"required,max=200"`
+	Quantity    int64  `form:"quantity" json:"quantity" binding:"required,min=1,max=10000"`
+	UnitPrice   int64  `form:"unit_price" json:"unit_price" binding:"required,min=1,max=1000000000"`
+}
+
+// CreateInvoiceForm ...
+type CreateInvoiceForm struct {
+	Items          []InvoiceItemForm `form:"items" json:"items" binding:"required,min=1,max=100,dive"`
+	Memo           string            `form:"memo" json:"memo" binding:"max=140"`
+	ExpiresInHours int               `form:"expires_in_hours" json:"expires_in_hours" binding:"omitempty,min=1,max=8760"`
+}
+
+// Name ...
+func (f MerchantForm) Name(tag string) (message string) {
+	switch tag {
+	case "required":
+		return "Please enter the name of the merchant"
+	case "min", "max":
+		return "The name should be between 2 and 100 characters"
+	default:
+		return "Something went wrong, please try again later"
+	}
+}
+
+// Items ...
+func (f MerchantForm) Items(field string, tag string) (message string) {
+	switch field {
+	case "Description":
+		if tag == "required" {
+			return "Please enter the description of every item"
+		}
+		return "The description of an item should be at most 200 characters"
+	case "Quantity":
+		return "The quantity of an item should be between 1 and 10000"
+	case "UnitPrice":
+		return "The unit price of an item should be between 1 and 1000000000"
+	}
+
+	switch tag {
+	case "required", "min":
+		return "Please enter at least one item"
+	case "max":
+		return "An invoice can have at most 100 items"
+	default:
+		return "Something went wrong, please try again later"
+	}
+}
+
+// Create ...
+func (f MerchantForm) Create(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			if err.Field() == "Name" {
+				return f.Name(err.Tag())
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
+
+// CreateInvoice ...
+func (f MerchantForm) CreateInvoice(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			if strings.Contains(err.Namespace(), "Items") {
+				return f.Items(err.Field(), err.Tag())
+			}
+
+			switch err.Field() {
+			case "Memo":
+				return "The memo should be at most 140 characters"
+			case "ExpiresInHours":
+				return "The expiry should be between 1 and 8760 hours"
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
@@ -0,0 +1,52 @@
+package forms
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// NotificationForm ...
+type NotificationForm struct{}
+
+// UpdateNotificationPreferencesForm turns channels on or off by notification type, the ones left out are kept
+type UpdateNotificationPreferencesForm struct {
+	Types map[string]map[string]bool `form:"types" json:"types" binding:"required,min=1,dive,keys,notificationType,endkeys,required,dive,keys,notificationChannel,endkeys"` //notificationType and notificationChannel rules are in validator.go
+}
+
+// Types ...
+func (f NotificationForm) Types(tag string) (message string) {
+	switch tag {
+	case "required", "min":
+		return "Please choose the notifications to change"
+	case "notificationType":
+		return "Unknown notification type"
+	case "notificationChannel":
+		return "Unknown notification channel"
+	default:
+		return "Something went wrong, please try again later"
+	}
+}
+
+// UpdatePreferences ...
+func (f NotificationForm) UpdatePreferences(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			if strings.HasPrefix(err.Field(), "Types") {
+				return f.Types(err.Tag())
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
@@ -0,0 +1,49 @@
+package forms
+
+import (
+	"encoding/json"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// PaymentRequestForm ...
+type PaymentRequestForm struct{}
+
+// CreatePaymentRequestForm ...
+type CreatePaymentRequestForm struct {
+	To             string `form:"to" json:"to" binding:"required,min=5,max=5"`
+	Amount         int64  `form:"amount" json:"amount" binding:"required,min=1"`
+	Memo           string `form:"memo" json:"memo" binding:"max=140"`
+	ExpiresInHours int    `form:"expires_in_hours" json:"expires_in_hours" binding:"omitempty,min=1,max=720"`
+}
+
+// Create ...
+func (f PaymentRequestForm) Create(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			switch err.Field() {
+			case "To":
+				return transactionForm.To(err.Tag())
+			case "Amount":
+				return transactionForm.Amount(err.Tag())
+			case "Memo":
+				return "The memo should be at most 140 characters"
+			case "ExpiresInHours":
+				return "The expiry should be between 1 and 720 hours"
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
+
+var transactionForm = new(TransactionForm)
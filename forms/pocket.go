@@ -0,0 +1,74 @@
+package forms
+
+import (
+	"encoding/json"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// PocketForm ...
+type PocketForm struct{}
+
+// CreatePocketForm ...
+type CreatePocketForm struct {
+	Name       string `form:"name" json:"name" binding:"required,min=1,max=50"`
+	Goal       int64  `form:"goal" json:"goal" binding:"omitempty,min=1"`
+	TargetDate string `form:"target_date" json:"target_date" binding:"omitempty,datetime=2006-01-02"`
+}
+
+// PocketMoveForm moves Amount between a pocket and the main balance
+type PocketMoveForm struct {
+	Amount int64 `form:"amount" json:"amount" binding:"required,min=1"`
+}
+
+// Create ...
+func (f PocketForm) Create(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			switch err.Field() {
+			case "Name":
+				if err.Tag() == "required" {
+					return "Please enter the name of the pocket"
+				}
+				return "The name should be at most 50 characters"
+			case "Goal":
+				return "The goal must be greater than 0"
+			case "TargetDate":
+				return "The target date must be YYYY-MM-DD"
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
+
+// Move ...
+func (f PocketForm) Move(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			if err.Field() == "Amount" {
+				return transactionForm.Amount(err.Tag())
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
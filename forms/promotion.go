@@ -0,0 +1,134 @@
+package forms
+
+import (
+	"encoding/json"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// PromotionForm ...
+type PromotionForm struct{}
+
+// CreateVoucherForm creates a voucher worth Amount. MaxRedemptions caps its uses in total, 1 (single-use) by default,
+// and PerUserLimit caps the uses of one user, 1 by default. The code is generated when empty
+type CreateVoucherForm struct {
+	Code           string `form:"code" json:"code" binding:"omitempty,min=4,max=32,alphanum"`
+	Amount         int64  `form:"amount" json:"amount" binding:"required,min=1"`
+	MaxRedemptions int64  `form:"max_redemptions" json:"max_redemptions" binding:"omitempty,min=1"`
+	PerUserLimit   int64  `form:"per_user_limit" json:"per_user_limit" binding:"omitempty,min=1"`
+	ExpiresInHours int    `form:"expires_in_hours" json:"expires_in_hours" binding:"omitempty,min=1,max=8760"`
+}
+
+// RedeemVoucherForm ...
+type RedeemVoucherForm struct {
+	Code string `form:"code" json:"code" binding:"required,max=32"`
+}
+
+// CreateCashbackRuleForm gives back Percentage of the qualifying payments of at least MinAmount, at most MaxCashback each.
+// A user gets at most MaxPerUser from the rule, and MaxPerUserDaily in 24 hours
+type CreateCashbackRuleForm struct {
+	Name            string  `form:"name" json:"name" binding:"required,max=50"`
+	Type            string  `form:"type" json:"type" binding:"required,oneof=TRANSFER INVOICE_PAYMENT"`
+	Percentage      float64 `form:"percentage" json:"percentage" binding:"required,gt=0,lte=100"`
+	MinAmount       int64   `form:"min_amount" json:"min_amount" binding:"omitempty,min=1"`
+	MaxCashback     int64   `form:"max_cashback" json:"max_cashback" binding:"omitempty,min=1"`
+	MaxPerUser      int64   `form:"max_per_user" json:"max_per_user" binding:"omitempty,min=1"`
+	MaxPerUserDaily int64   `form:"max_per_user_daily" json:"max_per_user_daily" binding:"omitempty,min=1"`
+	ExpiresInHours  int     `form:"expires_in_hours" json:"expires_in_hours" binding:"omitempty,min=1,max=8760"`
+}
+
+// CreateVoucher ...
+func (f PromotionForm) CreateVoucher(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			switch err.Field() {
+			case "Code":
+				return "The code should be 4 to 32 letters and digits"
+			case "Amount":
+				return transactionForm.Amount(err.Tag())
+			case "MaxRedemptions":
+				return "The maximum number of redemptions must be greater than 0"
+			case "PerUserLimit":
+				return "The limit per user must be greater than 0"
+			case "ExpiresInHours":
+				return "The expiry should be between 1 and 8760 hours"
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
+
+// Redeem ...
+func (f PromotionForm) Redeem(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			if err.Field() == "Code" {
+				if err.Tag() == "required" {
+					return "Please enter the voucher code"
+				}
+				return "Invalid voucher code"
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
+
+// CreateCashbackRule ...
+func (f PromotionForm) CreateCashbackRule(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			switch err.Field() {
+			case "Name":
+				if err.Tag() == "required" {
+					return "Please enter the name of the rule"
+				}
+				return "The name should be at most 50 characters"
+			case "Type":
+				return "The type must be TRANSFER or INVOICE_PAYMENT"
+			case "Percentage":
+				return "The percentage must be between 0 and 100"
+			case "MinAmount":
+				return "The minimum amount must be greater than 0"
+			case "MaxCashback":
+				return "The maximum cashback must be greater than 0"
+			case "MaxPerUser":
+				return "The maximum cashback per user must be greater than 0"
+			case "MaxPerUserDaily":
+				return "The maximum daily cashback per user must be greater than 0"
+			case "ExpiresInHours":
+				return "The expiry should be between 1 and 8760 hours"
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
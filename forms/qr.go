@@ -0,0 +1,75 @@
+package forms
+
+import (
+	"encoding/json"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// QRForm ...
+type QRForm struct{}
+
+// CreateQRForm describes a code to be paid to me, without amount the payer chooses it
+type CreateQRForm struct {
+	Amount           int64  `form:"amount" json:"amount" binding:"omitempty,min=1"`
+	Reference        string `form:"reference" json:"reference" binding:"max=64"`
+	ExpiresInMinutes int    `form:"expires_in_minutes" json:"expires_in_minutes" binding:"omitempty,min=1,max=1440"`
+}
+
+// PayQRForm ...
+type PayQRForm struct {
+	Payload string `form:"payload" json:"payload" binding:"required,max=512"`
+	Amount  int64  `form:"amount" json:"amount" binding:"omitempty,min=1"`
+}
+
+// Create ...
+func (f QRForm) Create(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			switch err.Field() {
+			case "Amount":
+				return transactionForm.Amount(err.Tag())
+			case "Reference":
+				return "The reference should be at most 64 characters"
+			case "ExpiresInMinutes":
+				return "The expiry should be between 1 and 1440 minutes"
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
+
+// Pay ...
+func (f QRForm) Pay(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			switch err.Field() {
+			case "Payload":
+				return "Please scan a valid QR code"
+			case "Amount":
+				return transactionForm.Amount(err.Tag())
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
@@ -3,6 +3,7 @@ package forms
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
 )
@@ -15,6 +16,9 @@ type CreateTransactionForm struct {
 	Amount    int64  `from:"amount" json:"amount,omitempty" binding:"required,min=0"`
 	Balance   int64  `from:"balance" json:"balance,omitempty" binding:"required,min=0"`
 	Type      string `form:"type" json:"type,omitempty" binding:"required"`
+	Reference string `form:"reference" json:"reference,omitempty"`
+	PocketID  string `form:"pocket_id" json:"pocket_id,omitempty"`
+	Memo      string `form:"memo" json:"memo,omitempty"`
 	CreatedAt int64  `form:"created_at" json:"created_at,omitempty"`
 	UpdatedAt int64  `form:"updated_at" json:"updated_at,omitempty"`
 }
@@ -22,6 +26,23 @@ type CreateTransactionForm struct {
 type TransferForm struct {
 	To string `form:"to" json:"to,omitempty"`
 	Amount int64 `form:"amount" json:"amount,omitempty" binding:"required,min=0"`
+	Pocket string `form:"pocket" json:"pocket,omitempty" binding:"omitempty,len=24,hexadecimal"` //Draw from this pocket instead of the main balance
+	Memo string `form:"memo" json:"memo,omitempty" binding:"omitempty,max=140"`
+}
+
+// UpdateTransactionForm edits the labels of a transaction, fields left out are kept and an empty tags list clears them.
+// Only the sender can edit the memo
+type UpdateTransactionForm struct {
+	Memo     *string  `form:"memo" json:"memo" binding:"omitempty,max=140"`
+	Category string   `form:"category" json:"category" binding:"omitempty,category"` //category rule is in validator.go
+	Tags     []string `form:"tags" json:"tags" binding:"omitempty,max=10,dive,min=1,max=30"`
+}
+
+// AnalyticsForm is read from the query string, months are YYYY-MM
+type AnalyticsForm struct {
+	From string `form:"from" binding:"omitempty,datetime=2006-01"`
+	To   string `form:"to" binding:"omitempty,datetime=2006-01"`
+	Tag  string `form:"tag" binding:"omitempty,max=30"`
 }
 
 func (f TransactionForm) From(tag string, errMsg ...string) string {
@@ -118,6 +139,14 @@ func (f TransactionForm) Transfer(err error) string {
 				return f.To(err.Tag())
 			}
 
+			if err.Field() == "Pocket" {
+				return "Pocket not found"
+			}
+
+			if err.Field() == "Memo" {
+				return "The memo should be at most 140 characters"
+			}
+
 			// if err.Field() == "Type" {
 			// 	return f.Type(err.Tag())
 			// }
@@ -130,3 +159,55 @@ func (f TransactionForm) Transfer(err error) string {
 	return "Something went wrong, please try again later"
 }
 
+
+// Update ...
+func (f TransactionForm) Update(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			switch err.Field() {
+			case "Memo":
+				return "The memo should be at most 140 characters"
+			case "Category":
+				return "Unknown category"
+			case "Tags":
+				return "A transaction can have at most 10 tags"
+			default:
+				//Errors of a single tag are on Tags[i]
+				if strings.HasPrefix(err.Field(), "Tags[") {
+					return "A tag should have between 1 and 30 characters"
+				}
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
+
+// Analytics ...
+func (f TransactionForm) Analytics(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+		for _, err := range err.(validator.ValidationErrors) {
+			switch err.Field() {
+			case "From", "To":
+				return "Months must be YYYY-MM"
+			case "Tag":
+				return "A tag should have at most 30 characters"
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
@@ -12,15 +12,59 @@ type UserForm struct{}
 
 // LoginForm ...
 type LoginForm struct {
-	Username string `form:"username" json:"username" binding:"required,min=5,max=5"`
-	Password string `form:"password" json:"password" binding:"required,min=3,max=50"`
+	Username   string `form:"username" json:"username" binding:"required,min=5,max=5"`
+	Password   string `form:"password" json:"password" binding:"required,min=3,max=72"`
+	DeviceName string `form:"device_name" json:"device_name" binding:"max=100"`
 }
 
 // RegisterForm ...
 type RegisterForm struct {
 	Name     string `form:"name" json:"name" binding:"required,min=3,max=20,fullName"` //fullName rule is in validator.go
 	Username string `form:"username" json:"username" binding:"required,min=5,max=5"`
-	Password string `form:"password" json:"password" binding:"required,min=3,max=50"`
+	Password string `form:"password" json:"password" binding:"required,min=8,max=72,strongPassword"` //strongPassword rule is in validator.go
+}
+
+// ChangePasswordForm ...
+type ChangePasswordForm struct {
+	OldPassword string `form:"old_password" json:"old_password" binding:"required,min=3,max=72"`
+	NewPassword string `form:"new_password" json:"new_password" binding:"required,min=8,max=72,strongPassword,nefield=OldPassword"`
+}
+
+// ForgotPasswordForm ...
+type ForgotPasswordForm struct {
+	Username string `form:"username" json:"username" binding:"required,min=5,max=5"`
+}
+
+// ResetPasswordForm ...
+type ResetPasswordForm struct {
+	Token       string `form:"token" json:"token" binding:"required"`
+	NewPassword string `form:"new_password" json:"new_password" binding:"required,min=8,max=72,strongPassword"`
+}
+
+// UpdateProfileForm changes the fields that are sent, a new email or phone has to be verified again
+type UpdateProfileForm struct {
+	Name      *string `form:"name" json:"name" binding:"omitempty,min=3,max=20,fullName"`
+	Email     *string `form:"email" json:"email" binding:"omitempty,max=254,email"`
+	Phone     *string `form:"phone" json:"phone" binding:"omitempty,e164"`
+	AvatarURL *string `form:"avatar_url" json:"avatar_url" binding:"omitempty,max=2048,url,startswith=https://"`
+	Locale    *string `form:"locale" json:"locale" binding:"omitempty,max=35,bcp47_language_tag"`
+	Timezone  *string `form:"timezone" json:"timezone" binding:"omitempty,max=64,timezone"`
+}
+
+// StartVerificationForm sends a code to the email or the phone of the profile
+type StartVerificationForm struct {
+	Channel string `form:"channel" json:"channel" binding:"required,oneof=email phone"`
+}
+
+// ConfirmVerificationForm ...
+type ConfirmVerificationForm struct {
+	Channel string `form:"channel" json:"channel" binding:"required,oneof=email phone"`
+	Code    string `form:"code" json:"code" binding:"required,len=6,numeric"`
+}
+
+// EraseAccountForm confirms the erasure of the account with its password
+type EraseAccountForm struct {
+	Password string `form:"password" json:"password" binding:"required,min=3,max=72"`
 }
 
 type TopUpForm struct {
@@ -28,7 +72,9 @@ type TopUpForm struct {
 }
 
 type WithDrawForm struct {
-	Amount int64 `form:"amount" json:"amount" binding:"min=0,required"`
+	Amount      int64  `form:"amount" json:"amount" binding:"min=0,required"`
+	BankAccount string `form:"bank_account_id" json:"bank_account_id" binding:"required,len=24,hexadecimal"` //One of the saved bank accounts of the user
+	Pocket      string `form:"pocket" json:"pocket,omitempty" binding:"omitempty,len=24,hexadecimal"`        //Draw from this pocket instead of the main balance
 }
 
 // Name ...
@@ -69,7 +115,7 @@ func (f UserForm) Password(tag string) (message string) {
 	case "required":
 		return "Please enter your password"
 	case "min", "max":
-		return "Your password should be between 3 and 50 characters"
+		return "Your password should be between 3 and 72 characters"
 	case "eqfield":
 		return "Your passwords does not match"
 	default:
@@ -77,6 +123,22 @@ func (f UserForm) Password(tag string) (message string) {
 	}
 }
 
+// NewPassword ...
+func (f UserForm) NewPassword(tag string) (message string) {
+	switch tag {
+	case "required":
+		return "Please enter your new password"
+	case "min", "max":
+		return "Your password should be between 8 and 72 characters"
+	case "strongPassword":
+		return "Your password should contain at least 3 of: lowercase letters, uppercase letters, numbers and symbols"
+	case "nefield":
+		return "Your new password must be different from the old one"
+	default:
+		return "Something went wrong, please try again later"
+	}
+}
+
 // Signin ...
 func (f UserForm) Login(err error) string {
 	switch err.(type) {
@@ -93,6 +155,9 @@ func (f UserForm) Login(err error) string {
 			if err.Field() == "Password" {
 				return f.Password(err.Tag())
 			}
+			if err.Field() == "DeviceName" {
+				return "The device name should be at most 100 characters"
+			}
 		}
 
 	default:
@@ -121,7 +186,7 @@ func (f UserForm) Register(err error) string {
 			}
 
 			if err.Field() == "Password" {
-				return f.Password(err.Tag())
+				return f.NewPassword(err.Tag())
 			}
 
 		}
@@ -182,6 +247,17 @@ func (f UserForm) WithDraw(err error) string {
 			if err.Field() == "Amount" {
 				return f.Amount(err.Tag())
 			}
+
+			if err.Field() == "Pocket" {
+				return "Pocket not found"
+			}
+
+			if err.Field() == "BankAccount" {
+				if err.Tag() == "required" {
+					return "Please choose the bank account to withdraw to"
+				}
+				return "Bank account not found"
+			}
 		}
 
 	default:
@@ -191,3 +267,156 @@ func (f UserForm) WithDraw(err error) string {
 	return "Something went wrong, please try again later"
 }
 
+// ChangePassword ...
+func (f UserForm) ChangePassword(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			if err.Field() == "OldPassword" {
+				return f.Password(err.Tag())
+			}
+
+			if err.Field() == "NewPassword" {
+				return f.NewPassword(err.Tag())
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
+
+// ForgotPassword ...
+func (f UserForm) ForgotPassword(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			if err.Field() == "Username" {
+				return f.Username(err.Tag())
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
+
+// ResetPassword ...
+func (f UserForm) ResetPassword(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			if err.Field() == "Token" {
+				return "Please enter the reset token"
+			}
+
+			if err.Field() == "NewPassword" {
+				return f.NewPassword(err.Tag())
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
+
+// UpdateProfile ...
+func (f UserForm) UpdateProfile(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			switch err.Field() {
+			case "Name":
+				return f.Name(err.Tag())
+			case "Email":
+				return "Please enter a valid email"
+			case "Phone":
+				return "Please enter the phone number in international format, e.g. +14155550123"
+			case "AvatarURL":
+				return "The avatar must be a valid https URL"
+			case "Locale":
+				return "The locale must be a language tag, e.g. en-US"
+			case "Timezone":
+				return "The timezone must be an IANA time zone, e.g. Europe/Paris"
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
+
+// Verification ...
+func (f UserForm) Verification(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			switch err.Field() {
+			case "Channel":
+				return "The channel must be email or phone"
+			case "Code":
+				return "The code has 6 digits"
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
+
+// EraseAccount ...
+func (f UserForm) EraseAccount(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			if err.Field() == "Password" {
+				return f.Password(err.Tag())
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
@@ -1,11 +1,17 @@
 package forms
 
 import (
+	"context"
+	"net/url"
+	"os"
 	"reflect"
 	"regexp"
 	"strings"
 	"sync"
+	"time"
+	"unicode"
 
+	"github.com/Massad/gin-boilerplate/utils"
 	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
 )
@@ -49,6 +55,23 @@ func (v *DefaultValidator) lazyinit() {
 
 		//Custom rule for user full name
 		v.validate.RegisterValidation("fullName", ValidateFullName)
+
+		//Custom rule for new passwords
+		v.validate.RegisterValidation("strongPassword", ValidateStrongPassword)
+
+		//Custom rule for API key and OAuth client scopes
+		v.validate.RegisterValidation("scope", ValidateScope)
+
+		//Custom rules for webhooks
+		v.validate.RegisterValidation("event", ValidateEvent)
+		v.validate.RegisterValidation("webhookURL", ValidateWebhookURL)
+
+		//Custom rule for transaction categories
+		v.validate.RegisterValidation("category", ValidateCategory)
+
+		//Custom rules for notification preferences
+		v.validate.RegisterValidation("notificationType", ValidateNotificationType)
+		v.validate.RegisterValidation("notificationChannel", ValidateNotificationChannel)
 	})
 }
 
@@ -76,3 +99,85 @@ func ValidateFullName(fl validator.FieldLevel) bool {
 	matched, _ := regexp.Match(`^[^±!@£$%^&*_+§¡€#¢§¶•ªº«\\/<>?:;'"|=.,0123456789]{3,20}$`, []byte(name))
 	return matched
 }
+
+//ValidateStrongPassword implements validator.Func
+//A new password needs at least 3 of: lowercase, uppercase, digit, symbol, and must not be a single repeated character
+func ValidateStrongPassword(fl validator.FieldLevel) bool {
+	password := fl.Field().String()
+
+	var lower, upper, digit, symbol bool
+	var first rune
+	repeated := true
+	for i, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsSpace(r):
+		default:
+			symbol = true
+		}
+		if i == 0 {
+			first = r
+		} else if r != first {
+			repeated = false
+		}
+	}
+
+	classes := 0
+	for _, ok := range []bool{lower, upper, digit, symbol} {
+		if ok {
+			classes++
+		}
+	}
+
+	return classes >= 3 && !repeated
+}
+
+//ValidateScope implements validator.Func
+func ValidateScope(fl validator.FieldLevel) bool {
+	return utils.IsValidScope(fl.Field().String())
+}
+
+//ValidateEvent implements validator.Func
+func ValidateEvent(fl validator.FieldLevel) bool {
+	return utils.IsValidEvent(fl.Field().String())
+}
+
+//ValidateCategory implements validator.Func
+func ValidateCategory(fl validator.FieldLevel) bool {
+	return utils.IsValidCategory(fl.Field().String())
+}
+
+//ValidateNotificationType implements validator.Func
+func ValidateNotificationType(fl validator.FieldLevel) bool {
+	return utils.IsValidNotificationType(fl.Field().String())
+}
+
+//ValidateNotificationChannel implements validator.Func
+func ValidateNotificationChannel(fl validator.FieldLevel) bool {
+	return utils.IsValidNotificationChannel(fl.Field().String())
+}
+
+//ValidateWebhookURL implements validator.Func
+//Webhooks must use https, plain http is only accepted outside of production for local testing
+//The host must resolve to public addresses only, the delivery checks them again when it connects
+func ValidateWebhookURL(fl validator.FieldLevel) bool {
+	u, err := url.Parse(fl.Field().String())
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if u.Scheme != "https" && (u.Scheme != "http" || os.Getenv("ENV") == "PRODUCTION") {
+		return false
+	}
+	if !utils.PublicHostsOnly() {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return utils.CheckPublicHost(ctx, u.Hostname()) == nil
+}
@@ -0,0 +1,39 @@
+//go:build all
+// +build all
+
+package forms
+
+import (
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateStrongPassword(t *testing.T) {
+	validate := validator.New()
+	validate.RegisterValidation("strongPassword", ValidateStrongPassword)
+
+	tests := []struct {
+		password string
+		valid    bool
+	}{
+		{"Password1", true},
+		{"password1!", true},
+		{"PASSWORD1!", true},
+		{"Pass word!", true},
+		{"password", false},
+		{"password1", false},
+		{"PASSWORD!", false},
+		{"12345678", false},
+		{"aaaaaaaa", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.password, func(t *testing.T) {
+			err := validate.Var(test.password, "strongPassword")
+			assert.Equal(t, test.valid, err == nil)
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package forms
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// WebhookForm ...
+type WebhookForm struct{}
+
+// CreateWebhookForm ...
+type CreateWebhookForm struct {
+	URL    string   `form:"url" json:"url" binding:"required,max=2048,webhookURL"` //webhookURL rule is in validator.go
+	Events []string `form:"events" json:"events" binding:"required,min=1,dive,event"`
+}
+
+// URL ...
+func (f WebhookForm) URL(tag string) (message string) {
+	switch tag {
+	case "required":
+		return "Please enter the URL of the endpoint"
+	case "max":
+		return "The URL is too long"
+	case "webhookURL":
+		return "The URL must be a valid https URL of a public host"
+	default:
+		return "Something went wrong, please try again later"
+	}
+}
+
+// Events ...
+func (f WebhookForm) Events(tag string) (message string) {
+	switch tag {
+	case "required", "min":
+		return "Please choose at least one event"
+	case "event":
+		return "Unknown event type"
+	default:
+		return "Something went wrong, please try again later"
+	}
+}
+
+// Create ...
+func (f WebhookForm) Create(err error) string {
+	switch err.(type) {
+	case validator.ValidationErrors:
+
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return "Something went wrong, please try again later"
+		}
+
+		for _, err := range err.(validator.ValidationErrors) {
+			if err.Field() == "URL" {
+				return f.URL(err.Tag())
+			}
+
+			if strings.HasPrefix(err.Field(), "Events") {
+				return f.Events(err.Tag())
+			}
+		}
+
+	default:
+		return "Invalid request"
+	}
+
+	return "Something went wrong, please try again later"
+}
@@ -0,0 +1,19 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+)
+
+var balanceModel = new(models.BalanceModel)
+
+// SnapshotBalances records the end of day balances of the last day that is over since BALANCE_SNAPSHOT_DELAY,
+// so that no transaction of the day is still to commit. Users already done are skipped
+func SnapshotBalances(ctx context.Context) error {
+	day := time.Now().UTC().Add(-utils.GetEnvDuration("BALANCE_SNAPSHOT_DELAY", 5*time.Minute)).AddDate(0, 0, -1)
+
+	return balanceModel.SnapshotDay(ctx, day.Format("2006-01-02"))
+}
@@ -0,0 +1,22 @@
+package jobs
+
+import (
+	"context"
+
+	"github.com/Massad/gin-boilerplate/models"
+)
+
+var batchTransferModel = new(models.BatchTransferModel)
+
+// ProcessBatchTransfers executes the pending items of BEST_EFFORT batches until there is none left
+func ProcessBatchTransfers(ctx context.Context) error {
+	for {
+		err := batchTransferModel.ProcessNext(ctx)
+		if models.IsNoEvent(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package jobs
+
+import (
+	"context"
+
+	"github.com/Massad/gin-boilerplate/encryption"
+	"github.com/Massad/gin-boilerplate/models"
+)
+
+var encryptionModel = new(models.EncryptionModel)
+
+// RotateEncryptionKeys reloads the key file to pick up a new master key, wraps the data keys again with it,
+// rotates the data key when it is due, then encrypts the users and the other personal documents still on an older data key
+func RotateEncryptionKeys(ctx context.Context) error {
+	if err := encryption.GetKeyFile().Load(); err != nil {
+		return err
+	}
+	if err := encryptionModel.RotateKeys(ctx); err != nil {
+		return err
+	}
+	if err := encryptionModel.ReencryptUsers(ctx); err != nil {
+		return err
+	}
+	return encryptionModel.ReencryptDocuments(ctx)
+}
@@ -0,0 +1,34 @@
+package jobs
+
+import (
+	"context"
+
+	"github.com/Massad/gin-boilerplate/models"
+)
+
+var gdprModel = new(models.GDPRModel)
+
+// ProcessGDPR completes the pending erasures, builds the pending exports and deletes the expired ones
+func ProcessGDPR(ctx context.Context) error {
+	for {
+		err := gdprModel.ProcessNextErasure(ctx)
+		if models.IsNoEvent(err) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		err := gdprModel.ProcessNextExport(ctx)
+		if models.IsNoEvent(err) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return gdprModel.ExpireExports(ctx)
+}
@@ -0,0 +1,35 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+)
+
+var interestModel = new(models.InterestModel)
+
+// AccrueInterest accrues the interest of the last days, oldest first, then posts the interest of the past months.
+// A day is accrued once it is over since BALANCE_SNAPSHOT_DELAY, like its balance snapshots, so that no transaction
+// of the day is still to commit. Days and months already done are skipped, so the job can run as often as needed
+func AccrueInterest(ctx context.Context) error {
+	now := time.Now().UTC().Add(-utils.GetEnvDuration("BALANCE_SNAPSHOT_DELAY", 5*time.Minute))
+
+	for i := utils.GetEnvInt("INTEREST_CATCH_UP_DAYS", 3); i >= 1; i-- {
+		if err := interestModel.AccrueDay(ctx, now.AddDate(0, 0, -i).Format("2006-01-02")); err != nil {
+			return err
+		}
+	}
+
+	month := now.Format("2006-01")
+	for {
+		err := interestModel.PostNext(ctx, month)
+		if models.IsNoEvent(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
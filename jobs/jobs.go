@@ -0,0 +1,22 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Every runs fn every interval for the lifetime of the process, each run gets its own timeout.
+// Failures are logged and the job keeps going on the next tick
+func Every(name string, interval time.Duration, timeout time.Duration, fn func(ctx context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		if err := fn(ctx); err != nil {
+			log.Printf("jobs: %s failed: %v", name, err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,44 @@
+package jobs
+
+import (
+	"context"
+
+	"github.com/Massad/gin-boilerplate/models"
+)
+
+var notificationModel = new(models.NotificationModel)
+
+// notificationBatchSize bounds the work of one tick so a backlog doesn't hold the job forever
+const notificationBatchSize = 100
+
+// SendNotifications turns new outbox events into notifications, then sends the notifications that are due
+func SendNotifications(ctx context.Context) error {
+	for i := 0; i < notificationBatchSize; i++ {
+		event, err := outboxModel.ClaimNotification(ctx, models.NotificationEvents)
+		if models.IsNoEvent(err) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if err = notificationModel.FromEvent(ctx, event); err != nil {
+			return err
+		}
+		if err = outboxModel.MarkNotified(ctx, event.ID); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < notificationBatchSize; i++ {
+		err := notificationModel.SendNext(ctx)
+		if models.IsNoEvent(err) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
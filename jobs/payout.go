@@ -0,0 +1,32 @@
+package jobs
+
+import (
+	"context"
+
+	"github.com/Massad/gin-boilerplate/models"
+)
+
+var payoutModel = new(models.PayoutModel)
+
+// ProcessPayouts submits the pending payouts to the provider, then polls the status of the payouts in flight
+func ProcessPayouts(ctx context.Context) error {
+	for {
+		err := payoutModel.SubmitNext(ctx)
+		if models.IsNoEvent(err) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		err := payoutModel.PollNext(ctx)
+		if models.IsNoEvent(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/utils"
+)
+
+var outboxModel = new(models.OutboxModel)
+var webhookModel = new(models.WebhookModel)
+
+// webhookClient connects to public addresses only. The check runs on the address actually dialed, so a host
+// resolving to a private address after its registration, or a redirect to one, is refused too
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: dialPublicOnly}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConnsPerHost: 2,
+	},
+}
+
+// dialPublicOnly refuses connections to non-public addresses, see utils.PublicHostsOnly
+func dialPublicOnly(network string, address string, conn syscall.RawConn) error {
+	if !utils.PublicHostsOnly() {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !utils.IsPublicIP(net.ParseIP(host)) {
+		return fmt.Errorf("webhook: refusing to connect to the non-public address %s", host)
+	}
+	return nil
+}
+
+// webhookBatchSize bounds the work of one tick so a backlog doesn't hold the job forever
+const webhookBatchSize = 100
+
+// DispatchWebhooks turns new outbox events into deliveries, then sends the deliveries that are due
+func DispatchWebhooks(ctx context.Context) error {
+	for i := 0; i < webhookBatchSize; i++ {
+		event, err := outboxModel.Claim(ctx)
+		if models.IsNoEvent(err) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if err = webhookModel.FanOut(ctx, event); err != nil {
+			return err
+		}
+		if err = outboxModel.MarkDispatched(ctx, event.ID); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < webhookBatchSize; i++ {
+		delivery, endpoint, err := webhookModel.ClaimDue(ctx)
+		if models.IsNoEvent(err) {
+			break
+		}
+		if err == models.ErrWebhookNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		attempt := deliver(ctx, delivery, endpoint)
+		if err = webhookModel.RecordAttempt(ctx, delivery, attempt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SignWebhook returns the X-Webhook-Signature header value: "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">".
+// Receivers recompute the HMAC with their endpoint secret and reject old timestamps to prevent replays
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func deliver(ctx context.Context, delivery models.WebhookDelivery, endpoint models.WebhookEndpoint) models.WebhookAttempt {
+	start := time.Now()
+	attempt := models.WebhookAttempt{CreatedAt: start.Unix()}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "wallet-webhooks/1.0")
+	req.Header.Set("X-Webhook-Id", delivery.EventID.Hex())
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Delivery", delivery.ID.Hex())
+	req.Header.Set("X-Webhook-Signature", SignWebhook(endpoint.Secret, start.Unix(), delivery.Body))
+
+	res, err := webhookClient.Do(req)
+	attempt.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer res.Body.Close()
+
+	attempt.StatusCode = res.StatusCode
+	return attempt
+}
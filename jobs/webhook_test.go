@@ -0,0 +1,22 @@
+//go:build all
+// +build all
+
+package jobs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+
+	//HMAC-SHA256 of "1700000000.{"id":"1"}" with the key "whsec_test"
+	signature := SignWebhook("whsec_test", 1700000000, body)
+	assert.Equal(t, "t=1700000000,v1=11bf4466ea17c3df3fd743af0b435368e16b7a05eb8eced85e8c4670767bdec5", signature)
+
+	assert.NotEqual(t, signature, SignWebhook("whsec_other", 1700000000, body), "the secret is signed")
+	assert.NotEqual(t, signature, SignWebhook("whsec_test", 1700000001, body), "the timestamp is signed")
+	assert.NotEqual(t, signature, SignWebhook("whsec_test", 1700000000, []byte(`{"id":"2"}`)), "the body is signed")
+}
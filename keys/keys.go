@@ -0,0 +1,334 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/utils"
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+// Key is a private signing key loaded from the key directory, its ID is the file name without ".pem"
+type Key struct {
+	ID        string
+	Private   crypto.Signer
+	CreatedAt time.Time
+}
+
+// Method returns the JWT signing method matching the key type
+func (k *Key) Method() jwt.SigningMethod {
+	if _, ok := k.Private.(ed25519.PrivateKey); ok {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// Public ...
+func (k *Key) Public() crypto.PublicKey {
+	return k.Private.Public()
+}
+
+// JWK is the public half of a key as published in the JWKS document (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet ...
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// AccessTokenLifetime is how long an access token is valid after it was signed
+const AccessTokenLifetime = 24 * time.Hour
+
+// KeyStore holds the signing keys of a directory.
+// The newest key signs new tokens; older keys keep verifying tokens until GracePeriod after they were superseded
+type KeyStore struct {
+	Dir              string
+	Algorithm        string
+	RotationInterval time.Duration
+	GracePeriod      time.Duration
+
+	mu   sync.RWMutex
+	keys []*Key
+}
+
+// NewKeyStore ...
+func NewKeyStore(dir, algorithm string, rotationInterval, gracePeriod time.Duration) *KeyStore {
+	return &KeyStore{
+		Dir:              dir,
+		Algorithm:        algorithm,
+		RotationInterval: rotationInterval,
+		GracePeriod:      gracePeriod,
+	}
+}
+
+// Load reads the key directory, creates a new key when the newest one is due for rotation and deletes retired keys
+func (s *KeyStore) Load() error {
+	//A key retired before the tokens it signed expire would log their users out
+	if s.GracePeriod < AccessTokenLifetime {
+		return fmt.Errorf("keys: the grace period %s must be at least the lifetime of an access token, %s", s.GracePeriod, AccessTokenLifetime)
+	}
+
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+
+	loaded, err := s.readDir()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if len(loaded) == 0 || now.Sub(loaded[0].CreatedAt) >= s.RotationInterval {
+		key, err := s.generate(now)
+		if err != nil {
+			return err
+		}
+		log.Printf("keys: generated signing key %s", key.ID)
+		loaded = append([]*Key{key}, loaded...)
+	}
+
+	//A key is retired once the key that replaced it is older than the grace period
+	active := loaded[:1]
+	for i := 1; i < len(loaded); i++ {
+		if now.Sub(loaded[i-1].CreatedAt) < s.GracePeriod {
+			active = append(active, loaded[i])
+			continue
+		}
+		log.Printf("keys: retiring signing key %s", loaded[i].ID)
+		os.Remove(filepath.Join(s.Dir, loaded[i].ID+".pem"))
+	}
+
+	s.mu.Lock()
+	s.keys = active
+	s.mu.Unlock()
+
+	return nil
+}
+
+// readDir returns the keys of the directory, newest first
+func (s *KeyStore) readDir() ([]*Key, error) {
+	files, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var loaded []*Key
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".pem" {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(s.Dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		private, err := parsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("keys: %s: %w", file.Name(), err)
+		}
+
+		id := strings.TrimSuffix(file.Name(), ".pem")
+		createdAt, err := keyCreatedAt(id)
+		if err != nil {
+			return nil, fmt.Errorf("keys: %s: %w", file.Name(), err)
+		}
+
+		loaded = append(loaded, &Key{
+			ID:        id,
+			Private:   private,
+			CreatedAt: createdAt,
+		})
+	}
+
+	sort.Slice(loaded, func(i, j int) bool {
+		if loaded[i].CreatedAt.Equal(loaded[j].CreatedAt) {
+			return loaded[i].ID > loaded[j].ID
+		}
+		return loaded[i].CreatedAt.After(loaded[j].CreatedAt)
+	})
+
+	return loaded, nil
+}
+
+// keyIDTimeFormat is the creation time a key ID starts with, "<time>-<random hex>"
+const keyIDTimeFormat = "20060102T150405Z"
+
+// keyCreatedAt returns the creation time encoded in the key ID. The modification time of the file is not used,
+// copying or restoring the directory would change which key signs and when the others retire
+func keyCreatedAt(id string) (time.Time, error) {
+	createdAt, err := time.Parse(keyIDTimeFormat, strings.SplitN(id, "-", 2)[0])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("the key ID must start with its creation time as %s", keyIDTimeFormat)
+	}
+	return createdAt, nil
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch private := parsed.(type) {
+		case *rsa.PrivateKey:
+			return private, nil
+		case ed25519.PrivateKey:
+			return private, nil
+		}
+	}
+
+	return nil, errors.New("only RSA and Ed25519 private keys are supported")
+}
+
+// generate creates a key for the configured algorithm and writes it to the directory
+func (s *KeyStore) generate(now time.Time) (*Key, error) {
+	var private crypto.Signer
+	var err error
+
+	switch s.Algorithm {
+	case "EdDSA":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case "RS256":
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return nil, fmt.Errorf("keys: unsupported signing algorithm %q", s.Algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	id := now.UTC().Format(keyIDTimeFormat) + "-" + hex.EncodeToString(suffix)
+
+	path := filepath.Join(s.Dir, id+".pem")
+	err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{ID: id, Private: private, CreatedAt: now}, nil
+}
+
+// Signer returns the key new tokens are signed with
+func (s *KeyStore) Signer() (*Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.keys) == 0 {
+		return nil, errors.New("keys: no signing key loaded")
+	}
+	return s.keys[0], nil
+}
+
+// Lookup finds an active key by its kid
+func (s *KeyStore) Lookup(kid string) (*Key, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, key := range s.keys {
+		if key.ID == kid {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// JWKS returns the public keys of every active key
+func (s *KeyStore) JWKS() JWKSet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range s.keys {
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method().Alg()}
+
+		switch public := key.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
+
+// Run reloads the directory every interval so rotations (ours or from other instances sharing the directory) are picked up
+func (s *KeyStore) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := s.Load(); err != nil {
+			log.Printf("keys: reload failed: %v", err)
+		}
+	}
+}
+
+var keyStore *KeyStore
+var keyStoreOnce sync.Once
+
+// GetKeyStore returns the key store configured from the environment, loading it on first use
+func GetKeyStore() *KeyStore {
+	keyStoreOnce.Do(func() {
+		keyStore = NewKeyStore(
+			utils.GetEnvString("JWT_KEY_DIR", "./jwt-keys"),
+			utils.GetEnvString("JWT_SIGNING_ALG", "RS256"),
+			utils.GetEnvDuration("JWT_KEY_ROTATION_INTERVAL", 30*24*time.Hour),
+			utils.GetEnvDuration("JWT_KEY_GRACE_PERIOD", 48*time.Hour),
+		)
+		if err := keyStore.Load(); err != nil {
+			log.Fatal(err)
+		}
+	})
+	return keyStore
+}
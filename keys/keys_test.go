@@ -0,0 +1,91 @@
+//go:build all
+// +build all
+
+package keys
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyCreatedAt(t *testing.T) {
+	createdAt, err := keyCreatedAt("20240102T030405Z-0a1b2c3d")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), createdAt)
+
+	for _, id := range []string{"", "0a1b2c3d", "2024-01-02-0a1b2c3d", "20240102T030405-0a1b2c3d"} {
+		_, err = keyCreatedAt(id)
+		assert.Error(t, err, id)
+	}
+}
+
+func TestKeyStoreRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwt-keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := NewKeyStore(dir, "EdDSA", 30*24*time.Hour, 48*time.Hour)
+	now := time.Now()
+
+	//retired was replaced by superseded more than the grace period ago, superseded is due for rotation
+	retired, err := store.generate(now.Add(-90 * 24 * time.Hour))
+	require.NoError(t, err)
+	superseded, err := store.generate(now.Add(-31 * 24 * time.Hour))
+	require.NoError(t, err)
+
+	//The modification time of the files must not matter
+	os.Chtimes(filepath.Join(dir, retired.ID+".pem"), now, now)
+
+	require.NoError(t, store.Load())
+
+	signer, err := store.Signer()
+	require.NoError(t, err)
+	assert.NotEqual(t, superseded.ID, signer.ID, "a new key signs once the newest one is due for rotation")
+	assert.WithinDuration(t, now, signer.CreatedAt, time.Minute)
+
+	_, ok := store.Lookup(superseded.ID)
+	assert.True(t, ok, "the superseded key verifies tokens during the grace period")
+	_, ok = store.Lookup(retired.ID)
+	assert.False(t, ok, "the retired key is not used anymore")
+	_, err = os.Stat(filepath.Join(dir, retired.ID+".pem"))
+	assert.True(t, os.IsNotExist(err), "the retired key is deleted")
+
+	assert.Len(t, store.JWKS().Keys, 2)
+
+	//Loading again keeps the same signer until the next rotation
+	require.NoError(t, store.Load())
+	again, err := store.Signer()
+	require.NoError(t, err)
+	assert.Equal(t, signer.ID, again.ID)
+}
+
+func TestKeyStoreGracePeriod(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwt-keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := NewKeyStore(dir, "EdDSA", 30*24*time.Hour, AccessTokenLifetime-time.Hour)
+	assert.Error(t, store.Load(), "keys must not retire before the access tokens they signed expire")
+
+	_, err = store.Signer()
+	assert.Error(t, err)
+}
+
+func TestKeyStoreInvalidKeyID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwt-keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := NewKeyStore(dir, "EdDSA", 30*24*time.Hour, 48*time.Hour)
+	key, err := store.generate(time.Now())
+	require.NoError(t, err)
+	require.NoError(t, os.Rename(filepath.Join(dir, key.ID+".pem"), filepath.Join(dir, "signing.pem")))
+
+	assert.Error(t, store.Load(), "the creation time is read from the key ID")
+}
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"runtime"
+	"time"
 
 	"github.com/Massad/gin-boilerplate/controllers"
 	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/encryption"
 	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/jobs"
+	"github.com/Massad/gin-boilerplate/keys"
+	"github.com/Massad/gin-boilerplate/models"
+	"github.com/Massad/gin-boilerplate/payments"
+	"github.com/Massad/gin-boilerplate/utils"
 	"github.com/gin-contrib/gzip"
 	"github.com/joho/godotenv"
 	uuid "github.com/twinj/uuid"
@@ -29,7 +37,7 @@ func CORSMiddleware() gin.HandlerFunc {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "http://localhost")
 		c.Writer.Header().Set("Access-Control-Max-Age", "86400")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, UPDATE")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "X-Requested-With, Content-Type, Origin, Authorization, Accept, Client-Security-Token, Accept-Encoding, x-access-token")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "X-Requested-With, Content-Type, Origin, Authorization, Accept, Client-Security-Token, Accept-Encoding, x-access-token, X-API-Key, Idempotency-Key")
 		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 
@@ -54,15 +62,67 @@ func RequestIDMiddleware() gin.HandlerFunc {
 
 var auth = new(controllers.AuthController)
 
+//streamingPaths are the routes that stream their response
+var streamingPaths = []string{"/v1/user/events", "/v1/user/ws"}
+
+//QueryTokenMiddleware ...
+//Browsers can't set headers on EventSource and WebSocket connections, so streaming routes also accept the token as ?access_token=
+//It runs before the logger and takes the token out of the query string, so it never reaches the access logs
+func QueryTokenMiddleware(paths []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if utils.Contains(paths, c.Request.URL.Path) {
+			query := c.Request.URL.Query()
+			if token := query.Get("access_token"); token != "" {
+				if c.GetHeader("Authorization") == "" {
+					c.Request.Header.Set("Authorization", "Bearer "+token)
+				}
+				query.Del("access_token")
+				c.Request.URL.RawQuery = query.Encode()
+			}
+		}
+		c.Next()
+	}
+}
+
 //TokenAuthMiddleware ...
 //JWT Authentication middleware attached to each request that needs to be authenitcated to validate the access_token in the header
-func TokenAuthMiddleware() gin.HandlerFunc {
+//API keys and service client tokens are accepted too, but only on routes listing the scopes they need
+func TokenAuthMiddleware(scopes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		auth.TokenValid(c)
+		auth.TokenValid(c, scopes...)
 		c.Next()
 	}
 }
 
+//modelIndexes create the indexes of the models at startup
+var modelIndexes = []struct {
+	name   string
+	ensure func(ctx context.Context) error
+}{
+	{"login attempt", new(models.LoginAttemptModel).EnsureIndexes},
+	{"batch transfer", new(models.BatchTransferModel).EnsureIndexes},
+	{"outbox", new(models.OutboxModel).EnsureIndexes},
+	{"webhook delivery", new(models.WebhookModel).EnsureIndexes},
+	{"voucher", new(models.VoucherModel).EnsureIndexes},
+}
+
+//exampleSecrets are the values of the secrets in .env.example, production must set its own
+var exampleSecrets = map[string]string{
+	"REFRESH_SECRET": "hjsajdhkjhf41jhagggdga",
+	"QR_SECRET":      "change-me-qr-secret",
+}
+
+//checkProduction returns an error when a secret is missing or left to its example value, or when the payment
+//providers are the ones of development
+func checkProduction() error {
+	for name, example := range exampleSecrets {
+		if value := os.Getenv(name); value == "" || value == example {
+			return fmt.Errorf("%s must be set to a secret of its own in production", name)
+		}
+	}
+	return payments.CheckProduction()
+}
+
 func main() {
 	//Load the .env file
 	err := godotenv.Load(".env")
@@ -72,23 +132,69 @@ func main() {
 
 	if os.Getenv("ENV") == "PRODUCTION" {
 		gin.SetMode(gin.ReleaseMode)
+
+		//Refuse the configuration of development: the example secrets and the fake payment providers
+		if err = checkProduction(); err != nil {
+			log.Fatal("error: ", err)
+		}
 	}
 
 	docs.SwaggerInfo.BasePath = "/"
-	//Start the default gin server
-	r := gin.Default()
+	//Start the gin server with the default logger and recovery, the query token is removed before the logger sees it
+	r := gin.New()
+	r.Use(QueryTokenMiddleware(streamingPaths), gin.Logger(), gin.Recovery())
 
 	//Custom form validator
 	binding.Validator = new(forms.DefaultValidator)
 
 	r.Use(CORSMiddleware())
 	r.Use(RequestIDMiddleware())
-	r.Use(gzip.Gzip(gzip.DefaultCompression))
+	//Streaming responses must not be buffered by the compressor
+	r.Use(gzip.Gzip(gzip.DefaultCompression, gzip.WithExcludedPaths(streamingPaths)))
 
 	//Start PostgreSQL database
 	//Example: db.GetDB() - More info in the models folder
 	db.ConnectDB()
 
+	//Load the JWT signing keys and keep rotating them in the background
+	go keys.GetKeyStore().Run(utils.GetEnvDuration("JWT_KEY_RELOAD_INTERVAL", time.Minute))
+
+	//Load the master keys of the field encryption, the key file is created once with cmd/encryptionkeys
+	encryption.GetKeyFile()
+
+	//Create the indexes the models rely on, e.g. to delete the failed logins once they are forgotten
+	indexCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	for _, index := range modelIndexes {
+		if err = index.ensure(indexCtx); err != nil {
+			log.Fatal("error: failed to create the "+index.name+" indexes: ", err)
+		}
+	}
+	cancel()
+
+	//Deliver the wallet events of the outbox to the webhooks
+	go jobs.Every("webhooks", utils.GetEnvDuration("WEBHOOK_DISPATCH_INTERVAL", 5*time.Second), time.Minute, jobs.DispatchWebhooks)
+
+	//Execute the BEST_EFFORT batch transfers
+	go jobs.Every("batch-transfers", utils.GetEnvDuration("BATCH_TRANSFER_INTERVAL", 5*time.Second), 5*time.Minute, jobs.ProcessBatchTransfers)
+
+	//Accrue the daily interest and post it every month
+	go jobs.Every("interest", utils.GetEnvDuration("INTEREST_INTERVAL", time.Hour), 30*time.Minute, jobs.AccrueInterest)
+
+	//Submit the payouts of withdrawals and follow their settlement
+	go jobs.Every("payouts", utils.GetEnvDuration("PAYOUT_INTERVAL", 10*time.Second), 5*time.Minute, jobs.ProcessPayouts)
+
+	//Record the end of day balances the balance history replays from
+	go jobs.Every("balance-snapshots", utils.GetEnvDuration("BALANCE_SNAPSHOT_INTERVAL", time.Hour), 30*time.Minute, jobs.SnapshotBalances)
+
+	//Complete account erasures, build data exports and delete the expired ones
+	go jobs.Every("gdpr", utils.GetEnvDuration("GDPR_INTERVAL", 10*time.Second), 10*time.Minute, jobs.ProcessGDPR)
+
+	//Rotate the encryption keys and encrypt the users again with the newest data key
+	go jobs.Every("encryption-keys", utils.GetEnvDuration("ENCRYPTION_ROTATION_INTERVAL", time.Hour), 30*time.Minute, jobs.RotateEncryptionKeys)
+
+	//Notify the users of the wallet events that concern them and send the notifications that are due
+	go jobs.Every("notifications", utils.GetEnvDuration("NOTIFICATION_INTERVAL", 5*time.Second), time.Minute, jobs.SendNotifications)
+
 	v1 := r.Group("/v1")
 	{
 		/*** START USER ***/
@@ -96,10 +202,199 @@ func main() {
 
 		v1.POST("/user/login", user.Login)
 		v1.POST("/user/register", user.Register)
-		v1.POST("/user/top-up", TokenAuthMiddleware(), user.TopUp)
-		v1.POST("/user/withdraw", TokenAuthMiddleware(), user.WithDraw)
-		v1.GET("/user/details", TokenAuthMiddleware(), user.Details)
-		v1.POST("/user/transfer", TokenAuthMiddleware(), user.Transfer)
+		v1.POST("/user/top-up", TokenAuthMiddleware(utils.SCOPE_TOP_UPS_WRITE), user.TopUp)
+		v1.POST("/user/withdraw", TokenAuthMiddleware(utils.SCOPE_WITHDRAWALS_WRITE), user.WithDraw)
+		v1.GET("/user/details", TokenAuthMiddleware(utils.SCOPE_TRANSACTIONS_READ), user.Details)
+		v1.POST("/user/transfer", TokenAuthMiddleware(utils.SCOPE_TRANSFERS_WRITE), user.Transfer)
+		v1.POST("/user/password/change", TokenAuthMiddleware(), user.ChangePassword)
+		v1.POST("/user/password/forgot", user.ForgotPassword)
+		v1.POST("/user/password/reset", user.ResetPassword)
+
+		/*** START REALTIME ***/
+		realtime := new(controllers.RealtimeController)
+
+		v1.GET("/user/events", TokenAuthMiddleware(utils.SCOPE_TRANSACTIONS_READ), realtime.Events)
+		v1.GET("/user/ws", TokenAuthMiddleware(utils.SCOPE_TRANSACTIONS_READ), realtime.WebSocket)
+
+		/*** START SESSION ***/
+		session := new(controllers.SessionController)
+
+		v1.GET("/user/sessions", TokenAuthMiddleware(), session.All)
+		v1.DELETE("/user/sessions", TokenAuthMiddleware(), session.DeleteAll)
+		v1.DELETE("/user/sessions/:id", TokenAuthMiddleware(), session.Delete)
+
+		/*** START API KEY ***/
+		apiKey := new(controllers.APIKeyController)
+
+		v1.POST("/user/api-keys", TokenAuthMiddleware(), apiKey.Create)
+		v1.GET("/user/api-keys", TokenAuthMiddleware(), apiKey.All)
+		v1.DELETE("/user/api-keys/:id", TokenAuthMiddleware(), apiKey.Delete)
+
+		/*** START OAUTH ***/
+		oauth := new(controllers.OAuthController)
+
+		v1.POST("/oauth/token", oauth.Token)
+		v1.POST("/user/oauth-clients", TokenAuthMiddleware(), oauth.CreateClient)
+		v1.GET("/user/oauth-clients", TokenAuthMiddleware(), oauth.Clients)
+		v1.DELETE("/user/oauth-clients/:id", TokenAuthMiddleware(), oauth.DeleteClient)
+
+		/*** START WEBHOOK ***/
+		webhook := new(controllers.WebhookController)
+
+		v1.POST("/webhooks", TokenAuthMiddleware(), webhook.Create)
+		v1.GET("/webhooks", TokenAuthMiddleware(), webhook.All)
+		v1.DELETE("/webhooks/:id", TokenAuthMiddleware(), webhook.Delete)
+		v1.GET("/webhooks/:id/deliveries", TokenAuthMiddleware(), webhook.Deliveries)
+		v1.POST("/webhooks/:id/deliveries/:delivery_id/replay", TokenAuthMiddleware(), webhook.Replay)
+
+		/*** START PAYMENT REQUEST ***/
+		paymentRequest := new(controllers.PaymentRequestController)
+
+		v1.POST("/payment-requests", TokenAuthMiddleware(), paymentRequest.Create)
+		v1.GET("/payment-requests", TokenAuthMiddleware(), paymentRequest.All)
+		v1.POST("/payment-requests/:id/accept", TokenAuthMiddleware(), paymentRequest.Accept)
+		v1.POST("/payment-requests/:id/decline", TokenAuthMiddleware(), paymentRequest.Decline)
+		v1.POST("/payment-requests/:id/cancel", TokenAuthMiddleware(), paymentRequest.Cancel)
+
+		/*** START BATCH TRANSFER ***/
+		batchTransfer := new(controllers.BatchTransferController)
+
+		v1.POST("/transfers/batch", TokenAuthMiddleware(utils.SCOPE_TRANSFERS_WRITE), batchTransfer.Create)
+		v1.GET("/transfers/batch", TokenAuthMiddleware(utils.SCOPE_TRANSACTIONS_READ), batchTransfer.All)
+		v1.GET("/transfers/batch/:id", TokenAuthMiddleware(utils.SCOPE_TRANSACTIONS_READ), batchTransfer.One)
+		v1.GET("/transfers/batch/:id/report", TokenAuthMiddleware(utils.SCOPE_TRANSACTIONS_READ), batchTransfer.Report)
+
+		/*** START BILL ***/
+		bill := new(controllers.BillController)
+
+		v1.POST("/bills", TokenAuthMiddleware(), bill.Create)
+		v1.GET("/bills", TokenAuthMiddleware(), bill.All)
+		v1.GET("/bills/:id", TokenAuthMiddleware(), bill.One)
+		v1.POST("/bills/:id/cancel", TokenAuthMiddleware(), bill.Cancel)
+
+		/*** START MERCHANT ***/
+		merchant := new(controllers.MerchantController)
+
+		v1.POST("/merchants", TokenAuthMiddleware(), merchant.Create)
+		v1.GET("/merchants", TokenAuthMiddleware(), merchant.All)
+		v1.POST("/merchants/:id/payout", TokenAuthMiddleware(), merchant.Payout)
+		v1.GET("/merchants/:id/dashboard", TokenAuthMiddleware(utils.SCOPE_TRANSACTIONS_READ), merchant.Dashboard)
+		v1.POST("/merchants/:id/invoices", TokenAuthMiddleware(), merchant.CreateInvoice)
+		v1.GET("/merchants/:id/invoices", TokenAuthMiddleware(utils.SCOPE_TRANSACTIONS_READ), merchant.Invoices)
+		v1.GET("/merchants/:id/invoices/:invoice_id", TokenAuthMiddleware(utils.SCOPE_TRANSACTIONS_READ), merchant.Invoice)
+		v1.POST("/merchants/:id/invoices/:invoice_id/refund", TokenAuthMiddleware(), merchant.Refund)
+		v1.GET("/payment-links/:code", TokenAuthMiddleware(), merchant.PaymentLink)
+		v1.POST("/payment-links/:code/pay", TokenAuthMiddleware(), merchant.Pay)
+
+		/*** START QR ***/
+		qr := new(controllers.QRController)
+
+		v1.POST("/qr", TokenAuthMiddleware(), qr.Create)
+		v1.GET("/qr/image", TokenAuthMiddleware(), qr.Image)
+		v1.POST("/pay/qr", TokenAuthMiddleware(utils.SCOPE_TRANSFERS_WRITE), qr.Pay)
+
+		/*** START POCKET ***/
+		pocket := new(controllers.PocketController)
+
+		v1.POST("/pockets", TokenAuthMiddleware(), pocket.Create)
+		v1.GET("/pockets", TokenAuthMiddleware(utils.SCOPE_TRANSACTIONS_READ), pocket.All)
+		v1.GET("/pockets/:id", TokenAuthMiddleware(utils.SCOPE_TRANSACTIONS_READ), pocket.One)
+		v1.DELETE("/pockets/:id", TokenAuthMiddleware(), pocket.Delete)
+		v1.POST("/pockets/:id/deposit", TokenAuthMiddleware(), pocket.Deposit)
+		v1.POST("/pockets/:id/withdraw", TokenAuthMiddleware(), pocket.Withdraw)
+		v1.GET("/pockets/:id/transactions", TokenAuthMiddleware(utils.SCOPE_TRANSACTIONS_READ), pocket.Transactions)
+
+		/*** START INTEREST ***/
+		interest := new(controllers.InterestController)
+
+		v1.GET("/interest", TokenAuthMiddleware(utils.SCOPE_TRANSACTIONS_READ), interest.Summary)
+
+		/*** START PROMOTION ***/
+		promotion := new(controllers.PromotionController)
+
+		v1.POST("/vouchers", TokenAuthMiddleware(), promotion.CreateVoucher)
+		v1.GET("/vouchers", TokenAuthMiddleware(), promotion.Vouchers)
+		v1.POST("/vouchers/redeem", TokenAuthMiddleware(), promotion.Redeem)
+		v1.POST("/cashback-rules", TokenAuthMiddleware(), promotion.CreateCashbackRule)
+		v1.GET("/cashback-rules", TokenAuthMiddleware(), promotion.CashbackRules)
+		v1.DELETE("/cashback-rules/:id", TokenAuthMiddleware(), promotion.DeactivateCashbackRule)
+
+		/*** START TOP-UP ***/
+		topUp := new(controllers.TopUpController)
+
+		v1.GET("/top-ups", TokenAuthMiddleware(utils.SCOPE_TRANSACTIONS_READ), topUp.All)
+		v1.GET("/top-ups/:id", TokenAuthMiddleware(utils.SCOPE_TRANSACTIONS_READ), topUp.One)
+		//Signed by the payment provider, no user token
+		v1.POST("/top-ups/callback", topUp.Callback)
+
+		/*** START PAYOUT ***/
+		payout := new(controllers.PayoutController)
+
+		v1.POST("/bank-accounts", TokenAuthMiddleware(), payout.CreateBankAccount)
+		v1.GET("/bank-accounts", TokenAuthMiddleware(), payout.BankAccounts)
+		v1.DELETE("/bank-accounts/:id", TokenAuthMiddleware(), payout.DeleteBankAccount)
+		v1.GET("/payouts", TokenAuthMiddleware(utils.SCOPE_TRANSACTIONS_READ), payout.All)
+		v1.GET("/payouts/:id", TokenAuthMiddleware(utils.SCOPE_TRANSACTIONS_READ), payout.One)
+		//Signed by the payout provider, no user token
+		v1.POST("/payouts/callback", payout.Callback)
+
+		/*** START BENEFICIARY ***/
+		beneficiary := new(controllers.BeneficiaryController)
+
+		v1.GET("/users/lookup", TokenAuthMiddleware(utils.SCOPE_TRANSFERS_WRITE), beneficiary.Lookup)
+		v1.POST("/beneficiaries", TokenAuthMiddleware(), beneficiary.Create)
+		v1.GET("/beneficiaries", TokenAuthMiddleware(), beneficiary.All)
+		v1.DELETE("/beneficiaries/:id", TokenAuthMiddleware(), beneficiary.Delete)
+		v1.GET("/beneficiaries/settings", TokenAuthMiddleware(), beneficiary.Settings)
+		v1.PUT("/beneficiaries/settings", TokenAuthMiddleware(), beneficiary.UpdateSettings)
+
+		/*** START TRANSACTION ***/
+		transaction := new(controllers.TransactionController)
+
+		v1.GET("/transactions/analytics", TokenAuthMiddleware(utils.SCOPE_TRANSACTIONS_READ), transaction.Analytics)
+		v1.PATCH("/transactions/:id", TokenAuthMiddleware(), transaction.Update)
+
+		/*** START BALANCE ***/
+		balance := new(controllers.BalanceController)
+
+		v1.GET("/user/balance", TokenAuthMiddleware(utils.SCOPE_TRANSACTIONS_READ), balance.At)
+		v1.GET("/user/balance/history", TokenAuthMiddleware(utils.SCOPE_TRANSACTIONS_READ), balance.History)
+
+		/*** START KYC ***/
+		kyc := new(controllers.KYCController)
+
+		v1.GET("/kyc", TokenAuthMiddleware(), kyc.Status)
+		v1.POST("/kyc/documents", TokenAuthMiddleware(), kyc.Upload)
+		v1.GET("/kyc/documents", TokenAuthMiddleware(), kyc.Documents)
+		v1.GET("/kyc/documents/:id/file", TokenAuthMiddleware(), kyc.File)
+		v1.POST("/kyc/submissions", TokenAuthMiddleware(), kyc.Submit)
+		v1.GET("/kyc/reviews", TokenAuthMiddleware(), kyc.Reviews)
+		v1.GET("/kyc/reviews/:id", TokenAuthMiddleware(), kyc.Review)
+		v1.POST("/kyc/reviews/:id/approve", TokenAuthMiddleware(), kyc.Approve)
+		v1.POST("/kyc/reviews/:id/reject", TokenAuthMiddleware(), kyc.Reject)
+
+		/*** START PROFILE ***/
+		profile := new(controllers.ProfileController)
+
+		v1.GET("/user/me", TokenAuthMiddleware(), profile.Me)
+		v1.PATCH("/user/me", TokenAuthMiddleware(), profile.Update)
+		v1.POST("/user/me/verifications", TokenAuthMiddleware(), profile.StartVerification)
+		v1.POST("/user/me/verifications/confirm", TokenAuthMiddleware(), profile.ConfirmVerification)
+
+		/*** START GDPR ***/
+		gdpr := new(controllers.GDPRController)
+
+		v1.POST("/user/exports", TokenAuthMiddleware(), gdpr.CreateExport)
+		v1.GET("/user/exports", TokenAuthMiddleware(), gdpr.Exports)
+		v1.GET("/user/exports/:id/download", TokenAuthMiddleware(), gdpr.Download)
+		v1.POST("/user/erasure", TokenAuthMiddleware(), gdpr.Erase)
+
+		/*** START NOTIFICATION ***/
+		notification := new(controllers.NotificationController)
+
+		v1.GET("/notifications", TokenAuthMiddleware(), notification.All)
+		v1.GET("/notifications/preferences", TokenAuthMiddleware(), notification.Preferences)
+		v1.PUT("/notifications/preferences", TokenAuthMiddleware(), notification.UpdatePreferences)
 	}
 
 	r.LoadHTMLGlob("./public/html/*")
@@ -112,6 +407,7 @@ func main() {
 			"goVersion":             runtime.Version(),
 		})
 	})
+	r.GET("/.well-known/jwks.json", auth.JWKS)
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	r.NoRoute(func(c *gin.Context) {
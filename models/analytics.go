@@ -0,0 +1,190 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CategoryTotal is the money spent and received in a category
+type CategoryTotal struct {
+	Category string `json:"category"`
+	Spending int64  `json:"spending"`
+	Income   int64  `json:"income"`
+	Count    int64  `json:"count"`
+}
+
+// MonthTotal is the money spent and received in a month, YYYY-MM in UTC
+type MonthTotal struct {
+	Month    string `json:"month"`
+	Spending int64  `json:"spending"`
+	Income   int64  `json:"income"`
+	Count    int64  `json:"count"`
+}
+
+// Analytics sums the transactions of a user from the month From to the month To, both included.
+// Moves between the main balance and pockets are neither spending nor income and are left out
+type Analytics struct {
+	From       string          `json:"from"`
+	To         string          `json:"to"`
+	Tag        string          `json:"tag,omitempty"`
+	Spending   int64           `json:"spending"`
+	Income     int64           `json:"income"`
+	Categories []CategoryTotal `json:"categories"`
+	Months     []MonthTotal    `json:"months"`
+}
+
+const (
+	analyticsMonths    = 6  //Months covered when the range is not given
+	maxAnalyticsMonths = 24 //Longest range
+)
+
+// Analytics returns the spending and income of the user per category and per month, computed by Mongo
+func (m TransactionModel) Analytics(ctx context.Context, userID primitive.ObjectID, form forms.AnalyticsForm) (analytics Analytics, err error) {
+	fmt.Println("Transaction model: Analytics")
+	userCollection := db.GetCollection(db.DB, "users")
+	transactionCollection := db.GetCollection(db.DB, "transactions")
+
+	tag := strings.ToLower(strings.TrimSpace(form.Tag))
+	now := time.Now().UTC()
+	end := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if form.To != "" {
+		end, _ = time.Parse("2006-01", form.To)
+	}
+	start := end.AddDate(0, 1-analyticsMonths, 0)
+	if form.From != "" {
+		start, _ = time.Parse("2006-01", form.From)
+	}
+	if start.After(end) {
+		return analytics, errors.New("the first month must not be after the last one")
+	}
+	if start.AddDate(0, maxAnalyticsMonths, 0).Before(end.AddDate(0, 1, 0)) {
+		return analytics, fmt.Errorf("the range can be at most %d months", maxAnalyticsMonths)
+	}
+
+	var user User
+	err = userCollection.FindOne(ctx, bson.M{"id": userID}).Decode(&user)
+	if err != nil {
+		return analytics, errors.New("something went wrong, please try again later")
+	}
+
+	match := bson.M{
+		"$or": []bson.M{
+			{"from": user.Username},
+			{"to": user.Username},
+		},
+		"createdat": bson.M{"$gte": start.Unix(), "$lt": end.AddDate(0, 1, 0).Unix()},
+		"type":      bson.M{"$nin": []string{utils.POCKET_DEPOSIT, utils.POCKET_WITHDRAW}},
+	}
+	if tag != "" {
+		match["$and"] = []bson.M{{"$or": []bson.M{
+			{"from": user.Username, "fromtags": tag},
+			{"to": user.Username, "totags": tag},
+		}}}
+	}
+
+	//A transaction to oneself is spending when it is a withdrawal, and income otherwise, like top-ups and interest
+	outgoing := bson.M{"$cond": bson.A{
+		bson.M{"$ne": bson.A{"$from", "$to"}},
+		bson.M{"$eq": bson.A{"$from", user.Username}},
+		bson.M{"$eq": bson.A{"$type", utils.WITHDRAW}},
+	}}
+	category := bson.M{"$cond": bson.A{
+		bson.M{"$eq": bson.A{"$from", user.Username}},
+		"$fromcategory",
+		"$tocategory",
+	}}
+	totals := func(key string) bson.A {
+		return bson.A{
+			bson.M{"$group": bson.M{
+				"_id":      "$" + key,
+				"spending": bson.M{"$sum": "$spending"},
+				"income":   bson.M{"$sum": "$income"},
+				"count":    bson.M{"$sum": 1},
+			}},
+			bson.M{"$project": bson.M{"_id": 0, key: "$_id", "spending": 1, "income": 1, "count": 1}},
+		}
+	}
+
+	pipeline := bson.A{
+		bson.M{"$match": match},
+		bson.M{"$project": bson.M{
+			"amount":   1,
+			"outgoing": outgoing,
+			"category": bson.M{"$ifNull": bson.A{category, typeCategoryExpression()}},
+			"month": bson.M{"$dateToString": bson.M{
+				"format": "%Y-%m",
+				"date":   bson.M{"$toDate": bson.M{"$multiply": bson.A{"$createdat", 1000}}},
+			}},
+		}},
+		bson.M{"$addFields": bson.M{
+			"spending": bson.M{"$cond": bson.A{"$outgoing", "$amount", 0}},
+			"income":   bson.M{"$cond": bson.A{"$outgoing", 0, "$amount"}},
+		}},
+		bson.M{"$facet": bson.M{
+			"categories": append(totals("category"), bson.M{"$sort": bson.D{{Key: "spending", Value: -1}, {Key: "income", Value: -1}, {Key: "category", Value: 1}}}),
+			"months":     totals("month"),
+		}},
+	}
+
+	results, err := transactionCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return analytics, errors.New("error when computing analytics")
+	}
+	defer results.Close(ctx)
+
+	var facets struct {
+		Categories []CategoryTotal
+		Months     []MonthTotal
+	}
+	if results.Next(ctx) {
+		if err = results.Decode(&facets); err != nil {
+			return analytics, errors.New("error when decoding analytics")
+		}
+	}
+
+	analytics.From = start.Format("2006-01")
+	analytics.To = end.Format("2006-01")
+	analytics.Tag = tag
+	analytics.Categories = facets.Categories
+	if analytics.Categories == nil {
+		analytics.Categories = []CategoryTotal{}
+	}
+	for _, total := range facets.Categories {
+		analytics.Spending += total.Spending
+		analytics.Income += total.Income
+	}
+
+	//Every month of the range is listed, the ones without transactions with zeros
+	months := map[string]MonthTotal{}
+	for _, total := range facets.Months {
+		months[total.Month] = total
+	}
+	for month := start; !month.After(end); month = month.AddDate(0, 1, 0) {
+		key := month.Format("2006-01")
+		total, ok := months[key]
+		if !ok {
+			total = MonthTotal{Month: key}
+		}
+		analytics.Months = append(analytics.Months, total)
+	}
+
+	return analytics, nil
+}
+
+// typeCategoryExpression gives the category of transactions created before categories, from their type
+func typeCategoryExpression() bson.M {
+	branches := bson.A{}
+	for transactionType, category := range typeCategories {
+		branches = append(branches, bson.M{"case": bson.M{"$eq": bson.A{"$type", transactionType}}, "then": category})
+	}
+	return bson.M{"$switch": bson.M{"branches": branches, "default": utils.CATEGORY_OTHER}}
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// APIKeyPrefix starts every personal API key so it can be recognized in headers, logs and secret scanners
+const APIKeyPrefix = "wk_"
+
+// APIKey is a personal API key, the key itself is only returned once at creation and stored as a SHA-256 hash.
+// Prefix is the public part of the key that identifies it in listings
+type APIKey struct {
+	ID         primitive.ObjectID `json:"id"`
+	UserID     primitive.ObjectID `json:"-"`
+	Name       string             `json:"name"`
+	Prefix     string             `json:"prefix"`
+	KeyHash    string             `json:"-"`
+	Scopes     []string           `json:"scopes"`
+	CreatedAt  int64              `json:"created_at"`
+	LastUsedAt int64              `json:"last_used_at,omitempty"`
+	RevokedAt  int64              `json:"revoked_at,omitempty"`
+}
+
+// ErrAPIKeyNotFound ...
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// ErrInvalidAPIKey ...
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// APIKeyModel ...
+type APIKeyModel struct{}
+
+// Create returns the stored key and the plain key, which can't be retrieved again.
+// Keys look like wk_<prefix>_<secret>
+func (m APIKeyModel) Create(ctx context.Context, userID primitive.ObjectID, form forms.CreateAPIKeyForm) (apiKey APIKey, plainKey string, err error) {
+	fmt.Println("APIKey model: Create")
+	apiKeyCollection := db.GetCollection(db.DB, "api_keys")
+
+	publicPart, err := generateHexToken(6)
+	if err != nil {
+		return apiKey, plainKey, errors.New("something went wrong, please try again later")
+	}
+	secret, err := generateHexToken(32)
+	if err != nil {
+		return apiKey, plainKey, errors.New("something went wrong, please try again later")
+	}
+
+	prefix := APIKeyPrefix + publicPart
+	plainKey = prefix + "_" + secret
+
+	apiKey = APIKey{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Name:      form.Name,
+		Prefix:    prefix,
+		KeyHash:   hashToken(plainKey),
+		Scopes:    form.Scopes,
+		CreatedAt: time.Now().Unix(),
+	}
+
+	_, err = apiKeyCollection.InsertOne(ctx, apiKey)
+	if err != nil {
+		return apiKey, "", errors.New("error when creating new api key")
+	}
+
+	return apiKey, plainKey, nil
+}
+
+// List ...
+func (m APIKeyModel) List(ctx context.Context, userID primitive.ObjectID) (apiKeys []APIKey, err error) {
+	fmt.Println("APIKey model: List")
+	apiKeyCollection := db.GetCollection(db.DB, "api_keys")
+
+	opts := options.Find().SetSort(bson.M{"createdat": -1})
+	results, err := apiKeyCollection.Find(ctx, bson.M{"userid": userID, "revokedat": int64(0)}, opts)
+	if err != nil {
+		return apiKeys, errors.New("error when retrieving api keys")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var apiKey APIKey
+		if err = results.Decode(&apiKey); err != nil {
+			return apiKeys, errors.New("error when decoding api key")
+		}
+
+		apiKeys = append(apiKeys, apiKey)
+	}
+
+	return apiKeys, nil
+}
+
+// Revoke ...
+func (m APIKeyModel) Revoke(ctx context.Context, userID primitive.ObjectID, apiKeyID primitive.ObjectID) error {
+	fmt.Println("APIKey model: Revoke")
+	apiKeyCollection := db.GetCollection(db.DB, "api_keys")
+
+	result, err := apiKeyCollection.UpdateOne(ctx,
+		bson.M{"id": apiKeyID, "userid": userID, "revokedat": int64(0)},
+		bson.M{"$set": bson.M{"revokedat": time.Now().Unix()}})
+	if err != nil {
+		return errors.New("internal server error")
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// Authenticate finds the active key matching plainKey and records its use
+func (m APIKeyModel) Authenticate(ctx context.Context, plainKey string) (apiKey APIKey, err error) {
+	apiKeyCollection := db.GetCollection(db.DB, "api_keys")
+
+	separator := strings.LastIndex(plainKey, "_")
+	if !strings.HasPrefix(plainKey, APIKeyPrefix) || separator <= len(APIKeyPrefix) {
+		return apiKey, ErrInvalidAPIKey
+	}
+
+	err = apiKeyCollection.FindOne(ctx, bson.M{"prefix": plainKey[:separator], "revokedat": int64(0)}).Decode(&apiKey)
+	if err == mongo.ErrNoDocuments {
+		return apiKey, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return apiKey, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(hashToken(plainKey))) != 1 {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+
+	//Refresh last-used at most once a minute to avoid a write per request
+	now := time.Now().Unix()
+	if now-apiKey.LastUsedAt >= 60 {
+		apiKeyCollection.UpdateOne(ctx, bson.M{"id": apiKey.ID}, bson.M{"$set": bson.M{"lastusedat": now}})
+	}
+
+	return apiKey, nil
+}
@@ -1,14 +1,19 @@
 package models
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/keys"
 	jwt "github.com/golang-jwt/jwt/v4"
 	uuid "github.com/twinj/uuid"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -20,12 +25,15 @@ type TokenDetails struct {
 	RefreshUUID  string
 	AtExpires    int64
 	RtExpires    int64
+	SessionID    primitive.ObjectID
 }
 
 //AccessDetails ...
 type AccessDetails struct {
 	AccessUUID string
 	UserID     primitive.ObjectID
+	ClientID   string   //Set for client-credentials tokens
+	Scopes     []string //Scopes of a client-credentials token, user tokens are not limited
 }
 
 //Token ...
@@ -41,22 +49,32 @@ type AuthModel struct{}
 func (m AuthModel) CreateToken(userID string) (*TokenDetails, error) {
 
 	td := &TokenDetails{}
-	td.AtExpires = time.Now().Add(time.Minute * 60 * 24).Unix()
+	td.AtExpires = time.Now().Add(keys.AccessTokenLifetime).Unix()
 	td.AccessUUID = uuid.NewV4().String()
 
 	td.RtExpires = time.Now().Add(time.Hour * 24 * 7).Unix()
 	td.RefreshUUID = uuid.NewV4().String()
 
+	//Every token pair belongs to one session, see CreateAuth
+	td.SessionID = primitive.NewObjectID()
+
 	var err error
 	//Creating Access Token
 	atClaims := jwt.MapClaims{}
 	atClaims["authorized"] = true
 	atClaims["access_uuid"] = td.AccessUUID
 	atClaims["user_id"] = userID
+	atClaims["session_id"] = td.SessionID.Hex()
 	atClaims["exp"] = td.AtExpires
 
-	at := jwt.NewWithClaims(jwt.SigningMethodHS256, atClaims)
-	td.AccessToken, err = at.SignedString([]byte(os.Getenv("ACCESS_SECRET")))
+	//Access tokens are signed with the current asymmetric key so other services can verify them from the JWKS
+	signingKey, err := keys.GetKeyStore().Signer()
+	if err != nil {
+		return nil, err
+	}
+	at := jwt.NewWithClaims(signingKey.Method(), atClaims)
+	at.Header["kid"] = signingKey.ID
+	td.AccessToken, err = at.SignedString(signingKey.Private)
 	if err != nil {
 		return nil, err
 	}
@@ -64,6 +82,7 @@ func (m AuthModel) CreateToken(userID string) (*TokenDetails, error) {
 	rtClaims := jwt.MapClaims{}
 	rtClaims["refresh_uuid"] = td.RefreshUUID
 	rtClaims["user_id"] = userID
+	rtClaims["session_id"] = td.SessionID.Hex()
 	rtClaims["exp"] = td.RtExpires
 	rt := jwt.NewWithClaims(jwt.SigningMethodHS256, rtClaims)
 	td.RefreshToken, err = rt.SignedString([]byte(os.Getenv("REFRESH_SECRET")))
@@ -73,21 +92,45 @@ func (m AuthModel) CreateToken(userID string) (*TokenDetails, error) {
 	return td, nil
 }
 
-//CreateAuth ...
-func (m AuthModel) CreateAuth(userid string, td *TokenDetails) error {
-	// at := time.Unix(td.AtExpires, 0) //converting Unix to UTC(to Time object)
-	// rt := time.Unix(td.RtExpires, 0)
-	// now := time.Now()
-
-	// errAccess := db.GetRedis().Set(td.AccessUUID, strconv.Itoa(int(userid)), at.Sub(now)).Err()
-	// if errAccess != nil {
-	// 	return errAccess
-	// }
-	// errRefresh := db.GetRedis().Set(td.RefreshUUID, strconv.Itoa(int(userid)), rt.Sub(now)).Err()
-	// if errRefresh != nil {
-	// 	return errRefresh
-	// }
-	return nil
+//CreateAuth records the session the token pair belongs to
+func (m AuthModel) CreateAuth(userid string, td *TokenDetails, device SessionDevice) error {
+	userID, err := primitive.ObjectIDFromHex(userid)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now().Unix()
+	sessionCollection := db.GetCollection(db.DB, "sessions")
+	_, err = sessionCollection.InsertOne(ctx, Session{
+		ID:          td.SessionID,
+		UserID:      userID,
+		AccessUUID:  td.AccessUUID,
+		RefreshUUID: td.RefreshUUID,
+		AtExpires:   td.AtExpires,
+		RtExpires:   td.RtExpires,
+		DeviceName:  device.Name,
+		UserAgent:   device.UserAgent,
+		IP:          device.IP,
+		CreatedAt:   now,
+		LastUsedAt:  now,
+	})
+	return err
+}
+
+//RevokeAuth deletes every session of the user except the one holding exceptAccessUUID (pass "" to revoke all)
+func (m AuthModel) RevokeAuth(ctx context.Context, userID primitive.ObjectID, exceptAccessUUID string) error {
+	sessionCollection := db.GetCollection(db.DB, "sessions")
+
+	filter := bson.M{"userid": userID}
+	if exceptAccessUUID != "" {
+		filter["accessuuid"] = bson.M{"$ne": exceptAccessUUID}
+	}
+
+	_, err := sessionCollection.DeleteMany(ctx, filter)
+	return err
 }
 
 //ExtractToken ...
@@ -105,11 +148,16 @@ func (m AuthModel) ExtractToken(r *http.Request) string {
 func (m AuthModel) VerifyToken(r *http.Request) (*jwt.Token, error) {
 	tokenString := m.ExtractToken(r)
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		//Make sure that the token method conform to "SigningMethodHMAC"
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		//Select the key by "kid" and make sure the token method matches that key
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keys.GetKeyStore().Lookup(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key: %v", token.Header["kid"])
+		}
+		if token.Method.Alg() != key.Method().Alg() {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(os.Getenv("ACCESS_SECRET")), nil
+		return key.Public(), nil
 	})
 	if err != nil {
 		return nil, err
@@ -136,31 +184,78 @@ func (m AuthModel) ExtractTokenMetadata(r *http.Request) (*AccessDetails, error)
 		return nil, err
 	}
 	claims, ok := token.Claims.(jwt.MapClaims)
-	
-	userId, err := primitive.ObjectIDFromHex(claims["user_id"].(string))
-	
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	userIDHex, _ := claims["user_id"].(string)
+	userId, err := primitive.ObjectIDFromHex(userIDHex)
 	if err != nil {
 		return nil, err
 	}
 
-	if ok && token.Valid {
-		accessUUID, ok := claims["access_uuid"].(string)
-		if !ok {
-			return nil, err
-		}
+	accessUUID, ok := claims["access_uuid"].(string)
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
 
-		if err != nil {
-			return nil, err
-		}
-		return &AccessDetails{
-			AccessUUID: accessUUID,
-			UserID:     userId,
-		}, nil
+	details := &AccessDetails{
+		AccessUUID: accessUUID,
+		UserID:     userId,
+	}
+
+	//Tokens of service clients carry the client and the scopes they were granted
+	if clientID, ok := claims["client_id"].(string); ok {
+		scope, _ := claims["scope"].(string)
+		details.ClientID = clientID
+		details.Scopes = strings.Fields(scope)
+	}
+
+	return details, nil
+}
+
+//ExtractAPIKey returns the personal API key of the request, sent either as "X-API-Key" or as a bearer token
+func (m AuthModel) ExtractAPIKey(r *http.Request) string {
+	if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+		return apiKey
+	}
+	if token := m.ExtractToken(r); strings.HasPrefix(token, APIKeyPrefix) {
+		return token
 	}
-	return nil, err
+	return ""
 }
 
 //FetchAuth ...
 func (m AuthModel) FetchAuth(authD *AccessDetails) (primitive.ObjectID, error) {
-	return authD.UserID, nil
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	//Client tokens have no session, they live as long as the client isn't revoked
+	if authD.ClientID != "" {
+		client, err := oauthClientModel.Find(ctx, authD.ClientID)
+		if err != nil || client.UserID != authD.UserID {
+			return primitive.NilObjectID, ErrInvalidClient
+		}
+		return client.UserID, nil
+	}
+
+	//The session must still exist (not logged out or revoked)
+	sessionCollection := db.GetCollection(db.DB, "sessions")
+	var session Session
+	now := time.Now().Unix()
+	err := sessionCollection.FindOne(ctx, bson.M{
+		"accessuuid": authD.AccessUUID,
+		"userid":     authD.UserID,
+		"atexpires":  bson.M{"$gt": now},
+	}).Decode(&session)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	//Refresh last-used at most once a minute to avoid a write per request
+	if now-session.LastUsedAt >= 60 {
+		sessionCollection.UpdateOne(ctx, bson.M{"id": session.ID}, bson.M{"$set": bson.M{"lastusedat": now}})
+	}
+
+	return session.UserID, nil
 }
@@ -0,0 +1,381 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BalanceSnapshot is the main balance of a user at the end of a day (UTC), its _id "<user>:<day>" makes it unique.
+// At is the last second of the day, the transactions created until then are included
+type BalanceSnapshot struct {
+	Key       string             `json:"-" bson:"_id"`
+	UserID    primitive.ObjectID `json:"-"`
+	Day       string             `json:"day"`
+	Balance   int64              `json:"balance"`
+	At        int64              `json:"at"`
+	CreatedAt int64              `json:"created_at"`
+}
+
+// BalancePoint is the main balance at the end of a day, or now for the current day
+type BalancePoint struct {
+	Day     string `json:"day"`
+	Balance int64  `json:"balance"`
+}
+
+// PointInTimeBalance is the main balance after the transactions created until At
+type PointInTimeBalance struct {
+	At      int64 `json:"at"`
+	Balance int64 `json:"balance"`
+}
+
+// BalanceModel ...
+type BalanceModel struct{}
+
+var balanceModel = new(BalanceModel)
+
+// maxBalanceHistoryDays bounds the days of a balance history
+const maxBalanceHistoryDays = 366
+
+// endOfDay returns the last second of day ("2006-01-02")
+func endOfDay(day time.Time) int64 {
+	return day.AddDate(0, 0, 1).Unix() - 1
+}
+
+// balanceChange is how a transaction changes the main balance of username.
+// Pockets are left out: moves to and from them change the main balance, the money a user spends from a pocket does not
+func balanceChange(username string) bson.M {
+	amount := "$amount"
+	minusAmount := bson.M{"$multiply": bson.A{"$amount", -1}}
+	fromUser := bson.M{"$eq": bson.A{"$from", username}}
+	betweenUsers := bson.M{"$ne": bson.A{"$from", "$to"}}
+	fromPocket := bson.M{"$ne": bson.A{bson.M{"$ifNull": bson.A{"$pocketid", ""}}, ""}}
+
+	return bson.M{"$switch": bson.M{
+		"branches": bson.A{
+			bson.M{"case": bson.M{"$eq": bson.A{"$type", utils.POCKET_DEPOSIT}}, "then": minusAmount},
+			bson.M{"case": bson.M{"$eq": bson.A{"$type", utils.POCKET_WITHDRAW}}, "then": amount},
+			bson.M{"case": bson.M{"$and": bson.A{fromUser, fromPocket}}, "then": 0},
+			bson.M{"case": bson.M{"$and": bson.A{betweenUsers, fromUser}}, "then": minusAmount},
+			bson.M{"case": betweenUsers, "then": amount},
+			//Top-ups, interest and reversals of withdrawals are the other transactions to oneself
+			bson.M{"case": bson.M{"$eq": bson.A{"$type", utils.WITHDRAW}}, "then": minusAmount},
+		},
+		"default": amount,
+	}}
+}
+
+// changes sums the changes of the main balance of username by the transactions created in (after, until], per day
+func (m BalanceModel) changes(ctx context.Context, username string, after int64, until int64) (days map[string]int64, err error) {
+	transactionCollection := db.GetCollection(db.DB, "transactions")
+
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{
+			"$or": []bson.M{
+				{"from": username},
+				{"to": username},
+			},
+			"createdat": bson.M{"$gt": after, "$lte": until},
+		}},
+		bson.M{"$group": bson.M{
+			"_id": bson.M{"$dateToString": bson.M{
+				"format": "%Y-%m-%d",
+				"date":   bson.M{"$toDate": bson.M{"$multiply": bson.A{"$createdat", 1000}}},
+			}},
+			"change": bson.M{"$sum": balanceChange(username)},
+		}},
+		bson.M{"$project": bson.M{"_id": 0, "day": "$_id", "change": 1}},
+	}
+
+	results, err := transactionCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return days, err
+	}
+	defer results.Close(ctx)
+
+	days = map[string]int64{}
+	for results.Next(ctx) {
+		var total struct {
+			Day    string
+			Change int64
+		}
+		if err = results.Decode(&total); err != nil {
+			return days, err
+		}
+		days[total.Day] = total.Change
+	}
+
+	return days, results.Err()
+}
+
+func sumChanges(days map[string]int64) (sum int64) {
+	for _, change := range days {
+		sum += change
+	}
+	return sum
+}
+
+// balanceAt computes the main balance of the user at the time at. It replays the transactions since the last
+// snapshot before at, or back from the first snapshot after it. Without snapshots it goes back from the current
+// balance, reading it and the transactions in one Mongo transaction so they agree
+func (m BalanceModel) balanceAt(ctx context.Context, user User, at int64) (int64, error) {
+	snapshotCollection := db.GetCollection(db.DB, "balance_snapshots")
+
+	var snapshot BalanceSnapshot
+	opts := options.FindOne().SetSort(bson.M{"at": -1})
+	err := snapshotCollection.FindOne(ctx, bson.M{"userid": user.ID, "at": bson.M{"$lte": at}}, opts).Decode(&snapshot)
+	if err == nil {
+		days, err := m.changes(ctx, user.Username, snapshot.At, at)
+		return snapshot.Balance + sumChanges(days), err
+	}
+	if err != mongo.ErrNoDocuments {
+		return 0, err
+	}
+
+	opts = options.FindOne().SetSort(bson.M{"at": 1})
+	err = snapshotCollection.FindOne(ctx, bson.M{"userid": user.ID, "at": bson.M{"$gt": at}}, opts).Decode(&snapshot)
+	if err == nil {
+		days, err := m.changes(ctx, user.Username, at, snapshot.At)
+		return snapshot.Balance - sumChanges(days), err
+	}
+	if err != mongo.ErrNoDocuments {
+		return 0, err
+	}
+
+	data, err := withTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		userCollection := db.GetCollection(db.DB, "users")
+
+		var current User
+		err := userCollection.FindOne(sessionContext, bson.M{"id": user.ID}).Decode(&current)
+		if err != nil {
+			return nil, err
+		}
+
+		days, err := m.changes(sessionContext, user.Username, at, math.MaxInt64)
+		if err != nil {
+			return nil, err
+		}
+
+		return current.Balance - sumChanges(days), nil
+	})
+	balance, _ := data.(int64)
+
+	return balance, err
+}
+
+// pocketChange is how a transaction of a pocket changes its balance: deposits, interest and reversals of
+// withdrawals add to it, moves back to the main balance, withdrawals and payments take from it
+func pocketChange() bson.M {
+	return bson.M{"$cond": bson.M{
+		"if":   bson.M{"$in": bson.A{"$type", bson.A{utils.POCKET_DEPOSIT, utils.INTEREST, utils.WITHDRAW_REVERSAL}}},
+		"then": "$amount",
+		"else": bson.M{"$multiply": bson.A{"$amount", -1}},
+	}}
+}
+
+// pocketBalanceAt computes the balance of the pocket at the time at, back from its current balance. The pocket
+// and the transactions since are read in one Mongo transaction so they agree
+func (m BalanceModel) pocketBalanceAt(ctx context.Context, pocket Pocket, at int64) (int64, error) {
+	data, err := withTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		pocketCollection := db.GetCollection(db.DB, "pockets")
+		transactionCollection := db.GetCollection(db.DB, "transactions")
+
+		var current Pocket
+		err := pocketCollection.FindOne(sessionContext, bson.M{"id": pocket.ID}).Decode(&current)
+		if err != nil {
+			return nil, err
+		}
+
+		results, err := transactionCollection.Aggregate(sessionContext, bson.A{
+			bson.M{"$match": bson.M{"pocketid": pocket.ID.Hex(), "createdat": bson.M{"$gt": at}}},
+			bson.M{"$group": bson.M{"_id": nil, "change": bson.M{"$sum": pocketChange()}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer results.Close(sessionContext)
+
+		var since struct {
+			Change int64
+		}
+		if results.Next(sessionContext) {
+			if err = results.Decode(&since); err != nil {
+				return nil, err
+			}
+		}
+
+		return current.Balance - since.Change, nil
+	})
+	balance, _ := data.(int64)
+
+	return balance, err
+}
+
+// At returns the main balance of the user at the unix time at
+func (m BalanceModel) At(ctx context.Context, userID primitive.ObjectID, at int64) (balance PointInTimeBalance, err error) {
+	fmt.Println("Balance model: At")
+	userCollection := db.GetCollection(db.DB, "users")
+
+	var user User
+	err = userCollection.FindOne(ctx, bson.M{"id": userID}).Decode(&user)
+	if err != nil {
+		return balance, errors.New("something went wrong, please try again later")
+	}
+
+	balance.At = at
+	if at < user.CreatedAt {
+		return balance, nil
+	}
+
+	balance.Balance, err = m.balanceAt(ctx, user, at)
+	if err != nil {
+		return balance, errors.New("error when computing the balance")
+	}
+
+	return balance, nil
+}
+
+// History returns the main balance of the user at the end of every day from the day from to the day to ("2006-01-02"),
+// the current day has the balance so far
+func (m BalanceModel) History(ctx context.Context, userID primitive.ObjectID, from string, to string) (points []BalancePoint, err error) {
+	fmt.Println("Balance model: History")
+	userCollection := db.GetCollection(db.DB, "users")
+
+	now := time.Now().UTC()
+	last := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if to != "" {
+		last, _ = time.Parse("2006-01-02", to)
+	}
+	first := last.AddDate(0, 0, -29)
+	if from != "" {
+		first, _ = time.Parse("2006-01-02", from)
+	}
+	if first.After(last) {
+		return points, errors.New("the first day must not be after the last one")
+	}
+	if first.AddDate(0, 0, maxBalanceHistoryDays).Before(last.AddDate(0, 0, 1)) {
+		return points, fmt.Errorf("the history can be at most %d days", maxBalanceHistoryDays)
+	}
+
+	var user User
+	err = userCollection.FindOne(ctx, bson.M{"id": userID}).Decode(&user)
+	if err != nil {
+		return points, errors.New("something went wrong, please try again later")
+	}
+
+	//The balance at the end of the first day, then the change of every following day
+	balance, err := m.balanceAt(ctx, user, endOfDay(first))
+	if err != nil {
+		return points, errors.New("error when computing the balance history")
+	}
+	days, err := m.changes(ctx, user.Username, endOfDay(first), endOfDay(last))
+	if err != nil {
+		return points, errors.New("error when computing the balance history")
+	}
+
+	today := now.Format("2006-01-02")
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		key := day.Format("2006-01-02")
+		if key > today {
+			break
+		}
+		if !day.Equal(first) {
+			balance += days[key]
+		}
+		points = append(points, BalancePoint{Day: key, Balance: balance})
+	}
+
+	return points, nil
+}
+
+// SnapshotDay records the balance of every user at the end of day ("2006-01-02"), and of the days since their
+// last snapshot. The day must be over for longer than the longest Mongo transaction, so none of its transactions
+// is still to commit. Users that already have the snapshot of the day are skipped
+func (m BalanceModel) SnapshotDay(ctx context.Context, day string) error {
+	fmt.Println("Balance model: SnapshotDay")
+	userCollection := db.GetCollection(db.DB, "users")
+
+	end, err := time.Parse("2006-01-02", day)
+	if err != nil {
+		return fmt.Errorf("invalid snapshot day %q", day)
+	}
+
+	results, err := userCollection.Find(ctx, bson.M{"createdat": bson.M{"$lte": endOfDay(end)}})
+	if err != nil {
+		return err
+	}
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var user User
+		if err = results.Decode(&user); err != nil {
+			return err
+		}
+		if err = m.snapshot(ctx, user, end); err != nil {
+			return err
+		}
+	}
+
+	return results.Err()
+}
+
+// snapshot records the balance of the user at the end of day, carrying the last snapshot forward
+// over the days missing since. Without a snapshot yet, it starts from the current balance
+func (m BalanceModel) snapshot(ctx context.Context, user User, day time.Time) error {
+	snapshotCollection := db.GetCollection(db.DB, "balance_snapshots")
+
+	var last BalanceSnapshot
+	opts := options.FindOne().SetSort(bson.M{"at": -1})
+	err := snapshotCollection.FindOne(ctx, bson.M{"userid": user.ID}, opts).Decode(&last)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	if err == nil && last.At >= endOfDay(day) {
+		return nil
+	}
+
+	var snapshots []BalanceSnapshot
+	if err == mongo.ErrNoDocuments {
+		balance, err := m.balanceAt(ctx, user, endOfDay(day))
+		if err != nil {
+			return err
+		}
+		snapshots = append(snapshots, BalanceSnapshot{Day: day.Format("2006-01-02"), Balance: balance, At: endOfDay(day)})
+	} else {
+		days, err := m.changes(ctx, user.Username, last.At, endOfDay(day))
+		if err != nil {
+			return err
+		}
+
+		balance := last.Balance
+		start, _ := time.Parse("2006-01-02", last.Day)
+		for next := start.AddDate(0, 0, 1); !next.After(day); next = next.AddDate(0, 0, 1) {
+			key := next.Format("2006-01-02")
+			balance += days[key]
+			snapshots = append(snapshots, BalanceSnapshot{Day: key, Balance: balance, At: endOfDay(next)})
+		}
+	}
+
+	now := time.Now().Unix()
+	for _, snapshot := range snapshots {
+		snapshot.Key = user.ID.Hex() + ":" + snapshot.Day
+		snapshot.UserID = user.ID
+		snapshot.CreatedAt = now
+
+		//Another instance of the job may have written it
+		_, err = snapshotCollection.InsertOne(ctx, snapshot)
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+
+	return nil
+}
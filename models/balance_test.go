@@ -0,0 +1,110 @@
+//go:build all
+// +build all
+
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestBalanceChange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	//The expression is evaluated by MongoDB over transactions of a scratch collection
+	collection := db.GetCollection(db.DB, "test_balance_changes_"+primitive.NewObjectID().Hex())
+	defer collection.Drop(ctx)
+
+	tests := []struct {
+		name     string
+		kind     string
+		from     string
+		to       string
+		pocketID string
+		change   int64
+	}{
+		{"top-up", utils.TOP_UP, "alice", "alice", "", 100},
+		{"withdrawal", utils.WITHDRAW, "alice", "alice", "", -100},
+		{"withdrawal from a pocket", utils.WITHDRAW, "alice", "alice", "p1", 0},
+		{"withdrawal given back", utils.WITHDRAW_REVERSAL, "alice", "alice", "", 100},
+		{"withdrawal given back to a pocket", utils.WITHDRAW_REVERSAL, "alice", "alice", "p1", 0},
+		{"transfer sent", utils.TRANSFER, "alice", "bob", "", -100},
+		{"transfer sent from a pocket", utils.TRANSFER, "alice", "bob", "p1", 0},
+		{"transfer received", utils.TRANSFER, "bob", "alice", "", 100},
+		{"transfer received from a pocket of the sender", utils.TRANSFER, "bob", "alice", "p2", 100},
+		{"pocket deposit", utils.POCKET_DEPOSIT, "alice", "alice", "p1", -100},
+		{"pocket withdrawal", utils.POCKET_WITHDRAW, "alice", "alice", "p1", 100},
+		{"interest", utils.INTEREST, "alice", "alice", "", 100},
+		{"interest of a pocket", utils.INTEREST, "alice", "alice", "p1", 0},
+		{"invoice paid", utils.INVOICE_PAYMENT, "alice", "MABCDEF1234", "", -100},
+		{"invoice refunded", utils.INVOICE_REFUND, "MABCDEF1234", "alice", "", 100},
+		{"merchant payout", utils.MERCHANT_PAYOUT, "MABCDEF1234", "alice", "", 100},
+		{"cashback", utils.CASHBACK, "promo", "alice", "", 100},
+		{"cashback taken back", utils.CASHBACK_REVERSAL, "alice", "promo", "", -100},
+	}
+
+	for i, test := range tests {
+		_, err := collection.InsertOne(ctx, bson.M{"_id": i, "type": test.kind, "from": test.from, "to": test.to, "amount": int64(100), "pocketid": test.pocketID})
+		require.NoError(t, err)
+	}
+
+	results, err := collection.Aggregate(ctx, bson.A{
+		bson.M{"$project": bson.M{"change": balanceChange("alice")}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+	})
+	require.NoError(t, err)
+	var changes []struct {
+		ID     int `bson:"_id"`
+		Change int64
+	}
+	require.NoError(t, results.All(ctx, &changes))
+	require.Len(t, changes, len(tests))
+
+	for i, test := range tests {
+		assert.Equal(t, test.change, changes[i].Change, test.name)
+	}
+}
+
+func TestBalanceAt(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	user := newTestUser(t, 10000)
+	other := newTestUser(t, 10000)
+	pocket, err := new(PocketModel).Create(ctx, user.ID, forms.CreatePocketForm{Name: "Savings"})
+	require.NoError(t, err)
+
+	//Transactions are timed to the second
+	before := time.Now().Unix()
+	time.Sleep(1100 * time.Millisecond)
+
+	_, err = new(UserModel).Transfer(ctx, user.ID, forms.TransferForm{To: other.Username, Amount: 1000})
+	require.NoError(t, err)
+	_, err = new(PocketModel).Deposit(ctx, user.ID, pocket.ID.Hex(), 2000)
+	require.NoError(t, err)
+	_, err = new(UserModel).Transfer(ctx, user.ID, forms.TransferForm{To: other.Username, Amount: 500, Pocket: pocket.ID.Hex()})
+	require.NoError(t, err)
+	_, err = new(PocketModel).Withdraw(ctx, user.ID, pocket.ID.Hex(), 700)
+	require.NoError(t, err)
+	_, err = new(UserModel).Transfer(ctx, other.ID, forms.TransferForm{To: user.Username, Amount: 300})
+	require.NoError(t, err)
+
+	now, err := new(BalanceModel).At(ctx, user.ID, time.Now().Unix())
+	require.NoError(t, err)
+	assert.Equal(t, int64(10000-1000-2000+700+300), now.Balance)
+	assert.Equal(t, testBalance(t, user.ID), now.Balance)
+
+	then, err := new(BalanceModel).At(ctx, user.ID, before)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10000), then.Balance, "the transactions since are replayed back")
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BankAccount is a bank account a user withdraws to, only the last 4 characters of its number are shown
+type BankAccount struct {
+	ID            primitive.ObjectID `json:"id"`
+	UserID        primitive.ObjectID `json:"-"`
+	HolderName    string             `json:"holder_name"`
+	BankCode      string             `json:"bank_code"`
+	AccountNumber string             `json:"-"`
+	Last4         string             `json:"account_number_last4"`
+	CreatedAt     int64              `json:"created_at"`
+}
+
+// ErrBankAccountNotFound ...
+var ErrBankAccountNotFound = errors.New("bank account not found")
+
+// BankAccountModel ...
+type BankAccountModel struct{}
+
+var bankAccountModel = new(BankAccountModel)
+
+// maxBankAccounts bounds the bank accounts of one user
+const maxBankAccounts = 10
+
+// Create ...
+func (m BankAccountModel) Create(ctx context.Context, userID primitive.ObjectID, form forms.CreateBankAccountForm) (account BankAccount, err error) {
+	fmt.Println("BankAccount model: Create")
+	accountCollection := db.GetCollection(db.DB, "bank_accounts")
+
+	count, err := accountCollection.CountDocuments(ctx, bson.M{"userid": userID})
+	if err != nil {
+		return account, errors.New("something went wrong, please try again later")
+	}
+	if count >= maxBankAccounts {
+		return account, fmt.Errorf("you can have at most %d bank accounts", maxBankAccounts)
+	}
+
+	number := strings.ToUpper(form.AccountNumber)
+	account = BankAccount{
+		ID:            primitive.NewObjectID(),
+		UserID:        userID,
+		HolderName:    form.HolderName,
+		BankCode:      strings.ToUpper(form.BankCode),
+		AccountNumber: number,
+		Last4:         number[len(number)-4:],
+		CreatedAt:     time.Now().Unix(),
+	}
+
+	_, err = accountCollection.InsertOne(ctx, account)
+	if err != nil {
+		return account, errors.New("error when creating new bank account")
+	}
+
+	return account, nil
+}
+
+// List ...
+func (m BankAccountModel) List(ctx context.Context, userID primitive.ObjectID) (accounts []BankAccount, err error) {
+	fmt.Println("BankAccount model: List")
+	accountCollection := db.GetCollection(db.DB, "bank_accounts")
+
+	results, err := accountCollection.Find(ctx, bson.M{"userid": userID}, options.Find().SetSort(bson.M{"createdat": 1}))
+	if err != nil {
+		return accounts, errors.New("error when retrieving bank accounts")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var account BankAccount
+		if err = results.Decode(&account); err != nil {
+			return accounts, errors.New("error when decoding bank account")
+		}
+
+		accounts = append(accounts, account)
+	}
+
+	return accounts, nil
+}
+
+// Find returns a bank account of the user, id is the hex of its ID
+func (m BankAccountModel) Find(ctx context.Context, userID primitive.ObjectID, id string) (account BankAccount, err error) {
+	accountCollection := db.GetCollection(db.DB, "bank_accounts")
+
+	accountID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return account, ErrBankAccountNotFound
+	}
+
+	err = accountCollection.FindOne(ctx, bson.M{"id": accountID, "userid": userID}).Decode(&account)
+	if err == mongo.ErrNoDocuments {
+		return account, ErrBankAccountNotFound
+	}
+	if err != nil {
+		return account, errors.New("something went wrong, please try again later")
+	}
+
+	return account, nil
+}
+
+// Delete removes a bank account, the payouts already sent to it keep its details
+func (m BankAccountModel) Delete(ctx context.Context, userID primitive.ObjectID, id string) error {
+	fmt.Println("BankAccount model: Delete")
+	accountCollection := db.GetCollection(db.DB, "bank_accounts")
+
+	account, err := m.Find(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = accountCollection.DeleteOne(ctx, bson.M{"id": account.ID})
+	if err != nil {
+		return errors.New("internal server error")
+	}
+
+	return nil
+}
@@ -1,7 +1,32 @@
 package models
 
+import (
+	"context"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
 type Query struct {
 	Page  int    `json:"page,omitempty"`
 	Limit int    `json:"limit,omitempty"`
 	Order string `json:"order,omitempty"`
 }
+
+// withTransaction runs fn in a Mongo transaction (majority writes, snapshot reads), like the money movements of UserModel
+func withTransaction(ctx context.Context, fn func(sessionContext mongo.SessionContext) (interface{}, error)) (interface{}, error) {
+	wc := writeconcern.New(writeconcern.WMajority())
+	rc := readconcern.Snapshot()
+	txnOpts := options.Transaction().SetWriteConcern(wc).SetReadConcern(rc)
+
+	session, err := db.DB.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	return session.WithTransaction(ctx, fn, txnOpts)
+}
@@ -0,0 +1,437 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/encryption"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BatchTransferItem is one transfer of a batch. Its status is updated in the same Mongo
+// transaction as the transfer, so an item is paid at most once whatever the retries
+type BatchTransferItem struct {
+	Index         int                `json:"index"`
+	To            string             `json:"to"`
+	Amount        int64              `json:"amount"`
+	Reference     string             `json:"reference,omitempty"`
+	Status        string             `json:"status"`
+	Error         string             `json:"error,omitempty"`
+	TransactionID primitive.ObjectID `json:"transaction_id,omitempty"`
+	ProcessedAt   int64              `json:"processed_at,omitempty"`
+}
+
+// BatchTransfer pays many recipients from one account
+type BatchTransfer struct {
+	ID             primitive.ObjectID  `json:"id"`
+	UserID         primitive.ObjectID  `json:"-"`
+	IdempotencyKey string              `json:"idempotency_key,omitempty"`
+	Mode           string              `json:"mode"`
+	Status         string              `json:"status"`
+	Error          string              `json:"error,omitempty"`
+	Total          int64               `json:"total"`
+	Count          int                 `json:"count"`
+	Succeeded      int                 `json:"succeeded"`
+	Failed         int                 `json:"failed"`
+	Items          []BatchTransferItem `json:"items,omitempty"`
+	LeaseUntil     int64               `json:"-"`
+	CreatedAt      int64               `json:"created_at"`
+	UpdatedAt      int64               `json:"updated_at"`
+	CompletedAt    int64               `json:"completed_at,omitempty"`
+}
+
+// BatchItemError tells why an item of a batch was rejected, Index is -1 for errors about the whole batch
+type BatchItemError struct {
+	Index   int    `json:"index"`
+	Message string `json:"message"`
+}
+
+// BatchValidationError is returned when items of a batch are invalid, nothing was executed
+type BatchValidationError struct {
+	Items []BatchItemError
+}
+
+func (e *BatchValidationError) Error() string {
+	if len(e.Items) == 1 {
+		return "1 item of the batch is invalid"
+	}
+	return fmt.Sprintf("%d items of the batch are invalid", len(e.Items))
+}
+
+// ErrBatchNotFound ...
+var ErrBatchNotFound = errors.New("batch not found")
+
+// BatchTransferModel ...
+type BatchTransferModel struct{}
+
+// batchLease is how long a worker owns a claimed batch before another one may resume it
+const batchLease = 5 * time.Minute
+
+// EnsureIndexes creates the unique index keeping a single batch per idempotency key of a user
+func (m BatchTransferModel) EnsureIndexes(ctx context.Context) error {
+	batchCollection := db.GetCollection(db.DB, "batch_transfers")
+
+	_, err := batchCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userid", Value: 1}, {Key: "idempotencykey", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"idempotencykey": bson.M{"$gt": ""}}),
+	})
+	return err
+}
+
+// Create validates every item up front then records the batch. A BEST_EFFORT batch is executed in the
+// background by ProcessNext, an ALL_OR_NOTHING batch is executed here in one Mongo transaction.
+// Sending the same idempotency key again returns the batch created the first time
+func (m BatchTransferModel) Create(ctx context.Context, userID primitive.ObjectID, idempotencyKey string, form forms.CreateBatchTransferForm) (batch BatchTransfer, err error) {
+	fmt.Println("BatchTransfer model: Create")
+	userCollection := db.GetCollection(db.DB, "users")
+	batchCollection := db.GetCollection(db.DB, "batch_transfers")
+
+	if idempotencyKey != "" {
+		err = batchCollection.FindOne(ctx, bson.M{"userid": userID, "idempotencykey": idempotencyKey}).Decode(&batch)
+		if err == nil {
+			return batch, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return batch, errors.New("something went wrong, please try again later")
+		}
+	}
+
+	var source User
+	err = userCollection.FindOne(ctx, bson.M{"id": userID}).Decode(&source)
+	if err != nil {
+		return batch, errors.New("something went wrong, please try again later")
+	}
+
+	if err = m.validate(ctx, source, form.Items); err != nil {
+		return batch, err
+	}
+
+	now := time.Now().Unix()
+	batch = BatchTransfer{
+		ID:             primitive.NewObjectID(),
+		UserID:         userID,
+		IdempotencyKey: idempotencyKey,
+		Mode:           form.Mode,
+		Status:         utils.BATCH_PENDING,
+		Count:          len(form.Items),
+		Items:          make([]BatchTransferItem, len(form.Items)),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if batch.Mode == "" {
+		batch.Mode = utils.BATCH_BEST_EFFORT
+	}
+	for i, item := range form.Items {
+		batch.Total += item.Amount
+		batch.Items[i] = BatchTransferItem{Index: i, To: item.To, Amount: item.Amount, Reference: item.Reference, Status: utils.BATCH_ITEM_PENDING}
+	}
+
+	//The upsert keeps two concurrent requests with the same idempotency key to a single batch. When both insert,
+	//the unique index refuses the second one and it returns the batch of the first, see EnsureIndexes
+	filter := bson.M{"id": batch.ID}
+	if idempotencyKey != "" {
+		filter = bson.M{"userid": userID, "idempotencykey": idempotencyKey}
+	}
+	result, err := batchCollection.UpdateOne(ctx, filter, bson.M{"$setOnInsert": batch}, options.Update().SetUpsert(true))
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return batch, errors.New("error when creating new batch")
+	}
+	if err != nil || result.UpsertedCount == 0 {
+		err = batchCollection.FindOne(ctx, filter).Decode(&batch)
+		return batch, err
+	}
+
+	if batch.Mode == utils.BATCH_ALL_OR_NOTHING {
+		return m.executeAll(ctx, batch)
+	}
+
+	return batch, nil
+}
+
+// validate checks the recipients, the references and the balance before anything is executed
+func (m BatchTransferModel) validate(ctx context.Context, source User, items []forms.BatchTransferItemForm) error {
+	userCollection := db.GetCollection(db.DB, "users")
+
+	usernames := make([]string, 0, len(items))
+	indexes := make([]string, 0, len(items))
+	for _, item := range items {
+		usernames = append(usernames, item.To)
+		indexes = append(indexes, encryption.GetKeyFile().BlindIndex("username", item.To))
+	}
+
+	//The id is needed to decrypt the username
+	filter := bson.M{"$or": []bson.M{{"usernameindex": bson.M{"$in": indexes}}, {"username": bson.M{"$in": usernames}}}}
+	results, err := userCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"id": 1, "username": 1}))
+	if err != nil {
+		return errors.New("something went wrong, please try again later")
+	}
+
+	var recipients []User
+	if err = results.All(ctx, &recipients); err != nil {
+		return errors.New("something went wrong, please try again later")
+	}
+
+	existing := map[string]bool{}
+	for _, recipient := range recipients {
+		existing[recipient.Username] = true
+	}
+
+	var invalid []BatchItemError
+	var total int64
+	references := map[string]bool{}
+	for i, item := range items {
+		switch {
+		case item.To == source.Username:
+			invalid = append(invalid, BatchItemError{Index: i, Message: "you can not transfer to yourself"})
+		case !existing[item.To]:
+			invalid = append(invalid, BatchItemError{Index: i, Message: "target user not existed"})
+		case item.Reference != "" && references[item.Reference]:
+			invalid = append(invalid, BatchItemError{Index: i, Message: "duplicate reference " + item.Reference})
+		}
+		references[item.Reference] = true
+		total += item.Amount
+	}
+
+	if total > source.Balance {
+		invalid = append(invalid, BatchItemError{Index: -1, Message: "your balance is not enough to execute the batch"})
+	}
+
+	if len(invalid) > 0 {
+		return &BatchValidationError{Items: invalid}
+	}
+
+	return nil
+}
+
+// itemReference links the transaction of an item to its batch
+func itemReference(batch BatchTransfer, index int) string {
+	return "batch:" + batch.ID.Hex() + ":" + strconv.Itoa(index)
+}
+
+// executeAll runs every item in one Mongo transaction, a single failure rolls the whole batch back
+func (m BatchTransferModel) executeAll(ctx context.Context, batch BatchTransfer) (BatchTransfer, error) {
+	batchCollection := db.GetCollection(db.DB, "batch_transfers")
+
+	failed := -1
+	data, err := withTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		failed = -1
+		items := make([]BatchTransferItem, len(batch.Items))
+		copy(items, batch.Items)
+
+		for i, item := range items {
+			transaction, err := userModel.transfer(sessionContext, batch.UserID, item.To, item.Amount, itemReference(batch, i), "", "")
+			if err != nil {
+				failed = i
+				return nil, err
+			}
+
+			items[i].Status = utils.BATCH_ITEM_SUCCEEDED
+			items[i].TransactionID = transaction.ID
+			items[i].ProcessedAt = time.Now().Unix()
+		}
+
+		now := time.Now().Unix()
+		result, err := batchCollection.UpdateOne(sessionContext, bson.M{"id": batch.ID, "status": utils.BATCH_PENDING}, bson.M{"$set": bson.M{
+			"status":      utils.BATCH_COMPLETED,
+			"items":       items,
+			"succeeded":   len(items),
+			"updatedat":   now,
+			"completedat": now,
+		}})
+		if err == nil && result.MatchedCount == 0 {
+			err = errors.New("the batch was already executed")
+		}
+
+		return items, err
+	})
+
+	now := time.Now().Unix()
+	if err != nil {
+		//Nothing was committed: every item failed, the one that broke the batch says why
+		for i := range batch.Items {
+			batch.Items[i].Status = utils.BATCH_ITEM_FAILED
+			batch.Items[i].ProcessedAt = now
+		}
+		batch.Status = utils.BATCH_FAILED
+		batch.Error = "no transfer was made: " + err.Error()
+		if failed >= 0 {
+			batch.Items[failed].Error = err.Error()
+			batch.Error = fmt.Sprintf("item %d failed, no transfer was made: %s", failed, err.Error())
+		}
+		batch.Failed = len(batch.Items)
+		batch.UpdatedAt = now
+		batch.CompletedAt = now
+
+		batchCollection.UpdateOne(ctx, bson.M{"id": batch.ID, "status": utils.BATCH_PENDING}, bson.M{"$set": bson.M{
+			"status":      batch.Status,
+			"error":       batch.Error,
+			"items":       batch.Items,
+			"failed":      batch.Failed,
+			"updatedat":   now,
+			"completedat": now,
+		}})
+
+		return batch, nil
+	}
+
+	batch.Items, _ = data.([]BatchTransferItem)
+	batch.Status = utils.BATCH_COMPLETED
+	batch.Succeeded = len(batch.Items)
+	batch.UpdatedAt = now
+	batch.CompletedAt = now
+
+	return batch, nil
+}
+
+// ProcessNext claims the oldest BEST_EFFORT batch waiting for work and executes its pending items,
+// each in its own Mongo transaction. A batch left behind by a crashed worker is resumed once its lease
+// expires. It returns mongo.ErrNoDocuments when there is nothing to do
+func (m BatchTransferModel) ProcessNext(ctx context.Context) error {
+	batchCollection := db.GetCollection(db.DB, "batch_transfers")
+
+	var batch BatchTransfer
+	now := time.Now().Unix()
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"createdat": 1}).SetReturnDocument(options.After)
+	err := batchCollection.FindOneAndUpdate(ctx,
+		bson.M{
+			"mode":       utils.BATCH_BEST_EFFORT,
+			"status":     bson.M{"$in": []string{utils.BATCH_PENDING, utils.BATCH_PROCESSING}},
+			"leaseuntil": bson.M{"$lt": now},
+		},
+		bson.M{"$set": bson.M{"status": utils.BATCH_PROCESSING, "leaseuntil": time.Now().Add(batchLease).Unix(), "updatedat": now}},
+		opts).Decode(&batch)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range batch.Items {
+		if item.Status != utils.BATCH_ITEM_PENDING {
+			continue
+		}
+		if err = m.executeItem(ctx, batch, item); err != nil {
+			return err
+		}
+	}
+
+	now = time.Now().Unix()
+	_, err = batchCollection.UpdateOne(ctx, bson.M{"id": batch.ID}, bson.M{"$set": bson.M{
+		"status":      utils.BATCH_COMPLETED,
+		"leaseuntil":  int64(0),
+		"updatedat":   now,
+		"completedat": now,
+	}})
+	return err
+}
+
+// executeItem pays one item. The item is only moved from PENDING inside the transaction of the transfer,
+// so an item already paid by a previous run is skipped. A transfer rejected for a business reason marks the
+// item FAILED, see RejectedError. Any other error leaves it PENDING for the next run
+func (m BatchTransferModel) executeItem(ctx context.Context, batch BatchTransfer, item BatchTransferItem) error {
+	batchCollection := db.GetCollection(db.DB, "batch_transfers")
+
+	field := "items." + strconv.Itoa(item.Index) + "."
+	pending := bson.M{"id": batch.ID, field + "status": utils.BATCH_ITEM_PENDING}
+
+	_, err := withTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		transaction, err := userModel.transfer(sessionContext, batch.UserID, item.To, item.Amount, itemReference(batch, item.Index), "", "")
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now().Unix()
+		result, err := batchCollection.UpdateOne(sessionContext, pending, bson.M{
+			"$set": bson.M{
+				field + "status":        utils.BATCH_ITEM_SUCCEEDED,
+				field + "transactionid": transaction.ID,
+				field + "processedat":   now,
+				"updatedat":             now,
+			},
+			"$inc": bson.M{"succeeded": 1},
+		})
+		if err == nil && result.MatchedCount == 0 {
+			err = errBatchItemDone
+		}
+
+		return nil, err
+	})
+	if err == errBatchItemDone {
+		return nil
+	}
+	if ctx.Err() != nil {
+		//Out of time, the item stays pending for the next run
+		return ctx.Err()
+	}
+	if err != nil && !IsRejected(err) {
+		return err
+	}
+	if err != nil {
+		now := time.Now().Unix()
+		_, err = batchCollection.UpdateOne(ctx, pending, bson.M{
+			"$set": bson.M{
+				field + "status":      utils.BATCH_ITEM_FAILED,
+				field + "error":       err.Error(),
+				field + "processedat": now,
+				"updatedat":           now,
+			},
+			"$inc": bson.M{"failed": 1},
+		})
+	}
+
+	return err
+}
+
+// errBatchItemDone aborts the transaction of an item another run already processed
+var errBatchItemDone = errors.New("the item was already processed")
+
+// Find returns a batch of the user, without its items unless withItems
+func (m BatchTransferModel) Find(ctx context.Context, userID primitive.ObjectID, id primitive.ObjectID, withItems bool) (batch BatchTransfer, err error) {
+	fmt.Println("BatchTransfer model: Find")
+	batchCollection := db.GetCollection(db.DB, "batch_transfers")
+
+	opts := options.FindOne()
+	if !withItems {
+		opts.SetProjection(bson.M{"items": 0})
+	}
+
+	err = batchCollection.FindOne(ctx, bson.M{"id": id, "userid": userID}, opts).Decode(&batch)
+	if err == mongo.ErrNoDocuments {
+		return batch, ErrBatchNotFound
+	}
+
+	return batch, err
+}
+
+// List returns the batches of the user without their items, newest first
+func (m BatchTransferModel) List(ctx context.Context, userID primitive.ObjectID, query Query) (batches []BatchTransfer, err error) {
+	fmt.Println("BatchTransfer model: List")
+	batchCollection := db.GetCollection(db.DB, "batch_transfers")
+
+	opts := options.Find().SetSort(bson.M{"createdat": -1}).SetProjection(bson.M{"items": 0}).
+		SetSkip(int64((query.Page - 1) * query.Limit)).SetLimit(int64(query.Limit))
+	results, err := batchCollection.Find(ctx, bson.M{"userid": userID}, opts)
+	if err != nil {
+		return batches, errors.New("error when retrieving batches")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var batch BatchTransfer
+		if err = results.Decode(&batch); err != nil {
+			return batches, errors.New("error when decoding batch")
+		}
+
+		batches = append(batches, batch)
+	}
+
+	return batches, nil
+}
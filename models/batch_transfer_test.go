@@ -0,0 +1,117 @@
+//go:build all
+// +build all
+
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestBatchAllOrNothing(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	source := newTestUser(t, 10000)
+	first := newTestUser(t, 0)
+	second := newTestUser(t, 0)
+	full := newFullTestUser(t)
+
+	batch, err := new(BatchTransferModel).Create(ctx, source.ID, "", forms.CreateBatchTransferForm{
+		Mode:  utils.BATCH_ALL_OR_NOTHING,
+		Items: []forms.BatchTransferItemForm{{To: first.Username, Amount: 1000}, {To: second.Username, Amount: 2000}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, utils.BATCH_COMPLETED, batch.Status)
+	assert.Equal(t, 2, batch.Succeeded)
+	assert.Equal(t, int64(7000), testBalance(t, source.ID))
+	assert.Equal(t, int64(1000), testBalance(t, first.ID))
+	assert.Equal(t, int64(2000), testBalance(t, second.ID))
+
+	//The second item is refused by the KYC limit of its recipient, the first one is rolled back
+	batch, err = new(BatchTransferModel).Create(ctx, source.ID, "", forms.CreateBatchTransferForm{
+		Mode:  utils.BATCH_ALL_OR_NOTHING,
+		Items: []forms.BatchTransferItemForm{{To: first.Username, Amount: 1000}, {To: full.Username, Amount: 1000}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, utils.BATCH_FAILED, batch.Status)
+	assert.Equal(t, 2, batch.Failed)
+	assert.Equal(t, "the target user can not receive this amount", batch.Items[1].Error)
+	assert.Equal(t, int64(7000), testBalance(t, source.ID))
+	assert.Equal(t, int64(1000), testBalance(t, first.ID))
+}
+
+func TestBatchBestEffort(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	source := newTestUser(t, 10000)
+	target := newTestUser(t, 0)
+	full := newFullTestUser(t)
+
+	created, err := new(BatchTransferModel).Create(ctx, source.ID, "", forms.CreateBatchTransferForm{
+		Items: []forms.BatchTransferItemForm{{To: target.Username, Amount: 1000}, {To: full.Username, Amount: 1000}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, utils.BATCH_PENDING, created.Status)
+
+	//Other batches may be waiting in the database, they are processed first
+	batch := created
+	for batch.Status != utils.BATCH_COMPLETED {
+		err = new(BatchTransferModel).ProcessNext(ctx)
+		if err == mongo.ErrNoDocuments {
+			break
+		}
+		require.NoError(t, err)
+		batch, err = new(BatchTransferModel).Find(ctx, source.ID, created.ID, true)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, utils.BATCH_COMPLETED, batch.Status)
+	assert.Equal(t, 1, batch.Succeeded)
+	assert.Equal(t, 1, batch.Failed)
+	assert.Equal(t, utils.BATCH_ITEM_SUCCEEDED, batch.Items[0].Status)
+	assert.NotEqual(t, primitive.NilObjectID, batch.Items[0].TransactionID)
+	assert.Equal(t, utils.BATCH_ITEM_FAILED, batch.Items[1].Status)
+	assert.Equal(t, int64(9000), testBalance(t, source.ID))
+	assert.Equal(t, int64(1000), testBalance(t, target.ID))
+}
+
+func TestBatchIdempotencyKey(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	require.NoError(t, new(BatchTransferModel).EnsureIndexes(ctx))
+
+	source := newTestUser(t, 10000)
+	target := newTestUser(t, 0)
+	form := forms.CreateBatchTransferForm{
+		Mode:  utils.BATCH_ALL_OR_NOTHING,
+		Items: []forms.BatchTransferItemForm{{To: target.Username, Amount: 1000}},
+	}
+
+	//Concurrent requests with the same key create one batch, and pay it once
+	ids := make(chan primitive.ObjectID, 5)
+	for i := 0; i < cap(ids); i++ {
+		go func() {
+			batch, err := new(BatchTransferModel).Create(ctx, source.ID, "same-key", form)
+			assert.NoError(t, err)
+			ids <- batch.ID
+		}()
+	}
+
+	first := <-ids
+	for i := 1; i < cap(ids); i++ {
+		assert.Equal(t, first, <-ids)
+	}
+	assert.Equal(t, int64(9000), testBalance(t, source.ID))
+	assert.Equal(t, int64(1000), testBalance(t, target.ID))
+}
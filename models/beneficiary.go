@@ -0,0 +1,276 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Beneficiary is a saved recipient of transfers. When transfers are restricted to beneficiaries,
+// a new one can receive money from TrustedAt, after the cooling-off period
+type Beneficiary struct {
+	ID            primitive.ObjectID `json:"id"`
+	UserID        primitive.ObjectID `json:"-"`
+	BeneficiaryID primitive.ObjectID `json:"-"`
+	Username      string             `json:"username"`
+	DisplayName   string             `json:"display_name"`
+	Nickname      string             `json:"nickname,omitempty"`
+	LastUsedAt    int64              `json:"last_used_at,omitempty"`
+	TrustedAt     int64              `json:"trusted_at"`
+	CreatedAt     int64              `json:"created_at"`
+}
+
+// BeneficiarySettings tells whether transfers are restricted to saved beneficiaries. Lifting the restriction
+// also waits for the cooling-off period: it stays on until ReleaseAt
+type BeneficiarySettings struct {
+	BeneficiariesOnly bool  `json:"beneficiaries_only"`
+	ReleaseAt         int64 `json:"release_at,omitempty"`
+	CoolingOff        int64 `json:"cooling_off_seconds"`
+}
+
+// Payee is what a payer sees of a recipient before sending money
+type Payee struct {
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	Beneficiary bool   `json:"beneficiary"`
+}
+
+// ErrBeneficiaryNotFound ...
+var ErrBeneficiaryNotFound = errors.New("beneficiary not found")
+
+// ErrPayeeNotFound ...
+var ErrPayeeNotFound = errors.New("user not found")
+
+// BeneficiaryModel ...
+type BeneficiaryModel struct{}
+
+var beneficiaryModel = new(BeneficiaryModel)
+
+// maxBeneficiaries bounds the address book of one user
+const maxBeneficiaries = 200
+
+func beneficiaryCoolingOff() time.Duration {
+	return utils.GetEnvDuration("BENEFICIARY_COOLING_OFF", 24*time.Hour)
+}
+
+// MaskName keeps the first letter of every word of a name: "John Smith" becomes "J*** S****"
+func MaskName(name string) string {
+	words := strings.Fields(name)
+	for i, word := range words {
+		letters := []rune(word)
+		words[i] = string(letters[0]) + strings.Repeat("*", len(letters)-1)
+	}
+	return strings.Join(words, " ")
+}
+
+// Lookup returns the masked name of a user so the payer can confirm the recipient
+func (m BeneficiaryModel) Lookup(ctx context.Context, userID primitive.ObjectID, username string) (payee Payee, err error) {
+	fmt.Println("Beneficiary model: Lookup")
+	userCollection := db.GetCollection(db.DB, "users")
+	beneficiaryCollection := db.GetCollection(db.DB, "beneficiaries")
+
+	var user User
+	err = userCollection.FindOne(ctx, userBy("username", username)).Decode(&user)
+	if err == mongo.ErrNoDocuments || (err == nil && user.ErasedAt != 0) {
+		return payee, ErrPayeeNotFound
+	}
+	if err != nil {
+		return payee, errors.New("something went wrong, please try again later")
+	}
+
+	count, err := beneficiaryCollection.CountDocuments(ctx, bson.M{"userid": userID, "beneficiaryid": user.ID})
+	if err != nil {
+		return payee, errors.New("something went wrong, please try again later")
+	}
+
+	return Payee{Username: user.Username, DisplayName: MaskName(user.Name), Beneficiary: count > 0}, nil
+}
+
+// Create ...
+func (m BeneficiaryModel) Create(ctx context.Context, userID primitive.ObjectID, form forms.CreateBeneficiaryForm) (beneficiary Beneficiary, err error) {
+	fmt.Println("Beneficiary model: Create")
+	userCollection := db.GetCollection(db.DB, "users")
+	beneficiaryCollection := db.GetCollection(db.DB, "beneficiaries")
+
+	var user User
+	err = userCollection.FindOne(ctx, userBy("username", form.Username)).Decode(&user)
+	if err == mongo.ErrNoDocuments || (err == nil && user.ErasedAt != 0) {
+		return beneficiary, ErrPayeeNotFound
+	}
+	if err != nil {
+		return beneficiary, errors.New("something went wrong, please try again later")
+	}
+	if user.ID == userID {
+		return beneficiary, errors.New("you can not add yourself as a beneficiary")
+	}
+
+	count, err := beneficiaryCollection.CountDocuments(ctx, bson.M{"userid": userID})
+	if err != nil {
+		return beneficiary, errors.New("something went wrong, please try again later")
+	}
+	if count >= maxBeneficiaries {
+		return beneficiary, fmt.Errorf("you can have at most %d beneficiaries", maxBeneficiaries)
+	}
+
+	count, err = beneficiaryCollection.CountDocuments(ctx, bson.M{"userid": userID, "beneficiaryid": user.ID})
+	if err != nil {
+		return beneficiary, errors.New("something went wrong, please try again later")
+	}
+	if count > 0 {
+		return beneficiary, errors.New("the user is already one of your beneficiaries")
+	}
+
+	now := time.Now()
+	beneficiary = Beneficiary{
+		ID:            primitive.NewObjectID(),
+		UserID:        userID,
+		BeneficiaryID: user.ID,
+		Username:      user.Username,
+		DisplayName:   MaskName(user.Name),
+		Nickname:      form.Nickname,
+		TrustedAt:     now.Add(beneficiaryCoolingOff()).Unix(),
+		CreatedAt:     now.Unix(),
+	}
+
+	_, err = beneficiaryCollection.InsertOne(ctx, beneficiary)
+	if err != nil {
+		return beneficiary, errors.New("error when creating new beneficiary")
+	}
+
+	return beneficiary, nil
+}
+
+// List returns the beneficiaries of the user, the most recently used first
+func (m BeneficiaryModel) List(ctx context.Context, userID primitive.ObjectID) (beneficiaries []Beneficiary, err error) {
+	fmt.Println("Beneficiary model: List")
+	beneficiaryCollection := db.GetCollection(db.DB, "beneficiaries")
+
+	opts := options.Find().SetSort(bson.D{{Key: "lastusedat", Value: -1}, {Key: "createdat", Value: -1}})
+	results, err := beneficiaryCollection.Find(ctx, bson.M{"userid": userID}, opts)
+	if err != nil {
+		return beneficiaries, errors.New("error when retrieving beneficiaries")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var beneficiary Beneficiary
+		if err = results.Decode(&beneficiary); err != nil {
+			return beneficiaries, errors.New("error when decoding beneficiary")
+		}
+
+		beneficiaries = append(beneficiaries, beneficiary)
+	}
+
+	return beneficiaries, nil
+}
+
+// Delete ...
+func (m BeneficiaryModel) Delete(ctx context.Context, userID primitive.ObjectID, id primitive.ObjectID) error {
+	fmt.Println("Beneficiary model: Delete")
+	beneficiaryCollection := db.GetCollection(db.DB, "beneficiaries")
+
+	result, err := beneficiaryCollection.DeleteOne(ctx, bson.M{"id": id, "userid": userID})
+	if err != nil {
+		return errors.New("internal server error")
+	}
+	if result.DeletedCount == 0 {
+		return ErrBeneficiaryNotFound
+	}
+
+	return nil
+}
+
+// Settings returns whether transfers of the user are restricted to beneficiaries
+func (m BeneficiaryModel) Settings(ctx context.Context, userID primitive.ObjectID) (settings BeneficiarySettings, err error) {
+	settingsCollection := db.GetCollection(db.DB, "beneficiary_settings")
+
+	var stored struct {
+		Restricted bool
+		ReleaseAt  int64
+	}
+	err = settingsCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&stored)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return settings, errors.New("something went wrong, please try again later")
+	}
+
+	settings.CoolingOff = int64(beneficiaryCoolingOff().Seconds())
+	if stored.Restricted && (stored.ReleaseAt == 0 || time.Now().Unix() < stored.ReleaseAt) {
+		settings.BeneficiariesOnly = true
+		settings.ReleaseAt = stored.ReleaseAt
+	}
+
+	return settings, nil
+}
+
+// UpdateSettings restricts transfers to beneficiaries at once, or lifts the restriction after the cooling-off period,
+// so someone taking over the account can not lift it to send money right away
+func (m BeneficiaryModel) UpdateSettings(ctx context.Context, userID primitive.ObjectID, beneficiariesOnly bool) (settings BeneficiarySettings, err error) {
+	fmt.Println("Beneficiary model: UpdateSettings")
+	settingsCollection := db.GetCollection(db.DB, "beneficiary_settings")
+
+	settings, err = m.Settings(ctx, userID)
+	if err != nil {
+		return settings, err
+	}
+
+	now := time.Now()
+	update := bson.M{"restricted": true, "releaseat": int64(0), "updatedat": now.Unix()}
+	if !beneficiariesOnly {
+		if !settings.BeneficiariesOnly || settings.ReleaseAt != 0 {
+			//Already off, or already being lifted
+			return settings, nil
+		}
+		update["releaseat"] = now.Add(beneficiaryCoolingOff()).Unix()
+	}
+
+	_, err = settingsCollection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": update}, options.Update().SetUpsert(true))
+	if err != nil {
+		return settings, errors.New("internal server error")
+	}
+
+	return m.Settings(ctx, userID)
+}
+
+// checkTransfer refuses a transfer to anyone but a trusted beneficiary when the sender restricted their transfers,
+// and records the use of the beneficiary. ctx must be the session context of the transfer
+func (m BeneficiaryModel) checkTransfer(ctx context.Context, userID primitive.ObjectID, targetID primitive.ObjectID) error {
+	beneficiaryCollection := db.GetCollection(db.DB, "beneficiaries")
+
+	settings, err := m.Settings(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	var beneficiary Beneficiary
+	err = beneficiaryCollection.FindOne(ctx, bson.M{"userid": userID, "beneficiaryid": targetID}).Decode(&beneficiary)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return errors.New("something went wrong, please try again later")
+	}
+
+	now := time.Now().Unix()
+	if settings.BeneficiariesOnly {
+		if err == mongo.ErrNoDocuments {
+			return rejected("your transfers are restricted to your saved beneficiaries")
+		}
+		if beneficiary.TrustedAt > now {
+			return rejected("the beneficiary can receive your transfers from %s", time.Unix(beneficiary.TrustedAt, 0).UTC().Format(time.RFC3339))
+		}
+	}
+
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+
+	_, err = beneficiaryCollection.UpdateOne(ctx, bson.M{"id": beneficiary.ID}, bson.M{"$set": bson.M{"lastusedat": now}})
+	return err
+}
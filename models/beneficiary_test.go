@@ -0,0 +1,30 @@
+//go:build all
+// +build all
+
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaskName(t *testing.T) {
+	tests := []struct {
+		name   string
+		masked string
+	}{
+		{"John Smith", "J*** S****"},
+		{"  John   Smith  ", "J*** S****"},
+		{"Ada", "A**"},
+		{"A B", "A B"},
+		{"Zoë Łukasz", "Z** Ł*****"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.masked, MaskName(test.name))
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BillShare is the part of a bill one participant owes. Each share but the creator's own is collected
+// with a payment request, Status is PAID or the status of that request
+type BillShare struct {
+	UserID    primitive.ObjectID `json:"-"`
+	Username  string             `json:"username"`
+	Amount    int64              `json:"amount"`
+	Status    string             `json:"status"`
+	RequestID primitive.ObjectID `json:"request_id,omitempty"`
+	PaidAt    int64              `json:"paid_at,omitempty"`
+}
+
+// Bill is an amount split among participants
+type Bill struct {
+	ID         primitive.ObjectID `json:"id"`
+	CreatorID  primitive.ObjectID `json:"-"`
+	Creator    string             `json:"creator"`
+	Title      string             `json:"title"`
+	Total      int64              `json:"total"`
+	Split      string             `json:"split"`
+	Status     string             `json:"status"`
+	PaidAmount int64              `json:"paid_amount"`
+	Shares     []BillShare        `json:"shares"`
+	CreatedAt  int64              `json:"created_at"`
+	UpdatedAt  int64              `json:"updated_at"`
+}
+
+// ErrBillNotFound ...
+var ErrBillNotFound = errors.New("bill not found")
+
+// BillModel ...
+type BillModel struct{}
+
+var billModel = new(BillModel)
+var paymentRequestModel = new(PaymentRequestModel)
+
+// splitShares returns the amount of every participant. Amounts are in minor units, the cents left over
+// by EQUAL and PERCENTAGE splits go one each to the first participants so the shares always add up to the total
+func splitShares(form forms.CreateBillForm) (amounts []int64, err error) {
+	amounts = make([]int64, len(form.Participants))
+	if len(amounts) == 0 {
+		return amounts, errors.New("please enter the participants of the bill")
+	}
+
+	switch form.Split {
+	case utils.SPLIT_EQUAL:
+		for i := range amounts {
+			amounts[i] = form.Total / int64(len(amounts))
+		}
+	case utils.SPLIT_PERCENTAGE:
+		//Percentages are handled in basis points to keep the arithmetic exact
+		var points int64
+		for i, participant := range form.Participants {
+			basisPoints := int64(math.Round(participant.Percentage * 100))
+			points += basisPoints
+			share, ok := utils.MulAmount(form.Total, basisPoints)
+			if !ok {
+				return amounts, errors.New("the total is too large to be split")
+			}
+			amounts[i] = share / 10000
+		}
+		if points != 10000 {
+			return amounts, errors.New("the percentages must add up to 100")
+		}
+	case utils.SPLIT_EXACT:
+		//An omitted amount binds as 0, every participant must be given one
+		var sum int64
+		for i, participant := range form.Participants {
+			if participant.Amount < 1 {
+				return amounts, errors.New("please enter the amount of every participant")
+			}
+			amounts[i] = participant.Amount
+			sum, _ = utils.AddAmount(sum, participant.Amount)
+		}
+		if sum != form.Total {
+			return amounts, errors.New("the amounts must add up to the total")
+		}
+		return amounts, nil
+	}
+
+	//Hand out the cents lost by rounding down in one step, one more to each of the first participants
+	var sum int64
+	for _, amount := range amounts {
+		sum += amount
+	}
+	left := form.Total - sum
+	n := int64(len(amounts))
+	for i := range amounts {
+		amounts[i] += left / n
+		if int64(i) < left%n {
+			amounts[i]++
+		}
+	}
+
+	for _, amount := range amounts {
+		if amount < 1 {
+			return amounts, errors.New("the total is too small to be split among the participants")
+		}
+	}
+
+	return amounts, nil
+}
+
+// Create splits the bill and sends a payment request to every participant, all in one Mongo transaction
+func (m BillModel) Create(ctx context.Context, creatorID primitive.ObjectID, form forms.CreateBillForm) (bill Bill, err error) {
+	fmt.Println("Bill model: Create")
+	userCollection := db.GetCollection(db.DB, "users")
+	billCollection := db.GetCollection(db.DB, "bills")
+
+	var creator User
+	err = userCollection.FindOne(ctx, bson.M{"id": creatorID}).Decode(&creator)
+	if err != nil {
+		return bill, errors.New("something went wrong, please try again later")
+	}
+
+	seen := map[string]bool{}
+	for _, participant := range form.Participants {
+		if seen[participant.Username] {
+			return bill, errors.New("the participant " + participant.Username + " is listed twice")
+		}
+		seen[participant.Username] = true
+	}
+
+	amounts, err := splitShares(form)
+	if err != nil {
+		return bill, err
+	}
+
+	ttl := utils.GetEnvDuration("PAYMENT_REQUEST_TTL", 7*24*time.Hour)
+	if form.ExpiresInHours > 0 {
+		ttl = time.Duration(form.ExpiresInHours) * time.Hour
+	}
+	expiresAt := time.Now().Add(ttl).Unix()
+
+	data, err := withTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		now := time.Now().Unix()
+		bill := Bill{
+			ID:        primitive.NewObjectID(),
+			CreatorID: creator.ID,
+			Creator:   creator.Username,
+			Title:     form.Title,
+			Total:     form.Total,
+			Split:     form.Split,
+			Status:    utils.BILL_OPEN,
+			Shares:    make([]BillShare, len(form.Participants)),
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+
+		for i, participant := range form.Participants {
+			//The creator's own share is settled by definition
+			if participant.Username == creator.Username {
+				bill.Shares[i] = BillShare{UserID: creator.ID, Username: creator.Username, Amount: amounts[i], Status: utils.SHARE_PAID, PaidAt: now}
+				bill.PaidAmount += amounts[i]
+				continue
+			}
+
+			request, err := paymentRequestModel.create(sessionContext, creator, participant.Username, amounts[i], form.Title, expiresAt, bill.ID)
+			if err != nil {
+				return bill, fmt.Errorf("%s: %s", participant.Username, err.Error())
+			}
+
+			bill.Shares[i] = BillShare{UserID: request.PayerID, Username: request.Payer, Amount: amounts[i], Status: request.Status, RequestID: request.ID}
+		}
+
+		if bill.PaidAmount >= bill.Total {
+			return bill, errors.New("please add at least one other participant")
+		}
+
+		_, err := billCollection.InsertOne(sessionContext, bill)
+		if err != nil {
+			return bill, errors.New("error when creating new bill")
+		}
+
+		return bill, nil
+	})
+	bill, _ = data.(Bill)
+
+	return bill, err
+}
+
+// markPaid records the payment of a share, ctx must be the session context of the transfer.
+// The bill is settled with its last share
+func (m BillModel) markPaid(ctx context.Context, request PaymentRequest) error {
+	billCollection := db.GetCollection(db.DB, "bills")
+
+	now := time.Now().Unix()
+	var bill Bill
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := billCollection.FindOneAndUpdate(ctx,
+		bson.M{"id": request.BillID, "shares.requestid": request.ID},
+		bson.M{
+			"$set": bson.M{"shares.$.status": utils.SHARE_PAID, "shares.$.paidat": now, "updatedat": now},
+			"$inc": bson.M{"paidamount": request.Amount},
+		}, opts).Decode(&bill)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if bill.PaidAmount >= bill.Total {
+		_, err = billCollection.UpdateOne(ctx, bson.M{"id": bill.ID, "status": utils.BILL_OPEN}, bson.M{"$set": bson.M{"status": utils.BILL_SETTLED}})
+	}
+
+	return err
+}
+
+// withRequestStatuses fills the status of the unpaid shares from their payment requests
+func (m BillModel) withRequestStatuses(ctx context.Context, bills []Bill) error {
+	requestCollection := db.GetCollection(db.DB, "payment_requests")
+
+	if err := paymentRequestModel.expire(ctx); err != nil {
+		return err
+	}
+
+	var requestIDs []primitive.ObjectID
+	for _, bill := range bills {
+		for _, share := range bill.Shares {
+			if share.Status != utils.SHARE_PAID && !share.RequestID.IsZero() {
+				requestIDs = append(requestIDs, share.RequestID)
+			}
+		}
+	}
+	if len(requestIDs) == 0 {
+		return nil
+	}
+
+	results, err := requestCollection.Find(ctx, bson.M{"id": bson.M{"$in": requestIDs}}, options.Find().SetProjection(bson.M{"id": 1, "status": 1}))
+	if err != nil {
+		return err
+	}
+
+	var requests []PaymentRequest
+	if err = results.All(ctx, &requests); err != nil {
+		return err
+	}
+
+	statuses := map[primitive.ObjectID]string{}
+	for _, request := range requests {
+		statuses[request.ID] = request.Status
+	}
+
+	for i := range bills {
+		for j, share := range bills[i].Shares {
+			if status, ok := statuses[share.RequestID]; ok && share.Status != utils.SHARE_PAID {
+				bills[i].Shares[j].Status = status
+			}
+		}
+	}
+
+	return nil
+}
+
+// List returns the bills the user created or takes part in, newest first
+func (m BillModel) List(ctx context.Context, userID primitive.ObjectID, query Query) (bills []Bill, err error) {
+	fmt.Println("Bill model: List")
+	billCollection := db.GetCollection(db.DB, "bills")
+
+	filter := bson.M{"$or": []bson.M{{"creatorid": userID}, {"shares.userid": userID}}}
+	opts := options.Find().SetSort(bson.M{"createdat": -1}).SetSkip(int64((query.Page - 1) * query.Limit)).SetLimit(int64(query.Limit))
+	results, err := billCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return bills, errors.New("error when retrieving bills")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var bill Bill
+		if err = results.Decode(&bill); err != nil {
+			return bills, errors.New("error when decoding bill")
+		}
+
+		bills = append(bills, bill)
+	}
+
+	if err = m.withRequestStatuses(ctx, bills); err != nil {
+		return bills, errors.New("error when retrieving bills")
+	}
+
+	return bills, nil
+}
+
+// Find returns a bill the user created or takes part in
+func (m BillModel) Find(ctx context.Context, userID primitive.ObjectID, id primitive.ObjectID) (bill Bill, err error) {
+	fmt.Println("Bill model: Find")
+	billCollection := db.GetCollection(db.DB, "bills")
+
+	err = billCollection.FindOne(ctx, bson.M{"id": id, "$or": []bson.M{{"creatorid": userID}, {"shares.userid": userID}}}).Decode(&bill)
+	if err == mongo.ErrNoDocuments {
+		return bill, ErrBillNotFound
+	}
+	if err != nil {
+		return bill, errors.New("something went wrong, please try again later")
+	}
+
+	bills := []Bill{bill}
+	if err = m.withRequestStatuses(ctx, bills); err != nil {
+		return bill, errors.New("something went wrong, please try again later")
+	}
+
+	return bills[0], nil
+}
+
+// Cancel closes an open bill of the creator and cancels its pending payment requests, paid shares are kept
+func (m BillModel) Cancel(ctx context.Context, creatorID primitive.ObjectID, id primitive.ObjectID) (bill Bill, err error) {
+	fmt.Println("Bill model: Cancel")
+	billCollection := db.GetCollection(db.DB, "bills")
+	requestCollection := db.GetCollection(db.DB, "payment_requests")
+
+	_, err = withTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		now := time.Now().Unix()
+		result, err := billCollection.UpdateOne(sessionContext,
+			bson.M{"id": id, "creatorid": creatorID, "status": utils.BILL_OPEN},
+			bson.M{"$set": bson.M{"status": utils.BILL_CANCELLED, "updatedat": now}})
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			count, err := billCollection.CountDocuments(sessionContext, bson.M{"id": id, "creatorid": creatorID})
+			if err == nil && count == 0 {
+				return nil, ErrBillNotFound
+			}
+			return nil, errors.New("only an open bill can be cancelled")
+		}
+
+		_, err = requestCollection.UpdateMany(sessionContext,
+			bson.M{"billid": id, "status": utils.REQUEST_PENDING},
+			bson.M{
+				"$set":  bson.M{"status": utils.REQUEST_CANCELLED, "updatedat": now},
+				"$push": bson.M{"history": PaymentRequestEvent{Status: utils.REQUEST_CANCELLED, By: "requester", At: now}},
+			})
+
+		return nil, err
+	})
+	if err != nil {
+		return bill, err
+	}
+
+	return m.Find(ctx, creatorID, id)
+}
@@ -0,0 +1,66 @@
+//go:build all
+// +build all
+
+package models
+
+import (
+	"math"
+	"testing"
+
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitShares(t *testing.T) {
+	participants := func(n int) []forms.BillParticipantForm {
+		return make([]forms.BillParticipantForm, n)
+	}
+	percentages := func(values ...float64) (list []forms.BillParticipantForm) {
+		for _, value := range values {
+			list = append(list, forms.BillParticipantForm{Percentage: value})
+		}
+		return list
+	}
+	amounts := func(values ...int64) (list []forms.BillParticipantForm) {
+		for _, value := range values {
+			list = append(list, forms.BillParticipantForm{Amount: value})
+		}
+		return list
+	}
+
+	tests := []struct {
+		name    string
+		form    forms.CreateBillForm
+		amounts []int64
+		err     string
+	}{
+		{"equal", forms.CreateBillForm{Total: 900, Split: utils.SPLIT_EQUAL, Participants: participants(3)}, []int64{300, 300, 300}, ""},
+		{"equal with cents left over", forms.CreateBillForm{Total: 1000, Split: utils.SPLIT_EQUAL, Participants: participants(3)}, []int64{334, 333, 333}, ""},
+		{"equal below one cent each", forms.CreateBillForm{Total: 2, Split: utils.SPLIT_EQUAL, Participants: participants(3)}, nil, "the total is too small to be split among the participants"},
+		{"percentage", forms.CreateBillForm{Total: 1000, Split: utils.SPLIT_PERCENTAGE, Participants: percentages(50, 30, 20)}, []int64{500, 300, 200}, ""},
+		{"percentage with cents left over", forms.CreateBillForm{Total: 100, Split: utils.SPLIT_PERCENTAGE, Participants: percentages(33.33, 33.33, 33.34)}, []int64{34, 33, 33}, ""},
+		{"percentage of the largest total", forms.CreateBillForm{Total: 1000000000000, Split: utils.SPLIT_PERCENTAGE, Participants: percentages(50, 50)}, []int64{500000000000, 500000000000}, ""},
+		{"percentage of a total too large", forms.CreateBillForm{Total: math.MaxInt64, Split: utils.SPLIT_PERCENTAGE, Participants: percentages(50, 50)}, nil, "the total is too large to be split"},
+		{"percentage with a cent for most participants", forms.CreateBillForm{Total: 6, Split: utils.SPLIT_PERCENTAGE, Participants: percentages(14.29, 14.29, 14.29, 14.29, 14.28, 14.28, 14.28)}, nil, "the total is too small to be split among the participants"},
+		{"percentage with the remainder handed out at once", forms.CreateBillForm{Total: 999999999999, Split: utils.SPLIT_PERCENTAGE, Participants: percentages(14.29, 14.29, 14.29, 14.29, 14.28, 14.28, 14.28)}, []int64{142900000000, 142900000000, 142900000000, 142900000000, 142800000000, 142800000000, 142799999999}, ""},
+		{"percentage not adding up", forms.CreateBillForm{Total: 1000, Split: utils.SPLIT_PERCENTAGE, Participants: percentages(50, 30)}, nil, "the percentages must add up to 100"},
+		{"no participants", forms.CreateBillForm{Total: 1000, Split: utils.SPLIT_EQUAL}, nil, "please enter the participants of the bill"},
+		{"exact", forms.CreateBillForm{Total: 1000, Split: utils.SPLIT_EXACT, Participants: amounts(700, 300)}, []int64{700, 300}, ""},
+		{"exact not adding up", forms.CreateBillForm{Total: 1000, Split: utils.SPLIT_EXACT, Participants: amounts(700, 200)}, nil, "the amounts must add up to the total"},
+		{"exact missing amount", forms.CreateBillForm{Total: 1000, Split: utils.SPLIT_EXACT, Participants: amounts(1000, 0)}, nil, "please enter the amount of every participant"},
+		{"exact overflow", forms.CreateBillForm{Total: 1000, Split: utils.SPLIT_EXACT, Participants: amounts(math.MaxInt64, 1001)}, nil, "the amounts must add up to the total"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := splitShares(test.form)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.amounts, result)
+		})
+	}
+}
@@ -0,0 +1,339 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CashbackRule gives back Percentage of the payments of Type (TRANSFER or INVOICE_PAYMENT) of at least MinAmount,
+// at most MaxCashback each when set. A user gets at most MaxPerUser from the rule, and MaxPerUserDaily in 24 hours,
+// when they are set. An ExpiresAt of 0 never expires
+type CashbackRule struct {
+	ID              primitive.ObjectID `json:"id"`
+	Name            string             `json:"name"`
+	Type            string             `json:"type"`
+	Percentage      float64            `json:"percentage"`
+	MinAmount       int64              `json:"min_amount"`
+	MaxCashback     int64              `json:"max_cashback,omitempty"`
+	MaxPerUser      int64              `json:"max_per_user,omitempty"`
+	MaxPerUserDaily int64              `json:"max_per_user_daily,omitempty"`
+	Active          bool               `json:"active"`
+	ExpiresAt       int64              `json:"expires_at,omitempty"`
+	CreatedAt       int64              `json:"created_at"`
+	UpdatedAt       int64              `json:"updated_at"`
+}
+
+// CashbackGrant is the cashback a rule gave on a payment. It counts against the caps of the rule for the user
+// until it is reversed, when the payment is refunded
+type CashbackGrant struct {
+	ID                   primitive.ObjectID `json:"id"`
+	RuleID               primitive.ObjectID `json:"rule_id"`
+	UserID               primitive.ObjectID `json:"-"`
+	Amount               int64              `json:"amount"`
+	PaymentTransactionID primitive.ObjectID `json:"payment_transaction_id"`
+	TransactionID        primitive.ObjectID `json:"transaction_id"`
+	ReversedAt           int64              `json:"reversed_at,omitempty"`
+	CreatedAt            int64              `json:"created_at"`
+}
+
+// ErrCashbackRuleNotFound ...
+var ErrCashbackRuleNotFound = errors.New("cashback rule not found")
+
+// CashbackModel ...
+type CashbackModel struct{}
+
+var cashbackModel = new(CashbackModel)
+
+// Cashback returns what the rule gives back on amount, 0 when the payment does not qualify
+func (r CashbackRule) Cashback(amount int64) int64 {
+	if amount < r.MinAmount {
+		return 0
+	}
+
+	//Basis points keep the arithmetic in integers, the cashback is rounded down
+	cashback := amount * int64(math.Round(r.Percentage*100)) / 10000
+	if r.MaxCashback > 0 && cashback > r.MaxCashback {
+		cashback = r.MaxCashback
+	}
+	return cashback
+}
+
+// Create ...
+func (m CashbackModel) Create(ctx context.Context, userID primitive.ObjectID, form forms.CreateCashbackRuleForm) (rule CashbackRule, err error) {
+	fmt.Println("Cashback model: Create")
+	ruleCollection := db.GetCollection(db.DB, "cashback_rules")
+
+	if err = checkMarketing(ctx, userID); err != nil {
+		return rule, err
+	}
+
+	now := time.Now().Unix()
+	rule = CashbackRule{
+		ID:              primitive.NewObjectID(),
+		Name:            form.Name,
+		Type:            form.Type,
+		Percentage:      form.Percentage,
+		MinAmount:       form.MinAmount,
+		MaxCashback:     form.MaxCashback,
+		MaxPerUser:      form.MaxPerUser,
+		MaxPerUserDaily: form.MaxPerUserDaily,
+		Active:          true,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	if form.ExpiresInHours > 0 {
+		rule.ExpiresAt = time.Now().Add(time.Duration(form.ExpiresInHours) * time.Hour).Unix()
+	}
+
+	_, err = ruleCollection.InsertOne(ctx, rule)
+	if err != nil {
+		return rule, errors.New("error when creating new cashback rule")
+	}
+
+	return rule, nil
+}
+
+// List returns the cashback rules, newest first
+func (m CashbackModel) List(ctx context.Context, userID primitive.ObjectID) (rules []CashbackRule, err error) {
+	fmt.Println("Cashback model: List")
+	ruleCollection := db.GetCollection(db.DB, "cashback_rules")
+
+	if err = checkMarketing(ctx, userID); err != nil {
+		return rules, err
+	}
+
+	results, err := ruleCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"createdat": -1}))
+	if err != nil {
+		return rules, errors.New("error when retrieving cashback rules")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var rule CashbackRule
+		if err = results.Decode(&rule); err != nil {
+			return rules, errors.New("error when decoding cashback rule")
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// Deactivate stops a rule, the cashback it gave stays
+func (m CashbackModel) Deactivate(ctx context.Context, userID primitive.ObjectID, id primitive.ObjectID) error {
+	fmt.Println("Cashback model: Deactivate")
+	ruleCollection := db.GetCollection(db.DB, "cashback_rules")
+
+	if err := checkMarketing(ctx, userID); err != nil {
+		return err
+	}
+
+	result, err := ruleCollection.UpdateOne(ctx, bson.M{"id": id}, bson.M{"$set": bson.M{"active": false, "updatedat": time.Now().Unix()}})
+	if err != nil {
+		return errors.New("internal server error")
+	}
+	if result.MatchedCount == 0 {
+		return ErrCashbackRuleNotFound
+	}
+
+	return nil
+}
+
+// apply credits the payer with the best cashback of the active rules for a payment of paymentType, within what is
+// left of the caps of each rule for the payer. ctx must be the session context of the transaction of the payment.
+// Payments that don't qualify, cashback the marketing account can not fund and cashback above the balance limit
+// of the payer are left alone: they never fail the payment
+func (m CashbackModel) apply(ctx context.Context, paymentType string, payerID primitive.ObjectID, amount int64, transactionID primitive.ObjectID) error {
+	ruleCollection := db.GetCollection(db.DB, "cashback_rules")
+	grantCollection := db.GetCollection(db.DB, "cashback_grants")
+
+	marketing, err := marketingAccount(ctx)
+	if err != nil || marketing.ID == payerID {
+		return nil
+	}
+
+	now := time.Now().Unix()
+	results, err := ruleCollection.Find(ctx, bson.M{
+		"type":      paymentType,
+		"active":    true,
+		"minamount": bson.M{"$lte": amount},
+		"$or":       bson.A{bson.M{"expiresat": int64(0)}, bson.M{"expiresat": bson.M{"$gt": now}}},
+	})
+	if err != nil {
+		return err
+	}
+	var rules []CashbackRule
+	if err = results.All(ctx, &rules); err != nil {
+		return err
+	}
+
+	var best CashbackRule
+	var cashback int64
+	for _, rule := range rules {
+		value, err := m.capped(ctx, rule, payerID, rule.Cashback(amount))
+		if err != nil {
+			return err
+		}
+		if value > cashback {
+			best, cashback = rule, value
+		}
+	}
+	if cashback == 0 {
+		return nil
+	}
+
+	//Cashback above the balance limit of the level of the payer is not given
+	var payer User
+	if err = db.GetCollection(db.DB, "users").FindOne(ctx, bson.M{"id": payerID}).Decode(&payer); err != nil {
+		return err
+	}
+	if kycModel.checkIncoming(ctx, payer, cashback, true) != nil {
+		return nil
+	}
+
+	transaction, err := reward(ctx, marketing, payerID, cashback, utils.CASHBACK, "cashback:"+transactionID.Hex())
+	if err == ErrPromotionBudget {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = grantCollection.InsertOne(ctx, CashbackGrant{
+		ID:                   primitive.NewObjectID(),
+		RuleID:               best.ID,
+		UserID:               payerID,
+		Amount:               cashback,
+		PaymentTransactionID: transactionID,
+		TransactionID:        transaction.ID,
+		CreatedAt:            time.Now().Unix(),
+	})
+
+	return err
+}
+
+// capped lowers cashback to what is left of the caps of the rule for the user
+func (m CashbackModel) capped(ctx context.Context, rule CashbackRule, userID primitive.ObjectID, cashback int64) (int64, error) {
+	caps := []struct {
+		limit int64
+		since int64
+	}{
+		{rule.MaxPerUser, 0},
+		{rule.MaxPerUserDaily, time.Now().Add(-24 * time.Hour).Unix()},
+	}
+	for _, c := range caps {
+		if cashback <= 0 || c.limit == 0 {
+			continue
+		}
+		granted, err := m.granted(ctx, rule.ID, userID, c.since)
+		if err != nil {
+			return 0, err
+		}
+		if left := c.limit - granted; left < cashback {
+			cashback = left
+		}
+	}
+
+	if cashback < 0 {
+		return 0, nil
+	}
+	return cashback, nil
+}
+
+// granted returns the cashback the rule gave the user since the time, reversed cashback excluded
+func (m CashbackModel) granted(ctx context.Context, ruleID primitive.ObjectID, userID primitive.ObjectID, since int64) (int64, error) {
+	grantCollection := db.GetCollection(db.DB, "cashback_grants")
+
+	results, err := grantCollection.Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"ruleid": ruleID, "userid": userID, "reversedat": int64(0), "createdat": bson.M{"$gte": since}}},
+		bson.M{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$amount"}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer results.Close(ctx)
+
+	var granted struct {
+		Total int64
+	}
+	if results.Next(ctx) {
+		if err = results.Decode(&granted); err != nil {
+			return 0, err
+		}
+	}
+
+	return granted.Total, nil
+}
+
+// reverse takes back the cashback given on a payment that is refunded and returns it to the marketing account.
+// The payer is taken at most their main balance, ctx must be the session context of the transaction of the refund
+func (m CashbackModel) reverse(ctx context.Context, paymentTransactionID primitive.ObjectID) error {
+	userCollection := db.GetCollection(db.DB, "users")
+	grantCollection := db.GetCollection(db.DB, "cashback_grants")
+
+	var grant CashbackGrant
+	now := time.Now().Unix()
+	err := grantCollection.FindOneAndUpdate(ctx,
+		bson.M{"paymenttransactionid": paymentTransactionID, "reversedat": int64(0)},
+		bson.M{"$set": bson.M{"reversedat": now}}).Decode(&grant)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	marketing, err := marketingAccount(ctx)
+	if err != nil {
+		return err
+	}
+	var user User
+	if err = userCollection.FindOne(ctx, bson.M{"id": grant.UserID}).Decode(&user); err != nil {
+		return err
+	}
+
+	amount := grant.Amount
+	if amount > user.Balance {
+		amount = user.Balance
+	}
+	if amount <= 0 {
+		return nil
+	}
+
+	_, err = userCollection.UpdateOne(ctx, bson.M{"id": user.ID}, bson.M{"$set": bson.M{"balance": user.Balance - amount, "updatedat": now}})
+	if err != nil {
+		return errors.New("internal server error")
+	}
+	_, err = userCollection.UpdateOne(ctx, bson.M{"id": marketing.ID}, bson.M{"$inc": bson.M{"balance": amount}, "$set": bson.M{"updatedat": now}})
+	if err != nil {
+		return errors.New("internal server error")
+	}
+
+	transaction, err := transactionModel.Create(ctx, forms.CreateTransactionForm{
+		From:      user.Username,
+		To:        marketing.Username,
+		Amount:    amount,
+		Balance:   user.Balance - amount,
+		Type:      utils.CASHBACK_REVERSAL,
+		Reference: "cashback:" + paymentTransactionID.Hex(),
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	return outboxModel.Add(ctx, utils.EVENT_REWARD_REVERSED, []string{user.Username}, transaction)
+}
@@ -0,0 +1,123 @@
+//go:build all
+// +build all
+
+package models
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCashbackRuleCashback(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     CashbackRule
+		amount   int64
+		cashback int64
+	}{
+		{"percentage", CashbackRule{Percentage: 2}, 10000, 200},
+		{"rounded down", CashbackRule{Percentage: 1.5}, 999, 14},
+		{"below the minimum amount", CashbackRule{Percentage: 2, MinAmount: 5000}, 4999, 0},
+		{"at the minimum amount", CashbackRule{Percentage: 2, MinAmount: 5000}, 5000, 100},
+		{"capped", CashbackRule{Percentage: 10, MaxCashback: 500}, 10000, 500},
+		{"under the cap", CashbackRule{Percentage: 10, MaxCashback: 500}, 1000, 100},
+		{"too small", CashbackRule{Percentage: 1}, 99, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.cashback, test.rule.Cashback(test.amount))
+		})
+	}
+}
+
+// withTestMarketing makes a new user with the balance the marketing account for the test
+func withTestMarketing(t *testing.T, balance int64) User {
+	t.Helper()
+
+	marketing := newTestUser(t, balance)
+	previous, set := os.LookupEnv("MARKETING_USERNAME")
+	os.Setenv("MARKETING_USERNAME", marketing.Username)
+	t.Cleanup(func() {
+		if set {
+			os.Setenv("MARKETING_USERNAME", previous)
+		} else {
+			os.Unsetenv("MARKETING_USERNAME")
+		}
+	})
+
+	return marketing
+}
+
+func TestInvoiceRefundReversesCashback(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	marketing := withTestMarketing(t, 1000000)
+	rule, err := new(CashbackModel).Create(ctx, marketing.ID, forms.CreateCashbackRuleForm{Name: "test", Type: utils.INVOICE_PAYMENT, Percentage: 10})
+	require.NoError(t, err)
+	defer new(CashbackModel).Deactivate(ctx, marketing.ID, rule.ID)
+
+	owner := newTestUser(t, 0)
+	payer := newTestUser(t, 10000)
+
+	merchant, err := new(MerchantModel).Create(ctx, owner.ID, forms.CreateMerchantForm{Name: "Test merchant"})
+	require.NoError(t, err)
+	invoice, err := new(InvoiceModel).Create(ctx, owner.ID, merchant.ID, forms.CreateInvoiceForm{
+		Items: []forms.InvoiceItemForm{{Description: "Coffee", Quantity: 2, UnitPrice: 2500}},
+	})
+	require.NoError(t, err)
+
+	invoice, err = new(InvoiceModel).Pay(ctx, payer.ID, invoice.Code)
+	require.NoError(t, err)
+
+	var grant CashbackGrant
+	err = db.GetCollection(db.DB, "cashback_grants").FindOne(ctx, bson.M{"paymenttransactionid": invoice.TransactionID}).Decode(&grant)
+	require.NoError(t, err, "the payment got cashback")
+	assert.True(t, grant.Amount >= 500, "the best rule gives at least the 10 percent of the test rule")
+	assert.Equal(t, 5000+grant.Amount, testBalance(t, payer.ID))
+	assert.Equal(t, 1000000-grant.Amount, testBalance(t, marketing.ID))
+
+	_, err = new(InvoiceModel).Refund(ctx, owner.ID, merchant.ID, invoice.ID)
+	require.NoError(t, err)
+
+	err = db.GetCollection(db.DB, "cashback_grants").FindOne(ctx, bson.M{"id": grant.ID}).Decode(&grant)
+	require.NoError(t, err)
+	assert.NotZero(t, grant.ReversedAt, "the refund reverses the cashback")
+	assert.Equal(t, int64(10000), testBalance(t, payer.ID))
+	assert.Equal(t, int64(1000000), testBalance(t, marketing.ID))
+
+	//The cashback can't be reversed twice
+	_, err = new(InvoiceModel).Refund(ctx, owner.ID, merchant.ID, invoice.ID)
+	assert.EqualError(t, err, "only a paid invoice can be refunded")
+	assert.Equal(t, int64(1000000), testBalance(t, marketing.ID))
+}
+
+func TestVoucherCodeUnique(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	require.NoError(t, new(VoucherModel).EnsureIndexes(ctx))
+	marketing := withTestMarketing(t, 0)
+
+	token, err := generateHexToken(8)
+	require.NoError(t, err)
+	code := "TEST" + strings.ToUpper(token)
+
+	voucher, err := new(VoucherModel).Create(ctx, marketing.ID, forms.CreateVoucherForm{Code: code, Amount: 100})
+	require.NoError(t, err)
+	assert.Equal(t, code, voucher.Code)
+
+	_, err = new(VoucherModel).Create(ctx, marketing.ID, forms.CreateVoucherForm{Code: strings.ToLower(code), Amount: 100})
+	assert.EqualError(t, err, "the voucher code is already used")
+}
@@ -0,0 +1,593 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/encryption"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DataKey is a data key of the field encryption as stored, wrapped by the master key MasterKeyID of the key file.
+// The newest one encrypts new values. Data keys are never deleted, values encrypted with an old one stay readable
+type DataKey struct {
+	ID          string `bson:"_id"`
+	MasterKeyID string
+	Wrapped     []byte
+	CreatedAt   int64
+}
+
+// EncryptionModel ...
+type EncryptionModel struct{}
+
+// dataKeyring caches the unwrapped data keys. The active one is looked up again after dataKeyRefresh,
+// so a rotation by another instance is picked up
+type dataKeyring struct {
+	mu        sync.RWMutex
+	keys      map[string]encryption.DataKey
+	active    string
+	refreshAt time.Time
+}
+
+var keyring = &dataKeyring{keys: map[string]encryption.DataKey{}}
+
+const dataKeyRefresh = time.Minute
+
+// activeKey returns the newest data key, it is created when there is none
+func (k *dataKeyring) activeKey(ctx context.Context) (encryption.DataKey, error) {
+	k.mu.RLock()
+	key, ok := k.keys[k.active]
+	fresh := time.Now().Before(k.refreshAt)
+	k.mu.RUnlock()
+	if ok && fresh {
+		return key, nil
+	}
+
+	dataKeyCollection := db.GetCollection(db.DB, "data_keys")
+
+	var stored DataKey
+	err := dataKeyCollection.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.M{"_id": -1})).Decode(&stored)
+	if err == mongo.ErrNoDocuments {
+		key, err = k.create(ctx)
+	} else if err == nil {
+		key, err = k.key(ctx, stored.ID)
+	}
+	if err != nil {
+		return key, err
+	}
+
+	k.mu.Lock()
+	k.active = key.ID
+	k.refreshAt = time.Now().Add(dataKeyRefresh)
+	k.mu.Unlock()
+
+	return key, nil
+}
+
+// key returns the data key id, unwrapped with the master key of the key file
+func (k *dataKeyring) key(ctx context.Context, id string) (encryption.DataKey, error) {
+	k.mu.RLock()
+	key, ok := k.keys[id]
+	k.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	dataKeyCollection := db.GetCollection(db.DB, "data_keys")
+
+	var stored DataKey
+	err := dataKeyCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&stored)
+	if err != nil {
+		return key, fmt.Errorf("data key %s: %v", id, err)
+	}
+
+	key, err = encryption.GetKeyFile().Unwrap(stored.MasterKeyID, stored.ID, stored.Wrapped)
+	if err != nil {
+		return key, err
+	}
+
+	k.mu.Lock()
+	k.keys[id] = key
+	k.mu.Unlock()
+
+	return key, nil
+}
+
+// create stores a new data key, wrapped with the active master key
+func (k *dataKeyring) create(ctx context.Context) (encryption.DataKey, error) {
+	dataKeyCollection := db.GetCollection(db.DB, "data_keys")
+
+	key, err := encryption.NewDataKey()
+	if err != nil {
+		return key, err
+	}
+
+	masterKeyID, wrapped, err := encryption.GetKeyFile().Wrap(key)
+	if err != nil {
+		return key, err
+	}
+
+	_, err = dataKeyCollection.InsertOne(ctx, DataKey{ID: key.ID, MasterKeyID: masterKeyID, Wrapped: wrapped, CreatedAt: time.Now().Unix()})
+	if err != nil {
+		return key, err
+	}
+
+	k.mu.Lock()
+	k.keys[key.ID] = key
+	k.mu.Unlock()
+
+	return key, nil
+}
+
+// indexedUserFields are the encrypted fields of User that are looked up, through their blind index "<field>index"
+var indexedUserFields = map[string]bool{"username": true, "email": true, "phone": true}
+
+// documentAdditionalData binds an encrypted value to the field and the document of the collection it belongs to
+func documentAdditionalData(collection string, id primitive.ObjectID, field string) string {
+	return collection + "/" + id.Hex() + "/" + field
+}
+
+// userAdditionalData binds an encrypted value to the field and the user it belongs to
+func userAdditionalData(userID primitive.ObjectID, field string) string {
+	return documentAdditionalData("users", userID, field)
+}
+
+// sealUserField adds to update the encrypted value of a field of the user, with its blind index if the field has one
+func sealUserField(key encryption.DataKey, update bson.M, userID primitive.ObjectID, field string, value string) (err error) {
+	sealed := ""
+	if value != "" {
+		sealed, err = encryption.Seal(key, userAdditionalData(userID, field), value)
+		if err != nil {
+			return err
+		}
+	}
+
+	update[field] = sealed
+	if indexedUserFields[field] {
+		update[field+"index"] = encryption.GetKeyFile().BlindIndex(field, value)
+	}
+	return nil
+}
+
+// setUserFields adds the encrypted fields to the $set of an update of the user
+func setUserFields(ctx context.Context, update bson.M, userID primitive.ObjectID, fields map[string]string) error {
+	key, err := keyring.activeKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	for field, value := range fields {
+		if err = sealUserField(key, update, userID, field, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openField decrypts a value sealed with additionalData, values written before the encryption are returned as they are
+func openField(ctx context.Context, additionalData string, value string) (string, error) {
+	if !encryption.IsEncrypted(value) {
+		return value, nil
+	}
+
+	id, err := encryption.KeyID(value)
+	if err != nil {
+		return "", err
+	}
+	key, err := keyring.key(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	return encryption.Open(key, additionalData, value)
+}
+
+// openUserField decrypts a field of the user
+func openUserField(ctx context.Context, userID primitive.ObjectID, field string, value string) (string, error) {
+	return openField(ctx, userAdditionalData(userID, field), value)
+}
+
+// userBy matches the users whose field is value through its blind index. Users written before the encryption
+// hold the plain value until ReencryptUsers rewrites them
+func userBy(field string, value string) bson.M {
+	return bson.M{"$or": []bson.M{
+		{field + "index": encryption.GetKeyFile().BlindIndex(field, value)},
+		{field: value},
+	}}
+}
+
+// plainUser is User without its BSON methods
+type plainUser User
+
+// encrypted returns the fields encrypted at rest
+func (u *plainUser) encrypted() map[string]*string {
+	return map[string]*string{"username": &u.Username, "name": &u.Name, "email": &u.Email, "phone": &u.Phone}
+}
+
+// MarshalBSON stores the personal fields of the user encrypted, with the blind indexes and the ID of the data key
+func (u User) MarshalBSON() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	key, err := keyring.activeKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plain := plainUser(u)
+	sealed := bson.M{}
+	for field, value := range plain.encrypted() {
+		if err = sealUserField(key, sealed, u.ID, field, *value); err != nil {
+			return nil, err
+		}
+		*value = sealed[field].(string)
+	}
+
+	data, err := bson.Marshal(plain)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	if err = bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	for _, field := range []string{"username", "email", "phone"} {
+		doc = append(doc, bson.E{Key: field + "index", Value: sealed[field+"index"]})
+	}
+	doc = append(doc, bson.E{Key: "keyid", Value: key.ID})
+
+	return bson.Marshal(doc)
+}
+
+// UnmarshalBSON decrypts the personal fields of the user
+func (u *User) UnmarshalBSON(data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var plain plainUser
+	if err := bson.Unmarshal(data, &plain); err != nil {
+		return err
+	}
+
+	for field, value := range plain.encrypted() {
+		opened, err := openUserField(ctx, plain.ID, field, *value)
+		if err != nil {
+			return fmt.Errorf("user %s: %s: %v", plain.ID.Hex(), field, err)
+		}
+		*value = opened
+	}
+
+	*u = User(plain)
+	return nil
+}
+
+// encryptedDocuments are the personal fields encrypted at rest outside of the users, by collection.
+// Their documents are identified by "id" and store the ID of their data key in "keyid", like the users.
+// The username is the public handle of an account, where it names a counterparty it is stored as it is
+var encryptedDocuments = map[string][]string{
+	"bank_accounts": {"holdername", "accountnumber"},
+	"payouts":       {"holdername", "accountnumber"},
+	"kyc_reviews":   {"username", "name"},
+}
+
+// sealDocument marshals plain, a copy of a document of the collection without its BSON methods, with its fields
+// encrypted and the ID of the data key. fields point into plain
+func sealDocument(collection string, id primitive.ObjectID, plain interface{}, fields map[string]*string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	key, err := keyring.activeKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for field, value := range fields {
+		if *value == "" {
+			continue
+		}
+		if *value, err = encryption.Seal(key, documentAdditionalData(collection, id, field), *value); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := bson.Marshal(plain)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	if err = bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	doc = append(doc, bson.E{Key: "keyid", Value: key.ID})
+
+	return bson.Marshal(doc)
+}
+
+// setDocumentFields adds the encrypted fields of a document of the collection to the $set of an update, with the
+// ID of the data key. fields must hold every encrypted field of the document, they all move to the active key
+func setDocumentFields(ctx context.Context, update bson.M, collection string, id primitive.ObjectID, fields map[string]string) error {
+	key, err := keyring.activeKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	for field, value := range fields {
+		sealed := ""
+		if value != "" {
+			if sealed, err = encryption.Seal(key, documentAdditionalData(collection, id, field), value); err != nil {
+				return err
+			}
+		}
+		update[field] = sealed
+	}
+	update["keyid"] = key.ID
+	return nil
+}
+
+// openDocument decrypts the fields of a document of the collection, read without its BSON methods
+func openDocument(collection string, id primitive.ObjectID, fields map[string]*string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for field, value := range fields {
+		opened, err := openField(ctx, documentAdditionalData(collection, id, field), *value)
+		if err != nil {
+			return fmt.Errorf("%s %s: %s: %v", collection, id.Hex(), field, err)
+		}
+		*value = opened
+	}
+	return nil
+}
+
+// plainBankAccount is BankAccount without its BSON methods
+type plainBankAccount BankAccount
+
+func (a *plainBankAccount) encrypted() map[string]*string {
+	return map[string]*string{"holdername": &a.HolderName, "accountnumber": &a.AccountNumber}
+}
+
+// MarshalBSON stores the holder name and the account number encrypted
+func (a BankAccount) MarshalBSON() ([]byte, error) {
+	plain := plainBankAccount(a)
+	return sealDocument("bank_accounts", a.ID, &plain, plain.encrypted())
+}
+
+// UnmarshalBSON ...
+func (a *BankAccount) UnmarshalBSON(data []byte) error {
+	var plain plainBankAccount
+	if err := bson.Unmarshal(data, &plain); err != nil {
+		return err
+	}
+	if err := openDocument("bank_accounts", plain.ID, plain.encrypted()); err != nil {
+		return err
+	}
+	*a = BankAccount(plain)
+	return nil
+}
+
+// plainPayout is Payout without its BSON methods
+type plainPayout Payout
+
+func (p *plainPayout) encrypted() map[string]*string {
+	return map[string]*string{"holdername": &p.HolderName, "accountnumber": &p.AccountNumber}
+}
+
+// MarshalBSON stores the holder name and the account number encrypted
+func (p Payout) MarshalBSON() ([]byte, error) {
+	plain := plainPayout(p)
+	return sealDocument("payouts", p.ID, &plain, plain.encrypted())
+}
+
+// UnmarshalBSON ...
+func (p *Payout) UnmarshalBSON(data []byte) error {
+	var plain plainPayout
+	if err := bson.Unmarshal(data, &plain); err != nil {
+		return err
+	}
+	if err := openDocument("payouts", plain.ID, plain.encrypted()); err != nil {
+		return err
+	}
+	*p = Payout(plain)
+	return nil
+}
+
+// plainKYCReview is KYCReview without its BSON methods
+type plainKYCReview KYCReview
+
+func (r *plainKYCReview) encrypted() map[string]*string {
+	return map[string]*string{"username": &r.Username, "name": &r.Name}
+}
+
+// MarshalBSON stores the username and the name encrypted
+func (r KYCReview) MarshalBSON() ([]byte, error) {
+	plain := plainKYCReview(r)
+	return sealDocument("kyc_reviews", r.ID, &plain, plain.encrypted())
+}
+
+// UnmarshalBSON ...
+func (r *KYCReview) UnmarshalBSON(data []byte) error {
+	var plain plainKYCReview
+	if err := bson.Unmarshal(data, &plain); err != nil {
+		return err
+	}
+	if err := openDocument("kyc_reviews", plain.ID, plain.encrypted()); err != nil {
+		return err
+	}
+	*r = KYCReview(plain)
+	return nil
+}
+
+// RotateKeys wraps again the data keys wrapped with a master key that is not the active one anymore,
+// and creates a new data key once the newest one is older than ENCRYPTION_DATA_KEY_ROTATION_INTERVAL
+func (m EncryptionModel) RotateKeys(ctx context.Context) error {
+	dataKeyCollection := db.GetCollection(db.DB, "data_keys")
+	keyFile := encryption.GetKeyFile()
+
+	results, err := dataKeyCollection.Find(ctx, bson.M{"masterkeyid": bson.M{"$ne": keyFile.ActiveMasterKey()}})
+	if err != nil {
+		return err
+	}
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var stored DataKey
+		if err = results.Decode(&stored); err != nil {
+			return err
+		}
+
+		key, err := keyFile.Unwrap(stored.MasterKeyID, stored.ID, stored.Wrapped)
+		if err != nil {
+			return err
+		}
+		masterKeyID, wrapped, err := keyFile.Wrap(key)
+		if err != nil {
+			return err
+		}
+
+		_, err = dataKeyCollection.UpdateOne(ctx,
+			bson.M{"_id": stored.ID, "masterkeyid": stored.MasterKeyID},
+			bson.M{"$set": bson.M{"masterkeyid": masterKeyID, "wrapped": wrapped}})
+		if err != nil {
+			return err
+		}
+	}
+	if err = results.Err(); err != nil {
+		return err
+	}
+
+	var newest DataKey
+	err = dataKeyCollection.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.M{"_id": -1})).Decode(&newest)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	interval := utils.GetEnvDuration("ENCRYPTION_DATA_KEY_ROTATION_INTERVAL", 30*24*time.Hour)
+	if err == nil && time.Since(time.Unix(newest.CreatedAt, 0)) < interval {
+		return nil
+	}
+
+	key, err := keyring.create(ctx)
+	if err != nil {
+		return err
+	}
+
+	keyring.mu.Lock()
+	keyring.active = key.ID
+	keyring.refreshAt = time.Now().Add(dataKeyRefresh)
+	keyring.mu.Unlock()
+
+	return nil
+}
+
+// ReencryptUsers encrypts with the active data key the users written with an older one, or before the encryption.
+// Only the encrypted fields are written, and only when they did not change meanwhile
+func (m EncryptionModel) ReencryptUsers(ctx context.Context) error {
+	userCollection := db.GetCollection(db.DB, "users")
+
+	key, err := keyring.activeKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	results, err := userCollection.Find(ctx, bson.M{"keyid": bson.M{"$ne": key.ID}})
+	if err != nil {
+		return err
+	}
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var user User
+		if err = results.Decode(&user); err != nil {
+			return err
+		}
+
+		plain := plainUser(user)
+		filter := bson.M{"id": user.ID}
+		update := bson.M{"keyid": key.ID}
+		for field, value := range plain.encrypted() {
+			stored, err := results.Current.LookupErr(field)
+			if err != nil {
+				filter[field] = bson.M{"$exists": false}
+			} else {
+				filter[field] = stored
+			}
+
+			if err = sealUserField(key, update, user.ID, field, *value); err != nil {
+				return err
+			}
+		}
+
+		_, err = userCollection.UpdateOne(ctx, filter, bson.M{"$set": update})
+		if err != nil {
+			return err
+		}
+	}
+
+	return results.Err()
+}
+
+// ReencryptDocuments does for the encryptedDocuments what ReencryptUsers does for the users
+func (m EncryptionModel) ReencryptDocuments(ctx context.Context) error {
+	key, err := keyring.activeKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	for collection, fields := range encryptedDocuments {
+		if err = m.reencrypt(ctx, key, collection, fields); err != nil {
+			return fmt.Errorf("%s: %v", collection, err)
+		}
+	}
+	return nil
+}
+
+// reencrypt encrypts the fields of the documents of the collection written with another data key than key
+func (m EncryptionModel) reencrypt(ctx context.Context, key encryption.DataKey, collection string, fields []string) error {
+	documentCollection := db.GetCollection(db.DB, collection)
+
+	results, err := documentCollection.Find(ctx, bson.M{"keyid": bson.M{"$ne": key.ID}})
+	if err != nil {
+		return err
+	}
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		id, ok := results.Current.Lookup("id").ObjectIDOK()
+		if !ok {
+			continue
+		}
+
+		filter := bson.M{"id": id}
+		update := bson.M{"keyid": key.ID}
+		for _, field := range fields {
+			stored, err := results.Current.LookupErr(field)
+			if err != nil {
+				filter[field] = bson.M{"$exists": false}
+				continue
+			}
+			filter[field] = stored
+
+			value, ok := stored.StringValueOK()
+			if !ok || value == "" {
+				continue
+			}
+			additionalData := documentAdditionalData(collection, id, field)
+			if value, err = openField(ctx, additionalData, value); err != nil {
+				return err
+			}
+			if update[field], err = encryption.Seal(key, additionalData, value); err != nil {
+				return err
+			}
+		}
+
+		_, err = documentCollection.UpdateOne(ctx, filter, bson.M{"$set": update})
+		if err != nil {
+			return err
+		}
+	}
+
+	return results.Err()
+}
@@ -0,0 +1,674 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/storage"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DataExport is a copy of the personal data of a user, a ZIP file built in the background and kept in the
+// blob store under Key until ExpiresAt
+type DataExport struct {
+	ID          primitive.ObjectID `json:"id"`
+	UserID      primitive.ObjectID `json:"-"`
+	Status      string             `json:"status"`
+	Key         string             `json:"-"`
+	Size        int64              `json:"size,omitempty"`
+	LeaseUntil  int64              `json:"-"`
+	ExpiresAt   int64              `json:"expires_at,omitempty"`
+	CreatedAt   int64              `json:"created_at"`
+	UpdatedAt   int64              `json:"updated_at"`
+	CompletedAt int64              `json:"completed_at,omitempty"`
+}
+
+// Erasure replaces the username of an erased user by Pseudonym wherever it is stored. Its _id is the user
+// so a user is erased once. Username is the erased username, only kept until the erasure is COMPLETED
+type Erasure struct {
+	UserID      primitive.ObjectID `json:"-" bson:"_id"`
+	Username    string             `json:"-"`
+	Pseudonym   string             `json:"pseudonym"`
+	Status      string             `json:"status"`
+	LeaseUntil  int64              `json:"-"`
+	CreatedAt   int64              `json:"created_at"`
+	CompletedAt int64              `json:"completed_at,omitempty"`
+}
+
+// ErrExportNotFound ...
+var ErrExportNotFound = errors.New("export not found")
+
+// ErrExportNotReady is returned when downloading an export that is still being built, failed or expired
+var ErrExportNotReady = errors.New("the export is not ready to download")
+
+// GDPRModel ...
+type GDPRModel struct{}
+
+// erasedName replaces the name of erased users
+const erasedName = "Erased user"
+
+// gdprLease is how long a worker owns a claimed export or erasure before another one may retry it
+const gdprLease = 10 * time.Minute
+
+// CreateExport asks for an export of the personal data of the user, one at a time
+func (m GDPRModel) CreateExport(ctx context.Context, userID primitive.ObjectID) (export DataExport, err error) {
+	fmt.Println("GDPR model: CreateExport")
+	exportCollection := db.GetCollection(db.DB, "user_exports")
+
+	count, err := exportCollection.CountDocuments(ctx, bson.M{
+		"userid": userID,
+		"status": bson.M{"$in": []string{utils.EXPORT_PENDING, utils.EXPORT_PROCESSING}},
+	})
+	if err != nil {
+		return export, errors.New("something went wrong, please try again later")
+	}
+	if count > 0 {
+		return export, errors.New("an export of your data is already being prepared")
+	}
+
+	now := time.Now().Unix()
+	export = DataExport{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Status:    utils.EXPORT_PENDING,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	_, err = exportCollection.InsertOne(ctx, export)
+	if err != nil {
+		return export, errors.New("error when creating new export")
+	}
+
+	return export, nil
+}
+
+// Exports returns the last exports of the user, the most recent first
+func (m GDPRModel) Exports(ctx context.Context, userID primitive.ObjectID) (exports []DataExport, err error) {
+	fmt.Println("GDPR model: Exports")
+	exportCollection := db.GetCollection(db.DB, "user_exports")
+
+	opts := options.Find().SetSort(bson.M{"createdat": -1}).SetLimit(20)
+	results, err := exportCollection.Find(ctx, bson.M{"userid": userID}, opts)
+	if err != nil {
+		return exports, errors.New("error when retrieving exports")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var export DataExport
+		if err = results.Decode(&export); err != nil {
+			return exports, errors.New("error when decoding export")
+		}
+
+		exports = append(exports, export)
+	}
+
+	return exports, nil
+}
+
+// OpenExport returns a READY export of the user with its ZIP file, the caller closes the file
+func (m GDPRModel) OpenExport(ctx context.Context, userID primitive.ObjectID, id primitive.ObjectID) (export DataExport, file io.ReadCloser, err error) {
+	fmt.Println("GDPR model: OpenExport")
+	exportCollection := db.GetCollection(db.DB, "user_exports")
+
+	err = exportCollection.FindOne(ctx, bson.M{"id": id, "userid": userID}).Decode(&export)
+	if err == mongo.ErrNoDocuments {
+		return export, nil, ErrExportNotFound
+	}
+	if err != nil {
+		return export, nil, errors.New("something went wrong, please try again later")
+	}
+	if export.Status != utils.EXPORT_READY || export.ExpiresAt <= time.Now().Unix() {
+		return export, nil, ErrExportNotReady
+	}
+
+	file, err = storage.GetBlobStore().Get(ctx, export.Key)
+	if err == storage.ErrBlobNotFound {
+		return export, nil, ErrExportNotReady
+	}
+	if err != nil {
+		return export, nil, errors.New("something went wrong, please try again later")
+	}
+
+	return export, file, nil
+}
+
+// ProcessNextExport builds the oldest pending export, it returns mongo.ErrNoDocuments when there is none.
+// The file is available for EXPORT_TTL
+func (m GDPRModel) ProcessNextExport(ctx context.Context) error {
+	userCollection := db.GetCollection(db.DB, "users")
+	exportCollection := db.GetCollection(db.DB, "user_exports")
+
+	var export DataExport
+	now := time.Now().Unix()
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"createdat": 1}).SetReturnDocument(options.After)
+	err := exportCollection.FindOneAndUpdate(ctx,
+		bson.M{
+			"status":     bson.M{"$in": []string{utils.EXPORT_PENDING, utils.EXPORT_PROCESSING}},
+			"leaseuntil": bson.M{"$lt": now},
+		},
+		bson.M{"$set": bson.M{"status": utils.EXPORT_PROCESSING, "leaseuntil": time.Now().Add(gdprLease).Unix(), "updatedat": now}},
+		opts).Decode(&export)
+	if err != nil {
+		return err
+	}
+
+	var user User
+	err = userCollection.FindOne(ctx, bson.M{"id": export.UserID}).Decode(&user)
+	if err == nil && user.ErasedAt != 0 {
+		err = errors.New("the user was erased")
+	}
+	if err != nil {
+		_, updateErr := exportCollection.UpdateOne(ctx, bson.M{"id": export.ID}, bson.M{"$set": bson.M{
+			"status":     utils.EXPORT_FAILED,
+			"leaseuntil": int64(0),
+			"updatedat":  time.Now().Unix(),
+		}})
+		if updateErr != nil {
+			return updateErr
+		}
+		return fmt.Errorf("export %s: %v", export.ID.Hex(), err)
+	}
+
+	data, err := m.archive(ctx, user)
+	if err != nil {
+		//Left PROCESSING, it is retried once the lease is over
+		return err
+	}
+
+	key := "exports/" + user.ID.Hex() + "/" + export.ID.Hex() + ".zip"
+	if err = storage.GetBlobStore().Put(ctx, key, bytes.NewReader(data)); err != nil {
+		return err
+	}
+
+	now = time.Now().Unix()
+	_, err = exportCollection.UpdateOne(ctx, bson.M{"id": export.ID}, bson.M{"$set": bson.M{
+		"status":      utils.EXPORT_READY,
+		"key":         key,
+		"size":        int64(len(data)),
+		"leaseuntil":  int64(0),
+		"expiresat":   time.Now().Add(utils.GetEnvDuration("EXPORT_TTL", 7*24*time.Hour)).Unix(),
+		"updatedat":   now,
+		"completedat": now,
+	}})
+	return err
+}
+
+// archive returns the ZIP file of the personal data of the user: their profile, sessions, events and transactions in JSON
+func (m GDPRModel) archive(ctx context.Context, user User) ([]byte, error) {
+	sessionCollection := db.GetCollection(db.DB, "sessions")
+	outboxCollection := db.GetCollection(db.DB, "outbox")
+	transactionCollection := db.GetCollection(db.DB, "transactions")
+
+	byDate := options.Find().SetSort(bson.M{"createdat": 1})
+
+	sessions := []Session{}
+	results, err := sessionCollection.Find(ctx, bson.M{"userid": user.ID}, byDate)
+	if err != nil {
+		return nil, err
+	}
+	if err = results.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+
+	events := []EventEnvelope{}
+	results, err = outboxCollection.Find(ctx, bson.M{"usernames": user.Username}, byDate)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var event OutboxEvent
+		if err = results.Decode(&event); err != nil {
+			return nil, err
+		}
+		events = append(events, EventEnvelope{ID: event.ID.Hex(), Type: event.Type, CreatedAt: event.CreatedAt, Data: event.Payload})
+	}
+	if err = results.Err(); err != nil {
+		return nil, err
+	}
+
+	transactions := []Transaction{}
+	results, err = transactionCollection.Find(ctx, bson.M{"$or": []bson.M{{"from": user.Username}, {"to": user.Username}}}, byDate)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var transaction Transaction
+		if err = results.Decode(&transaction); err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, transaction.For(user.Username))
+	}
+	if err = results.Err(); err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", profileOf(user)},
+		{"sessions.json", sessions},
+		{"events.json", events},
+		{"transactions.json", transactions},
+	}
+	for _, file := range files {
+		w, err := archive.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.MarshalIndent(file.data, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err = archive.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// ExpireExports deletes the files of the exports past their expiry
+func (m GDPRModel) ExpireExports(ctx context.Context) error {
+	exportCollection := db.GetCollection(db.DB, "user_exports")
+
+	results, err := exportCollection.Find(ctx, bson.M{"status": utils.EXPORT_READY, "expiresat": bson.M{"$lte": time.Now().Unix()}})
+	if err != nil {
+		return err
+	}
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var export DataExport
+		if err = results.Decode(&export); err != nil {
+			return err
+		}
+
+		if err = storage.GetBlobStore().Delete(ctx, export.Key); err != nil {
+			return err
+		}
+		_, err = exportCollection.UpdateOne(ctx, bson.M{"id": export.ID}, bson.M{"$set": bson.M{"status": utils.EXPORT_EXPIRED, "updatedat": time.Now().Unix()}})
+		if err != nil {
+			return err
+		}
+	}
+
+	return results.Err()
+}
+
+// RequestErasure erases the personal data of the user once they confirmed it with their password. The account is
+// closed at once: personal fields are cleared, sessions, API keys and OAuth clients are deleted, and no money can
+// be sent to it anymore. The username is replaced by a pseudonym in the background by ProcessNextErasure.
+// Transactions, payouts, top-ups and KYC reviews are kept for financial record retention, under the pseudonym.
+// The account must hold no money and have nothing in flight
+func (m GDPRModel) RequestErasure(ctx context.Context, userID primitive.ObjectID, password string) (erasure Erasure, err error) {
+	fmt.Println("GDPR model: RequestErasure")
+
+	pseudonym, err := generateHexToken(8)
+	if err != nil {
+		return erasure, errors.New("something went wrong, please try again later")
+	}
+
+	data, err := withTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		userCollection := db.GetCollection(db.DB, "users")
+		erasureCollection := db.GetCollection(db.DB, "erasures")
+
+		var user User
+		err := userCollection.FindOne(sessionContext, bson.M{"id": userID}).Decode(&user)
+		if err != nil {
+			return nil, errors.New("something went wrong, please try again later")
+		}
+		if user.ErasedAt != 0 {
+			return nil, errors.New("the account is already erased")
+		}
+
+		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+		if err != nil {
+			return nil, errors.New("your password is incorrect")
+		}
+
+		if err = m.checkErasable(sessionContext, user); err != nil {
+			return nil, err
+		}
+
+		now := time.Now().Unix()
+		erasure := Erasure{
+			UserID:    user.ID,
+			Username:  user.Username,
+			Pseudonym: "erased:" + pseudonym,
+			Status:    utils.ERASURE_PENDING,
+			CreatedAt: now,
+		}
+		_, err = erasureCollection.InsertOne(sessionContext, erasure)
+		if err != nil {
+			return nil, errors.New("error when erasing the account")
+		}
+
+		//The username stays until every copy of it is replaced, so nobody can register it meanwhile
+		update := bson.M{
+			"password":      "",
+			"emailverified": false,
+			"phoneverified": false,
+			"avatarurl":     "",
+			"locale":        "",
+			"timezone":      "",
+			"erasedat":      now,
+			"updatedat":     now,
+		}
+		err = setUserFields(sessionContext, update, user.ID, map[string]string{"name": erasedName, "email": "", "phone": ""})
+		if err != nil {
+			return nil, errors.New("error when erasing the account")
+		}
+		_, err = userCollection.UpdateOne(sessionContext, bson.M{"id": user.ID}, bson.M{"$set": update})
+		if err != nil {
+			return nil, errors.New("error when erasing the account")
+		}
+
+		for _, name := range []string{"api_keys", "oauth_clients", "profile_verifications", "password_resets"} {
+			_, err = db.GetCollection(db.DB, name).DeleteMany(sessionContext, bson.M{"userid": user.ID})
+			if err != nil {
+				return nil, errors.New("error when erasing the account")
+			}
+		}
+		if err = authModel.RevokeAuth(sessionContext, user.ID, ""); err != nil {
+			return nil, errors.New("error when erasing the account")
+		}
+
+		return erasure, nil
+	})
+	if err != nil {
+		return erasure, err
+	}
+
+	return data.(Erasure), nil
+}
+
+// checkErasable refuses the erasure of an account that still holds money or has money in flight, including
+// withdrawals that can still be returned and paid invoices that can still be refunded
+func (m GDPRModel) checkErasable(ctx context.Context, user User) error {
+	if user.Balance != 0 {
+		return errors.New("please withdraw your balance before erasing your account")
+	}
+
+	now := time.Now()
+	returnable := now.Add(-utils.GetEnvDuration("PAYOUT_RETURN_WINDOW", 72*time.Hour)).Unix()
+	refundable := now.Add(-utils.GetEnvDuration("INVOICE_REFUND_WINDOW", 30*24*time.Hour)).Unix()
+
+	checks := []struct {
+		collection string
+		filter     bson.M
+		message    string
+	}{
+		{"pockets", bson.M{"userid": user.ID, "balance": bson.M{"$ne": 0}}, "please empty your pockets before erasing your account"},
+		{"merchants", bson.M{"ownerid": user.ID, "balance": bson.M{"$ne": 0}}, "please pay out your merchants before erasing your account"},
+		{"payouts", bson.M{"userid": user.ID, "status": bson.M{"$in": []string{utils.PAYOUT_PENDING, utils.PAYOUT_SUBMITTED}}}, "please wait for your withdrawals to settle before erasing your account"},
+		{"payouts", bson.M{"userid": user.ID, "status": utils.PAYOUT_SETTLED, "settledat": bson.M{"$gte": returnable}}, "please wait for the return window of your withdrawals to end before erasing your account"},
+		{"invoices", bson.M{"paidbyid": user.ID, "status": utils.INVOICE_PAID, "paidat": bson.M{"$gte": refundable}}, "please wait for the refund window of the invoices you paid to end before erasing your account"},
+		{"top_ups", bson.M{"userid": user.ID, "status": utils.TOP_UP_PENDING}, "please wait for your top-ups to complete before erasing your account"},
+		{"batch_transfers", bson.M{"userid": user.ID, "status": bson.M{"$in": []string{utils.BATCH_PENDING, utils.BATCH_PROCESSING}}}, "please wait for your batch transfers to complete before erasing your account"},
+		{"payment_requests", bson.M{"status": utils.REQUEST_PENDING, "$or": []bson.M{{"requesterid": user.ID}, {"payerid": user.ID}}}, "please close your pending payment requests before erasing your account"},
+	}
+	for _, check := range checks {
+		count, err := db.GetCollection(db.DB, check.collection).CountDocuments(ctx, check.filter)
+		if err != nil {
+			return errors.New("something went wrong, please try again later")
+		}
+		if count > 0 {
+			return errors.New(check.message)
+		}
+	}
+
+	return nil
+}
+
+// ProcessNextErasure completes the oldest pending erasure, it returns mongo.ErrNoDocuments when there is none.
+// Every step can run again, a failed erasure is retried once the lease is over
+func (m GDPRModel) ProcessNextErasure(ctx context.Context) error {
+	erasureCollection := db.GetCollection(db.DB, "erasures")
+	userCollection := db.GetCollection(db.DB, "users")
+
+	var erasure Erasure
+	now := time.Now().Unix()
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"createdat": 1}).SetReturnDocument(options.After)
+	err := erasureCollection.FindOneAndUpdate(ctx,
+		bson.M{"status": utils.ERASURE_PENDING, "leaseuntil": bson.M{"$lt": now}},
+		bson.M{"$set": bson.M{"leaseuntil": time.Now().Add(gdprLease).Unix()}},
+		opts).Decode(&erasure)
+	if err != nil {
+		return err
+	}
+
+	if err = m.pseudonymize(ctx, erasure); err != nil {
+		return err
+	}
+	if err = m.deletePersonalData(ctx, erasure); err != nil {
+		return err
+	}
+
+	update := bson.M{}
+	if err = setUserFields(ctx, update, erasure.UserID, map[string]string{"username": erasure.Pseudonym}); err != nil {
+		return err
+	}
+	_, err = userCollection.UpdateOne(ctx, bson.M{"id": erasure.UserID}, bson.M{"$set": update})
+	if err != nil {
+		return err
+	}
+
+	now = time.Now().Unix()
+	_, err = erasureCollection.UpdateOne(ctx, bson.M{"_id": erasure.UserID}, bson.M{"$set": bson.M{
+		"status":      utils.ERASURE_COMPLETED,
+		"username":    "",
+		"leaseuntil":  int64(0),
+		"completedat": now,
+	}})
+	return err
+}
+
+// pseudonymize replaces the username by the pseudonym in the records kept: the ledger keeps every amount and
+// both sides of every transaction, only who the erased side was is lost. Memos and tags the user wrote are cleared
+func (m GDPRModel) pseudonymize(ctx context.Context, erasure Erasure) error {
+	username, pseudonym := erasure.Username, erasure.Pseudonym
+
+	updates := []struct {
+		collection string
+		filter     bson.M
+		update     bson.M
+		opts       *options.UpdateOptions
+	}{
+		{"transactions", bson.M{"from": username}, bson.M{"$set": bson.M{"from": pseudonym, "memo": "", "fromtags": []string{}}}, nil},
+		{"transactions", bson.M{"to": username}, bson.M{"$set": bson.M{"to": pseudonym, "totags": []string{}}}, nil},
+		{"payment_requests", bson.M{"requester": username}, bson.M{"$set": bson.M{"requester": pseudonym, "memo": ""}}, nil},
+		{"payment_requests", bson.M{"payer": username}, bson.M{"$set": bson.M{"payer": pseudonym}}, nil},
+		{"bills", bson.M{"creator": username}, bson.M{"$set": bson.M{"creator": pseudonym}}, nil},
+		{"bills", bson.M{"shares.username": username}, bson.M{"$set": bson.M{"shares.$[share].username": pseudonym}},
+			options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"share.username": username}}})},
+		{"batch_transfers", bson.M{"items.to": username}, bson.M{"$set": bson.M{"items.$[item].to": pseudonym}},
+			options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"item.to": username}}})},
+		{"invoices", bson.M{"paidby": username}, bson.M{"$set": bson.M{"paidby": pseudonym}}, nil},
+		{"merchants", bson.M{"owner": username}, bson.M{"$set": bson.M{"owner": pseudonym}}, nil},
+		{"qr_redemptions", bson.M{"to": username}, bson.M{"$set": bson.M{"to": pseudonym}}, nil},
+		{"notifications", bson.M{"data.from": username}, bson.M{"$set": bson.M{"data.from": pseudonym, "data.memo": ""}}, nil},
+	}
+	for _, u := range updates {
+		var opts []*options.UpdateOptions
+		if u.opts != nil {
+			opts = append(opts, u.opts)
+		}
+		_, err := db.GetCollection(db.DB, u.collection).UpdateMany(ctx, u.filter, u.update, opts...)
+		if err != nil {
+			return fmt.Errorf("pseudonymizing %s: %v", u.collection, err)
+		}
+	}
+
+	if err := m.pseudonymizeReviews(ctx, erasure); err != nil {
+		return fmt.Errorf("pseudonymizing kyc_reviews: %v", err)
+	}
+
+	return m.pseudonymizeEvents(ctx, username, pseudonym)
+}
+
+// pseudonymizeReviews replaces the username and the name in the KYC reviews of the user. Their fields are encrypted
+// and bound to their review, so every review is written on its own
+func (m GDPRModel) pseudonymizeReviews(ctx context.Context, erasure Erasure) error {
+	reviewCollection := db.GetCollection(db.DB, "kyc_reviews")
+
+	results, err := reviewCollection.Find(ctx, bson.M{"userid": erasure.UserID}, options.Find().SetProjection(bson.M{"id": 1}))
+	if err != nil {
+		return err
+	}
+	var reviews []struct {
+		ID primitive.ObjectID
+	}
+	if err = results.All(ctx, &reviews); err != nil {
+		return err
+	}
+
+	for _, review := range reviews {
+		update := bson.M{}
+		err = setDocumentFields(ctx, update, "kyc_reviews", review.ID, map[string]string{"username": erasure.Pseudonym, "name": erasedName})
+		if err != nil {
+			return err
+		}
+		if _, err = reviewCollection.UpdateOne(ctx, bson.M{"id": review.ID}, bson.M{"$set": update}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// eventPartyFields are the fields of the event payloads that hold the username of a party: the sides of a
+// transaction and the payer of an invoice
+var eventPartyFields = []string{"from", "to", "owner", "paid_by"}
+
+// pseudonymizeEvents replaces the username in the events of the user, their payloads included
+func (m GDPRModel) pseudonymizeEvents(ctx context.Context, username string, pseudonym string) error {
+	outboxCollection := db.GetCollection(db.DB, "outbox")
+
+	results, err := outboxCollection.Find(ctx, bson.M{"usernames": username})
+	if err != nil {
+		return err
+	}
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var event OutboxEvent
+		if err = results.Decode(&event); err != nil {
+			return err
+		}
+
+		for i := range event.Usernames {
+			if event.Usernames[i] == username {
+				event.Usernames[i] = pseudonym
+			}
+		}
+		payload, err := pseudonymizePayload(event.Payload, username, pseudonym)
+		if err != nil {
+			return err
+		}
+		_, err = outboxCollection.UpdateOne(ctx, bson.M{"id": event.ID}, bson.M{"$set": bson.M{
+			"usernames": event.Usernames,
+			"payload":   payload,
+		}})
+		if err != nil {
+			return err
+		}
+	}
+
+	return results.Err()
+}
+
+// pseudonymizePayload replaces the username in the party fields of an event payload, any other field is kept
+// as it is even when it has the same value. The memo is cleared when the user sent the transaction, as in the ledger
+func pseudonymizePayload(payload []byte, username string, pseudonym string) ([]byte, error) {
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, err
+	}
+
+	changed := false
+	for _, field := range eventPartyFields {
+		if value, ok := fields[field].(string); ok && value == username {
+			fields[field] = pseudonym
+			changed = true
+		}
+	}
+	if fields["from"] == pseudonym {
+		delete(fields, "memo")
+	}
+	if !changed {
+		return payload, nil
+	}
+
+	return json.Marshal(fields)
+}
+
+// deletePersonalData deletes what is not kept for financial record retention: the address book of the user and
+// their entries in the ones of others, their bank accounts, webhooks, notifications, exports and login attempts
+func (m GDPRModel) deletePersonalData(ctx context.Context, erasure Erasure) error {
+	exportCollection := db.GetCollection(db.DB, "user_exports")
+
+	deletes := []struct {
+		collection string
+		filter     bson.M
+	}{
+		{"beneficiaries", bson.M{"$or": []bson.M{{"userid": erasure.UserID}, {"beneficiaryid": erasure.UserID}}}},
+		{"beneficiary_settings", bson.M{"_id": erasure.UserID}},
+		{"bank_accounts", bson.M{"userid": erasure.UserID}},
+		{"webhooks", bson.M{"userid": erasure.UserID}},
+		{"webhook_deliveries", bson.M{"userid": erasure.UserID}},
+		{"login_attempts", bson.M{"key": bson.M{"$regex": "^" + regexp.QuoteMeta(UsernameAttemptKey(erasure.Username)+"|")}}},
+		{"notifications", bson.M{"userid": erasure.UserID}},
+		{"notification_preferences", bson.M{"_id": erasure.UserID}},
+		{"known_devices", bson.M{"userid": erasure.UserID}},
+	}
+	for _, d := range deletes {
+		_, err := db.GetCollection(db.DB, d.collection).DeleteMany(ctx, d.filter)
+		if err != nil {
+			return fmt.Errorf("deleting %s: %v", d.collection, err)
+		}
+	}
+
+	results, err := exportCollection.Find(ctx, bson.M{"userid": erasure.UserID, "key": bson.M{"$ne": ""}})
+	if err != nil {
+		return err
+	}
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var export DataExport
+		if err = results.Decode(&export); err != nil {
+			return err
+		}
+		if err = storage.GetBlobStore().Delete(ctx, export.Key); err != nil {
+			return err
+		}
+	}
+	if err = results.Err(); err != nil {
+		return err
+	}
+
+	_, err = exportCollection.DeleteMany(ctx, bson.M{"userid": erasure.UserID})
+	return err
+}
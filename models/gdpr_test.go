@@ -0,0 +1,66 @@
+//go:build all
+// +build all
+
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/encryption"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPseudonymizeReviews(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	user := newTestUser(t, 0)
+	reviewCollection := db.GetCollection(db.DB, "kyc_reviews")
+
+	review := KYCReview{ID: primitive.NewObjectID(), UserID: user.ID, Username: user.Username, Name: "Test User", Level: utils.KYC_IDENTITY, Status: utils.KYC_PENDING}
+	_, err := reviewCollection.InsertOne(ctx, review)
+	require.NoError(t, err)
+
+	erasure := Erasure{UserID: user.ID, Username: user.Username, Pseudonym: "erased-" + user.ID.Hex()}
+	require.NoError(t, new(GDPRModel).pseudonymizeReviews(ctx, erasure))
+
+	//The pseudonym and the name are encrypted at rest with the active data key
+	var stored bson.M
+	require.NoError(t, reviewCollection.FindOne(ctx, bson.M{"id": review.ID}).Decode(&stored))
+	assert.True(t, encryption.IsEncrypted(stored["username"].(string)))
+	assert.True(t, encryption.IsEncrypted(stored["name"].(string)))
+	key, err := keyring.activeKey(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, stored["keyid"])
+
+	require.NoError(t, reviewCollection.FindOne(ctx, bson.M{"id": review.ID}).Decode(&review))
+	assert.Equal(t, erasure.Pseudonym, review.Username)
+	assert.Equal(t, erasedName, review.Name)
+}
+
+func TestPseudonymizePayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+	}{
+		{"sender", `{"amount":100,"from":"alice","memo":"rent","to":"bob"}`, `{"amount":100,"from":"erased","to":"bob"}`},
+		{"recipient", `{"amount":100,"from":"bob","memo":"rent","to":"alice"}`, `{"amount":100,"from":"bob","memo":"rent","to":"erased"}`},
+		{"payer of an invoice", `{"memo":"alice","merchant":"alice","paid_by":"alice","total":3000}`, `{"memo":"alice","merchant":"alice","paid_by":"erased","total":3000}`},
+		{"other fields with the username", `{"from":"bob","reference":"alice","to":"carol"}`, `{"from":"bob","reference":"alice","to":"carol"}`},
+		{"large amount", `{"amount":9007199254740993,"to":"alice"}`, `{"amount":9007199254740993,"to":"erased"}`},
+	}
+
+	for _, test := range tests {
+		payload, err := pseudonymizePayload([]byte(test.payload), "alice", "erased")
+		require.NoError(t, err, test.name)
+		assert.Equal(t, test.want, string(payload), test.name)
+	}
+}
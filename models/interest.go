@@ -0,0 +1,436 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// InterestTier pays Rate, an annual rate in basis points, on balances of at least MinBalance
+type InterestTier struct {
+	MinBalance int64 `json:"min_balance"`
+	Rate       int64 `json:"annual_rate_bps"`
+}
+
+// InterestSchedule is a list of tiers sorted by MinBalance. The tier a balance falls in applies to the whole balance
+type InterestSchedule []InterestTier
+
+// InterestAccount is the accrual state of a main balance or of a pocket.
+// Carry is the fraction of a unit not paid yet, in 1/interestDenominator units, and Accrued is what waits for the monthly posting
+type InterestAccount struct {
+	Key       string             `json:"-" bson:"_id"`
+	UserID    primitive.ObjectID `json:"-"`
+	PocketID  string             `json:"pocket_id,omitempty"`
+	Accrued   int64              `json:"accrued"`
+	Carry     int64              `json:"-"`
+	LastDay   string             `json:"last_day"`
+	UpdatedAt int64              `json:"updated_at"`
+}
+
+// InterestAccrual is the interest of one account for one day, its _id "<account>:<day>" makes a day accrue at most once
+type InterestAccrual struct {
+	Key       string             `json:"-" bson:"_id"`
+	Account   string             `json:"-"`
+	UserID    primitive.ObjectID `json:"-"`
+	PocketID  string             `json:"pocket_id,omitempty"`
+	Day       string             `json:"day"`
+	Month     string             `json:"month"`
+	Balance   int64              `json:"balance"`
+	Rate      int64              `json:"annual_rate_bps"`
+	Amount    int64              `json:"amount"`
+	Posted    bool               `json:"posted"`
+	CreatedAt int64              `json:"created_at"`
+}
+
+// InterestSummary is what a user sees of their interest: the rates and what accrued since the last posting
+type InterestSummary struct {
+	BalanceRates InterestSchedule  `json:"balance_rates"`
+	PocketRates  InterestSchedule  `json:"pocket_rates"`
+	Accounts     []InterestAccount `json:"accounts"`
+}
+
+// InterestModel ...
+type InterestModel struct{}
+
+// interestDenominator turns balance * basis points into a daily amount: 10000 basis points, 365 days a year
+const interestDenominator = 10000 * 365
+
+// maxInterestRate is 100% a year
+const maxInterestRate = 10000
+
+// ParseInterestSchedule reads "<min balance>:<annual rate in basis points>,..." e.g. "0:100,100000:150".
+// An empty value is a schedule paying nothing
+func ParseInterestSchedule(value string) (schedule InterestSchedule, err error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return schedule, nil
+	}
+
+	for _, tier := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(tier), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid interest tier %q", tier)
+		}
+
+		min, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || min < 0 {
+			return nil, fmt.Errorf("invalid minimum balance in interest tier %q", tier)
+		}
+		rate, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || rate < 0 || rate > maxInterestRate {
+			return nil, fmt.Errorf("invalid rate in interest tier %q", tier)
+		}
+
+		schedule = append(schedule, InterestTier{MinBalance: min, Rate: rate})
+	}
+
+	sort.Slice(schedule, func(i, j int) bool { return schedule[i].MinBalance < schedule[j].MinBalance })
+	for i := 1; i < len(schedule); i++ {
+		if schedule[i].MinBalance == schedule[i-1].MinBalance {
+			return nil, fmt.Errorf("duplicate interest tier for balance %d", schedule[i].MinBalance)
+		}
+	}
+
+	return schedule, nil
+}
+
+// Rate returns the annual rate of the tier the balance falls in, 0 below the first tier
+func (s InterestSchedule) Rate(balance int64) int64 {
+	var rate int64
+	for _, tier := range s {
+		if balance < tier.MinBalance {
+			break
+		}
+		rate = tier.Rate
+	}
+	return rate
+}
+
+// DailyInterest returns the whole units earned in a day by balance at rate, and the fraction left to carry to the next day.
+// It rounds down: balance * rate + carry is split into whole units and a remainder below interestDenominator,
+// so no fraction is ever lost or paid twice
+func DailyInterest(balance int64, rate int64, carry int64) (amount int64, remainder int64) {
+	numerator := new(big.Int).Mul(big.NewInt(balance), big.NewInt(rate))
+	numerator.Add(numerator, big.NewInt(carry))
+
+	quotient, modulus := new(big.Int).QuoRem(numerator, big.NewInt(interestDenominator), new(big.Int))
+	return quotient.Int64(), modulus.Int64()
+}
+
+// interestSchedules reads the schedules of main balances and pockets from the environment
+func interestSchedules() (balanceRates InterestSchedule, pocketRates InterestSchedule, err error) {
+	balanceRates, err = ParseInterestSchedule(os.Getenv("INTEREST_BALANCE_RATES"))
+	if err != nil {
+		return nil, nil, err
+	}
+	pocketRates, err = ParseInterestSchedule(os.Getenv("INTEREST_POCKET_RATES"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return balanceRates, pocketRates, nil
+}
+
+func interestAccountKey(userID primitive.ObjectID, pocketID string) string {
+	if pocketID == "" {
+		return "balance:" + userID.Hex()
+	}
+	return "pocket:" + pocketID
+}
+
+// AccrueDay accrues the interest of day ("2006-01-02") on every main balance and pocket paying some.
+// The balance accrued on is the one at the end of the day (UTC): the balance snapshot of the day for main balances,
+// replayed back from the current balance for pockets and for users without snapshots yet.
+// Running a day again skips the accounts it was already accrued for
+func (m InterestModel) AccrueDay(ctx context.Context, day string) error {
+	fmt.Println("Interest model: AccrueDay")
+	userCollection := db.GetCollection(db.DB, "users")
+	pocketCollection := db.GetCollection(db.DB, "pockets")
+
+	start, err := time.Parse("2006-01-02", day)
+	if err != nil {
+		return fmt.Errorf("invalid interest day %q", day)
+	}
+	//Balances opened after the day earn nothing for it
+	filter := bson.M{"createdat": bson.M{"$lt": start.AddDate(0, 0, 1).Unix()}}
+	end := endOfDay(start)
+
+	balanceRates, pocketRates, err := interestSchedules()
+	if err != nil {
+		return err
+	}
+
+	if len(balanceRates) > 0 {
+		results, err := userCollection.Find(ctx, filter)
+		if err != nil {
+			return err
+		}
+		defer results.Close(ctx)
+		for results.Next(ctx) {
+			var user User
+			if err = results.Decode(&user); err != nil {
+				return err
+			}
+			done, err := m.accrued(ctx, user.ID, "", day)
+			if err != nil {
+				return err
+			}
+			if done {
+				continue
+			}
+			balance, err := balanceModel.balanceAt(ctx, user, end)
+			if err != nil {
+				return err
+			}
+			if err = m.accrue(ctx, user.ID, "", day, balance, balanceRates.Rate(balance)); err != nil {
+				return err
+			}
+		}
+		if err = results.Err(); err != nil {
+			return err
+		}
+	}
+
+	if len(pocketRates) > 0 {
+		results, err := pocketCollection.Find(ctx, filter)
+		if err != nil {
+			return err
+		}
+		defer results.Close(ctx)
+		for results.Next(ctx) {
+			var pocket Pocket
+			if err = results.Decode(&pocket); err != nil {
+				return err
+			}
+			done, err := m.accrued(ctx, pocket.UserID, pocket.ID.Hex(), day)
+			if err != nil {
+				return err
+			}
+			if done {
+				continue
+			}
+			balance, err := balanceModel.pocketBalanceAt(ctx, pocket, end)
+			if err != nil {
+				return err
+			}
+			if err = m.accrue(ctx, pocket.UserID, pocket.ID.Hex(), day, balance, pocketRates.Rate(balance)); err != nil {
+				return err
+			}
+		}
+		if err = results.Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// accrued tells whether the interest of the account was already accrued for the day. It saves computing the
+// balance of the day again, the unique accrual _id is what guarantees a day is accrued once
+func (m InterestModel) accrued(ctx context.Context, userID primitive.ObjectID, pocketID string, day string) (bool, error) {
+	accrualCollection := db.GetCollection(db.DB, "interest_accruals")
+
+	count, err := accrualCollection.CountDocuments(ctx, bson.M{"_id": interestAccountKey(userID, pocketID) + ":" + day})
+	return count > 0, err
+}
+
+// accrue records the interest of one account for one day and moves its carry forward in one Mongo transaction.
+// The accrual _id is unique, so a day that was already accrued changes nothing
+func (m InterestModel) accrue(ctx context.Context, userID primitive.ObjectID, pocketID string, day string, balance int64, rate int64) error {
+	accountCollection := db.GetCollection(db.DB, "interest_accounts")
+	accrualCollection := db.GetCollection(db.DB, "interest_accruals")
+
+	if balance <= 0 || rate == 0 {
+		return nil
+	}
+
+	account := interestAccountKey(userID, pocketID)
+	key := account + ":" + day
+
+	_, err := withTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		var state InterestAccount
+		err := accountCollection.FindOne(sessionContext, bson.M{"_id": account}).Decode(&state)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+
+		amount, carry := DailyInterest(balance, rate, state.Carry)
+
+		now := time.Now().Unix()
+		_, err = accrualCollection.InsertOne(sessionContext, InterestAccrual{
+			Key:       key,
+			Account:   account,
+			UserID:    userID,
+			PocketID:  pocketID,
+			Day:       day,
+			Month:     day[:7],
+			Balance:   balance,
+			Rate:      rate,
+			Amount:    amount,
+			CreatedAt: now,
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		lastDay := state.LastDay
+		if day > lastDay {
+			lastDay = day
+		}
+
+		_, err = accountCollection.UpdateOne(sessionContext, bson.M{"_id": account}, bson.M{
+			"$set": bson.M{"userid": userID, "pocketid": pocketID, "carry": carry, "lastday": lastDay, "updatedat": now},
+			"$inc": bson.M{"accrued": amount},
+		}, options.Update().SetUpsert(true))
+		return nil, err
+	})
+
+	return err
+}
+
+// PostNext pays one account the interest it accrued during one month before month ("2006-01").
+// It returns mongo.ErrNoDocuments when there is nothing left to post, see IsNoEvent
+func (m InterestModel) PostNext(ctx context.Context, month string) error {
+	accrualCollection := db.GetCollection(db.DB, "interest_accruals")
+
+	var accrual InterestAccrual
+	err := accrualCollection.FindOne(ctx, bson.M{"posted": false, "month": bson.M{"$lt": month}}, options.FindOne().SetSort(bson.M{"day": 1})).Decode(&accrual)
+	if err != nil {
+		return err
+	}
+
+	return m.post(ctx, accrual.Account, accrual.Month)
+}
+
+// post credits the unposted accruals of an account for a month as one INTEREST transaction, marking them posted
+// in the same Mongo transaction. The interest of a deleted pocket goes to the main balance
+func (m InterestModel) post(ctx context.Context, account string, month string) error {
+	fmt.Println("Interest model: post")
+	userCollection := db.GetCollection(db.DB, "users")
+	pocketCollection := db.GetCollection(db.DB, "pockets")
+	accountCollection := db.GetCollection(db.DB, "interest_accounts")
+	accrualCollection := db.GetCollection(db.DB, "interest_accruals")
+
+	_, err := withTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		filter := bson.M{"account": account, "month": month, "posted": false}
+
+		results, err := accrualCollection.Find(sessionContext, filter)
+		if err != nil {
+			return nil, err
+		}
+		var accruals []InterestAccrual
+		if err = results.All(sessionContext, &accruals); err != nil {
+			return nil, err
+		}
+		if len(accruals) == 0 {
+			return nil, nil
+		}
+
+		var amount int64
+		for _, accrual := range accruals {
+			amount += accrual.Amount
+		}
+
+		now := time.Now().Unix()
+		_, err = accrualCollection.UpdateMany(sessionContext, filter, bson.M{"$set": bson.M{"posted": true}})
+		if err != nil {
+			return nil, err
+		}
+		if amount == 0 {
+			return nil, nil
+		}
+
+		_, err = accountCollection.UpdateOne(sessionContext, bson.M{"_id": account}, bson.M{"$inc": bson.M{"accrued": -amount}, "$set": bson.M{"updatedat": now}})
+		if err != nil {
+			return nil, err
+		}
+
+		userID := accruals[0].UserID
+		var user User
+		if err = userCollection.FindOne(sessionContext, bson.M{"id": userID}).Decode(&user); err != nil {
+			return nil, err
+		}
+
+		var pocket Pocket
+		if pocketID := accruals[0].PocketID; pocketID != "" {
+			pocket, err = pocketModel.Find(sessionContext, userID, pocketID)
+			if err != nil && err != ErrPocketNotFound {
+				return nil, err
+			}
+		}
+
+		form := forms.CreateTransactionForm{
+			From:      user.Username,
+			To:        user.Username,
+			Amount:    amount,
+			Type:      utils.INTEREST,
+			Reference: "interest:" + month,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if !pocket.ID.IsZero() {
+			form.Balance = pocket.Balance + amount
+			form.PocketID = pocket.ID.Hex()
+			_, err = pocketCollection.UpdateOne(sessionContext, bson.M{"id": pocket.ID}, bson.M{"$set": bson.M{"balance": form.Balance, "updatedat": now}})
+		} else {
+			form.Balance = user.Balance + amount
+			_, err = userCollection.UpdateOne(sessionContext, bson.M{"id": user.ID}, bson.M{"$set": bson.M{"balance": form.Balance, "updatedat": now}})
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		transaction, err := transactionModel.Create(sessionContext, form)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, outboxModel.Add(sessionContext, utils.EVENT_INTEREST, []string{user.Username}, transaction)
+	})
+
+	return err
+}
+
+// Summary returns the rates and the interest accrued since the last posting on the balances of the user
+func (m InterestModel) Summary(ctx context.Context, userID primitive.ObjectID) (summary InterestSummary, err error) {
+	fmt.Println("Interest model: Summary")
+	accountCollection := db.GetCollection(db.DB, "interest_accounts")
+
+	summary.BalanceRates, summary.PocketRates, err = interestSchedules()
+	if err != nil {
+		return summary, errors.New("something went wrong, please try again later")
+	}
+
+	results, err := accountCollection.Find(ctx, bson.M{"userid": userID}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return summary, errors.New("error when retrieving interest")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var account InterestAccount
+		if err = results.Decode(&account); err != nil {
+			return summary, errors.New("error when decoding interest")
+		}
+
+		summary.Accounts = append(summary.Accounts, account)
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,86 @@
+//go:build all
+// +build all
+
+package models
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseInterestSchedule(t *testing.T) {
+	tests := []struct {
+		value    string
+		schedule InterestSchedule
+		valid    bool
+	}{
+		{"", nil, true},
+		{"0:100", InterestSchedule{{MinBalance: 0, Rate: 100}}, true},
+		{"100000:150, 0:100", InterestSchedule{{MinBalance: 0, Rate: 100}, {MinBalance: 100000, Rate: 150}}, true},
+		{"0:10000", InterestSchedule{{MinBalance: 0, Rate: 10000}}, true},
+		{"0:10001", nil, false},
+		{"0:-1", nil, false},
+		{"-1:100", nil, false},
+		{"0:100,0:150", nil, false},
+		{"0", nil, false},
+		{"0:100:1", nil, false},
+		{"a:100", nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			schedule, err := ParseInterestSchedule(test.value)
+			if !test.valid {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.schedule, schedule)
+		})
+	}
+}
+
+func TestInterestScheduleRate(t *testing.T) {
+	schedule := InterestSchedule{{MinBalance: 1000, Rate: 100}, {MinBalance: 100000, Rate: 150}}
+
+	assert.Equal(t, int64(0), schedule.Rate(999))
+	assert.Equal(t, int64(100), schedule.Rate(1000))
+	assert.Equal(t, int64(100), schedule.Rate(99999))
+	assert.Equal(t, int64(150), schedule.Rate(100000))
+}
+
+func TestDailyInterest(t *testing.T) {
+	tests := []struct {
+		name      string
+		balance   int64
+		rate      int64
+		carry     int64
+		amount    int64
+		remainder int64
+	}{
+		{"nothing", 0, 100, 0, 0, 0},
+		{"whole units", 3650000, 100, 0, 100, 0},
+		{"rounded down", 1000000, 100, 0, 27, 1450000},
+		{"carry completes a unit", 1000000, 100, 2200000, 28, 0},
+		{"no rate", 1000000, 0, 5, 0, 5},
+		{"no overflow", math.MaxInt64, 10000, 0, math.MaxInt64 / 365, math.MaxInt64 % 365 * 10000},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			amount, remainder := DailyInterest(test.balance, test.rate, test.carry)
+			assert.Equal(t, test.amount, amount)
+			assert.Equal(t, test.remainder, remainder)
+		})
+	}
+
+	//A year of daily accruals pays the annual rate, no fraction is lost
+	var total, carry, amount int64
+	for day := 0; day < 365; day++ {
+		amount, carry = DailyInterest(123457, 175, carry)
+		total += amount
+	}
+	assert.Equal(t, int64(123457*175/10000), total)
+}
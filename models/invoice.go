@@ -0,0 +1,418 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// InvoiceItem ...
+type InvoiceItem struct {
+	Description string `json:"description"`
+	Quantity    int64  `json:"quantity"`
+	UnitPrice   int64  `json:"unit_price"`
+	Amount      int64  `json:"amount"`
+}
+
+// Invoice is a bill of a merchant, paid by any logged-in user holding its Code (the payment link)
+type Invoice struct {
+	ID                  primitive.ObjectID `json:"id"`
+	MerchantID          primitive.ObjectID `json:"merchant_id"`
+	Merchant            string             `json:"merchant"`
+	MerchantCode        string             `json:"merchant_code"`
+	Number              string             `json:"number"`
+	Code                string             `json:"code"`
+	Items               []InvoiceItem      `json:"items"`
+	Total               int64              `json:"total"`
+	Memo                string             `json:"memo,omitempty"`
+	Status              string             `json:"status"`
+	PaidByID            primitive.ObjectID `json:"-"`
+	PaidBy              string             `json:"paid_by,omitempty"`
+	PaidAt              int64              `json:"paid_at,omitempty"`
+	TransactionID       primitive.ObjectID `json:"transaction_id,omitempty"`
+	RefundedAt          int64              `json:"refunded_at,omitempty"`
+	RefundTransactionID primitive.ObjectID `json:"refund_transaction_id,omitempty"`
+	ExpiresAt           int64              `json:"expires_at"`
+	CreatedAt           int64              `json:"created_at"`
+	UpdatedAt           int64              `json:"updated_at"`
+}
+
+// ErrInvoiceNotFound ...
+var ErrInvoiceNotFound = errors.New("invoice not found")
+
+// ErrInvalidInvoiceTotal ...
+var ErrInvalidInvoiceTotal = errors.New("the total of the invoice is out of range")
+
+// InvoiceModel ...
+type InvoiceModel struct{}
+
+// Create adds an invoice to a merchant of the owner, numbered in sequence per merchant
+func (m InvoiceModel) Create(ctx context.Context, ownerID primitive.ObjectID, merchantID primitive.ObjectID, form forms.CreateInvoiceForm) (invoice Invoice, err error) {
+	fmt.Println("Invoice model: Create")
+	merchantCollection := db.GetCollection(db.DB, "merchants")
+	invoiceCollection := db.GetCollection(db.DB, "invoices")
+
+	//Checked math: a total wrapping around would pay the payer and take from the merchant
+	var total int64
+	items := make([]InvoiceItem, len(form.Items))
+	for i, item := range form.Items {
+		amount, ok := utils.MulAmount(item.Quantity, item.UnitPrice)
+		if ok {
+			total, ok = utils.AddAmount(total, amount)
+		}
+		if !ok {
+			return invoice, ErrInvalidInvoiceTotal
+		}
+		items[i] = InvoiceItem{Description: item.Description, Quantity: item.Quantity, UnitPrice: item.UnitPrice, Amount: amount}
+	}
+	if total <= 0 {
+		return invoice, ErrInvalidInvoiceTotal
+	}
+
+	var merchant Merchant
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = merchantCollection.FindOneAndUpdate(ctx, bson.M{"id": merchantID, "ownerid": ownerID}, bson.M{"$inc": bson.M{"invoicecount": 1}}, opts).Decode(&merchant)
+	if err == mongo.ErrNoDocuments {
+		return invoice, ErrMerchantNotFound
+	}
+	if err != nil {
+		return invoice, errors.New("something went wrong, please try again later")
+	}
+
+	code, err := generateToken(9)
+	if err != nil {
+		return invoice, errors.New("something went wrong, please try again later")
+	}
+
+	ttl := utils.GetEnvDuration("INVOICE_TTL", 30*24*time.Hour)
+	if form.ExpiresInHours > 0 {
+		ttl = time.Duration(form.ExpiresInHours) * time.Hour
+	}
+
+	now := time.Now().Unix()
+	invoice = Invoice{
+		ID:           primitive.NewObjectID(),
+		MerchantID:   merchant.ID,
+		Merchant:     merchant.Name,
+		MerchantCode: merchant.Code,
+		Number:       fmt.Sprintf("INV-%06d", merchant.InvoiceCount),
+		Code:         code,
+		Items:        items,
+		Total:        total,
+		Memo:         form.Memo,
+		Status:       utils.INVOICE_OPEN,
+		ExpiresAt:    time.Now().Add(ttl).Unix(),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	_, err = invoiceCollection.InsertOne(ctx, invoice)
+	if err != nil {
+		return invoice, errors.New("error when creating new invoice")
+	}
+
+	return invoice, nil
+}
+
+// expire moves the open invoices past their expiry to EXPIRED
+func (m InvoiceModel) expire(ctx context.Context) error {
+	invoiceCollection := db.GetCollection(db.DB, "invoices")
+
+	now := time.Now().Unix()
+	_, err := invoiceCollection.UpdateMany(ctx,
+		bson.M{"status": utils.INVOICE_OPEN, "expiresat": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"status": utils.INVOICE_EXPIRED, "updatedat": now}})
+	return err
+}
+
+// List returns the invoices of a merchant of the owner, newest first, optionally filtered by status
+func (m InvoiceModel) List(ctx context.Context, ownerID primitive.ObjectID, merchantID primitive.ObjectID, status string, query Query) (invoices []Invoice, err error) {
+	fmt.Println("Invoice model: List")
+	invoiceCollection := db.GetCollection(db.DB, "invoices")
+
+	if _, err = merchantModel.Find(ctx, ownerID, merchantID); err != nil {
+		return invoices, err
+	}
+	if err = m.expire(ctx); err != nil {
+		return invoices, errors.New("error when retrieving invoices")
+	}
+
+	filter := bson.M{"merchantid": merchantID}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	opts := options.Find().SetSort(bson.M{"createdat": -1}).SetSkip(int64((query.Page - 1) * query.Limit)).SetLimit(int64(query.Limit))
+	results, err := invoiceCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return invoices, errors.New("error when retrieving invoices")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var invoice Invoice
+		if err = results.Decode(&invoice); err != nil {
+			return invoices, errors.New("error when decoding invoice")
+		}
+
+		invoices = append(invoices, invoice)
+	}
+
+	return invoices, nil
+}
+
+// Find returns an invoice of a merchant of the owner
+func (m InvoiceModel) Find(ctx context.Context, ownerID primitive.ObjectID, merchantID primitive.ObjectID, id primitive.ObjectID) (invoice Invoice, err error) {
+	fmt.Println("Invoice model: Find")
+	invoiceCollection := db.GetCollection(db.DB, "invoices")
+
+	if _, err = merchantModel.Find(ctx, ownerID, merchantID); err != nil {
+		return invoice, err
+	}
+	if err = m.expire(ctx); err != nil {
+		return invoice, errors.New("something went wrong, please try again later")
+	}
+
+	err = invoiceCollection.FindOne(ctx, bson.M{"id": id, "merchantid": merchantID}).Decode(&invoice)
+	if err == mongo.ErrNoDocuments {
+		return invoice, ErrInvoiceNotFound
+	}
+	if err != nil {
+		return invoice, errors.New("something went wrong, please try again later")
+	}
+
+	return invoice, nil
+}
+
+// FindByCode returns the invoice behind a payment link
+func (m InvoiceModel) FindByCode(ctx context.Context, code string) (invoice Invoice, err error) {
+	fmt.Println("Invoice model: FindByCode")
+	invoiceCollection := db.GetCollection(db.DB, "invoices")
+
+	if err = m.expire(ctx); err != nil {
+		return invoice, errors.New("something went wrong, please try again later")
+	}
+
+	err = invoiceCollection.FindOne(ctx, bson.M{"code": code}).Decode(&invoice)
+	if err == mongo.ErrNoDocuments {
+		return invoice, ErrInvoiceNotFound
+	}
+	if err != nil {
+		return invoice, errors.New("something went wrong, please try again later")
+	}
+
+	return invoice, nil
+}
+
+// Pay settles the invoice behind a payment link from the wallet of the user to the merchant balance
+func (m InvoiceModel) Pay(ctx context.Context, userID primitive.ObjectID, code string) (invoice Invoice, err error) {
+	fmt.Println("Invoice model: Pay")
+	userCollection := db.GetCollection(db.DB, "users")
+	merchantCollection := db.GetCollection(db.DB, "merchants")
+	invoiceCollection := db.GetCollection(db.DB, "invoices")
+
+	data, err := withTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		var payer User
+		err := userCollection.FindOne(sessionContext, bson.M{"id": userID}).Decode(&payer)
+		if err != nil {
+			return invoice, errors.New("something went wrong, please try again later")
+		}
+
+		var invoice Invoice
+		now := time.Now().Unix()
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = invoiceCollection.FindOneAndUpdate(sessionContext,
+			bson.M{"code": code, "status": utils.INVOICE_OPEN, "expiresat": bson.M{"$gt": now}},
+			bson.M{"$set": bson.M{"status": utils.INVOICE_PAID, "paidbyid": payer.ID, "paidby": payer.Username, "paidat": now, "updatedat": now}},
+			opts).Decode(&invoice)
+		if err == mongo.ErrNoDocuments {
+			invoice, err = m.FindByCode(sessionContext, code)
+			if err != nil {
+				return invoice, err
+			}
+			return invoice, fmt.Errorf("the invoice is %s", invoice.Status)
+		}
+		if err != nil {
+			return invoice, err
+		}
+
+		var merchant Merchant
+		err = merchantCollection.FindOne(sessionContext, bson.M{"id": invoice.MerchantID}).Decode(&merchant)
+		if err != nil {
+			return invoice, errors.New("something went wrong, please try again later")
+		}
+		if merchant.OwnerID == payer.ID {
+			return invoice, errors.New("you can not pay an invoice of your own merchant")
+		}
+
+		if invoice.Total <= 0 {
+			return invoice, ErrInvalidInvoiceTotal
+		}
+
+		if payer.Balance < invoice.Total {
+			return invoice, errors.New("your balance is not enough to execute the transaction")
+		}
+
+		if err = kycModel.checkOutgoing(sessionContext, payer, invoice.Total); err != nil {
+			return invoice, err
+		}
+
+		//The money ends up with the owner of the merchant, so it is a transfer to them for the beneficiary restriction
+		if err = beneficiaryModel.checkTransfer(sessionContext, payer.ID, merchant.OwnerID); err != nil {
+			return invoice, err
+		}
+
+		balance := payer.Balance - invoice.Total
+		_, err = userCollection.UpdateOne(sessionContext, bson.M{"id": payer.ID}, bson.M{"$set": bson.M{"balance": balance, "updatedat": now}})
+		if err != nil {
+			return invoice, errors.New("internal server error")
+		}
+
+		_, err = merchantCollection.UpdateOne(sessionContext, bson.M{"id": merchant.ID}, bson.M{"$inc": bson.M{"balance": invoice.Total}, "$set": bson.M{"updatedat": now}})
+		if err != nil {
+			return invoice, errors.New("internal server error")
+		}
+
+		transaction, err := transactionModel.Create(sessionContext, forms.CreateTransactionForm{
+			From:      payer.Username,
+			To:        merchant.Code,
+			Amount:    invoice.Total,
+			Balance:   balance,
+			Type:      utils.INVOICE_PAYMENT,
+			Reference: "invoice:" + invoice.ID.Hex(),
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+		if err != nil {
+			return invoice, err
+		}
+
+		invoice.TransactionID = transaction.ID
+		_, err = invoiceCollection.UpdateOne(sessionContext, bson.M{"id": invoice.ID}, bson.M{"$set": bson.M{"transactionid": transaction.ID}})
+		if err != nil {
+			return invoice, err
+		}
+
+		err = outboxModel.Add(sessionContext, utils.EVENT_INVOICE_PAID, []string{payer.Username, merchant.Owner}, invoice)
+		if err != nil {
+			return invoice, err
+		}
+
+		err = cashbackModel.apply(sessionContext, utils.INVOICE_PAYMENT, payer.ID, invoice.Total, transaction.ID)
+
+		return invoice, err
+	})
+	invoice, _ = data.(Invoice)
+
+	return invoice, err
+}
+
+// Refund gives the amount of a paid invoice back to the payer, from the merchant balance, and takes back the
+// cashback of the payment. An invoice can be refunded until INVOICE_REFUND_WINDOW after its payment, and not once
+// the payer erased their account
+func (m InvoiceModel) Refund(ctx context.Context, ownerID primitive.ObjectID, merchantID primitive.ObjectID, id primitive.ObjectID) (invoice Invoice, err error) {
+	fmt.Println("Invoice model: Refund")
+	userCollection := db.GetCollection(db.DB, "users")
+	merchantCollection := db.GetCollection(db.DB, "merchants")
+	invoiceCollection := db.GetCollection(db.DB, "invoices")
+
+	data, err := withTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		merchant, err := merchantModel.Find(sessionContext, ownerID, merchantID)
+		if err != nil {
+			return invoice, err
+		}
+
+		var invoice Invoice
+		now := time.Now().Unix()
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = invoiceCollection.FindOneAndUpdate(sessionContext,
+			bson.M{"id": id, "merchantid": merchant.ID, "status": utils.INVOICE_PAID},
+			bson.M{"$set": bson.M{"status": utils.INVOICE_REFUNDED, "refundedat": now, "updatedat": now}},
+			opts).Decode(&invoice)
+		if err == mongo.ErrNoDocuments {
+			err = invoiceCollection.FindOne(sessionContext, bson.M{"id": id, "merchantid": merchant.ID}).Decode(&invoice)
+			if err == mongo.ErrNoDocuments {
+				return invoice, ErrInvoiceNotFound
+			}
+			if err != nil {
+				return invoice, err
+			}
+			return invoice, errors.New("only a paid invoice can be refunded")
+		}
+		if err != nil {
+			return invoice, err
+		}
+
+		if time.Since(time.Unix(invoice.PaidAt, 0)) > utils.GetEnvDuration("INVOICE_REFUND_WINDOW", 30*24*time.Hour) {
+			return invoice, errors.New("the invoice was paid too long ago to be refunded")
+		}
+
+		if merchant.Balance < invoice.Total {
+			return invoice, errors.New("the merchant balance is not enough to refund the invoice")
+		}
+
+		var payer User
+		err = userCollection.FindOne(sessionContext, bson.M{"id": invoice.PaidByID}).Decode(&payer)
+		if err != nil {
+			return invoice, errors.New("something went wrong, please try again later")
+		}
+		if payer.ErasedAt != 0 {
+			return invoice, errors.New("the payer of the invoice closed their account")
+		}
+
+		if err = kycModel.checkIncoming(sessionContext, payer, invoice.Total, false); err != nil {
+			return invoice, err
+		}
+
+		_, err = merchantCollection.UpdateOne(sessionContext, bson.M{"id": merchant.ID}, bson.M{"$inc": bson.M{"balance": -invoice.Total}, "$set": bson.M{"updatedat": now}})
+		if err != nil {
+			return invoice, errors.New("internal server error")
+		}
+
+		_, err = userCollection.UpdateOne(sessionContext, bson.M{"id": payer.ID}, bson.M{"$set": bson.M{"balance": payer.Balance + invoice.Total, "updatedat": now}})
+		if err != nil {
+			return invoice, errors.New("internal server error")
+		}
+
+		transaction, err := transactionModel.Create(sessionContext, forms.CreateTransactionForm{
+			From:      merchant.Code,
+			To:        payer.Username,
+			Amount:    invoice.Total,
+			Balance:   payer.Balance + invoice.Total,
+			Type:      utils.INVOICE_REFUND,
+			Reference: "invoice:" + invoice.ID.Hex(),
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+		if err != nil {
+			return invoice, err
+		}
+
+		invoice.RefundTransactionID = transaction.ID
+		_, err = invoiceCollection.UpdateOne(sessionContext, bson.M{"id": invoice.ID}, bson.M{"$set": bson.M{"refundtransactionid": transaction.ID}})
+		if err != nil {
+			return invoice, err
+		}
+
+		err = outboxModel.Add(sessionContext, utils.EVENT_INVOICE_REFUNDED, []string{payer.Username, merchant.Owner}, invoice)
+		if err != nil {
+			return invoice, err
+		}
+
+		err = cashbackModel.reverse(sessionContext, invoice.TransactionID)
+
+		return invoice, err
+	})
+	invoice, _ = data.(Invoice)
+
+	return invoice, err
+}
@@ -0,0 +1,40 @@
+//go:build all
+// +build all
+
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestInvoicePayRecordsBalance(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	owner := newTestUser(t, 0)
+	payer := newTestUser(t, 10000)
+
+	merchant, err := new(MerchantModel).Create(ctx, owner.ID, forms.CreateMerchantForm{Name: "Test merchant"})
+	require.NoError(t, err)
+	invoice, err := new(InvoiceModel).Create(ctx, owner.ID, merchant.ID, forms.CreateInvoiceForm{
+		Items: []forms.InvoiceItemForm{{Description: "Coffee", Quantity: 3, UnitPrice: 1000}},
+	})
+	require.NoError(t, err)
+
+	invoice, err = new(InvoiceModel).Pay(ctx, payer.ID, invoice.Code)
+	require.NoError(t, err)
+
+	var transaction Transaction
+	err = db.GetCollection(db.DB, "transactions").FindOne(ctx, bson.M{"id": invoice.TransactionID}).Decode(&transaction)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3000), transaction.Amount)
+	assert.Equal(t, int64(7000), transaction.Balance, "the balance of the payer after the payment")
+}
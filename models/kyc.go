@@ -0,0 +1,565 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/storage"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// KYCDocument is an identity document uploaded by a user, the file is in the blob store under Key
+type KYCDocument struct {
+	ID          primitive.ObjectID `json:"id"`
+	UserID      primitive.ObjectID `json:"-"`
+	Type        string             `json:"type"`
+	ContentType string             `json:"content_type"`
+	Size        int64              `json:"size"`
+	SHA256      string             `json:"sha256"`
+	Key         string             `json:"-"`
+	CreatedAt   int64              `json:"created_at"`
+}
+
+// KYCReview asks a reviewer to check the documents of a user for a level
+type KYCReview struct {
+	ID          primitive.ObjectID   `json:"id"`
+	UserID      primitive.ObjectID   `json:"-"`
+	Username    string               `json:"username"`
+	Name        string               `json:"name"`
+	Level       int                  `json:"level"`
+	DocumentIDs []primitive.ObjectID `json:"document_ids"`
+	Status      string               `json:"status"`
+	Reason      string               `json:"reason,omitempty"`
+	ReviewerID  primitive.ObjectID   `json:"-"`
+	ReviewedAt  int64                `json:"reviewed_at,omitempty"`
+	CreatedAt   int64                `json:"created_at"`
+	UpdatedAt   int64                `json:"updated_at"`
+}
+
+// KYCReviewDetails is a review with its documents, for reviewers
+type KYCReviewDetails struct {
+	KYCReview
+	Documents []KYCDocument `json:"documents"`
+}
+
+// KYCLimits are the limits of a level, 0 is no limit. DailyLimit bounds the money sent and withdrawn
+// in a day (UTC), BalanceLimit bounds the main balance money can be received on
+type KYCLimits struct {
+	Level        int   `json:"level"`
+	DailyLimit   int64 `json:"daily_limit"`
+	BalanceLimit int64 `json:"balance_limit"`
+}
+
+// KYCStatus is what a user sees of their verification
+type KYCStatus struct {
+	Level     int           `json:"level"`
+	Limits    KYCLimits     `json:"limits"`
+	Levels    []KYCLimits   `json:"levels"`
+	Review    *KYCReview    `json:"review,omitempty"`
+	Documents []KYCDocument `json:"documents"`
+}
+
+// ErrKYCDocumentNotFound ...
+var ErrKYCDocumentNotFound = errors.New("document not found")
+
+// ErrKYCReviewNotFound ...
+var ErrKYCReviewNotFound = errors.New("review not found")
+
+// ErrNotReviewer is returned when a user who is not in KYC_REVIEWERS uses the review queue
+var ErrNotReviewer = errors.New("only KYC reviewers can do this")
+
+// ErrDocumentType is returned for files that are not JPEG, PNG or PDF
+var ErrDocumentType = errors.New("the document must be a JPEG or PNG image or a PDF file")
+
+// KYCModel ...
+type KYCModel struct{}
+
+var kycModel = new(KYCModel)
+
+// maxKYCDocuments bounds the documents one user can upload
+const maxKYCDocuments = 20
+
+// kycDocumentTypes are the content types accepted, as sniffed from the file and not as declared by the client
+var kycDocumentTypes = []string{"image/jpeg", "image/png", "application/pdf"}
+
+// kycRequirements are the documents a level needs
+var kycRequirements = map[int][]string{
+	utils.KYC_IDENTITY: {utils.DOCUMENT_ID_FRONT, utils.DOCUMENT_SELFIE},
+	utils.KYC_ADDRESS:  {utils.DOCUMENT_ID_FRONT, utils.DOCUMENT_SELFIE, utils.DOCUMENT_PROOF_OF_ADDRESS},
+}
+
+// kycLevelLimits reads the limits of every level from KYC_DAILY_LIMITS and KYC_BALANCE_LIMITS,
+// comma separated lists with one amount per level starting at level 0, e.g. "20000,200000,0"
+func kycLevelLimits() (levels []KYCLimits, err error) {
+	daily := strings.Split(utils.GetEnvString("KYC_DAILY_LIMITS", "20000,200000,0"), ",")
+	balance := strings.Split(utils.GetEnvString("KYC_BALANCE_LIMITS", "50000,1000000,0"), ",")
+	if len(daily) != utils.KYC_ADDRESS+1 || len(balance) != utils.KYC_ADDRESS+1 {
+		return levels, fmt.Errorf("KYC limits need one amount per level, from 0 to %d", utils.KYC_ADDRESS)
+	}
+
+	for level := range daily {
+		limits := KYCLimits{Level: level}
+		limits.DailyLimit, err = strconv.ParseInt(strings.TrimSpace(daily[level]), 10, 64)
+		if err != nil || limits.DailyLimit < 0 {
+			return levels, fmt.Errorf("invalid KYC daily limit %q", daily[level])
+		}
+		limits.BalanceLimit, err = strconv.ParseInt(strings.TrimSpace(balance[level]), 10, 64)
+		if err != nil || limits.BalanceLimit < 0 {
+			return levels, fmt.Errorf("invalid KYC balance limit %q", balance[level])
+		}
+		levels = append(levels, limits)
+	}
+
+	return levels, nil
+}
+
+func kycLimits(level int) (KYCLimits, error) {
+	levels, err := kycLevelLimits()
+	if err != nil {
+		return KYCLimits{}, err
+	}
+	if level < 0 || level >= len(levels) {
+		return KYCLimits{}, fmt.Errorf("unknown KYC level %d", level)
+	}
+	return levels[level], nil
+}
+
+// checkReviewer returns the user, or ErrNotReviewer unless their username is in KYC_REVIEWERS
+func checkReviewer(ctx context.Context, userID primitive.ObjectID) (user User, err error) {
+	userCollection := db.GetCollection(db.DB, "users")
+
+	err = userCollection.FindOne(ctx, bson.M{"id": userID}).Decode(&user)
+	if err != nil {
+		return user, errors.New("something went wrong, please try again later")
+	}
+
+	for _, username := range strings.Split(os.Getenv("KYC_REVIEWERS"), ",") {
+		if strings.TrimSpace(username) == user.Username && user.Username != "" {
+			return user, nil
+		}
+	}
+	return user, ErrNotReviewer
+}
+
+// Upload stores a document of the user. data is the whole file, its content type is sniffed from its first bytes
+func (m KYCModel) Upload(ctx context.Context, userID primitive.ObjectID, documentType string, data []byte) (document KYCDocument, err error) {
+	fmt.Println("KYC model: Upload")
+	documentCollection := db.GetCollection(db.DB, "kyc_documents")
+
+	contentType := http.DetectContentType(data)
+	if !utils.Contains(kycDocumentTypes, contentType) {
+		return document, ErrDocumentType
+	}
+
+	count, err := documentCollection.CountDocuments(ctx, bson.M{"userid": userID})
+	if err != nil {
+		return document, errors.New("something went wrong, please try again later")
+	}
+	if count >= maxKYCDocuments {
+		return document, fmt.Errorf("you can upload at most %d documents", maxKYCDocuments)
+	}
+
+	sum := sha256.Sum256(data)
+	document = KYCDocument{
+		ID:          primitive.NewObjectID(),
+		UserID:      userID,
+		Type:        documentType,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		SHA256:      hex.EncodeToString(sum[:]),
+		CreatedAt:   time.Now().Unix(),
+	}
+	document.Key = "kyc/" + userID.Hex() + "/" + document.ID.Hex()
+
+	blobs := storage.GetBlobStore()
+	if err = blobs.Put(ctx, document.Key, bytes.NewReader(data)); err != nil {
+		return document, errors.New("error when storing the document")
+	}
+
+	_, err = documentCollection.InsertOne(ctx, document)
+	if err != nil {
+		blobs.Delete(ctx, document.Key)
+		return document, errors.New("error when creating new document")
+	}
+
+	return document, nil
+}
+
+// Documents lists the documents of the user, newest first
+func (m KYCModel) Documents(ctx context.Context, userID primitive.ObjectID) (documents []KYCDocument, err error) {
+	fmt.Println("KYC model: Documents")
+	documentCollection := db.GetCollection(db.DB, "kyc_documents")
+
+	results, err := documentCollection.Find(ctx, bson.M{"userid": userID}, options.Find().SetSort(bson.M{"createdat": -1}))
+	if err != nil {
+		return documents, errors.New("error when retrieving documents")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var document KYCDocument
+		if err = results.Decode(&document); err != nil {
+			return documents, errors.New("error when decoding document")
+		}
+
+		documents = append(documents, document)
+	}
+
+	return documents, nil
+}
+
+// Open returns a document and its file, to its owner or to a reviewer. The caller closes the file
+func (m KYCModel) Open(ctx context.Context, userID primitive.ObjectID, id primitive.ObjectID) (document KYCDocument, file io.ReadCloser, err error) {
+	fmt.Println("KYC model: Open")
+	documentCollection := db.GetCollection(db.DB, "kyc_documents")
+
+	err = documentCollection.FindOne(ctx, bson.M{"id": id}).Decode(&document)
+	if err == mongo.ErrNoDocuments {
+		return document, nil, ErrKYCDocumentNotFound
+	}
+	if err != nil {
+		return document, nil, errors.New("something went wrong, please try again later")
+	}
+
+	if document.UserID != userID {
+		//Other users must not learn that the document exists
+		if _, err = checkReviewer(ctx, userID); err != nil {
+			return document, nil, ErrKYCDocumentNotFound
+		}
+	}
+
+	file, err = storage.GetBlobStore().Get(ctx, document.Key)
+	if err == storage.ErrBlobNotFound {
+		return document, nil, ErrKYCDocumentNotFound
+	}
+	if err != nil {
+		return document, nil, errors.New("something went wrong, please try again later")
+	}
+
+	return document, file, nil
+}
+
+// Status returns the level of the user with its limits, their last review and their documents
+func (m KYCModel) Status(ctx context.Context, userID primitive.ObjectID) (status KYCStatus, err error) {
+	fmt.Println("KYC model: Status")
+	userCollection := db.GetCollection(db.DB, "users")
+	reviewCollection := db.GetCollection(db.DB, "kyc_reviews")
+
+	var user User
+	err = userCollection.FindOne(ctx, bson.M{"id": userID}).Decode(&user)
+	if err != nil {
+		return status, errors.New("something went wrong, please try again later")
+	}
+
+	status.Levels, err = kycLevelLimits()
+	if err != nil {
+		return status, err
+	}
+	status.Level = user.KYCLevel
+	status.Limits, err = kycLimits(user.KYCLevel)
+	if err != nil {
+		return status, err
+	}
+
+	var review KYCReview
+	opts := options.FindOne().SetSort(bson.M{"createdat": -1})
+	err = reviewCollection.FindOne(ctx, bson.M{"userid": userID}, opts).Decode(&review)
+	if err == nil {
+		status.Review = &review
+	} else if err != mongo.ErrNoDocuments {
+		return status, errors.New("something went wrong, please try again later")
+	}
+
+	status.Documents, err = m.Documents(ctx, userID)
+	if err != nil {
+		return status, err
+	}
+	if status.Documents == nil {
+		status.Documents = []KYCDocument{}
+	}
+
+	return status, nil
+}
+
+// Submit asks for the review of the documents of the user for level. The last document of every type
+// the level needs is sent, and a user has at most one review pending
+func (m KYCModel) Submit(ctx context.Context, userID primitive.ObjectID, level int) (review KYCReview, err error) {
+	fmt.Println("KYC model: Submit")
+	userCollection := db.GetCollection(db.DB, "users")
+	reviewCollection := db.GetCollection(db.DB, "kyc_reviews")
+
+	var user User
+	err = userCollection.FindOne(ctx, bson.M{"id": userID}).Decode(&user)
+	if err != nil {
+		return review, errors.New("something went wrong, please try again later")
+	}
+	if level <= user.KYCLevel {
+		return review, fmt.Errorf("you are already verified for level %d", user.KYCLevel)
+	}
+
+	count, err := reviewCollection.CountDocuments(ctx, bson.M{"userid": userID, "status": utils.KYC_PENDING})
+	if err != nil {
+		return review, errors.New("something went wrong, please try again later")
+	}
+	if count > 0 {
+		return review, errors.New("your documents are already being reviewed")
+	}
+
+	documents, err := m.Documents(ctx, userID)
+	if err != nil {
+		return review, err
+	}
+
+	now := time.Now().Unix()
+	review = KYCReview{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Username:  user.Username,
+		Name:      user.Name,
+		Level:     level,
+		Status:    utils.KYC_PENDING,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	//Documents are sorted newest first
+	for _, documentType := range kycRequirements[level] {
+		found := false
+		for _, document := range documents {
+			if document.Type == documentType {
+				review.DocumentIDs = append(review.DocumentIDs, document.ID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return review, fmt.Errorf("please upload your %s document first", strings.ReplaceAll(documentType, "_", " "))
+		}
+	}
+	//The back of the ID card is optional, but sent when there is one
+	for _, document := range documents {
+		if document.Type == utils.DOCUMENT_ID_BACK {
+			review.DocumentIDs = append(review.DocumentIDs, document.ID)
+			break
+		}
+	}
+
+	_, err = reviewCollection.InsertOne(ctx, review)
+	if err != nil {
+		return review, errors.New("error when creating new review")
+	}
+
+	return review, nil
+}
+
+// Reviews lists the reviews with status, the oldest first so the queue is worked in order. Reviewers only
+func (m KYCModel) Reviews(ctx context.Context, reviewerID primitive.ObjectID, status string, query Query) (reviews []KYCReview, err error) {
+	fmt.Println("KYC model: Reviews")
+	reviewCollection := db.GetCollection(db.DB, "kyc_reviews")
+
+	if _, err = checkReviewer(ctx, reviewerID); err != nil {
+		return reviews, err
+	}
+
+	opts := options.Find().SetSort(bson.M{"createdat": 1}).SetSkip(int64((query.Page - 1) * query.Limit)).SetLimit(int64(query.Limit))
+	results, err := reviewCollection.Find(ctx, bson.M{"status": status}, opts)
+	if err != nil {
+		return reviews, errors.New("error when retrieving reviews")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var review KYCReview
+		if err = results.Decode(&review); err != nil {
+			return reviews, errors.New("error when decoding review")
+		}
+
+		reviews = append(reviews, review)
+	}
+
+	return reviews, nil
+}
+
+// Review returns a review with its documents. Reviewers only
+func (m KYCModel) Review(ctx context.Context, reviewerID primitive.ObjectID, id primitive.ObjectID) (details KYCReviewDetails, err error) {
+	fmt.Println("KYC model: Review")
+	reviewCollection := db.GetCollection(db.DB, "kyc_reviews")
+	documentCollection := db.GetCollection(db.DB, "kyc_documents")
+
+	if _, err = checkReviewer(ctx, reviewerID); err != nil {
+		return details, err
+	}
+
+	err = reviewCollection.FindOne(ctx, bson.M{"id": id}).Decode(&details.KYCReview)
+	if err == mongo.ErrNoDocuments {
+		return details, ErrKYCReviewNotFound
+	}
+	if err != nil {
+		return details, errors.New("something went wrong, please try again later")
+	}
+
+	results, err := documentCollection.Find(ctx, bson.M{"id": bson.M{"$in": details.DocumentIDs}})
+	if err != nil {
+		return details, errors.New("error when retrieving documents")
+	}
+
+	defer results.Close(ctx)
+	details.Documents = []KYCDocument{}
+	for results.Next(ctx) {
+		var document KYCDocument
+		if err = results.Decode(&document); err != nil {
+			return details, errors.New("error when decoding document")
+		}
+
+		details.Documents = append(details.Documents, document)
+	}
+
+	return details, nil
+}
+
+// Approve approves a pending review and raises the level of its user. Reviewers only, and not on their own review
+func (m KYCModel) Approve(ctx context.Context, reviewerID primitive.ObjectID, id primitive.ObjectID) (review KYCReview, err error) {
+	fmt.Println("KYC model: Approve")
+	userCollection := db.GetCollection(db.DB, "users")
+
+	data, err := withTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		review, err := m.decide(sessionContext, reviewerID, id, utils.KYC_APPROVED, "")
+		if err != nil {
+			return review, err
+		}
+
+		//A level is never lowered by the approval of a lower one
+		_, err = userCollection.UpdateOne(sessionContext, bson.M{"id": review.UserID}, bson.M{
+			"$max": bson.M{"kyclevel": review.Level},
+			"$set": bson.M{"updatedat": time.Now().Unix()},
+		})
+		if err != nil {
+			return review, errors.New("internal server error")
+		}
+
+		return review, nil
+	})
+	review, _ = data.(KYCReview)
+
+	return review, err
+}
+
+// Reject rejects a pending review with the reason shown to its user. Reviewers only, and not on their own review
+func (m KYCModel) Reject(ctx context.Context, reviewerID primitive.ObjectID, id primitive.ObjectID, reason string) (review KYCReview, err error) {
+	fmt.Println("KYC model: Reject")
+
+	return m.decide(ctx, reviewerID, id, utils.KYC_REJECTED, reason)
+}
+
+// decide moves a review from PENDING to status, a review decided already is left as it is
+func (m KYCModel) decide(ctx context.Context, reviewerID primitive.ObjectID, id primitive.ObjectID, status string, reason string) (review KYCReview, err error) {
+	reviewCollection := db.GetCollection(db.DB, "kyc_reviews")
+
+	if _, err = checkReviewer(ctx, reviewerID); err != nil {
+		return review, err
+	}
+
+	now := time.Now().Unix()
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = reviewCollection.FindOneAndUpdate(ctx,
+		bson.M{"id": id, "status": utils.KYC_PENDING, "userid": bson.M{"$ne": reviewerID}},
+		bson.M{"$set": bson.M{"status": status, "reason": reason, "reviewerid": reviewerID, "reviewedat": now, "updatedat": now}},
+		opts).Decode(&review)
+	if err == mongo.ErrNoDocuments {
+		err = reviewCollection.FindOne(ctx, bson.M{"id": id}).Decode(&review)
+		if err == mongo.ErrNoDocuments {
+			return review, ErrKYCReviewNotFound
+		}
+		if err != nil {
+			return review, errors.New("something went wrong, please try again later")
+		}
+		if review.UserID == reviewerID {
+			return review, errors.New("you can not review your own documents")
+		}
+		return review, fmt.Errorf("the review is %s", review.Status)
+	}
+	if err != nil {
+		return review, errors.New("internal server error")
+	}
+
+	return review, nil
+}
+
+// checkOutgoing refuses to let the user send or withdraw amount beyond the daily limit of their level.
+// ctx must be the session context of the money movement, so its earlier transactions count
+func (m KYCModel) checkOutgoing(ctx context.Context, user User, amount int64) error {
+	transactionCollection := db.GetCollection(db.DB, "transactions")
+
+	limits, err := kycLimits(user.KYCLevel)
+	if err != nil {
+		return err
+	}
+	if limits.DailyLimit == 0 {
+		return nil
+	}
+	if amount > limits.DailyLimit {
+		return rejected("the amount is above your daily limit of %d, verify your identity to raise it", limits.DailyLimit)
+	}
+
+	now := time.Now().UTC()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	results, err := transactionCollection.Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{
+			"from":      user.Username,
+			"type":      bson.M{"$in": []string{utils.TRANSFER, utils.WITHDRAW, utils.INVOICE_PAYMENT}},
+			"createdat": bson.M{"$gte": start.Unix()},
+		}},
+		bson.M{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$amount"}}},
+	})
+	if err != nil {
+		return errors.New("something went wrong, please try again later")
+	}
+	defer results.Close(ctx)
+
+	var sent struct {
+		Total int64
+	}
+	if results.Next(ctx) {
+		if err = results.Decode(&sent); err != nil {
+			return errors.New("something went wrong, please try again later")
+		}
+	}
+
+	if sent.Total+amount > limits.DailyLimit {
+		return rejected("you can send %d more today, verify your identity to raise your daily limit", limits.DailyLimit-sent.Total)
+	}
+
+	return nil
+}
+
+// checkIncoming refuses amount when it would take the main balance of the user above the limit of their level,
+// self tells whether the user is the one moving the money, like for a top-up
+func (m KYCModel) checkIncoming(ctx context.Context, user User, amount int64, self bool) error {
+	limits, err := kycLimits(user.KYCLevel)
+	if err != nil {
+		return err
+	}
+	if limits.BalanceLimit == 0 || user.Balance+amount <= limits.BalanceLimit {
+		return nil
+	}
+
+	if self {
+		return rejected("your balance can not go above %d, verify your identity to raise the limit", limits.BalanceLimit)
+	}
+	return rejected("the target user can not receive this amount")
+}
@@ -0,0 +1,173 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LoginAttempt tracks the failed logins for a single key (a username from a client IP, or a client IP).
+// ExpireAt is a BSON date for the TTL index: the attempt is deleted once its failures are forgotten and its lock is over
+type LoginAttempt struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LastFailure int64     `json:"last_failure"`
+	LockedUntil int64     `json:"locked_until"`
+	ExpireAt    time.Time `json:"-"`
+}
+
+// LoginThrottledError is returned while a username or IP has to wait before trying again
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return "too many failed login attempts, please try again later"
+}
+
+// ErrInvalidCredentials is the only error a caller sees for a wrong username or password,
+// so the response never tells whether the username exists
+var ErrInvalidCredentials = errors.New("the username or password is incorrect")
+
+// LoginAttemptModel ...
+type LoginAttemptModel struct{}
+
+// UsernameAttemptKey ...
+func UsernameAttemptKey(username string) string {
+	return "username:" + strings.ToLower(username)
+}
+
+// IPAttemptKey ...
+func IPAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// LoginAttemptKey is the key of the failed logins of a username from one client IP. Failures from other IPs
+// don't count, so nobody can lock the owner of an account out by failing to log in as them
+func LoginAttemptKey(username string, ip string) string {
+	return UsernameAttemptKey(username) + "|" + IPAttemptKey(ip)
+}
+
+// EnsureIndexes creates the TTL index deleting the attempts that expired
+func (m LoginAttemptModel) EnsureIndexes(ctx context.Context) error {
+	attemptCollection := db.GetCollection(db.DB, "login_attempts")
+
+	_, err := attemptCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"expireat": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// loginLockoutDuration is both how long a key stays locked and how long failures are remembered
+func loginLockoutDuration() time.Duration {
+	return utils.GetEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+}
+
+// loginBackoff is the delay required after the given number of consecutive failures: base, 2*base, 4*base...
+func loginBackoff(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+
+	lockout := loginLockoutDuration()
+	backoff := utils.GetEnvDuration("LOGIN_BACKOFF_BASE", time.Second)
+	for i := 1; i < failures; i++ {
+		backoff *= 2
+		if backoff >= lockout {
+			return lockout
+		}
+	}
+	return backoff
+}
+
+// Check returns a *LoginThrottledError if any of the keys is locked or still inside its backoff delay
+func (m LoginAttemptModel) Check(ctx context.Context, keys ...string) error {
+	attemptCollection := db.GetCollection(db.DB, "login_attempts")
+	now := time.Now()
+
+	var retryAfter time.Duration
+	for _, key := range keys {
+		var attempt LoginAttempt
+		err := attemptCollection.FindOne(ctx, bson.M{"key": key}).Decode(&attempt)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if lockedUntil := time.Unix(attempt.LockedUntil, 0); now.Before(lockedUntil) {
+			if wait := lockedUntil.Sub(now); wait > retryAfter {
+				retryAfter = wait
+			}
+			continue
+		}
+
+		if nextAllowed := time.Unix(attempt.LastFailure, 0).Add(loginBackoff(attempt.Failures)); now.Before(nextAllowed) {
+			if wait := nextAllowed.Sub(now); wait > retryAfter {
+				retryAfter = wait
+			}
+		}
+	}
+
+	if retryAfter > 0 {
+		return &LoginThrottledError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// Fail records a failed login for the key and locks it once maxFailures is reached
+func (m LoginAttemptModel) Fail(ctx context.Context, key string, maxFailures int) error {
+	fmt.Println("LoginAttempt model: Fail")
+	attemptCollection := db.GetCollection(db.DB, "login_attempts")
+
+	now := time.Now()
+	lockout := loginLockoutDuration()
+
+	//Forget failures that are older than the lockout window
+	_, err := attemptCollection.UpdateOne(ctx, bson.M{
+		"key":         key,
+		"lastfailure": bson.M{"$lt": now.Add(-lockout).Unix()},
+		"lockeduntil": bson.M{"$lt": now.Unix()},
+	}, bson.M{"$set": bson.M{"failures": 0}})
+	if err != nil {
+		return err
+	}
+
+	//A lock ends at most lockout after the last failure, so the attempt can go once both are over
+	var attempt LoginAttempt
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err = attemptCollection.FindOneAndUpdate(ctx, bson.M{"key": key}, bson.M{
+		"$inc":         bson.M{"failures": 1},
+		"$set":         bson.M{"lastfailure": now.Unix(), "expireat": now.Add(lockout)},
+		"$setOnInsert": bson.M{"lockeduntil": int64(0)},
+	}, opts).Decode(&attempt)
+	if err != nil {
+		return err
+	}
+
+	if attempt.Failures >= maxFailures {
+		_, err = attemptCollection.UpdateOne(ctx, bson.M{"key": key}, bson.M{"$set": bson.M{
+			"failures":    0,
+			"lockeduntil": now.Add(lockout).Unix(),
+		}})
+	}
+
+	return err
+}
+
+// Reset clears the failures of the key after a successful login
+func (m LoginAttemptModel) Reset(ctx context.Context, key string) error {
+	attemptCollection := db.GetCollection(db.DB, "login_attempts")
+	_, err := attemptCollection.DeleteOne(ctx, bson.M{"key": key})
+	return err
+}
@@ -0,0 +1,39 @@
+//go:build all
+// +build all
+
+package models
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	os.Setenv("LOGIN_BACKOFF_BASE", "1s")
+	os.Setenv("LOGIN_LOCKOUT_DURATION", "15m")
+	defer os.Unsetenv("LOGIN_BACKOFF_BASE")
+	defer os.Unsetenv("LOGIN_LOCKOUT_DURATION")
+
+	tests := []struct {
+		failures int
+		backoff  time.Duration
+	}{
+		{-1, 0},
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{10, 512 * time.Second},
+		{11, 15 * time.Minute},
+		{1000, 15 * time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.backoff.String(), func(t *testing.T) {
+			assert.Equal(t, test.backoff, loginBackoff(test.failures))
+		})
+	}
+}
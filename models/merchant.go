@@ -0,0 +1,272 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Merchant is a business account owned by a user. It has its own balance, credited by paid invoices,
+// and appears in transactions under its Code, which never collides with a username
+type Merchant struct {
+	ID           primitive.ObjectID `json:"id"`
+	OwnerID      primitive.ObjectID `json:"-"`
+	Owner        string             `json:"owner"`
+	Name         string             `json:"name"`
+	Code         string             `json:"code"`
+	Balance      int64              `json:"balance"`
+	InvoiceCount int64              `json:"invoice_count"`
+	CreatedAt    int64              `json:"created_at"`
+	UpdatedAt    int64              `json:"updated_at"`
+}
+
+// MerchantSalesDay is one day of the dashboard of a merchant, Net is Gross minus Refunded
+type MerchantSalesDay struct {
+	Day      string `json:"day"`
+	Paid     int64  `json:"paid"`
+	Gross    int64  `json:"gross"`
+	Refunds  int64  `json:"refunds"`
+	Refunded int64  `json:"refunded"`
+	Net      int64  `json:"net"`
+}
+
+// ErrMerchantNotFound ...
+var ErrMerchantNotFound = errors.New("merchant not found")
+
+// MerchantModel ...
+type MerchantModel struct{}
+
+var merchantModel = new(MerchantModel)
+
+// Create ...
+func (m MerchantModel) Create(ctx context.Context, ownerID primitive.ObjectID, form forms.CreateMerchantForm) (merchant Merchant, err error) {
+	fmt.Println("Merchant model: Create")
+	userCollection := db.GetCollection(db.DB, "users")
+	merchantCollection := db.GetCollection(db.DB, "merchants")
+
+	var owner User
+	err = userCollection.FindOne(ctx, bson.M{"id": ownerID}).Decode(&owner)
+	if err != nil {
+		return merchant, errors.New("something went wrong, please try again later")
+	}
+
+	code, err := generateHexToken(5)
+	if err != nil {
+		return merchant, errors.New("something went wrong, please try again later")
+	}
+
+	now := time.Now().Unix()
+	merchant = Merchant{
+		ID:        primitive.NewObjectID(),
+		OwnerID:   owner.ID,
+		Owner:     owner.Username,
+		Name:      form.Name,
+		Code:      "M" + strings.ToUpper(code),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	_, err = merchantCollection.InsertOne(ctx, merchant)
+	if err != nil {
+		return merchant, errors.New("error when creating new merchant")
+	}
+
+	return merchant, nil
+}
+
+// List ...
+func (m MerchantModel) List(ctx context.Context, ownerID primitive.ObjectID) (merchants []Merchant, err error) {
+	fmt.Println("Merchant model: List")
+	merchantCollection := db.GetCollection(db.DB, "merchants")
+
+	results, err := merchantCollection.Find(ctx, bson.M{"ownerid": ownerID}, options.Find().SetSort(bson.M{"createdat": 1}))
+	if err != nil {
+		return merchants, errors.New("error when retrieving merchants")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var merchant Merchant
+		if err = results.Decode(&merchant); err != nil {
+			return merchants, errors.New("error when decoding merchant")
+		}
+
+		merchants = append(merchants, merchant)
+	}
+
+	return merchants, nil
+}
+
+// Find returns a merchant of the owner
+func (m MerchantModel) Find(ctx context.Context, ownerID primitive.ObjectID, id primitive.ObjectID) (merchant Merchant, err error) {
+	merchantCollection := db.GetCollection(db.DB, "merchants")
+
+	err = merchantCollection.FindOne(ctx, bson.M{"id": id, "ownerid": ownerID}).Decode(&merchant)
+	if err == mongo.ErrNoDocuments {
+		return merchant, ErrMerchantNotFound
+	}
+	if err != nil {
+		return merchant, errors.New("something went wrong, please try again later")
+	}
+
+	return merchant, nil
+}
+
+// Payout moves the whole balance of the merchant to the wallet of its owner
+func (m MerchantModel) Payout(ctx context.Context, ownerID primitive.ObjectID, id primitive.ObjectID) (transaction Transaction, err error) {
+	fmt.Println("Merchant model: Payout")
+	userCollection := db.GetCollection(db.DB, "users")
+	merchantCollection := db.GetCollection(db.DB, "merchants")
+
+	data, err := withTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		merchant, err := m.Find(sessionContext, ownerID, id)
+		if err != nil {
+			return transaction, err
+		}
+		if merchant.Balance <= 0 {
+			return transaction, errors.New("the merchant balance is empty")
+		}
+
+		var owner User
+		err = userCollection.FindOne(sessionContext, bson.M{"id": ownerID}).Decode(&owner)
+		if err != nil {
+			return transaction, errors.New("something went wrong, please try again later")
+		}
+
+		if err = kycModel.checkIncoming(sessionContext, owner, merchant.Balance, true); err != nil {
+			return transaction, err
+		}
+
+		now := time.Now().Unix()
+		_, err = merchantCollection.UpdateOne(sessionContext, bson.M{"id": merchant.ID}, bson.M{"$set": bson.M{"balance": int64(0), "updatedat": now}})
+		if err != nil {
+			return transaction, errors.New("internal server error")
+		}
+
+		_, err = userCollection.UpdateOne(sessionContext, bson.M{"id": owner.ID}, bson.M{"$set": bson.M{"balance": owner.Balance + merchant.Balance, "updatedat": now}})
+		if err != nil {
+			return transaction, errors.New("internal server error")
+		}
+
+		transaction, err := transactionModel.Create(sessionContext, forms.CreateTransactionForm{
+			From:      merchant.Code,
+			To:        owner.Username,
+			Amount:    merchant.Balance,
+			Balance:   owner.Balance + merchant.Balance,
+			Type:      utils.MERCHANT_PAYOUT,
+			Reference: "merchant:" + merchant.ID.Hex(),
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+		if err != nil {
+			return transaction, err
+		}
+
+		err = outboxModel.Add(sessionContext, utils.EVENT_MERCHANT_PAYOUT, []string{owner.Username}, transaction)
+
+		return transaction, err
+	})
+	transaction, _ = data.(Transaction)
+
+	return transaction, err
+}
+
+// Dashboard returns the sales of the merchant per day (UTC) between from and to, oldest first.
+// Sales are counted on the day the invoice was paid, refunds on the day they were made
+func (m MerchantModel) Dashboard(ctx context.Context, ownerID primitive.ObjectID, id primitive.ObjectID, from time.Time, to time.Time) (days []MerchantSalesDay, err error) {
+	fmt.Println("Merchant model: Dashboard")
+	invoiceCollection := db.GetCollection(db.DB, "invoices")
+
+	merchant, err := m.Find(ctx, ownerID, id)
+	if err != nil {
+		return days, err
+	}
+
+	totals := map[string]*MerchantSalesDay{}
+	day := func(key string) *MerchantSalesDay {
+		if totals[key] == nil {
+			totals[key] = &MerchantSalesDay{Day: key}
+		}
+		return totals[key]
+	}
+
+	//Sum the invoices per day of dateField
+	sumPerDay := func(dateField string) ([]bson.M, error) {
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{
+				"merchantid": merchant.ID,
+				dateField:    bson.M{"$gte": from.Unix(), "$lt": to.Unix()},
+			}}},
+			{{Key: "$group", Value: bson.M{
+				"_id": bson.M{"$dateToString": bson.M{
+					"format": "%Y-%m-%d",
+					"date":   bson.M{"$toDate": bson.M{"$multiply": []interface{}{"$" + dateField, 1000}}},
+				}},
+				"count": bson.M{"$sum": 1},
+				"total": bson.M{"$sum": "$total"},
+			}}},
+		}
+
+		results, err := invoiceCollection.Aggregate(ctx, pipeline)
+		if err != nil {
+			return nil, err
+		}
+
+		var rows []bson.M
+		err = results.All(ctx, &rows)
+		return rows, err
+	}
+
+	paid, err := sumPerDay("paidat")
+	if err != nil {
+		return days, errors.New("error when retrieving the sales")
+	}
+	for _, row := range paid {
+		sales := day(row["_id"].(string))
+		sales.Paid = toInt64(row["count"])
+		sales.Gross = toInt64(row["total"])
+	}
+
+	refunded, err := sumPerDay("refundedat")
+	if err != nil {
+		return days, errors.New("error when retrieving the sales")
+	}
+	for _, row := range refunded {
+		sales := day(row["_id"].(string))
+		sales.Refunds = toInt64(row["count"])
+		sales.Refunded = toInt64(row["total"])
+	}
+
+	for _, sales := range totals {
+		sales.Net = sales.Gross - sales.Refunded
+		days = append(days, *sales)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Day < days[j].Day })
+
+	return days, nil
+}
+
+// toInt64 reads a number from an aggregation result, Mongo returns int32 or int64 depending on its size
+func toInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,387 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/notifiers"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Notification is an entry of the delivery log: a notification of Type to the user on one Channel.
+// Its ID comes from what caused it, so an event handled twice notifies once. Data fills the template,
+// the email address or phone number is looked up when it is sent and never stored
+type Notification struct {
+	ID            string             `json:"id" bson:"_id"`
+	UserID        primitive.ObjectID `json:"-"`
+	Type          string             `json:"type"`
+	Channel       string             `json:"channel"`
+	Data          map[string]string  `json:"-"`
+	Status        string             `json:"status"`
+	Subject       string             `json:"subject,omitempty"`
+	Attempts      int                `json:"attempts"`
+	Error         string             `json:"error,omitempty"`
+	NextAttemptAt int64              `json:"-"`
+	SentAt        int64              `json:"sent_at,omitempty"`
+	CreatedAt     int64              `json:"created_at"`
+	UpdatedAt     int64              `json:"updated_at"`
+}
+
+// NotificationPreferences tells, by notification type then channel, whether the user gets it there
+type NotificationPreferences struct {
+	UserID    primitive.ObjectID         `json:"-" bson:"_id"`
+	Types     map[string]map[string]bool `json:"types"`
+	UpdatedAt int64                      `json:"updated_at,omitempty"`
+}
+
+// KnownDevice is a device the user signed in from, the first sign-in from any other one is notified
+type KnownDevice struct {
+	ID         string `bson:"_id"`
+	UserID     primitive.ObjectID
+	LastSeenAt int64
+	CreatedAt  int64
+}
+
+// NotificationModel ...
+type NotificationModel struct{}
+
+var notificationModel = new(NotificationModel)
+
+// defaultNotificationChannels are the preferences of the users who never changed them,
+// SMS are only sent for sign-ins from new devices
+var defaultNotificationChannels = map[string]map[string]bool{
+	utils.NOTIFICATION_TRANSFER_RECEIVED:    {utils.NOTIFICATION_EMAIL: true, utils.NOTIFICATION_SMS: false, utils.NOTIFICATION_PUSH: true},
+	utils.NOTIFICATION_WITHDRAWAL_COMPLETED: {utils.NOTIFICATION_EMAIL: true, utils.NOTIFICATION_SMS: false, utils.NOTIFICATION_PUSH: true},
+	utils.NOTIFICATION_NEW_DEVICE:           {utils.NOTIFICATION_EMAIL: true, utils.NOTIFICATION_SMS: true, utils.NOTIFICATION_PUSH: true},
+}
+
+// NotificationEvents are the outbox events turned into notifications
+var NotificationEvents = []string{utils.EVENT_TRANSFER, utils.EVENT_WITHDRAW_SETTLED}
+
+// notificationLease is how long a sender owns a claimed notification before another one may retry it
+const notificationLease = 60
+
+// notificationRetryDelay is the wait before the next attempt: 1m, 2m, 4m... capped at 1 hour
+func notificationRetryDelay(attempts int) time.Duration {
+	delay := time.Minute
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
+
+// preferences returns the preferences of the user, the defaults filling what they did not set
+func (m NotificationModel) preferences(ctx context.Context, userID primitive.ObjectID) (preferences NotificationPreferences, err error) {
+	preferenceCollection := db.GetCollection(db.DB, "notification_preferences")
+
+	err = preferenceCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&preferences)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return preferences, err
+	}
+
+	types := map[string]map[string]bool{}
+	for kind, channels := range defaultNotificationChannels {
+		types[kind] = map[string]bool{}
+		for channel, enabled := range channels {
+			if stored, ok := preferences.Types[kind][channel]; ok {
+				enabled = stored
+			}
+			types[kind][channel] = enabled
+		}
+	}
+
+	preferences.UserID = userID
+	preferences.Types = types
+	return preferences, nil
+}
+
+// Preferences ...
+func (m NotificationModel) Preferences(ctx context.Context, userID primitive.ObjectID) (NotificationPreferences, error) {
+	fmt.Println("Notification model: Preferences")
+
+	preferences, err := m.preferences(ctx, userID)
+	if err != nil {
+		return preferences, errors.New("error when retrieving notification preferences")
+	}
+	return preferences, nil
+}
+
+// UpdatePreferences turns on or off the channels of the form, the others keep their setting
+func (m NotificationModel) UpdatePreferences(ctx context.Context, userID primitive.ObjectID, form forms.UpdateNotificationPreferencesForm) (NotificationPreferences, error) {
+	fmt.Println("Notification model: UpdatePreferences")
+	preferenceCollection := db.GetCollection(db.DB, "notification_preferences")
+
+	set := bson.M{"updatedat": time.Now().Unix()}
+	for kind, channels := range form.Types {
+		for channel, enabled := range channels {
+			set["types."+kind+"."+channel] = enabled
+		}
+	}
+
+	_, err := preferenceCollection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": set}, options.Update().SetUpsert(true))
+	if err != nil {
+		return NotificationPreferences{}, errors.New("error when updating notification preferences")
+	}
+
+	return m.Preferences(ctx, userID)
+}
+
+// List returns the delivery log of the user, newest first, optionally only the notifications with status
+func (m NotificationModel) List(ctx context.Context, userID primitive.ObjectID, status string, query Query) (notifications []Notification, err error) {
+	fmt.Println("Notification model: List")
+	notificationCollection := db.GetCollection(db.DB, "notifications")
+
+	filter := bson.M{"userid": userID}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	opts := options.Find().SetSort(bson.M{"createdat": -1}).SetSkip(int64((query.Page - 1) * query.Limit)).SetLimit(int64(query.Limit))
+	results, err := notificationCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return notifications, errors.New("error when retrieving notifications")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var notification Notification
+		if err = results.Decode(&notification); err != nil {
+			return notifications, errors.New("error when decoding notification")
+		}
+
+		notifications = append(notifications, notification)
+	}
+
+	return notifications, nil
+}
+
+// enqueue adds a notification of kind for the user on each channel they want it on and can receive it on:
+// email and SMS need a verified address. source identifies what caused it, enqueuing it again changes nothing
+func (m NotificationModel) enqueue(ctx context.Context, user User, kind string, source string, data map[string]string) error {
+	notificationCollection := db.GetCollection(db.DB, "notifications")
+
+	preferences, err := m.preferences(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, channel := range utils.NOTIFICATION_CHANNELS {
+		if !preferences.Types[kind][channel] {
+			continue
+		}
+		if _, verified := notificationAddress(user, channel); !verified {
+			continue
+		}
+
+		_, err = notificationCollection.InsertOne(ctx, Notification{
+			ID:            source + ":" + user.ID.Hex() + ":" + channel,
+			UserID:        user.ID,
+			Type:          kind,
+			Channel:       channel,
+			Data:          data,
+			Status:        utils.NOTIFICATION_PENDING,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// notificationAddress returns where to reach the user on channel, and whether it can be used
+func notificationAddress(user User, channel string) (string, bool) {
+	switch channel {
+	case utils.NOTIFICATION_EMAIL:
+		return user.Email, user.Email != "" && user.EmailVerified
+	case utils.NOTIFICATION_SMS:
+		return user.Phone, user.Phone != "" && user.PhoneVerified
+	default:
+		return user.Username, true
+	}
+}
+
+// FromEvent enqueues the notifications of an outbox event: the recipient of a transfer,
+// the owner of a settled withdrawal. Users that no longer exist are not notified
+func (m NotificationModel) FromEvent(ctx context.Context, event OutboxEvent) error {
+	userCollection := db.GetCollection(db.DB, "users")
+
+	var kind, username string
+	var data map[string]string
+
+	switch event.Type {
+	case utils.EVENT_TRANSFER:
+		var transaction Transaction
+		if err := json.Unmarshal(event.Payload, &transaction); err != nil {
+			return err
+		}
+		if transaction.From == transaction.To {
+			return nil
+		}
+		kind, username = utils.NOTIFICATION_TRANSFER_RECEIVED, transaction.To
+		data = map[string]string{"amount": strconv.FormatInt(transaction.Amount, 10), "from": transaction.From, "memo": transaction.Memo}
+	case utils.EVENT_WITHDRAW_SETTLED:
+		var payout Payout
+		if err := json.Unmarshal(event.Payload, &payout); err != nil {
+			return err
+		}
+		if len(event.Usernames) == 0 {
+			return nil
+		}
+		kind, username = utils.NOTIFICATION_WITHDRAWAL_COMPLETED, event.Usernames[0]
+		data = map[string]string{"amount": strconv.FormatInt(payout.Amount, 10), "last4": payout.Last4}
+	default:
+		return nil
+	}
+
+	var user User
+	err := userCollection.FindOne(ctx, userBy("username", username)).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.ErasedAt != 0 {
+		return nil
+	}
+
+	return m.enqueue(ctx, user, kind, event.ID.Hex(), data)
+}
+
+// signedIn records the device of a successful sign-in and notifies the user the first time it is seen.
+// The very first device of the user is only recorded. Errors are logged, they must not fail the sign-in
+func (m NotificationModel) signedIn(ctx context.Context, user User, device SessionDevice) {
+	deviceCollection := db.GetCollection(db.DB, "known_devices")
+
+	now := time.Now().Unix()
+	result, err := deviceCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID.Hex() + ":" + hashToken(device.Name+"\n"+device.UserAgent)},
+		bson.M{"$set": bson.M{"lastseenat": now}, "$setOnInsert": bson.M{"userid": user.ID, "createdat": now}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		fmt.Println("Notification model: signedIn:", err)
+		return
+	}
+	if result.UpsertedCount == 0 {
+		return
+	}
+
+	known, err := deviceCollection.CountDocuments(ctx, bson.M{"userid": user.ID})
+	if err != nil || known < 2 {
+		return
+	}
+
+	name := device.Name
+	if name == "" {
+		name = device.UserAgent
+	}
+	err = m.enqueue(ctx, user, utils.NOTIFICATION_NEW_DEVICE, "device:"+result.UpsertedID.(string), map[string]string{
+		"device": name,
+		"ip":     device.IP,
+		"at":     strconv.FormatInt(now, 10),
+	})
+	if err != nil {
+		fmt.Println("Notification model: signedIn:", err)
+	}
+}
+
+// SendNext sends the oldest notification that is due, it returns mongo.ErrNoDocuments when there is none.
+// A failed attempt is retried later with exponential backoff, until NOTIFICATION_MAX_ATTEMPTS
+func (m NotificationModel) SendNext(ctx context.Context) error {
+	notificationCollection := db.GetCollection(db.DB, "notifications")
+	userCollection := db.GetCollection(db.DB, "users")
+
+	now := time.Now().Unix()
+	var notification Notification
+	err := notificationCollection.FindOneAndUpdate(ctx,
+		bson.M{"status": utils.NOTIFICATION_PENDING, "nextattemptat": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"nextattemptat": now + notificationLease}},
+		options.FindOneAndUpdate().SetSort(bson.M{"nextattemptat": 1}).SetReturnDocument(options.After)).Decode(&notification)
+	if err != nil {
+		return err
+	}
+
+	var user User
+	err = userCollection.FindOne(ctx, bson.M{"id": notification.UserID}).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	subject, sendErr := m.send(ctx, user, notification)
+
+	attempts := notification.Attempts + 1
+	update := bson.M{"attempts": attempts, "subject": subject, "error": "", "updatedat": time.Now().Unix()}
+	switch {
+	case sendErr == nil:
+		update["status"] = utils.NOTIFICATION_SENT
+		update["sentat"] = time.Now().Unix()
+		update["nextattemptat"] = int64(0)
+	case attempts >= utils.GetEnvInt("NOTIFICATION_MAX_ATTEMPTS", 5):
+		update["status"] = utils.NOTIFICATION_FAILED
+		update["error"] = sendErr.Error()
+		update["nextattemptat"] = int64(0)
+	default:
+		update["error"] = sendErr.Error()
+		update["nextattemptat"] = time.Now().Add(notificationRetryDelay(attempts)).Unix()
+	}
+
+	_, err = notificationCollection.UpdateOne(ctx, bson.M{"_id": notification.ID}, bson.M{"$set": update})
+	return err
+}
+
+// send renders the notification in the language of the user and hands it to the notifier of its channel
+func (m NotificationModel) send(ctx context.Context, user User, notification Notification) (subject string, err error) {
+	if user.ID.IsZero() || user.ErasedAt != 0 {
+		return "", errors.New("the user no longer exists")
+	}
+
+	to, ok := notificationAddress(user, notification.Channel)
+	if !ok {
+		return "", fmt.Errorf("no verified %s address", notification.Channel)
+	}
+
+	data := map[string]string{"name": user.Name}
+	if user.Name == "" {
+		data["name"] = user.Username
+	}
+	for key, value := range notification.Data {
+		data[key] = value
+	}
+	if at, err := strconv.ParseInt(data["at"], 10, 64); err == nil {
+		location, err := time.LoadLocation(user.Timezone)
+		if err != nil {
+			location = time.UTC
+		}
+		data["time"] = time.Unix(at, 0).In(location).Format("2006-01-02 15:04 MST")
+	}
+
+	subject, body, err := notifiers.Render(notification.Type, user.Locale, notification.Channel, data)
+	if err != nil {
+		return "", err
+	}
+
+	return subject, notifiers.GetNotifier().Send(ctx, notifiers.Message{
+		To:      to,
+		Channel: notification.Channel,
+		Subject: subject,
+		Body:    body,
+	})
+}
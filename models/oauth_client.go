@@ -0,0 +1,197 @@
+package models
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/keys"
+	"github.com/Massad/gin-boilerplate/utils"
+	jwt "github.com/golang-jwt/jwt/v4"
+	uuid "github.com/twinj/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OAuthClient is a service client allowed to use the client-credentials grant.
+// It acts on behalf of the user who registered it, limited to its scopes
+type OAuthClient struct {
+	ID         primitive.ObjectID `json:"id"`
+	ClientID   string             `json:"client_id"`
+	SecretHash string             `json:"-"`
+	UserID     primitive.ObjectID `json:"-"`
+	Name       string             `json:"name"`
+	Scopes     []string           `json:"scopes"`
+	CreatedAt  int64              `json:"created_at"`
+	LastUsedAt int64              `json:"last_used_at,omitempty"`
+	RevokedAt  int64              `json:"revoked_at,omitempty"`
+}
+
+// ClientToken is the client-credentials token response (RFC 6749 section 4.4.3)
+type ClientToken struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+// ErrOAuthClientNotFound ...
+var ErrOAuthClientNotFound = errors.New("oauth client not found")
+
+// ErrInvalidClient ...
+var ErrInvalidClient = errors.New("invalid_client")
+
+// ErrInvalidScope ...
+var ErrInvalidScope = errors.New("invalid_scope")
+
+// OAuthClientModel ...
+type OAuthClientModel struct{}
+
+// Create registers a client for the user, the plain secret is only returned here
+func (m OAuthClientModel) Create(ctx context.Context, userID primitive.ObjectID, form forms.CreateOAuthClientForm) (client OAuthClient, secret string, err error) {
+	fmt.Println("OAuthClient model: Create")
+	clientCollection := db.GetCollection(db.DB, "oauth_clients")
+
+	clientID, err := generateHexToken(12)
+	if err != nil {
+		return client, secret, errors.New("something went wrong, please try again later")
+	}
+	secret, err = generateToken(32)
+	if err != nil {
+		return client, "", errors.New("something went wrong, please try again later")
+	}
+
+	client = OAuthClient{
+		ID:         primitive.NewObjectID(),
+		ClientID:   "svc_" + clientID,
+		SecretHash: hashToken(secret),
+		UserID:     userID,
+		Name:       form.Name,
+		Scopes:     form.Scopes,
+		CreatedAt:  time.Now().Unix(),
+	}
+
+	_, err = clientCollection.InsertOne(ctx, client)
+	if err != nil {
+		return client, "", errors.New("error when creating new oauth client")
+	}
+
+	return client, secret, nil
+}
+
+// List ...
+func (m OAuthClientModel) List(ctx context.Context, userID primitive.ObjectID) (clients []OAuthClient, err error) {
+	fmt.Println("OAuthClient model: List")
+	clientCollection := db.GetCollection(db.DB, "oauth_clients")
+
+	opts := options.Find().SetSort(bson.M{"createdat": -1})
+	results, err := clientCollection.Find(ctx, bson.M{"userid": userID, "revokedat": int64(0)}, opts)
+	if err != nil {
+		return clients, errors.New("error when retrieving oauth clients")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var client OAuthClient
+		if err = results.Decode(&client); err != nil {
+			return clients, errors.New("error when decoding oauth client")
+		}
+
+		clients = append(clients, client)
+	}
+
+	return clients, nil
+}
+
+// Revoke disables the client, tokens already issued to it stop working immediately
+func (m OAuthClientModel) Revoke(ctx context.Context, userID primitive.ObjectID, id primitive.ObjectID) error {
+	fmt.Println("OAuthClient model: Revoke")
+	clientCollection := db.GetCollection(db.DB, "oauth_clients")
+
+	result, err := clientCollection.UpdateOne(ctx,
+		bson.M{"id": id, "userid": userID, "revokedat": int64(0)},
+		bson.M{"$set": bson.M{"revokedat": time.Now().Unix()}})
+	if err != nil {
+		return errors.New("internal server error")
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrOAuthClientNotFound
+	}
+
+	return nil
+}
+
+// Find returns the active client with the given client_id
+func (m OAuthClientModel) Find(ctx context.Context, clientID string) (client OAuthClient, err error) {
+	clientCollection := db.GetCollection(db.DB, "oauth_clients")
+
+	err = clientCollection.FindOne(ctx, bson.M{"clientid": clientID, "revokedat": int64(0)}).Decode(&client)
+	if err == mongo.ErrNoDocuments {
+		return client, ErrInvalidClient
+	}
+
+	return client, err
+}
+
+// IssueToken implements the client-credentials grant: it checks the secret and signs an access token
+// limited to the requested scopes (all of the client's scopes when none are requested)
+func (m OAuthClientModel) IssueToken(ctx context.Context, form forms.ClientCredentialsForm) (token ClientToken, err error) {
+	fmt.Println("OAuthClient model: IssueToken")
+	clientCollection := db.GetCollection(db.DB, "oauth_clients")
+
+	client, err := m.Find(ctx, form.ClientID)
+	if err != nil {
+		return token, ErrInvalidClient
+	}
+
+	if subtle.ConstantTimeCompare([]byte(client.SecretHash), []byte(hashToken(form.ClientSecret))) != 1 {
+		return token, ErrInvalidClient
+	}
+
+	scopes := client.Scopes
+	if form.Scope != "" {
+		scopes = strings.Fields(form.Scope)
+		for _, scope := range scopes {
+			if !utils.HasScope(client.Scopes, scope) {
+				return token, ErrInvalidScope
+			}
+		}
+	}
+
+	now := time.Now()
+	ttl := utils.GetEnvDuration("OAUTH_TOKEN_TTL", time.Hour)
+
+	claims := jwt.MapClaims{}
+	claims["access_uuid"] = uuid.NewV4().String()
+	claims["user_id"] = client.UserID.Hex()
+	claims["client_id"] = client.ClientID
+	claims["scope"] = strings.Join(scopes, " ")
+	claims["exp"] = now.Add(ttl).Unix()
+
+	signingKey, err := keys.GetKeyStore().Signer()
+	if err != nil {
+		return token, err
+	}
+	at := jwt.NewWithClaims(signingKey.Method(), claims)
+	at.Header["kid"] = signingKey.ID
+	token.AccessToken, err = at.SignedString(signingKey.Private)
+	if err != nil {
+		return token, err
+	}
+
+	clientCollection.UpdateOne(ctx, bson.M{"id": client.ID}, bson.M{"$set": bson.M{"lastusedat": now.Unix()}})
+
+	token.TokenType = "Bearer"
+	token.ExpiresIn = int64(ttl.Seconds())
+	token.Scope = strings.Join(scopes, " ")
+
+	return token, nil
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OutboxEvent is a wallet event written in the same Mongo transaction as the balance change it describes,
+// so an event exists if and only if the money moved. Usernames are the users the event concerns.
+// Webhooks and notifications consume the events independently, each with its own lease and mark
+type OutboxEvent struct {
+	ID                     primitive.ObjectID `json:"id"`
+	Type                   string             `json:"type"`
+	Usernames              []string           `json:"-"`
+	Payload                []byte             `json:"-"`
+	CreatedAt              int64              `json:"created_at"`
+	LeaseUntil             int64              `json:"-"`
+	DispatchedAt           int64              `json:"-"`
+	NotificationLeaseUntil int64              `json:"-"`
+	NotifiedAt             int64              `json:"-"`
+}
+
+// EventEnvelope is the JSON document sent to consumers of an event
+type EventEnvelope struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt int64           `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// OutboxModel ...
+type OutboxModel struct{}
+
+// outboxLease is how long a dispatcher owns a claimed event before another one may retry it
+const outboxLease = 60
+
+// EnsureIndexes creates the indexes Claim and ClaimNotification find the oldest event left to them with
+func (m OutboxModel) EnsureIndexes(ctx context.Context) error {
+	outboxCollection := db.GetCollection(db.DB, "outbox")
+
+	_, err := outboxCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "dispatchedat", Value: 1}, {Key: "createdat", Value: 1}}},
+		{Keys: bson.D{{Key: "notifiedat", Value: 1}, {Key: "createdat", Value: 1}}},
+	})
+	return err
+}
+
+// Add writes an event, ctx must be the session context of the transaction moving the money
+func (m OutboxModel) Add(ctx context.Context, eventType string, usernames []string, data interface{}) error {
+	outboxCollection := db.GetCollection(db.DB, "outbox")
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = outboxCollection.InsertOne(ctx, OutboxEvent{
+		ID:        primitive.NewObjectID(),
+		Type:      eventType,
+		Usernames: usernames,
+		Payload:   payload,
+		CreatedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		return errors.New("error when writing the event")
+	}
+
+	return nil
+}
+
+// Claim leases the oldest event not dispatched yet, it returns mongo.ErrNoDocuments when there is none
+func (m OutboxModel) Claim(ctx context.Context) (event OutboxEvent, err error) {
+	outboxCollection := db.GetCollection(db.DB, "outbox")
+
+	now := time.Now().Unix()
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"createdat": 1}).SetReturnDocument(options.After)
+	err = outboxCollection.FindOneAndUpdate(ctx,
+		bson.M{"dispatchedat": int64(0), "leaseuntil": bson.M{"$lt": now}},
+		bson.M{"$set": bson.M{"leaseuntil": now + outboxLease}},
+		opts).Decode(&event)
+
+	return event, err
+}
+
+// MarkDispatched ...
+func (m OutboxModel) MarkDispatched(ctx context.Context, eventID primitive.ObjectID) error {
+	outboxCollection := db.GetCollection(db.DB, "outbox")
+	_, err := outboxCollection.UpdateOne(ctx, bson.M{"id": eventID}, bson.M{"$set": bson.M{"dispatchedat": time.Now().Unix()}})
+	return err
+}
+
+// ClaimNotification leases the oldest event of one of eventTypes not turned into notifications yet,
+// it returns mongo.ErrNoDocuments when there is none. Events written before notifications existed are skipped
+func (m OutboxModel) ClaimNotification(ctx context.Context, eventTypes []string) (event OutboxEvent, err error) {
+	outboxCollection := db.GetCollection(db.DB, "outbox")
+
+	now := time.Now().Unix()
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"createdat": 1}).SetReturnDocument(options.After)
+	err = outboxCollection.FindOneAndUpdate(ctx,
+		bson.M{"type": bson.M{"$in": eventTypes}, "notifiedat": int64(0), "notificationleaseuntil": bson.M{"$lt": now}},
+		bson.M{"$set": bson.M{"notificationleaseuntil": now + outboxLease}},
+		opts).Decode(&event)
+
+	return event, err
+}
+
+// MarkNotified ...
+func (m OutboxModel) MarkNotified(ctx context.Context, eventID primitive.ObjectID) error {
+	outboxCollection := db.GetCollection(db.DB, "outbox")
+	_, err := outboxCollection.UpdateOne(ctx, bson.M{"id": eventID}, bson.M{"$set": bson.M{"notifiedat": time.Now().Unix()}})
+	return err
+}
+
+// Envelope returns the JSON body consumers receive for the event
+func (e OutboxEvent) Envelope() ([]byte, error) {
+	return json.Marshal(EventEnvelope{
+		ID:        e.ID.Hex(),
+		Type:      e.Type,
+		CreatedAt: e.CreatedAt,
+		Data:      json.RawMessage(e.Payload),
+	})
+}
+
+// IsNoEvent reports whether Claim found nothing to do
+func IsNoEvent(err error) bool {
+	return err == mongo.ErrNoDocuments
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/notifiers"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordReset is a pending reset, only the SHA-256 of the token is stored
+type PasswordReset struct {
+	ID        primitive.ObjectID `json:"id"`
+	UserID    primitive.ObjectID `json:"user_id"`
+	TokenHash string             `json:"-"`
+	ExpiresAt int64              `json:"expires_at"`
+	UsedAt    int64              `json:"used_at"`
+	CreatedAt int64              `json:"created_at"`
+}
+
+// ErrInvalidResetToken ...
+var ErrInvalidResetToken = errors.New("the reset token is invalid or has expired")
+
+// PasswordResetModel ...
+type PasswordResetModel struct{}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateToken returns a random URL-safe token of n bytes of entropy
+func generateToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// generateHexToken is generateToken for places where the token must only contain [0-9a-f]
+func generateHexToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Request issues a reset token for the username and sends it through the notifier.
+// Unknown usernames are silently ignored so the caller can't probe which accounts exist
+func (m PasswordResetModel) Request(ctx context.Context, form forms.ForgotPasswordForm) error {
+	fmt.Println("PasswordReset model: Request")
+	userCollection := db.GetCollection(db.DB, "users")
+	resetCollection := db.GetCollection(db.DB, "password_resets")
+
+	var user User
+	err := userCollection.FindOne(ctx, userBy("username", form.Username)).Decode(&user)
+	if err == mongo.ErrNoDocuments || (err == nil && user.ErasedAt != 0) {
+		return nil
+	}
+	if err != nil {
+		return errors.New("something went wrong, please try again later")
+	}
+
+	token, err := generateToken(32)
+	if err != nil {
+		return errors.New("something went wrong, please try again later")
+	}
+
+	//Only the latest token of a user stays valid
+	_, err = resetCollection.DeleteMany(ctx, bson.M{"userid": user.ID, "usedat": int64(0)})
+	if err != nil {
+		return errors.New("something went wrong, please try again later")
+	}
+
+	ttl := utils.GetEnvDuration("PASSWORD_RESET_TTL", 30*time.Minute)
+	now := time.Now()
+	_, err = resetCollection.InsertOne(ctx, PasswordReset{
+		ID:        primitive.NewObjectID(),
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(ttl).Unix(),
+		CreatedAt: now.Unix(),
+	})
+	if err != nil {
+		return errors.New("something went wrong, please try again later")
+	}
+
+	return notifiers.GetNotifier().Send(ctx, notifiers.Message{
+		To:      user.Username,
+		Subject: "Reset your password",
+		Body:    fmt.Sprintf("Use this token to reset your password: %s\nIt expires in %s. If you did not ask for a reset you can ignore this message.", token, ttl),
+	})
+}
+
+// Reset consumes the token, sets the new password and logs the user out everywhere
+func (m PasswordResetModel) Reset(ctx context.Context, form forms.ResetPasswordForm) error {
+	fmt.Println("PasswordReset model: Reset")
+	userCollection := db.GetCollection(db.DB, "users")
+	resetCollection := db.GetCollection(db.DB, "password_resets")
+
+	now := time.Now().Unix()
+
+	//Marking the token used in the same query that finds it makes it single-use
+	var reset PasswordReset
+	err := resetCollection.FindOneAndUpdate(ctx, bson.M{
+		"tokenhash": hashToken(form.Token),
+		"usedat":    int64(0),
+		"expiresat": bson.M{"$gt": now},
+	}, bson.M{"$set": bson.M{"usedat": now}}).Decode(&reset)
+	if err == mongo.ErrNoDocuments {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return errors.New("something went wrong, please try again later")
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(form.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.New("something went wrong, please try again later")
+	}
+
+	_, err = userCollection.UpdateOne(ctx, bson.M{"id": reset.UserID}, bson.M{"$set": bson.M{"password": string(hashedPassword), "updatedat": now}})
+	if err != nil {
+		return errors.New("internal server error")
+	}
+
+	return authModel.RevokeAuth(ctx, reset.UserID, "")
+}
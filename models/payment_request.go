@@ -0,0 +1,241 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PaymentRequestEvent is one step of the lifecycle of a request, By is "requester", "payer" or "system"
+type PaymentRequestEvent struct {
+	Status string `json:"status"`
+	By     string `json:"by"`
+	At     int64  `json:"at"`
+}
+
+// PaymentRequest asks Payer to send Amount to Requester.
+// Once accepted, TransactionID is the transfer that settled it
+type PaymentRequest struct {
+	ID            primitive.ObjectID    `json:"id"`
+	RequesterID   primitive.ObjectID    `json:"-"`
+	Requester     string                `json:"requester"`
+	PayerID       primitive.ObjectID    `json:"-"`
+	Payer         string                `json:"payer"`
+	Amount        int64                 `json:"amount"`
+	Memo          string                `json:"memo,omitempty"`
+	Status        string                `json:"status"`
+	TransactionID primitive.ObjectID    `json:"transaction_id,omitempty"`
+	BillID        primitive.ObjectID    `json:"bill_id,omitempty"`
+	ExpiresAt     int64                 `json:"expires_at"`
+	History       []PaymentRequestEvent `json:"history"`
+	CreatedAt     int64                 `json:"created_at"`
+	UpdatedAt     int64                 `json:"updated_at"`
+}
+
+// ErrPaymentRequestNotFound ...
+var ErrPaymentRequestNotFound = errors.New("payment request not found")
+
+// PaymentRequestModel ...
+type PaymentRequestModel struct{}
+
+// Create ...
+func (m PaymentRequestModel) Create(ctx context.Context, requesterID primitive.ObjectID, form forms.CreatePaymentRequestForm) (request PaymentRequest, err error) {
+	fmt.Println("PaymentRequest model: Create")
+	userCollection := db.GetCollection(db.DB, "users")
+
+	var requester User
+	err = userCollection.FindOne(ctx, bson.M{"id": requesterID}).Decode(&requester)
+	if err != nil {
+		return request, errors.New("something went wrong, please try again later")
+	}
+
+	ttl := utils.GetEnvDuration("PAYMENT_REQUEST_TTL", 7*24*time.Hour)
+	if form.ExpiresInHours > 0 {
+		ttl = time.Duration(form.ExpiresInHours) * time.Hour
+	}
+
+	return m.create(ctx, requester, form.To, form.Amount, form.Memo, time.Now().Add(ttl).Unix(), primitive.NilObjectID)
+}
+
+// create is shared with the features issuing requests on behalf of a user, billID links the shares of a split bill
+func (m PaymentRequestModel) create(ctx context.Context, requester User, payerUsername string, amount int64, memo string, expiresAt int64, billID primitive.ObjectID) (request PaymentRequest, err error) {
+	userCollection := db.GetCollection(db.DB, "users")
+	requestCollection := db.GetCollection(db.DB, "payment_requests")
+
+	var payer User
+	err = userCollection.FindOne(ctx, userBy("username", payerUsername)).Decode(&payer)
+	if err == mongo.ErrNoDocuments || (err == nil && payer.ErasedAt != 0) {
+		return request, errors.New("target user not existed")
+	}
+	if err != nil {
+		return request, errors.New("something went wrong, please try again later")
+	}
+
+	if payer.ID == requester.ID {
+		return request, errors.New("you can not request money from yourself")
+	}
+
+	now := time.Now().Unix()
+	request = PaymentRequest{
+		ID:          primitive.NewObjectID(),
+		RequesterID: requester.ID,
+		Requester:   requester.Username,
+		PayerID:     payer.ID,
+		Payer:       payer.Username,
+		Amount:      amount,
+		Memo:        memo,
+		Status:      utils.REQUEST_PENDING,
+		ExpiresAt:   expiresAt,
+		BillID:      billID,
+		History:     []PaymentRequestEvent{{Status: utils.REQUEST_PENDING, By: "requester", At: now}},
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	_, err = requestCollection.InsertOne(ctx, request)
+	if err != nil {
+		return request, errors.New("error when creating new payment request")
+	}
+
+	return request, nil
+}
+
+// expire moves the pending requests past their expiry to EXPIRED
+func (m PaymentRequestModel) expire(ctx context.Context) error {
+	requestCollection := db.GetCollection(db.DB, "payment_requests")
+
+	now := time.Now().Unix()
+	_, err := requestCollection.UpdateMany(ctx,
+		bson.M{"status": utils.REQUEST_PENDING, "expiresat": bson.M{"$lte": now}},
+		bson.M{
+			"$set":  bson.M{"status": utils.REQUEST_EXPIRED, "updatedat": now},
+			"$push": bson.M{"history": PaymentRequestEvent{Status: utils.REQUEST_EXPIRED, By: "system", At: now}},
+		})
+	return err
+}
+
+// List returns the requests of the user, "incoming" (to pay), "outgoing" (sent) or both when role is empty
+func (m PaymentRequestModel) List(ctx context.Context, userID primitive.ObjectID, role string, status string) (requests []PaymentRequest, err error) {
+	fmt.Println("PaymentRequest model: List")
+	requestCollection := db.GetCollection(db.DB, "payment_requests")
+
+	if err = m.expire(ctx); err != nil {
+		return requests, errors.New("error when retrieving payment requests")
+	}
+
+	var filter bson.M
+	switch role {
+	case "incoming":
+		filter = bson.M{"payerid": userID}
+	case "outgoing":
+		filter = bson.M{"requesterid": userID}
+	default:
+		filter = bson.M{"$or": []bson.M{{"payerid": userID}, {"requesterid": userID}}}
+	}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	opts := options.Find().SetSort(bson.M{"createdat": -1})
+	results, err := requestCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return requests, errors.New("error when retrieving payment requests")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var request PaymentRequest
+		if err = results.Decode(&request); err != nil {
+			return requests, errors.New("error when decoding payment request")
+		}
+
+		requests = append(requests, request)
+	}
+
+	return requests, nil
+}
+
+// transition moves a pending request owned by the user (as payer or requester, per ownerField) to status
+func (m PaymentRequestModel) transition(ctx context.Context, id primitive.ObjectID, ownerField string, ownerID primitive.ObjectID, by string, status string) (request PaymentRequest, err error) {
+	requestCollection := db.GetCollection(db.DB, "payment_requests")
+
+	now := time.Now().Unix()
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = requestCollection.FindOneAndUpdate(ctx,
+		bson.M{"id": id, ownerField: ownerID, "status": utils.REQUEST_PENDING, "expiresat": bson.M{"$gt": now}},
+		bson.M{
+			"$set":  bson.M{"status": status, "updatedat": now},
+			"$push": bson.M{"history": PaymentRequestEvent{Status: status, By: by, At: now}},
+		}, opts).Decode(&request)
+	if err != mongo.ErrNoDocuments {
+		return request, err
+	}
+
+	//Tell why the request can't move
+	err = requestCollection.FindOne(ctx, bson.M{"id": id, ownerField: ownerID}).Decode(&request)
+	if err == mongo.ErrNoDocuments {
+		return request, ErrPaymentRequestNotFound
+	}
+	if err != nil {
+		return request, err
+	}
+	if request.Status == utils.REQUEST_PENDING {
+		return request, errors.New("the payment request has expired")
+	}
+	return request, fmt.Errorf("the payment request is already %s", request.Status)
+}
+
+// Accept pays the request: the status change and the transfer happen in one Mongo transaction,
+// so a failed transfer (e.g. not enough balance) leaves the request pending
+func (m PaymentRequestModel) Accept(ctx context.Context, payerID primitive.ObjectID, id primitive.ObjectID) (request PaymentRequest, err error) {
+	fmt.Println("PaymentRequest model: Accept")
+	requestCollection := db.GetCollection(db.DB, "payment_requests")
+
+	data, err := withTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		request, err := m.transition(sessionContext, id, "payerid", payerID, "payer", utils.REQUEST_ACCEPTED)
+		if err != nil {
+			return request, err
+		}
+
+		transaction, err := userModel.transfer(sessionContext, payerID, request.Requester, request.Amount, "payment_request:"+request.ID.Hex(), request.Memo, "")
+		if err != nil {
+			return request, err
+		}
+
+		request.TransactionID = transaction.ID
+		_, err = requestCollection.UpdateOne(sessionContext, bson.M{"id": request.ID}, bson.M{"$set": bson.M{"transactionid": transaction.ID}})
+		if err != nil {
+			return request, err
+		}
+
+		if !request.BillID.IsZero() {
+			err = billModel.markPaid(sessionContext, request)
+		}
+
+		return request, err
+	})
+	request, _ = data.(PaymentRequest)
+
+	return request, err
+}
+
+// Decline ...
+func (m PaymentRequestModel) Decline(ctx context.Context, payerID primitive.ObjectID, id primitive.ObjectID) (request PaymentRequest, err error) {
+	fmt.Println("PaymentRequest model: Decline")
+	return m.transition(ctx, id, "payerid", payerID, "payer", utils.REQUEST_DECLINED)
+}
+
+// Cancel ...
+func (m PaymentRequestModel) Cancel(ctx context.Context, requesterID primitive.ObjectID, id primitive.ObjectID) (request PaymentRequest, err error) {
+	fmt.Println("PaymentRequest model: Cancel")
+	return m.transition(ctx, id, "requesterid", requesterID, "requester", utils.REQUEST_CANCELLED)
+}
@@ -0,0 +1,321 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/payments"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PayoutEvent is a status change of a payout
+type PayoutEvent struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+	At     int64  `json:"at"`
+}
+
+// Payout is a withdrawal on its way to a bank account. The amount leaves the balance when the payout is created
+// and is held until the payout settles, it comes back if the payout fails or is returned.
+// Statuses: PENDING -> SUBMITTED -> SETTLED -> RETURNED, or PENDING or SUBMITTED -> FAILED
+type Payout struct {
+	ID                    primitive.ObjectID `json:"id"`
+	UserID                primitive.ObjectID `json:"-"`
+	Amount                int64              `json:"amount"`
+	PocketID              string             `json:"pocket_id,omitempty"`
+	BankAccountID         primitive.ObjectID `json:"bank_account_id"`
+	HolderName            string             `json:"holder_name"`
+	BankCode              string             `json:"bank_code"`
+	AccountNumber         string             `json:"-"`
+	Last4                 string             `json:"account_number_last4"`
+	Status                string             `json:"status"`
+	Reason                string             `json:"reason,omitempty"`
+	Provider              string             `json:"provider"`
+	ProviderReference     string             `json:"provider_reference,omitempty"`
+	TransactionID         primitive.ObjectID `json:"transaction_id"`
+	ReversalTransactionID primitive.ObjectID `json:"reversal_transaction_id,omitempty"`
+	History               []PayoutEvent      `json:"history"`
+	LeaseUntil            int64              `json:"-"`
+	NextPollAt            int64              `json:"-"`
+	SubmittedAt           int64              `json:"submitted_at,omitempty"`
+	SettledAt             int64              `json:"settled_at,omitempty"`
+	CreatedAt             int64              `json:"created_at"`
+	UpdatedAt             int64              `json:"updated_at"`
+}
+
+// ErrPayoutNotFound ...
+var ErrPayoutNotFound = errors.New("payout not found")
+
+// PayoutModel ...
+type PayoutModel struct{}
+
+var payoutModel = new(PayoutModel)
+
+// payoutTransitions lists the statuses a payout can move to from each status, anything else is ignored
+// so updates arriving late or twice change nothing
+var payoutTransitions = map[string][]string{
+	utils.PAYOUT_PENDING:   {utils.PAYOUT_SUBMITTED, utils.PAYOUT_FAILED},
+	utils.PAYOUT_SUBMITTED: {utils.PAYOUT_SETTLED, utils.PAYOUT_FAILED},
+	utils.PAYOUT_SETTLED:   {utils.PAYOUT_RETURNED},
+}
+
+// create records the payout of a withdrawal, ctx must be the session context of the transaction taking the money
+func (m PayoutModel) create(ctx context.Context, id primitive.ObjectID, userID primitive.ObjectID, account BankAccount, amount int64, pocketID string, transactionID primitive.ObjectID) (payout Payout, err error) {
+	payoutCollection := db.GetCollection(db.DB, "payouts")
+
+	now := time.Now().Unix()
+	payout = Payout{
+		ID:            id,
+		UserID:        userID,
+		Amount:        amount,
+		PocketID:      pocketID,
+		BankAccountID: account.ID,
+		HolderName:    account.HolderName,
+		BankCode:      account.BankCode,
+		AccountNumber: account.AccountNumber,
+		Last4:         account.Last4,
+		Status:        utils.PAYOUT_PENDING,
+		Provider:      payments.GetPayoutProvider().Name(),
+		TransactionID: transactionID,
+		History:       []PayoutEvent{{Status: utils.PAYOUT_PENDING, At: now}},
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	_, err = payoutCollection.InsertOne(ctx, payout)
+	if err != nil {
+		return payout, errors.New("error when creating new payout")
+	}
+
+	return payout, nil
+}
+
+// List returns the payouts of the user, newest first
+func (m PayoutModel) List(ctx context.Context, userID primitive.ObjectID, query Query) (payouts []Payout, err error) {
+	fmt.Println("Payout model: List")
+	payoutCollection := db.GetCollection(db.DB, "payouts")
+
+	opts := options.Find().SetSort(bson.M{"createdat": -1}).SetSkip(int64((query.Page - 1) * query.Limit)).SetLimit(int64(query.Limit))
+	results, err := payoutCollection.Find(ctx, bson.M{"userid": userID}, opts)
+	if err != nil {
+		return payouts, errors.New("error when retrieving payouts")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var payout Payout
+		if err = results.Decode(&payout); err != nil {
+			return payouts, errors.New("error when decoding payout")
+		}
+
+		payouts = append(payouts, payout)
+	}
+
+	return payouts, nil
+}
+
+// Find ...
+func (m PayoutModel) Find(ctx context.Context, userID primitive.ObjectID, id primitive.ObjectID) (payout Payout, err error) {
+	payoutCollection := db.GetCollection(db.DB, "payouts")
+
+	err = payoutCollection.FindOne(ctx, bson.M{"id": id, "userid": userID}).Decode(&payout)
+	if err == mongo.ErrNoDocuments {
+		return payout, ErrPayoutNotFound
+	}
+	if err != nil {
+		return payout, errors.New("something went wrong, please try again later")
+	}
+
+	return payout, nil
+}
+
+// SubmitNext sends one PENDING payout to the provider. A payout the provider rejects fails and its amount is given
+// back, any other failed submission is retried after the lease expires, the payout ID is the reference the provider
+// deduplicates on.
+// It returns mongo.ErrNoDocuments when there is nothing to submit, see IsNoEvent
+func (m PayoutModel) SubmitNext(ctx context.Context) error {
+	payoutCollection := db.GetCollection(db.DB, "payouts")
+
+	now := time.Now()
+	var payout Payout
+	err := payoutCollection.FindOneAndUpdate(ctx,
+		bson.M{"status": utils.PAYOUT_PENDING, "leaseuntil": bson.M{"$lte": now.Unix()}},
+		bson.M{"$set": bson.M{"leaseuntil": now.Add(utils.GetEnvDuration("PAYOUT_RETRY_INTERVAL", time.Minute)).Unix()}},
+		options.FindOneAndUpdate().SetSort(bson.M{"createdat": 1})).Decode(&payout)
+	if err != nil {
+		return err
+	}
+
+	reference, err := payments.GetPayoutProvider().SubmitPayout(ctx, payments.PayoutOrder{
+		Reference:     payout.ID.Hex(),
+		Amount:        payout.Amount,
+		HolderName:    payout.HolderName,
+		BankCode:      payout.BankCode,
+		AccountNumber: payout.AccountNumber,
+	})
+	var rejected *payments.PayoutRejectedError
+	if errors.As(err, &rejected) {
+		return m.apply(ctx, payout.ID, payments.PayoutUpdate{Status: utils.PAYOUT_FAILED, Reason: rejected.Reason})
+	}
+	if err != nil {
+		fmt.Println("Payout model: submit failed:", err)
+		return nil
+	}
+
+	return m.apply(ctx, payout.ID, payments.PayoutUpdate{ID: reference, Status: utils.PAYOUT_SUBMITTED})
+}
+
+// PollNext asks the provider for the status of one payout in flight. Settled payouts are polled until
+// PAYOUT_RETURN_WINDOW is over, in case they are returned.
+// It returns mongo.ErrNoDocuments when no payout is due, see IsNoEvent
+func (m PayoutModel) PollNext(ctx context.Context) error {
+	payoutCollection := db.GetCollection(db.DB, "payouts")
+
+	now := time.Now()
+	var payout Payout
+	err := payoutCollection.FindOneAndUpdate(ctx,
+		bson.M{"status": bson.M{"$in": bson.A{utils.PAYOUT_SUBMITTED, utils.PAYOUT_SETTLED}}, "nextpollat": bson.M{"$gt": 0, "$lte": now.Unix()}},
+		bson.M{"$set": bson.M{"nextpollat": now.Add(utils.GetEnvDuration("PAYOUT_POLL_INTERVAL", time.Minute)).Unix()}},
+		options.FindOneAndUpdate().SetSort(bson.M{"nextpollat": 1})).Decode(&payout)
+	if err != nil {
+		return err
+	}
+
+	if payout.Status == utils.PAYOUT_SETTLED && now.Sub(time.Unix(payout.SettledAt, 0)) > utils.GetEnvDuration("PAYOUT_RETURN_WINDOW", 72*time.Hour) {
+		_, err = payoutCollection.UpdateOne(ctx, bson.M{"id": payout.ID}, bson.M{"$set": bson.M{"nextpollat": 0}})
+		return err
+	}
+
+	update, err := payments.GetPayoutProvider().PayoutStatus(ctx, payout.ProviderReference)
+	if err != nil {
+		fmt.Println("Payout model: poll failed:", err)
+		return nil
+	}
+
+	return m.apply(ctx, payout.ID, update)
+}
+
+// Callback applies a verified status update pushed by the provider
+func (m PayoutModel) Callback(ctx context.Context, update payments.PayoutUpdate) error {
+	fmt.Println("Payout model: Callback")
+	payoutCollection := db.GetCollection(db.DB, "payouts")
+
+	var payout Payout
+	err := payoutCollection.FindOne(ctx, bson.M{"provider": payments.GetPayoutProvider().Name(), "providerreference": update.ID}).Decode(&payout)
+	if err == mongo.ErrNoDocuments {
+		return ErrPayoutNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return m.apply(ctx, payout.ID, update)
+}
+
+// apply moves a payout to the status of update in one Mongo transaction, when payoutTransitions allows it.
+// A FAILED or RETURNED payout gives its amount back to the pocket it came from, or to the main balance
+// when it came from there or the pocket is gone. The balance limit does not apply: the money was the user's
+// before the withdrawal, and a refused reversal would leave the payout stuck with the money gone
+func (m PayoutModel) apply(ctx context.Context, id primitive.ObjectID, update payments.PayoutUpdate) error {
+	userCollection := db.GetCollection(db.DB, "users")
+	pocketCollection := db.GetCollection(db.DB, "pockets")
+	payoutCollection := db.GetCollection(db.DB, "payouts")
+
+	_, err := withTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		var payout Payout
+		err := payoutCollection.FindOne(sessionContext, bson.M{"id": id}).Decode(&payout)
+		if err != nil {
+			return nil, err
+		}
+		if !utils.Contains(payoutTransitions[payout.Status], update.Status) {
+			return nil, nil
+		}
+
+		now := time.Now().Unix()
+		set := bson.M{"status": update.Status, "reason": update.Reason, "updatedat": now}
+		switch update.Status {
+		case utils.PAYOUT_SUBMITTED:
+			set["providerreference"] = update.ID
+			set["submittedat"] = now
+			set["nextpollat"] = now
+		case utils.PAYOUT_SETTLED:
+			set["settledat"] = now
+
+			var user User
+			if err = userCollection.FindOne(sessionContext, bson.M{"id": payout.UserID}).Decode(&user); err != nil {
+				return nil, err
+			}
+
+			//The holder name stays out of the event, events outlive the erasure of the account
+			settled := payout
+			settled.Status, settled.SettledAt, settled.HolderName = update.Status, now, ""
+			err = outboxModel.Add(sessionContext, utils.EVENT_WITHDRAW_SETTLED, []string{user.Username}, settled)
+			if err != nil {
+				return nil, err
+			}
+		case utils.PAYOUT_FAILED, utils.PAYOUT_RETURNED:
+			set["nextpollat"] = 0
+
+			var user User
+			if err = userCollection.FindOne(sessionContext, bson.M{"id": payout.UserID}).Decode(&user); err != nil {
+				return nil, err
+			}
+
+			var pocket Pocket
+			if payout.PocketID != "" {
+				pocket, err = pocketModel.Find(sessionContext, user.ID, payout.PocketID)
+				if err != nil && err != ErrPocketNotFound {
+					return nil, err
+				}
+			}
+
+			form := forms.CreateTransactionForm{
+				From:      user.Username,
+				To:        user.Username,
+				Amount:    payout.Amount,
+				Type:      utils.WITHDRAW_REVERSAL,
+				Reference: "payout:" + payout.ID.Hex(),
+				CreatedAt: now,
+				UpdatedAt: now,
+			}
+			if !pocket.ID.IsZero() {
+				form.Balance = pocket.Balance + payout.Amount
+				form.PocketID = pocket.ID.Hex()
+				_, err = pocketCollection.UpdateOne(sessionContext, bson.M{"id": pocket.ID}, bson.M{"$set": bson.M{"balance": form.Balance, "updatedat": now}})
+			} else {
+				form.Balance = user.Balance + payout.Amount
+				_, err = userCollection.UpdateOne(sessionContext, bson.M{"id": user.ID}, bson.M{"$set": bson.M{"balance": form.Balance, "updatedat": now}})
+			}
+			if err != nil {
+				return nil, errors.New("internal server error")
+			}
+
+			transaction, err := transactionModel.Create(sessionContext, form)
+			if err != nil {
+				return nil, err
+			}
+			set["reversaltransactionid"] = transaction.ID
+
+			err = outboxModel.Add(sessionContext, utils.EVENT_WITHDRAW_REVERSED, []string{user.Username}, transaction)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		_, err = payoutCollection.UpdateOne(sessionContext, bson.M{"id": payout.ID}, bson.M{
+			"$set":  set,
+			"$push": bson.M{"history": PayoutEvent{Status: update.Status, Reason: update.Reason, At: now}},
+		})
+		return nil, err
+	})
+
+	return err
+}
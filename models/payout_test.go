@@ -0,0 +1,139 @@
+//go:build all
+// +build all
+
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/payments"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newTestPayout records a payout of the user in the status, the amount already left their balance
+func newTestPayout(t *testing.T, user User, status string, amount int64, pocketID string) Payout {
+	t.Helper()
+
+	now := time.Now().Unix()
+	payout := Payout{
+		ID:            primitive.NewObjectID(),
+		UserID:        user.ID,
+		Amount:        amount,
+		PocketID:      pocketID,
+		HolderName:    "Test User",
+		BankCode:      "TEST",
+		AccountNumber: "000123456789",
+		Last4:         "6789",
+		Status:        status,
+		Provider:      "test",
+		History:       []PayoutEvent{{Status: status, At: now}},
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	_, err := db.GetCollection(db.DB, "payouts").InsertOne(context.Background(), payout)
+	require.NoError(t, err)
+
+	return payout
+}
+
+func TestPayoutTransitions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		from     string
+		to       string
+		status   string
+		reversed bool
+	}{
+		{utils.PAYOUT_PENDING, utils.PAYOUT_SUBMITTED, utils.PAYOUT_SUBMITTED, false},
+		{utils.PAYOUT_PENDING, utils.PAYOUT_FAILED, utils.PAYOUT_FAILED, true},
+		{utils.PAYOUT_PENDING, utils.PAYOUT_SETTLED, utils.PAYOUT_PENDING, false},
+		{utils.PAYOUT_SUBMITTED, utils.PAYOUT_SETTLED, utils.PAYOUT_SETTLED, false},
+		{utils.PAYOUT_SUBMITTED, utils.PAYOUT_FAILED, utils.PAYOUT_FAILED, true},
+		{utils.PAYOUT_SUBMITTED, utils.PAYOUT_RETURNED, utils.PAYOUT_SUBMITTED, false},
+		{utils.PAYOUT_SETTLED, utils.PAYOUT_RETURNED, utils.PAYOUT_RETURNED, true},
+		{utils.PAYOUT_SETTLED, utils.PAYOUT_FAILED, utils.PAYOUT_SETTLED, false},
+		{utils.PAYOUT_FAILED, utils.PAYOUT_SUBMITTED, utils.PAYOUT_FAILED, false},
+		{utils.PAYOUT_RETURNED, utils.PAYOUT_RETURNED, utils.PAYOUT_RETURNED, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.from+" to "+test.to, func(t *testing.T) {
+			user := newTestUser(t, 1000)
+			payout := newTestPayout(t, user, test.from, 500, "")
+
+			err := payoutModel.apply(ctx, payout.ID, payments.PayoutUpdate{ID: "ref-" + payout.ID.Hex(), Status: test.to})
+			require.NoError(t, err)
+
+			payout, err = payoutModel.Find(ctx, user.ID, payout.ID)
+			require.NoError(t, err)
+			assert.Equal(t, test.status, payout.Status)
+
+			if test.reversed {
+				assert.Equal(t, int64(1500), testBalance(t, user.ID), "the amount is given back")
+				assert.False(t, payout.ReversalTransactionID.IsZero())
+				assert.Zero(t, payout.NextPollAt, "the payout is not polled anymore")
+			} else {
+				assert.Equal(t, int64(1000), testBalance(t, user.ID))
+				assert.True(t, payout.ReversalTransactionID.IsZero())
+			}
+
+			//Applying the update again changes nothing
+			err = payoutModel.apply(ctx, payout.ID, payments.PayoutUpdate{ID: "ref-" + payout.ID.Hex(), Status: test.to})
+			require.NoError(t, err)
+			if test.reversed {
+				assert.Equal(t, int64(1500), testBalance(t, user.ID), "the amount is given back once")
+			}
+		})
+	}
+}
+
+func TestPayoutReturnedAboveBalanceLimit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	//The balance is at the limit of the level, the returned money was the user's before the withdrawal
+	user := newFullTestUser(t)
+	payout := newTestPayout(t, user, utils.PAYOUT_SETTLED, 500, "")
+
+	require.NoError(t, payoutModel.apply(ctx, payout.ID, payments.PayoutUpdate{Status: utils.PAYOUT_RETURNED, Reason: "account closed"}))
+
+	payout, err := payoutModel.Find(ctx, user.ID, payout.ID)
+	require.NoError(t, err)
+	assert.Equal(t, utils.PAYOUT_RETURNED, payout.Status)
+	assert.Equal(t, "account closed", payout.Reason)
+	assert.Equal(t, user.Balance+500, testBalance(t, user.ID))
+}
+
+func TestPayoutFailedToPocket(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	user := newTestUser(t, 1000)
+	pocket, err := new(PocketModel).Create(ctx, user.ID, forms.CreatePocketForm{Name: "Savings"})
+	require.NoError(t, err)
+	payout := newTestPayout(t, user, utils.PAYOUT_SUBMITTED, 500, pocket.ID.Hex())
+
+	require.NoError(t, payoutModel.apply(ctx, payout.ID, payments.PayoutUpdate{Status: utils.PAYOUT_FAILED}))
+
+	pocket, err = new(PocketModel).Find(ctx, user.ID, pocket.ID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, int64(500), pocket.Balance, "the amount goes back to the pocket it came from")
+	assert.Equal(t, int64(1000), testBalance(t, user.ID))
+
+	//The pocket is gone, the amount goes back to the main balance
+	_, err = new(PocketModel).Withdraw(ctx, user.ID, pocket.ID.Hex(), 500)
+	require.NoError(t, err)
+	require.NoError(t, new(PocketModel).Delete(ctx, user.ID, pocket.ID.Hex()))
+	payout = newTestPayout(t, user, utils.PAYOUT_SUBMITTED, 300, pocket.ID.Hex())
+	require.NoError(t, payoutModel.apply(ctx, payout.ID, payments.PayoutUpdate{Status: utils.PAYOUT_FAILED}))
+	assert.Equal(t, int64(1800), testBalance(t, user.ID))
+}
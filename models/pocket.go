@@ -0,0 +1,259 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Pocket is money a user sets aside from the main balance, with an optional goal to reach by TargetDate
+type Pocket struct {
+	ID         primitive.ObjectID `json:"id"`
+	UserID     primitive.ObjectID `json:"-"`
+	Name       string             `json:"name"`
+	Balance    int64              `json:"balance"`
+	Goal       int64              `json:"goal,omitempty"`
+	TargetDate string             `json:"target_date,omitempty"`
+	CreatedAt  int64              `json:"created_at"`
+	UpdatedAt  int64              `json:"updated_at"`
+}
+
+// ErrPocketNotFound ...
+var ErrPocketNotFound = errors.New("pocket not found")
+
+// PocketModel ...
+type PocketModel struct{}
+
+var pocketModel = new(PocketModel)
+
+// maxPockets bounds the pockets of one user
+const maxPockets = 20
+
+// Create ...
+func (m PocketModel) Create(ctx context.Context, userID primitive.ObjectID, form forms.CreatePocketForm) (pocket Pocket, err error) {
+	fmt.Println("Pocket model: Create")
+	pocketCollection := db.GetCollection(db.DB, "pockets")
+
+	count, err := pocketCollection.CountDocuments(ctx, bson.M{"userid": userID})
+	if err != nil {
+		return pocket, errors.New("something went wrong, please try again later")
+	}
+	if count >= maxPockets {
+		return pocket, fmt.Errorf("you can have at most %d pockets", maxPockets)
+	}
+
+	now := time.Now().Unix()
+	pocket = Pocket{
+		ID:         primitive.NewObjectID(),
+		UserID:     userID,
+		Name:       form.Name,
+		Goal:       form.Goal,
+		TargetDate: form.TargetDate,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	_, err = pocketCollection.InsertOne(ctx, pocket)
+	if err != nil {
+		return pocket, errors.New("error when creating new pocket")
+	}
+
+	return pocket, nil
+}
+
+// List ...
+func (m PocketModel) List(ctx context.Context, userID primitive.ObjectID) (pockets []Pocket, err error) {
+	fmt.Println("Pocket model: List")
+	pocketCollection := db.GetCollection(db.DB, "pockets")
+
+	results, err := pocketCollection.Find(ctx, bson.M{"userid": userID}, options.Find().SetSort(bson.M{"createdat": 1}))
+	if err != nil {
+		return pockets, errors.New("error when retrieving pockets")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var pocket Pocket
+		if err = results.Decode(&pocket); err != nil {
+			return pockets, errors.New("error when decoding pocket")
+		}
+
+		pockets = append(pockets, pocket)
+	}
+
+	return pockets, nil
+}
+
+// Find returns a pocket of the user, id is the hex of its ID
+func (m PocketModel) Find(ctx context.Context, userID primitive.ObjectID, id string) (pocket Pocket, err error) {
+	pocketCollection := db.GetCollection(db.DB, "pockets")
+
+	pocketID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return pocket, ErrPocketNotFound
+	}
+
+	err = pocketCollection.FindOne(ctx, bson.M{"id": pocketID, "userid": userID}).Decode(&pocket)
+	if err == mongo.ErrNoDocuments {
+		return pocket, ErrPocketNotFound
+	}
+	if err != nil {
+		return pocket, errors.New("something went wrong, please try again later")
+	}
+
+	return pocket, nil
+}
+
+// Delete removes an empty pocket
+func (m PocketModel) Delete(ctx context.Context, userID primitive.ObjectID, id string) error {
+	fmt.Println("Pocket model: Delete")
+	pocketCollection := db.GetCollection(db.DB, "pockets")
+
+	pocket, err := m.Find(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := pocketCollection.DeleteOne(ctx, bson.M{"id": pocket.ID, "balance": int64(0)})
+	if err != nil {
+		return errors.New("internal server error")
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("please move the money of the pocket to your balance before deleting it")
+	}
+
+	return nil
+}
+
+// debit takes amount from a pocket of the user, ctx must be the session context of the transaction
+// spending it. The returned pocket has its balance before the debit
+func (m PocketModel) debit(ctx context.Context, userID primitive.ObjectID, id string, amount int64) (pocket Pocket, err error) {
+	pocketCollection := db.GetCollection(db.DB, "pockets")
+
+	pocket, err = m.Find(ctx, userID, id)
+	if err != nil {
+		return pocket, err
+	}
+
+	if pocket.Balance < amount {
+		return pocket, rejected("your pocket balance is not enough to execute the transaction")
+	}
+
+	_, err = pocketCollection.UpdateOne(ctx, bson.M{"id": pocket.ID}, bson.M{"$set": bson.M{"balance": pocket.Balance - amount, "updatedat": time.Now().Unix()}})
+	if err != nil {
+		return pocket, errors.New("internal server error")
+	}
+
+	return pocket, nil
+}
+
+// Deposit moves amount from the main balance to the pocket
+func (m PocketModel) Deposit(ctx context.Context, userID primitive.ObjectID, id string, amount int64) (transaction Transaction, err error) {
+	fmt.Println("Pocket model: Deposit")
+	return m.move(ctx, userID, id, amount, utils.POCKET_DEPOSIT)
+}
+
+// Withdraw moves amount from the pocket back to the main balance
+func (m PocketModel) Withdraw(ctx context.Context, userID primitive.ObjectID, id string, amount int64) (transaction Transaction, err error) {
+	fmt.Println("Pocket model: Withdraw")
+	return m.move(ctx, userID, id, amount, utils.POCKET_WITHDRAW)
+}
+
+// move runs a move between the main balance and a pocket in one Mongo transaction.
+// The transaction records the main balance after the move
+func (m PocketModel) move(ctx context.Context, userID primitive.ObjectID, id string, amount int64, moveType string) (transaction Transaction, err error) {
+	userCollection := db.GetCollection(db.DB, "users")
+	pocketCollection := db.GetCollection(db.DB, "pockets")
+
+	data, err := withTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		var user User
+		err := userCollection.FindOne(sessionContext, bson.M{"id": userID}).Decode(&user)
+		if err != nil {
+			return transaction, errors.New("something went wrong, please try again later")
+		}
+
+		now := time.Now().Unix()
+		balance := user.Balance
+		var pocket Pocket
+		if moveType == utils.POCKET_DEPOSIT {
+			pocket, err = m.Find(sessionContext, userID, id)
+			if err != nil {
+				return transaction, err
+			}
+			if user.Balance < amount {
+				return transaction, errors.New("your balance is not enough to execute the transaction")
+			}
+
+			balance -= amount
+			_, err = pocketCollection.UpdateOne(sessionContext, bson.M{"id": pocket.ID}, bson.M{"$set": bson.M{"balance": pocket.Balance + amount, "updatedat": now}})
+		} else {
+			//Money back from a pocket counts toward the balance limit like any money received
+			if err = kycModel.checkIncoming(sessionContext, user, amount, true); err != nil {
+				return transaction, err
+			}
+			pocket, err = m.debit(sessionContext, userID, id, amount)
+			balance += amount
+		}
+		if err != nil {
+			return transaction, err
+		}
+
+		_, err = userCollection.UpdateOne(sessionContext, bson.M{"id": user.ID}, bson.M{"$set": bson.M{"balance": balance, "updatedat": now}})
+		if err != nil {
+			return transaction, errors.New("internal server error")
+		}
+
+		return transactionModel.Create(sessionContext, forms.CreateTransactionForm{
+			From:      user.Username,
+			To:        user.Username,
+			Amount:    amount,
+			Balance:   balance,
+			Type:      moveType,
+			PocketID:  pocket.ID.Hex(),
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+	})
+	transaction, _ = data.(Transaction)
+
+	return transaction, err
+}
+
+// Transactions returns the history of a pocket: moves, and transfers and withdrawals drawn from it, newest first
+func (m PocketModel) Transactions(ctx context.Context, userID primitive.ObjectID, id string, query Query) (transactions []Transaction, err error) {
+	fmt.Println("Pocket model: Transactions")
+	transactionCollection := db.GetCollection(db.DB, "transactions")
+
+	pocket, err := m.Find(ctx, userID, id)
+	if err != nil {
+		return transactions, err
+	}
+
+	opts := options.Find().SetSort(bson.M{"createdat": -1}).SetSkip(int64((query.Page - 1) * query.Limit)).SetLimit(int64(query.Limit))
+	results, err := transactionCollection.Find(ctx, bson.M{"pocketid": pocket.ID.Hex()}, opts)
+	if err != nil {
+		return transactions, errors.New("error when retrieving transactions")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var transaction Transaction
+		if err = results.Decode(&transaction); err != nil {
+			return transactions, errors.New("error when decoding transaction")
+		}
+
+		//The owner of the pocket is the sender of all its transactions
+		transactions = append(transactions, transaction.For(transaction.From))
+	}
+
+	return transactions, nil
+}
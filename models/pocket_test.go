@@ -0,0 +1,45 @@
+//go:build all
+// +build all
+
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestPocketWithdrawBalanceLimit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	user := newFullTestUser(t)
+	pocket, err := new(PocketModel).Create(ctx, user.ID, forms.CreatePocketForm{Name: "Savings"})
+	require.NoError(t, err)
+
+	_, err = new(PocketModel).Deposit(ctx, user.ID, pocket.ID.Hex(), 1000)
+	require.NoError(t, err)
+
+	//Back to the limit
+	_, err = new(PocketModel).Withdraw(ctx, user.ID, pocket.ID.Hex(), 500)
+	require.NoError(t, err)
+	assert.Equal(t, user.Balance-500, testBalance(t, user.ID))
+
+	//The main balance is full again, money from the pocket would take it above the limit
+	_, err = db.GetCollection(db.DB, "users").UpdateOne(ctx, bson.M{"id": user.ID}, bson.M{"$set": bson.M{"balance": user.Balance}})
+	require.NoError(t, err)
+
+	_, err = new(PocketModel).Withdraw(ctx, user.ID, pocket.ID.Hex(), 500)
+	assert.True(t, IsRejected(err), "the balance limit refuses the withdrawal: %v", err)
+	assert.Equal(t, user.Balance, testBalance(t, user.ID))
+
+	pocket, err = new(PocketModel).Find(ctx, user.ID, pocket.ID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, int64(500), pocket.Balance, "the money stays in the pocket")
+}
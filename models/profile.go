@@ -0,0 +1,299 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/notifiers"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Profile is what a user sees of their own account
+type Profile struct {
+	ID            primitive.ObjectID `json:"id"`
+	Username      string             `json:"username"`
+	Name          string             `json:"name"`
+	Email         string             `json:"email,omitempty"`
+	EmailVerified bool               `json:"email_verified"`
+	Phone         string             `json:"phone,omitempty"`
+	PhoneVerified bool               `json:"phone_verified"`
+	AvatarURL     string             `json:"avatar_url,omitempty"`
+	Locale        string             `json:"locale,omitempty"`
+	Timezone      string             `json:"timezone,omitempty"`
+	Balance       int64              `json:"balance"`
+	KYCLevel      int                `json:"kyc_level"`
+	CreatedAt     int64              `json:"created_at"`
+	UpdatedAt     int64              `json:"updated_at"`
+}
+
+// ProfileVerification is the pending code of a user for one channel, its _id is "<user>:<channel>" so a new
+// code replaces the previous one. The code is bound to Value: changing the email or phone voids it
+type ProfileVerification struct {
+	Key       string             `json:"-" bson:"_id"`
+	UserID    primitive.ObjectID `json:"-"`
+	Channel   string             `json:"channel"`
+	Value     string             `json:"-"`
+	CodeHash  string             `json:"-"`
+	Attempts  int                `json:"attempts"`
+	ExpiresAt int64              `json:"expires_at"`
+	CreatedAt int64              `json:"created_at"`
+}
+
+// ErrInvalidVerificationCode ...
+var ErrInvalidVerificationCode = errors.New("the code is invalid or has expired")
+
+// ProfileModel ...
+type ProfileModel struct{}
+
+// maxVerificationAttempts is the number of wrong codes after which a code is void
+const maxVerificationAttempts = 5
+
+// profileOf ...
+func profileOf(user User) Profile {
+	return Profile{
+		ID:            user.ID,
+		Username:      user.Username,
+		Name:          user.Name,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Phone:         user.Phone,
+		PhoneVerified: user.PhoneVerified,
+		AvatarURL:     user.AvatarURL,
+		Locale:        user.Locale,
+		Timezone:      user.Timezone,
+		Balance:       user.Balance,
+		KYCLevel:      user.KYCLevel,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+	}
+}
+
+// verificationCodeHash binds the code to the user, the channel and the value it was sent to
+func verificationCodeHash(userID primitive.ObjectID, channel string, value string, code string) string {
+	return hashToken(userID.Hex() + ":" + channel + ":" + value + ":" + code)
+}
+
+// generateCode returns a random code of 6 digits
+func generateCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// Get ...
+func (m ProfileModel) Get(ctx context.Context, userID primitive.ObjectID) (profile Profile, err error) {
+	fmt.Println("Profile model: Get")
+	userCollection := db.GetCollection(db.DB, "users")
+
+	var user User
+	err = userCollection.FindOne(ctx, bson.M{"id": userID}).Decode(&user)
+	if err != nil {
+		return profile, errors.New("something went wrong, please try again later")
+	}
+
+	return profileOf(user), nil
+}
+
+// Update changes the fields sent in the form. A changed email or phone is not verified anymore
+func (m ProfileModel) Update(ctx context.Context, userID primitive.ObjectID, form forms.UpdateProfileForm) (profile Profile, err error) {
+	fmt.Println("Profile model: Update")
+	userCollection := db.GetCollection(db.DB, "users")
+
+	var user User
+	err = userCollection.FindOne(ctx, bson.M{"id": userID}).Decode(&user)
+	if err != nil {
+		return profile, errors.New("something went wrong, please try again later")
+	}
+
+	update := bson.M{"updatedat": time.Now().Unix()}
+	fields := map[string]string{}
+	if form.Name != nil {
+		fields["name"] = strings.Join(strings.Fields(*form.Name), " ")
+	}
+	if form.Email != nil {
+		email := strings.ToLower(strings.TrimSpace(*form.Email))
+		if email != user.Email {
+			fields["email"] = email
+			update["emailverified"] = false
+		}
+	}
+	if form.Phone != nil && *form.Phone != user.Phone {
+		fields["phone"] = *form.Phone
+		update["phoneverified"] = false
+	}
+	if err = setUserFields(ctx, update, userID, fields); err != nil {
+		return profile, errors.New("something went wrong, please try again later")
+	}
+	if form.AvatarURL != nil {
+		update["avatarurl"] = *form.AvatarURL
+	}
+	if form.Locale != nil {
+		update["locale"] = *form.Locale
+	}
+	if form.Timezone != nil {
+		update["timezone"] = *form.Timezone
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = userCollection.FindOneAndUpdate(ctx, bson.M{"id": userID}, bson.M{"$set": update}, opts).Decode(&user)
+	if err != nil {
+		return profile, errors.New("internal server error")
+	}
+
+	return profileOf(user), nil
+}
+
+// contact returns the email or the phone of the user for channel ("email" or "phone"), with the notifier channel
+// to send to it and whether it is verified
+func contact(user User, channel string) (value string, notifierChannel string, verified bool) {
+	if channel == "phone" {
+		return user.Phone, notifiers.ChannelSMS, user.PhoneVerified
+	}
+	return user.Email, notifiers.ChannelEmail, user.EmailVerified
+}
+
+// StartVerification sends a code to the email or the phone of the user. A new code can be asked for
+// once VERIFICATION_RESEND_INTERVAL has passed, it replaces the previous one
+func (m ProfileModel) StartVerification(ctx context.Context, userID primitive.ObjectID, channel string) error {
+	fmt.Println("Profile model: StartVerification")
+	userCollection := db.GetCollection(db.DB, "users")
+	verificationCollection := db.GetCollection(db.DB, "profile_verifications")
+
+	var user User
+	err := userCollection.FindOne(ctx, bson.M{"id": userID}).Decode(&user)
+	if err != nil {
+		return errors.New("something went wrong, please try again later")
+	}
+
+	value, notifierChannel, verified := contact(user, channel)
+	if value == "" {
+		return fmt.Errorf("please add your %s to your profile first", channel)
+	}
+	if verified {
+		return fmt.Errorf("your %s is already verified", channel)
+	}
+
+	key := userID.Hex() + ":" + channel
+	now := time.Now()
+
+	var previous ProfileVerification
+	err = verificationCollection.FindOne(ctx, bson.M{"_id": key}).Decode(&previous)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return errors.New("something went wrong, please try again later")
+	}
+	resend := utils.GetEnvDuration("VERIFICATION_RESEND_INTERVAL", time.Minute)
+	if err == nil && previous.Value == value && now.Unix() < previous.CreatedAt+int64(resend.Seconds()) {
+		return errors.New("a code was just sent, please wait before asking for a new one")
+	}
+
+	code, err := generateCode()
+	if err != nil {
+		return errors.New("something went wrong, please try again later")
+	}
+
+	ttl := utils.GetEnvDuration("VERIFICATION_CODE_TTL", 10*time.Minute)
+	_, err = verificationCollection.ReplaceOne(ctx, bson.M{"_id": key}, ProfileVerification{
+		Key:       key,
+		UserID:    userID,
+		Channel:   channel,
+		Value:     value,
+		CodeHash:  verificationCodeHash(userID, channel, value, code),
+		ExpiresAt: now.Add(ttl).Unix(),
+		CreatedAt: now.Unix(),
+	}, options.Replace().SetUpsert(true))
+	if err != nil {
+		return errors.New("something went wrong, please try again later")
+	}
+
+	return notifiers.GetNotifier().Send(ctx, notifiers.Message{
+		To:      value,
+		Channel: notifierChannel,
+		Subject: "Your verification code",
+		Body:    fmt.Sprintf("Your verification code is %s. It expires in %s. If you did not ask for it you can ignore this message.", code, ttl),
+	})
+}
+
+// ConfirmVerification marks the email or the phone of the user verified when code is the one sent to it.
+// A code is void after maxVerificationAttempts wrong ones
+func (m ProfileModel) ConfirmVerification(ctx context.Context, userID primitive.ObjectID, channel string, code string) (profile Profile, err error) {
+	fmt.Println("Profile model: ConfirmVerification")
+	userCollection := db.GetCollection(db.DB, "users")
+	verificationCollection := db.GetCollection(db.DB, "profile_verifications")
+
+	var user User
+	err = userCollection.FindOne(ctx, bson.M{"id": userID}).Decode(&user)
+	if err != nil {
+		return profile, errors.New("something went wrong, please try again later")
+	}
+	value, _, _ := contact(user, channel)
+
+	//Counting the attempt in the same query that finds the code bounds the guesses, even concurrent ones
+	key := userID.Hex() + ":" + channel
+	var verification ProfileVerification
+	err = verificationCollection.FindOneAndUpdate(ctx, bson.M{
+		"_id":       key,
+		"value":     value,
+		"attempts":  bson.M{"$lt": maxVerificationAttempts},
+		"expiresat": bson.M{"$gt": time.Now().Unix()},
+	}, bson.M{"$inc": bson.M{"attempts": 1}}).Decode(&verification)
+	if err == mongo.ErrNoDocuments {
+		return profile, ErrInvalidVerificationCode
+	}
+	if err != nil {
+		return profile, errors.New("something went wrong, please try again later")
+	}
+
+	hash := verificationCodeHash(userID, channel, value, code)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(verification.CodeHash)) != 1 {
+		return profile, ErrInvalidVerificationCode
+	}
+
+	//An email or a phone proves the identity of one account only
+	field := channel
+	filter := userBy(field, value)
+	filter[field+"verified"] = true
+	filter["id"] = bson.M{"$ne": userID}
+	count, err := userCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return profile, errors.New("something went wrong, please try again later")
+	}
+	if count > 0 {
+		return profile, fmt.Errorf("this %s is already used by another account", channel)
+	}
+
+	filter = userBy(field, value)
+	filter["id"] = userID
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = userCollection.FindOneAndUpdate(ctx,
+		filter,
+		bson.M{"$set": bson.M{field + "verified": true, "updatedat": time.Now().Unix()}},
+		opts).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		//Changed meanwhile
+		return profile, ErrInvalidVerificationCode
+	}
+	if err != nil {
+		return profile, errors.New("internal server error")
+	}
+
+	_, err = verificationCollection.DeleteOne(ctx, bson.M{"_id": key})
+	if err != nil {
+		return profile, errors.New("internal server error")
+	}
+
+	return profileOf(user), nil
+}
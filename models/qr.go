@@ -0,0 +1,199 @@
+package models
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// QRClaims is what a payment QR code carries. Short keys keep the code small enough to scan easily
+type QRClaims struct {
+	To        string `json:"t"`
+	Amount    int64  `json:"a,omitempty"`
+	Reference string `json:"r,omitempty"`
+	ExpiresAt int64  `json:"e"`
+	Nonce     string `json:"n"`
+}
+
+// QRCode is a signed payload ready to be rendered
+type QRCode struct {
+	Payload   string `json:"payload"`
+	To        string `json:"to"`
+	Amount    int64  `json:"amount,omitempty"`
+	Reference string `json:"reference,omitempty"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// qrPrefix versions the payload format: "WQR1.<base64url claims>.<base64url HMAC-SHA256>"
+const qrPrefix = "WQR1"
+
+// ErrInvalidQR is returned for payloads that are malformed, tampered with or not ours
+var ErrInvalidQR = errors.New("invalid QR code")
+
+// ErrQRExpired ...
+var ErrQRExpired = errors.New("the QR code has expired")
+
+// ErrQRUsed is returned when a code is paid a second time
+var ErrQRUsed = errors.New("the QR code has already been used")
+
+// QRModel ...
+type QRModel struct{}
+
+func qrSecret() ([]byte, error) {
+	secret := os.Getenv("QR_SECRET")
+	if secret == "" {
+		return nil, errors.New("QR payments are not configured")
+	}
+	return []byte(secret), nil
+}
+
+func signQR(secret []byte, signed string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+// EncodeQR signs the claims into a payload
+func EncodeQR(claims QRClaims) (string, error) {
+	secret, err := qrSecret()
+	if err != nil {
+		return "", err
+	}
+
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := qrPrefix + "." + base64.RawURLEncoding.EncodeToString(body)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signQR(secret, signed)), nil
+}
+
+// DecodeQR checks the signature of a payload and returns its claims, expired codes included
+func DecodeQR(payload string) (claims QRClaims, err error) {
+	secret, err := qrSecret()
+	if err != nil {
+		return claims, err
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 || parts[0] != qrPrefix {
+		return claims, ErrInvalidQR
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, signQR(secret, parts[0]+"."+parts[1])) {
+		return claims, ErrInvalidQR
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(body, &claims) != nil || claims.To == "" || claims.Nonce == "" {
+		return claims, ErrInvalidQR
+	}
+
+	return claims, nil
+}
+
+// Create signs a single-use code asking to be paid to the user
+func (m QRModel) Create(ctx context.Context, userID primitive.ObjectID, form forms.CreateQRForm) (code QRCode, err error) {
+	fmt.Println("QR model: Create")
+	userCollection := db.GetCollection(db.DB, "users")
+
+	var user User
+	err = userCollection.FindOne(ctx, bson.M{"id": userID}).Decode(&user)
+	if err != nil {
+		return code, errors.New("something went wrong, please try again later")
+	}
+
+	nonce, err := generateHexToken(12)
+	if err != nil {
+		return code, errors.New("something went wrong, please try again later")
+	}
+
+	ttl := utils.GetEnvDuration("QR_TTL", 15*time.Minute)
+	if form.ExpiresInMinutes > 0 {
+		ttl = time.Duration(form.ExpiresInMinutes) * time.Minute
+	}
+
+	claims := QRClaims{
+		To:        user.Username,
+		Amount:    form.Amount,
+		Reference: form.Reference,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+		Nonce:     nonce,
+	}
+
+	payload, err := EncodeQR(claims)
+	if err != nil {
+		return code, err
+	}
+
+	return QRCode{Payload: payload, To: claims.To, Amount: claims.Amount, Reference: claims.Reference, ExpiresAt: claims.ExpiresAt}, nil
+}
+
+// Pay verifies the code and transfers its amount (or the payer's amount when the code has none) to its recipient.
+// The nonce is recorded as the _id of a redemption in the same Mongo transaction as the transfer,
+// so a code is paid at most once
+func (m QRModel) Pay(ctx context.Context, userID primitive.ObjectID, form forms.PayQRForm) (transaction Transaction, err error) {
+	fmt.Println("QR model: Pay")
+	redemptionCollection := db.GetCollection(db.DB, "qr_redemptions")
+
+	claims, err := DecodeQR(form.Payload)
+	if err != nil {
+		return transaction, err
+	}
+	if claims.ExpiresAt <= time.Now().Unix() {
+		return transaction, ErrQRExpired
+	}
+
+	amount := claims.Amount
+	if amount == 0 {
+		amount = form.Amount
+	}
+	if amount == 0 {
+		return transaction, errors.New("please enter the amount to pay")
+	}
+	if claims.Amount != 0 && form.Amount != 0 && form.Amount != claims.Amount {
+		return transaction, errors.New("the amount does not match the QR code")
+	}
+
+	reference := "qr:" + claims.Nonce
+	if claims.Reference != "" {
+		reference = claims.Reference
+	}
+
+	data, err := withTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		_, err := redemptionCollection.InsertOne(sessionContext, bson.M{
+			"_id":        claims.Nonce,
+			"payerid":    userID,
+			"to":         claims.To,
+			"amount":     amount,
+			"redeemedat": time.Now().Unix(),
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			return transaction, ErrQRUsed
+		}
+		if err != nil {
+			return transaction, err
+		}
+
+		return userModel.transfer(sessionContext, userID, claims.To, amount, reference, "", "")
+	})
+	transaction, _ = data.(Transaction)
+
+	return transaction, err
+}
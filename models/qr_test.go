@@ -0,0 +1,91 @@
+//go:build all
+// +build all
+
+package models
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecodeQR(t *testing.T) {
+	os.Setenv("QR_SECRET", "test-qr-secret")
+	defer os.Unsetenv("QR_SECRET")
+
+	claims := QRClaims{To: "alice", Amount: 1500, Reference: "order-1", ExpiresAt: 1700000000, Nonce: "abc123"}
+	payload, err := EncodeQR(claims)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(payload, qrPrefix+"."))
+
+	decoded, err := DecodeQR(payload)
+	assert.NoError(t, err)
+	assert.Equal(t, claims, decoded)
+
+	parts := strings.Split(payload, ".")
+	tampered, err := EncodeQR(QRClaims{To: "alice", Amount: 1, ExpiresAt: 1700000000, Nonce: "abc123"})
+	require.NoError(t, err)
+	tamperedBody := strings.Split(tampered, ".")[1]
+
+	tests := []struct {
+		name    string
+		payload string
+	}{
+		{"amount changed", parts[0] + "." + tamperedBody + "." + parts[2]},
+		{"signature removed", parts[0] + "." + parts[1]},
+		{"other prefix", "WQR2." + parts[1] + "." + parts[2]},
+		{"signature not base64", parts[0] + "." + parts[1] + ".!!"},
+		{"body not JSON", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte("{")) + "." + parts[2]},
+		{"empty", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodeQR(test.payload)
+			assert.Equal(t, ErrInvalidQR, err)
+		})
+	}
+
+	os.Setenv("QR_SECRET", "another-qr-secret")
+	_, err = DecodeQR(payload)
+	assert.Equal(t, ErrInvalidQR, err, "a code signed with another secret")
+
+	os.Unsetenv("QR_SECRET")
+	_, err = EncodeQR(claims)
+	assert.EqualError(t, err, "QR payments are not configured")
+}
+
+func TestQRPayOnce(t *testing.T) {
+	os.Setenv("QR_SECRET", "test-qr-secret")
+	defer os.Unsetenv("QR_SECRET")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	payer := newTestUser(t, 10000)
+	payee := newTestUser(t, 0)
+
+	code, err := new(QRModel).Create(ctx, payee.ID, forms.CreateQRForm{Amount: 2500})
+	require.NoError(t, err)
+
+	transaction, err := new(QRModel).Pay(ctx, payer.ID, forms.PayQRForm{Payload: code.Payload})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2500), transaction.Amount)
+
+	_, err = new(QRModel).Pay(ctx, payer.ID, forms.PayQRForm{Payload: code.Payload})
+	assert.Equal(t, ErrQRUsed, err, "a code is paid once")
+
+	assert.Equal(t, int64(7500), testBalance(t, payer.ID))
+	assert.Equal(t, int64(2500), testBalance(t, payee.ID))
+
+	expired, err := EncodeQR(QRClaims{To: payee.Username, Amount: 100, ExpiresAt: time.Now().Add(-time.Minute).Unix(), Nonce: "expired"})
+	require.NoError(t, err)
+	_, err = new(QRModel).Pay(ctx, payer.ID, forms.PayQRForm{Payload: expired})
+	assert.Equal(t, ErrQRExpired, err)
+}
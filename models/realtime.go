@@ -0,0 +1,346 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RealtimeEvent is pushed to connected clients. ID is the change stream resume token:
+// a client reconnecting with it receives every event it missed
+type RealtimeEvent struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Realtime event types
+const (
+	RealtimeTransaction = "transaction"
+	RealtimeBalance     = "balance"
+	RealtimePocket      = "pocket"
+)
+
+// changeEvent is the part of a MongoDB change stream document we need
+type changeEvent struct {
+	OperationType string          `bson:"operationType"`
+	Ns            changeNamespace `bson:"ns"`
+	FullDocument  *changeDocument `bson:"fullDocument"`
+}
+
+// changeNamespace is the collection of a change
+type changeNamespace struct {
+	Coll string `bson:"coll"`
+}
+
+// changeDocument holds the fields of the outbox events, users and pockets kept by the projection of the stream
+type changeDocument struct {
+	ID        primitive.ObjectID `bson:"id"`
+	UserID    primitive.ObjectID `bson:"userid"`
+	Balance   int64              `bson:"balance"`
+	UpdatedAt int64              `bson:"updatedat"`
+	Usernames []string           `bson:"usernames"`
+	Payload   []byte             `bson:"payload"`
+}
+
+// realtimeChange is an event of the shared stream with the users it concerns: the usernames of a transaction,
+// the owner of a balance otherwise
+type realtimeChange struct {
+	event     RealtimeEvent
+	userID    primitive.ObjectID
+	usernames []string
+}
+
+// concerns tells whether the change goes to the user
+func (c realtimeChange) concerns(userID primitive.ObjectID, username string) bool {
+	if c.event.Type != RealtimeTransaction {
+		return c.userID == userID
+	}
+	for _, name := range c.usernames {
+		if name == username {
+			return true
+		}
+	}
+	return false
+}
+
+// RealtimeStream follows the wallet changes of one user
+type RealtimeStream struct {
+	userID   primitive.ObjectID
+	username string
+	events   chan RealtimeEvent
+	done     chan struct{}
+	err      error
+	end      sync.Once
+}
+
+// stop ends the stream with err, Next returns it once the events queued before are read
+func (s *RealtimeStream) stop(err error) {
+	s.end.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+// ErrResumeTokenExpired is returned when the resume token is unknown or fell out of the oplog,
+// the client has to reload its state and reconnect without a token
+var ErrResumeTokenExpired = errors.New("the resume token is invalid or has expired")
+
+// errRealtimeLagging ends the stream of a client that does not read its events fast enough,
+// it reconnects with the ID of its last event
+var errRealtimeLagging = errors.New("the client is too slow to read its events")
+
+// realtimeQueue is the number of events waiting for a client before its stream is ended
+const realtimeQueue = 64
+
+// realtimeHub follows a single change stream for the instance and fans its events out to the connected clients.
+// It keeps at least the last REALTIME_BUFFER events, a client reconnecting with the ID of one of them gets the ones it missed
+type realtimeHub struct {
+	start       sync.Mutex
+	mu          sync.Mutex
+	running     bool
+	subscribers map[*RealtimeStream]struct{}
+	recent      []realtimeChange
+}
+
+var hub = &realtimeHub{subscribers: map[*RealtimeStream]struct{}{}}
+
+// ensureRunning opens the shared change stream when it is not followed yet
+func (h *realtimeHub) ensureRunning(ctx context.Context) error {
+	h.start.Lock()
+	defer h.start.Unlock()
+
+	h.mu.Lock()
+	running := h.running
+	h.mu.Unlock()
+	if running {
+		return nil
+	}
+
+	//Only the collections and the fields the clients are sent
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$or": []bson.M{
+			{
+				"ns.coll":       "outbox",
+				"operationType": "insert",
+			},
+			{
+				"ns.coll":       bson.M{"$in": []string{"users", "pockets"}},
+				"operationType": "update",
+				"updateDescription.updatedFields.balance": bson.M{"$exists": true},
+			},
+		}}}},
+		{{Key: "$project", Value: bson.M{
+			"operationType":          1,
+			"ns":                     1,
+			"fullDocument.id":        1,
+			"fullDocument.userid":    1,
+			"fullDocument.balance":   1,
+			"fullDocument.updatedat": 1,
+			"fullDocument.usernames": 1,
+			"fullDocument.payload":   1,
+		}}},
+	}
+
+	database := db.DB.Database(db.GetCollection(db.DB, "outbox").Database().Name())
+	stream, err := database.Watch(ctx, pipeline, options.ChangeStream().SetFullDocument(options.UpdateLookup))
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	h.running = true
+	h.mu.Unlock()
+
+	go h.follow(stream)
+	return nil
+}
+
+// follow publishes the events of the stream until it fails. The driver already resumes after transient errors,
+// so the clients are then disconnected and the recent events dropped, the next Watch opens a new stream
+func (h *realtimeHub) follow(stream *mongo.ChangeStream) {
+	ctx := context.Background()
+	defer stream.Close(ctx)
+
+	for stream.Next(ctx) {
+		var change changeEvent
+		if err := stream.Decode(&change); err != nil {
+			log.Printf("realtime: %v", err)
+			continue
+		}
+		//The document was deleted before it was looked up
+		if change.FullDocument == nil {
+			continue
+		}
+
+		published, err := realtimeChangeOf(change)
+		if err != nil {
+			log.Printf("realtime: %v", err)
+			continue
+		}
+		published.event.ID = stream.ResumeToken().Lookup("_data").StringValue()
+		h.publish(published)
+	}
+
+	err := stream.Err()
+	if err == nil {
+		err = errors.New("the change stream was closed")
+	}
+	log.Printf("realtime: the change stream failed: %v", err)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running = false
+	h.recent = nil
+	for subscriber := range h.subscribers {
+		subscriber.stop(err)
+		delete(h.subscribers, subscriber)
+	}
+}
+
+// realtimeChangeOf returns the event sent for the change
+func realtimeChangeOf(change changeEvent) (published realtimeChange, err error) {
+	doc := change.FullDocument
+
+	switch change.Ns.Coll {
+	case "outbox":
+		published.usernames = doc.Usernames
+		published.event.Type = RealtimeTransaction
+		published.event.Data = json.RawMessage(doc.Payload)
+	case "users":
+		published.userID = doc.ID
+		published.event.Type = RealtimeBalance
+		published.event.Data, err = json.Marshal(map[string]int64{"balance": doc.Balance, "updated_at": doc.UpdatedAt})
+	case "pockets":
+		published.userID = doc.UserID
+		published.event.Type = RealtimePocket
+		published.event.Data, err = json.Marshal(map[string]interface{}{"id": doc.ID, "balance": doc.Balance, "updated_at": doc.UpdatedAt})
+	default:
+		err = fmt.Errorf("unexpected change of %s", change.Ns.Coll)
+	}
+
+	return published, err
+}
+
+// publish keeps the change and queues it for the clients it concerns, a client whose queue is full is disconnected
+func (h *realtimeHub) publish(change realtimeChange) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.recent = append(h.recent, change)
+	//Trimmed once it doubles, so the events are not copied on every change
+	if size := utils.GetEnvInt("REALTIME_BUFFER", 10000); len(h.recent) > 2*size {
+		h.recent = append(h.recent[:0:0], h.recent[len(h.recent)-size:]...)
+	}
+
+	for subscriber := range h.subscribers {
+		if !change.concerns(subscriber.userID, subscriber.username) {
+			continue
+		}
+		select {
+		case subscriber.events <- change.event:
+		default:
+			subscriber.stop(errRealtimeLagging)
+			delete(h.subscribers, subscriber)
+		}
+	}
+}
+
+// subscribe registers a stream of the user, queued with the events after resumeToken when given
+func (h *realtimeHub) subscribe(userID primitive.ObjectID, username string, resumeToken string) (*RealtimeStream, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var missed []RealtimeEvent
+	if resumeToken != "" {
+		found := false
+		for _, change := range h.recent {
+			if found && change.concerns(userID, username) {
+				missed = append(missed, change.event)
+			}
+			if change.event.ID == resumeToken {
+				found = true
+			}
+		}
+		if !found {
+			return nil, ErrResumeTokenExpired
+		}
+	}
+
+	stream := &RealtimeStream{
+		userID:   userID,
+		username: username,
+		events:   make(chan RealtimeEvent, len(missed)+realtimeQueue),
+		done:     make(chan struct{}),
+	}
+	for _, event := range missed {
+		stream.events <- event
+	}
+	h.subscribers[stream] = struct{}{}
+
+	return stream, nil
+}
+
+// unsubscribe ...
+func (h *realtimeHub) unsubscribe(stream *RealtimeStream) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, stream)
+}
+
+// RealtimeModel ...
+type RealtimeModel struct{}
+
+// Watch follows the new transactions (outbox inserts), the balance and the pocket balance updates of the user.
+// The events come from the stream shared by every client of the instance. The stream starts after resumeToken
+// when given, the token has to be one of the last events seen by the instance
+func (m RealtimeModel) Watch(ctx context.Context, userID primitive.ObjectID, resumeToken string) (*RealtimeStream, error) {
+	fmt.Println("Realtime model: Watch")
+	userCollection := db.GetCollection(db.DB, "users")
+
+	var user User
+	err := userCollection.FindOne(ctx, bson.M{"id": userID}).Decode(&user)
+	if err != nil {
+		return nil, errors.New("something went wrong, please try again later")
+	}
+
+	if err = hub.ensureRunning(ctx); err != nil {
+		return nil, err
+	}
+
+	return hub.subscribe(userID, user.Username, resumeToken)
+}
+
+// Next blocks until the next event, ctx cancellation ends the wait
+func (s *RealtimeStream) Next(ctx context.Context) (event RealtimeEvent, err error) {
+	select {
+	case event = <-s.events:
+		return event, nil
+	default:
+	}
+
+	select {
+	case event = <-s.events:
+		return event, nil
+	case <-s.done:
+		return event, s.err
+	case <-ctx.Done():
+		return event, ctx.Err()
+	}
+}
+
+// Close ...
+func (s *RealtimeStream) Close(ctx context.Context) error {
+	hub.unsubscribe(s)
+	return nil
+}
@@ -0,0 +1,79 @@
+//go:build all
+// +build all
+
+package models
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRealtimeHub(t *testing.T) {
+	h := &realtimeHub{subscribers: map[*RealtimeStream]struct{}{}}
+	alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+
+	transfer, err := realtimeChangeOf(changeEvent{Ns: changeNamespace{"outbox"}, FullDocument: &changeDocument{Usernames: []string{"alice", "bob"}, Payload: []byte(`{"amount":100}`)}})
+	require.NoError(t, err)
+	transfer.event.ID = "1"
+	pocket, err := realtimeChangeOf(changeEvent{Ns: changeNamespace{"pockets"}, FullDocument: &changeDocument{ID: primitive.NewObjectID(), UserID: alice, Balance: 500}})
+	require.NoError(t, err)
+	pocket.event.ID = "2"
+	balance, err := realtimeChangeOf(changeEvent{Ns: changeNamespace{"users"}, FullDocument: &changeDocument{ID: bob, Balance: 900}})
+	require.NoError(t, err)
+	balance.event.ID = "3"
+
+	stream, err := h.subscribe(alice, "alice", "")
+	require.NoError(t, err)
+	for _, change := range []realtimeChange{transfer, pocket, balance} {
+		h.publish(change)
+	}
+
+	//The balance of bob is not sent to alice
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, want := range []string{RealtimeTransaction, RealtimePocket} {
+		event, err := stream.Next(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, want, event.Type)
+	}
+	cancel()
+	_, err = stream.Next(ctx)
+	assert.Equal(t, context.Canceled, err, "no other event")
+	require.NoError(t, stream.Close(context.Background()))
+
+	//Bob reconnects after the transfer and only gets the balance change
+	stream, err = h.subscribe(bob, "bob", "1")
+	require.NoError(t, err)
+	event, err := stream.Next(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, RealtimeBalance, event.Type)
+	assert.Equal(t, "3", event.ID)
+	assert.JSONEq(t, `{"balance":900,"updated_at":0}`, string(event.Data))
+	require.NoError(t, stream.Close(context.Background()))
+
+	_, err = h.subscribe(bob, "bob", "unknown")
+	assert.Equal(t, ErrResumeTokenExpired, err)
+}
+
+func TestRealtimeHubLagging(t *testing.T) {
+	h := &realtimeHub{subscribers: map[*RealtimeStream]struct{}{}}
+	alice := primitive.NewObjectID()
+
+	stream, err := h.subscribe(alice, "alice", "")
+	require.NoError(t, err)
+	for i := 0; i <= realtimeQueue; i++ {
+		h.publish(realtimeChange{event: RealtimeEvent{ID: primitive.NewObjectID().Hex(), Type: RealtimeBalance}, userID: alice})
+	}
+
+	//The events queued are still read, then the stream ends
+	for i := 0; i < realtimeQueue; i++ {
+		_, err = stream.Next(context.Background())
+		require.NoError(t, err)
+	}
+	_, err = stream.Next(context.Background())
+	assert.Equal(t, errRealtimeLagging, err)
+	assert.Empty(t, h.subscribers)
+}
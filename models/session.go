@@ -0,0 +1,94 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Session is one login of a user, it holds the UUIDs of the token pair issued for it.
+// Deleting the session revokes both the access and the refresh token
+type Session struct {
+	ID          primitive.ObjectID `json:"id"`
+	UserID      primitive.ObjectID `json:"-"`
+	AccessUUID  string             `json:"-"`
+	RefreshUUID string             `json:"-"`
+	AtExpires   int64              `json:"-"`
+	RtExpires   int64              `json:"expires_at"`
+	DeviceName  string             `json:"device_name,omitempty"`
+	UserAgent   string             `json:"user_agent,omitempty"`
+	IP          string             `json:"ip,omitempty"`
+	CreatedAt   int64              `json:"created_at"`
+	LastUsedAt  int64              `json:"last_used_at"`
+	Current     bool               `json:"current" bson:"-"`
+}
+
+// SessionDevice describes the client a session is created from
+type SessionDevice struct {
+	Name      string
+	UserAgent string
+	IP        string
+}
+
+// ErrSessionNotFound ...
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionModel ...
+type SessionModel struct{}
+
+// List returns the sessions of the user that can still be used, the one holding currentAccessUUID is flagged as current
+func (m SessionModel) List(ctx context.Context, userID primitive.ObjectID, currentAccessUUID string) (sessions []Session, err error) {
+	fmt.Println("Session model: List")
+	sessionCollection := db.GetCollection(db.DB, "sessions")
+
+	opts := options.Find().SetSort(bson.M{"lastusedat": -1})
+	results, err := sessionCollection.Find(ctx, bson.M{
+		"userid":    userID,
+		"rtexpires": bson.M{"$gt": time.Now().Unix()},
+	}, opts)
+	if err != nil {
+		return sessions, errors.New("error when retrieving sessions")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var session Session
+		if err = results.Decode(&session); err != nil {
+			return sessions, errors.New("error when decoding session")
+		}
+
+		session.Current = session.AccessUUID == currentAccessUUID
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// Revoke deletes one session of the user
+func (m SessionModel) Revoke(ctx context.Context, userID primitive.ObjectID, sessionID primitive.ObjectID) error {
+	fmt.Println("Session model: Revoke")
+	sessionCollection := db.GetCollection(db.DB, "sessions")
+
+	result, err := sessionCollection.DeleteOne(ctx, bson.M{"id": sessionID, "userid": userID})
+	if err != nil {
+		return errors.New("internal server error")
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// RevokeAll logs the user out everywhere, including the current session
+func (m SessionModel) RevokeAll(ctx context.Context, userID primitive.ObjectID) error {
+	fmt.Println("Session model: RevokeAll")
+	return authModel.RevokeAuth(ctx, userID, "")
+}
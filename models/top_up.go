@@ -0,0 +1,235 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/payments"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TopUp is money a user pays through the payment provider. It stays PENDING until a signed callback of the provider
+// confirms the checkout, only then the balance is credited
+type TopUp struct {
+	ID                primitive.ObjectID `json:"id"`
+	UserID            primitive.ObjectID `json:"-"`
+	Amount            int64              `json:"amount"`
+	Status            string             `json:"status"`
+	Provider          string             `json:"provider"`
+	ProviderReference string             `json:"provider_reference,omitempty"`
+	CheckoutURL       string             `json:"checkout_url,omitempty"`
+	TransactionID     primitive.ObjectID `json:"transaction_id,omitempty"`
+	CompletedAt       int64              `json:"completed_at,omitempty"`
+	CreatedAt         int64              `json:"created_at"`
+	UpdatedAt         int64              `json:"updated_at"`
+}
+
+// ErrTopUpNotFound ...
+var ErrTopUpNotFound = errors.New("top-up not found")
+
+// ErrCallbackMismatch is returned for a verified callback that doesn't match its top-up
+var ErrCallbackMismatch = errors.New("the callback does not match the top-up")
+
+// TopUpModel ...
+type TopUpModel struct{}
+
+// Create records a PENDING top-up and opens its checkout at the provider, the user pays at the returned CheckoutURL
+func (m TopUpModel) Create(ctx context.Context, userID primitive.ObjectID, form forms.TopUpForm) (topUp TopUp, err error) {
+	fmt.Println("TopUp model: Create")
+	userCollection := db.GetCollection(db.DB, "users")
+	topUpCollection := db.GetCollection(db.DB, "top_ups")
+
+	var user User
+	err = userCollection.FindOne(ctx, bson.M{"id": userID}).Decode(&user)
+	if err != nil {
+		return topUp, errors.New("something went wrong, please try again later")
+	}
+
+	//Checked when the checkout starts, the money paid is credited even if the balance changed meanwhile
+	if err = kycModel.checkIncoming(ctx, user, form.Amount, true); err != nil {
+		return topUp, err
+	}
+
+	provider := payments.GetProvider()
+	now := time.Now().Unix()
+	topUp = TopUp{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Amount:    form.Amount,
+		Status:    utils.TOP_UP_PENDING,
+		Provider:  provider.Name(),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	_, err = topUpCollection.InsertOne(ctx, topUp)
+	if err != nil {
+		return topUp, errors.New("error when creating new top-up")
+	}
+
+	session, err := provider.CreateCheckout(ctx, payments.Checkout{
+		Reference:   topUp.ID.Hex(),
+		Amount:      topUp.Amount,
+		Username:    user.Username,
+		CallbackURL: os.Getenv("PAYMENT_CALLBACK_URL"),
+		ReturnURL:   os.Getenv("PAYMENT_RETURN_URL"),
+	})
+	if err != nil {
+		fmt.Println("TopUp model: checkout failed:", err)
+		topUpCollection.UpdateOne(ctx, bson.M{"id": topUp.ID}, bson.M{"$set": bson.M{"status": utils.TOP_UP_FAILED, "updatedat": time.Now().Unix()}})
+		return topUp, errors.New("the payment provider is unavailable, please try again later")
+	}
+
+	topUp.ProviderReference = session.ID
+	topUp.CheckoutURL = session.URL
+	_, err = topUpCollection.UpdateOne(ctx, bson.M{"id": topUp.ID}, bson.M{"$set": bson.M{"providerreference": session.ID, "checkouturl": session.URL}})
+	if err != nil {
+		return topUp, errors.New("internal server error")
+	}
+
+	return topUp, nil
+}
+
+// Find ...
+func (m TopUpModel) Find(ctx context.Context, userID primitive.ObjectID, id primitive.ObjectID) (topUp TopUp, err error) {
+	topUpCollection := db.GetCollection(db.DB, "top_ups")
+
+	err = topUpCollection.FindOne(ctx, bson.M{"id": id, "userid": userID}).Decode(&topUp)
+	if err == mongo.ErrNoDocuments {
+		return topUp, ErrTopUpNotFound
+	}
+	if err != nil {
+		return topUp, errors.New("something went wrong, please try again later")
+	}
+
+	return topUp, nil
+}
+
+// List returns the top-ups of the user, newest first
+func (m TopUpModel) List(ctx context.Context, userID primitive.ObjectID, query Query) (topUps []TopUp, err error) {
+	fmt.Println("TopUp model: List")
+	topUpCollection := db.GetCollection(db.DB, "top_ups")
+
+	opts := options.Find().SetSort(bson.M{"createdat": -1}).SetSkip(int64((query.Page - 1) * query.Limit)).SetLimit(int64(query.Limit))
+	results, err := topUpCollection.Find(ctx, bson.M{"userid": userID}, opts)
+	if err != nil {
+		return topUps, errors.New("error when retrieving top-ups")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var topUp TopUp
+		if err = results.Decode(&topUp); err != nil {
+			return topUps, errors.New("error when decoding top-up")
+		}
+
+		topUps = append(topUps, topUp)
+	}
+
+	return topUps, nil
+}
+
+// Complete applies a verified provider callback. The callback ID is recorded as the _id of a document in the same
+// Mongo transaction as the credit, so a replayed or retried callback changes nothing, and only a PENDING top-up
+// moves to SUCCEEDED (crediting the balance) or FAILED
+func (m TopUpModel) Complete(ctx context.Context, callback payments.Callback) (topUp TopUp, err error) {
+	fmt.Println("TopUp model: Complete")
+	userCollection := db.GetCollection(db.DB, "users")
+	topUpCollection := db.GetCollection(db.DB, "top_ups")
+	callbackCollection := db.GetCollection(db.DB, "payment_callbacks")
+
+	id, err := primitive.ObjectIDFromHex(callback.Reference)
+	if err != nil {
+		return topUp, ErrTopUpNotFound
+	}
+	provider := payments.GetProvider().Name()
+
+	data, err := withTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		var topUp TopUp
+		err := topUpCollection.FindOne(sessionContext, bson.M{"id": id, "provider": provider}).Decode(&topUp)
+		if err == mongo.ErrNoDocuments {
+			return topUp, ErrTopUpNotFound
+		}
+		if err != nil {
+			return topUp, err
+		}
+
+		now := time.Now().Unix()
+		_, err = callbackCollection.InsertOne(sessionContext, bson.M{
+			"_id":        provider + ":" + callback.ID,
+			"topupid":    topUp.ID,
+			"status":     callback.Status,
+			"amount":     callback.Amount,
+			"receivedat": now,
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			return topUp, nil
+		}
+		if err != nil {
+			return topUp, err
+		}
+
+		if callback.CheckoutID != topUp.ProviderReference || callback.Amount != topUp.Amount {
+			return topUp, ErrCallbackMismatch
+		}
+		if topUp.Status != utils.TOP_UP_PENDING {
+			return topUp, nil
+		}
+
+		if callback.Status != payments.StatusSucceeded {
+			topUp.Status = utils.TOP_UP_FAILED
+			topUp.CompletedAt = now
+			_, err = topUpCollection.UpdateOne(sessionContext, bson.M{"id": topUp.ID}, bson.M{"$set": bson.M{"status": topUp.Status, "completedat": now, "updatedat": now}})
+			return topUp, err
+		}
+
+		var user User
+		err = userCollection.FindOne(sessionContext, bson.M{"id": topUp.UserID}).Decode(&user)
+		if err != nil {
+			return topUp, errors.New("something went wrong, please try again later")
+		}
+
+		_, err = userCollection.UpdateOne(sessionContext, bson.M{"id": user.ID}, bson.M{"$set": bson.M{"balance": user.Balance + topUp.Amount, "updatedat": now}})
+		if err != nil {
+			return topUp, errors.New("internal server error")
+		}
+
+		transaction, err := transactionModel.Create(sessionContext, forms.CreateTransactionForm{
+			From:      user.Username,
+			To:        user.Username,
+			Amount:    topUp.Amount,
+			Balance:   user.Balance + topUp.Amount,
+			Type:      utils.TOP_UP,
+			Reference: "top-up:" + topUp.ID.Hex(),
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+		if err != nil {
+			return topUp, err
+		}
+
+		topUp.Status = utils.TOP_UP_SUCCEEDED
+		topUp.TransactionID = transaction.ID
+		topUp.CompletedAt = now
+		_, err = topUpCollection.UpdateOne(sessionContext, bson.M{"id": topUp.ID}, bson.M{"$set": bson.M{"status": topUp.Status, "transactionid": transaction.ID, "completedat": now, "updatedat": now}})
+		if err != nil {
+			return topUp, errors.New("internal server error")
+		}
+
+		err = outboxModel.Add(sessionContext, utils.EVENT_TOP_UP, []string{user.Username}, transaction)
+
+		return topUp, err
+	})
+	topUp, _ = data.(TopUp)
+
+	return topUp, err
+}
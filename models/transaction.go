@@ -4,12 +4,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Massad/gin-boilerplate/db"
 	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type Transaction struct {
@@ -19,8 +23,62 @@ type Transaction struct {
 	Balance   int64              `json:"balance,omitempty"`
 	From      string             `json:"from,omitempty"`
 	To        string             `json:"to,omitempty"`
+	Reference string             `json:"reference,omitempty"`
+	PocketID  string             `json:"pocket_id,omitempty"`
+	Memo      string             `json:"memo,omitempty"`
 	CreatedAt int64              `json:"created_at,omitempty"`
 	UpdatedAt int64              `json:"updated_at,omitempty"`
+
+	//Labels of each party, the sender and the recipient see their own ones as Category and Tags
+	FromCategory string   `json:"-"`
+	FromTags     []string `json:"-"`
+	ToCategory   string   `json:"-"`
+	ToTags       []string `json:"-"`
+	Category     string   `json:"category,omitempty" bson:"-"`
+	Tags         []string `json:"tags,omitempty" bson:"-"`
+}
+
+// ErrTransactionNotFound ...
+var ErrTransactionNotFound = errors.New("transaction not found")
+
+// typeCategories is the category a transaction gets from its type
+var typeCategories = map[string]string{
+	utils.TOP_UP:            utils.CATEGORY_TOP_UPS,
+	utils.WITHDRAW:          utils.CATEGORY_WITHDRAWALS,
+	utils.WITHDRAW_REVERSAL: utils.CATEGORY_WITHDRAWALS,
+	utils.TRANSFER:          utils.CATEGORY_TRANSFERS,
+	utils.INVOICE_PAYMENT:   utils.CATEGORY_SHOPPING,
+	utils.INVOICE_REFUND:    utils.CATEGORY_REFUNDS,
+	utils.MERCHANT_PAYOUT:   utils.CATEGORY_SALES,
+	utils.POCKET_DEPOSIT:    utils.CATEGORY_SAVINGS,
+	utils.POCKET_WITHDRAW:   utils.CATEGORY_SAVINGS,
+	utils.INTEREST:          utils.CATEGORY_INTEREST,
+	utils.VOUCHER:           utils.CATEGORY_REWARDS,
+	utils.CASHBACK:          utils.CATEGORY_REWARDS,
+	utils.CASHBACK_REVERSAL: utils.CATEGORY_REWARDS,
+}
+
+// Categorize returns the category a transaction of the type gets when it is created
+func Categorize(transactionType string) string {
+	if category, ok := typeCategories[transactionType]; ok {
+		return category
+	}
+	return utils.CATEGORY_OTHER
+}
+
+// For returns the transaction as username sees it, with their category and tags.
+// A transaction to oneself, like a top-up, carries its labels on the sender side
+func (t Transaction) For(username string) Transaction {
+	if t.From == username {
+		t.Category, t.Tags = t.FromCategory, t.FromTags
+	} else {
+		t.Category, t.Tags = t.ToCategory, t.ToTags
+	}
+	if t.Category == "" {
+		//Transactions created before categories
+		t.Category = Categorize(t.Type)
+	}
+	return t
 }
 
 // TransactionModel ...
@@ -32,26 +90,40 @@ func (m TransactionModel) Create(ctx context.Context, form forms.CreateTransacti
 
 	transactionCollection := db.GetCollection(db.DB, "transactions")
 
-	result, err := transactionCollection.InsertOne(ctx, Transaction{
-		Type:      form.Type,
-		Amount:    form.Amount,
-		Balance:   form.Balance,
-		From:      form.From,
-		To:        form.To,
-		CreatedAt: time.Now().Unix(),
-		UpdatedAt: time.Now().Unix(),
+	id := primitive.NewObjectID()
+	category := Categorize(form.Type)
+	_, err = transactionCollection.InsertOne(ctx, Transaction{
+		ID:           id,
+		Type:         form.Type,
+		Amount:       form.Amount,
+		Balance:      form.Balance,
+		From:         form.From,
+		To:           form.To,
+		Reference:    form.Reference,
+		PocketID:     form.PocketID,
+		Memo:         form.Memo,
+		CreatedAt:    time.Now().Unix(),
+		UpdatedAt:    time.Now().Unix(),
+		FromCategory: category,
+		ToCategory:   category,
 	})
 
 	if err != nil {
 		return transaction, errors.New("error when creating new transaction")
 	}
 
-	transaction.ID = result.InsertedID.(primitive.ObjectID)
+	transaction.ID = id
 	transaction.Amount = form.Amount
 	transaction.Type = form.Type
 	transaction.Balance = form.Balance
 	transaction.From = form.From
 	transaction.To = form.To
+	transaction.Reference = form.Reference
+	transaction.PocketID = form.PocketID
+	transaction.Memo = form.Memo
+	transaction.FromCategory = category
+	transaction.ToCategory = category
+	transaction.Category = category
 	transaction.CreatedAt = form.CreatedAt
 	transaction.UpdatedAt = form.UpdatedAt
 
@@ -80,8 +152,65 @@ func (m TransactionModel) Retrieve(ctx context.Context, user User) (transactions
 			return transactions, errors.New("error when decoding transaction")
 		}
 
-		transactions = append(transactions, transaction)
+		transactions = append(transactions, transaction.For(user.Username))
 	}
 
 	return transactions, nil
 }
+
+// Update edits the memo, category and tags of a transaction of the user. Tags are trimmed, lowercased and deduplicated
+func (m TransactionModel) Update(ctx context.Context, userID primitive.ObjectID, id primitive.ObjectID, form forms.UpdateTransactionForm) (transaction Transaction, err error) {
+	fmt.Println("Transaction model: Update")
+	userCollection := db.GetCollection(db.DB, "users")
+	transactionCollection := db.GetCollection(db.DB, "transactions")
+
+	var user User
+	err = userCollection.FindOne(ctx, bson.M{"id": userID}).Decode(&user)
+	if err != nil {
+		return transaction, errors.New("something went wrong, please try again later")
+	}
+
+	err = transactionCollection.FindOne(ctx, bson.M{"id": id, "$or": []bson.M{
+		{"from": user.Username},
+		{"to": user.Username},
+	}}).Decode(&transaction)
+	if err == mongo.ErrNoDocuments {
+		return transaction, ErrTransactionNotFound
+	}
+	if err != nil {
+		return transaction, errors.New("something went wrong, please try again later")
+	}
+
+	side := "to"
+	if transaction.From == user.Username {
+		side = "from"
+	}
+
+	update := bson.M{"updatedat": time.Now().Unix()}
+	if form.Memo != nil {
+		if side != "from" {
+			return transaction, errors.New("only the sender can edit the memo")
+		}
+		update["memo"] = strings.TrimSpace(*form.Memo)
+	}
+	if form.Category != "" {
+		update[side+"category"] = form.Category
+	}
+	if form.Tags != nil {
+		tags := []string{}
+		for _, tag := range form.Tags {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if tag != "" && !utils.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+		update[side+"tags"] = tags
+	}
+
+	err = transactionCollection.FindOneAndUpdate(ctx, bson.M{"id": transaction.ID}, bson.M{"$set": update}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&transaction)
+	if err != nil {
+		return transaction, errors.New("internal server error")
+	}
+
+	return transaction.For(user.Username), nil
+}
@@ -2,8 +2,10 @@ package models
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/Massad/gin-boilerplate/db"
@@ -28,6 +30,17 @@ type User struct {
 	UpdatedAt int64              `json:"updated_at,omitempty"`
 	CreatedAt int64              `json:"created_at,omitempty"`
 	Balance   int64              `json:"balance,omitempty"`
+	KYCLevel  int                `json:"kyc_level"`
+	ErasedAt  int64              `json:"-"` //Set when the user asked for the erasure of their personal data
+
+	//Profile, email and phone are only trusted once verified with a code sent to them
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified"`
+	Phone         string `json:"phone,omitempty"`
+	PhoneVerified bool   `json:"phone_verified"`
+	AvatarURL     string `json:"avatar_url,omitempty"`
+	Locale        string `json:"locale,omitempty"`
+	Timezone      string `json:"timezone,omitempty"`
 }
 
 // UserModel ...
@@ -35,27 +48,54 @@ type UserModel struct{}
 
 var authModel = new(AuthModel)
 var transactionModel = new(TransactionModel)
+var loginAttemptModel = new(LoginAttemptModel)
+var oauthClientModel = new(OAuthClientModel)
+var outboxModel = new(OutboxModel)
+var userModel = new(UserModel)
 
 // Login ...
-func (m UserModel) Login(form forms.LoginForm) (user User, token Token, err error) {
+func (m UserModel) Login(form forms.LoginForm, device SessionDevice) (user User, token Token, err error) {
 	fmt.Println("User model: Login")
 	userCollection := db.GetCollection(db.DB, "users")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	err = userCollection.FindOne(ctx, bson.M{"username": form.Username}).Decode(&user)
+	loginKey := LoginAttemptKey(form.Username, device.IP)
+	ipKey := IPAttemptKey(device.IP)
+
+	err = loginAttemptModel.Check(ctx, loginKey, ipKey)
 	if err != nil {
 		return user, token, err
 	}
 
+	err = userCollection.FindOne(ctx, userBy("username", form.Username)).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return user, token, err
+	}
+
+	//Unknown usernames are compared against a dummy hash so they take as long as a wrong password
+	byteHashedPassword := []byte(user.Password)
+	if err == mongo.ErrNoDocuments {
+		byteHashedPassword = dummyPasswordHash()
+	}
+
 	//Compare the password form and database if match
 	bytePassword := []byte(form.Password)
-	byteHashedPassword := []byte(user.Password)
 
 	err = bcrypt.CompareHashAndPassword(byteHashedPassword, bytePassword)
 
-	if err != nil {
+	if err != nil || user.ID.IsZero() {
+		if err = loginAttemptModel.Fail(ctx, loginKey, utils.GetEnvInt("LOGIN_MAX_ATTEMPTS", 5)); err != nil {
+			return User{}, token, err
+		}
+		if err = loginAttemptModel.Fail(ctx, ipKey, utils.GetEnvInt("LOGIN_MAX_IP_ATTEMPTS", 20)); err != nil {
+			return User{}, token, err
+		}
+		return User{}, token, ErrInvalidCredentials
+	}
+
+	if err = loginAttemptModel.Reset(ctx, loginKey); err != nil {
 		return user, token, err
 	}
 
@@ -65,15 +105,33 @@ func (m UserModel) Login(form forms.LoginForm) (user User, token Token, err erro
 		return user, token, err
 	}
 
-	saveErr := authModel.CreateAuth(user.ID.Hex(), tokenDetails)
-	if saveErr == nil {
-		token.AccessToken = tokenDetails.AccessToken
-		token.RefreshToken = tokenDetails.RefreshToken
+	device.Name = form.DeviceName
+	saveErr := authModel.CreateAuth(user.ID.Hex(), tokenDetails, device)
+	if saveErr != nil {
+		return user, token, saveErr
 	}
 
+	notificationModel.signedIn(ctx, user, device)
+
+	token.AccessToken = tokenDetails.AccessToken
+	token.RefreshToken = tokenDetails.RefreshToken
+
 	return user, token, nil
 }
 
+var dummyHashOnce sync.Once
+var dummyHash []byte
+
+// dummyPasswordHash returns a bcrypt hash of a random password with the same cost as real ones
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		password := make([]byte, 32)
+		rand.Read(password)
+		dummyHash, _ = bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	})
+	return dummyHash
+}
+
 // Register ...
 func (m UserModel) Register(form forms.RegisterForm) (user User, err error) {
 	//Check if the user exists in database
@@ -83,10 +141,7 @@ func (m UserModel) Register(form forms.RegisterForm) (user User, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	err = userCollection.FindOne(ctx, bson.D{{
-		Key:   "username",
-		Value: form.Username,
-	}}).Decode(&user)
+	err = userCollection.FindOne(ctx, userBy("username", form.Username)).Decode(&user)
 
 	if err != nil && err != mongo.ErrNoDocuments {
 		return user, errors.New("something went wrong, please try again later")
@@ -123,68 +178,38 @@ func (m UserModel) Register(form forms.RegisterForm) (user User, err error) {
 	return user, errors.New("username already existed")
 }
 
-func (m UserModel) TopUp(ctx context.Context, userID primitive.ObjectID, form forms.TopUpForm) (transaction Transaction, err error) {
-	//Check if the user exists in database
-	fmt.Println("User model: TopUp")
-
-	userCollection := db.GetCollection(db.GetDB(), "users")
-
-	wc := writeconcern.New(writeconcern.WMajority())
-	rc := readconcern.Snapshot()
-	txnOpts := options.Transaction().SetWriteConcern(wc).SetReadConcern(rc)
+// ChangePassword verifies the old password, stores the new one and revokes every other session of the user
+func (m UserModel) ChangePassword(ctx context.Context, userID primitive.ObjectID, accessUUID string, form forms.ChangePasswordForm) error {
+	fmt.Println("User model: ChangePassword")
+	userCollection := db.GetCollection(db.DB, "users")
 
-	session, err := db.DB.StartSession()
+	var user User
+	err := userCollection.FindOne(ctx, bson.M{"id": userID}).Decode(&user)
 	if err != nil {
-		return transaction, err
+		return errors.New("something went wrong, please try again later")
 	}
-	defer session.EndSession(ctx)
-
-	callback := func(sessionContext mongo.SessionContext) (interface{}, error) {
-		var user User
-		err = userCollection.FindOne(sessionContext, bson.M{"id": userID}).Decode(&user)
-		if err != nil && err != mongo.ErrNoDocuments {
-			return transaction, errors.New("something went wrong, please try again later")
-		}
-
-		now := time.Now().Unix()
 
-		update := bson.M{"balance": user.Balance + form.Amount, "updatedat": now}
-		result, err := userCollection.UpdateOne(sessionContext, bson.M{"id": userID}, bson.M{"$set": update})
-
-		if err != nil {
-			return transaction, errors.New("internal server error")
-		}
-
-		var updatedUser User
-
-		if result.MatchedCount == 1 {
-			err = userCollection.FindOne(sessionContext, bson.M{"id": userID}).Decode(&updatedUser)
-		}
-
-		if err != nil {
-			return transaction, errors.New("internal server error")
-		}
-
-		transaction, err = transactionModel.Create(ctx, forms.CreateTransactionForm{
-			From:      updatedUser.Username,
-			To:        updatedUser.Username,
-			Amount:    form.Amount,
-			Balance:   updatedUser.Balance,
-			Type:      utils.TOP_UP,
-			CreatedAt: now,
-			UpdatedAt: now,
-		})
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(form.OldPassword))
+	if err != nil {
+		return errors.New("your old password is incorrect")
+	}
 
-		return transaction, err
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(form.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.New("something went wrong, please try again later")
 	}
 
-	data, err := session.WithTransaction(ctx, callback, txnOpts)
-	v, _ := data.(Transaction)
+	_, err = userCollection.UpdateOne(ctx, bson.M{"id": userID}, bson.M{"$set": bson.M{"password": string(hashedPassword), "updatedat": time.Now().Unix()}})
+	if err != nil {
+		return errors.New("internal server error")
+	}
 
-	return v, err
+	return authModel.RevokeAuth(ctx, userID, accessUUID)
 }
 
-func (m UserModel) WithDraw(ctx context.Context, userID primitive.ObjectID, form forms.WithDrawForm) (transaction Transaction, err error) {
+// WithDraw takes the amount from the main balance or a pocket and creates the payout sending it to a saved bank account.
+// The payout settles asynchronously, see PayoutModel
+func (m UserModel) WithDraw(ctx context.Context, userID primitive.ObjectID, form forms.WithDrawForm) (payout Payout, err error) {
 	//Check if the user exists in database
 	fmt.Println("User model: WithDraw")
 
@@ -195,131 +220,235 @@ func (m UserModel) WithDraw(ctx context.Context, userID primitive.ObjectID, form
 
 	session, err := db.DB.StartSession()
 	if err != nil {
-		return transaction, err
+		return payout, err
 	}
 	defer session.EndSession(ctx)
 
 	callback := func(sessionContext mongo.SessionContext) (interface{}, error) {
 		var user User
 		err = userCollection.FindOne(sessionContext, bson.M{"id": userID}).Decode(&user)
-		if err != nil && err != mongo.ErrNoDocuments {
-			return user, errors.New("something went wrong, please try again later")
-		}
-
-		if user.Balance < form.Amount {
-			return user, errors.New("your balance is not enough to withdraw")
+		if err != nil {
+			return payout, errors.New("something went wrong, please try again later")
 		}
 
-		now := time.Now().Unix()
-
-		update := bson.M{"balance": user.Balance - form.Amount, "updatedat": now}
-		result, err := userCollection.UpdateOne(sessionContext, bson.M{"id": userID}, bson.M{"$set": update})
-
+		account, err := bankAccountModel.Find(sessionContext, userID, form.BankAccount)
 		if err != nil {
-			return user, errors.New("internal server error")
+			return payout, err
 		}
 
-		//get updated user details
-		var updatedUser User
-		if result.MatchedCount == 1 {
-			err = userCollection.FindOne(sessionContext, bson.M{"id": userID}).Decode(&updatedUser)
+		if err = kycModel.checkOutgoing(sessionContext, user, form.Amount); err != nil {
+			return payout, err
 		}
 
-		if err != nil {
-			return user, errors.New("internal server error")
+		now := time.Now().Unix()
+		var balance int64
+		pocketID := ""
+
+		//Withdraw from a pocket, the transaction records the pocket balance
+		if form.Pocket != "" {
+			pocket, err := pocketModel.debit(sessionContext, userID, form.Pocket, form.Amount)
+			if err != nil {
+				return payout, err
+			}
+			balance = pocket.Balance - form.Amount
+			pocketID = pocket.ID.Hex()
+		} else {
+			if user.Balance < form.Amount {
+				return payout, errors.New("your balance is not enough to withdraw")
+			}
+
+			balance = user.Balance - form.Amount
+			_, err = userCollection.UpdateOne(sessionContext, bson.M{"id": userID}, bson.M{"$set": bson.M{"balance": balance, "updatedat": now}})
+			if err != nil {
+				return payout, errors.New("internal server error")
+			}
 		}
 
-		transaction, err = transactionModel.Create(sessionContext, forms.CreateTransactionForm{
-			From:      updatedUser.Username,
-			To:        updatedUser.Username,
+		payoutID := primitive.NewObjectID()
+		transaction, err := transactionModel.Create(sessionContext, forms.CreateTransactionForm{
+			From:      user.Username,
+			To:        user.Username,
 			Amount:    form.Amount,
-			Balance:   updatedUser.Balance,
+			Balance:   balance,
 			Type:      utils.WITHDRAW,
+			Reference: "payout:" + payoutID.Hex(),
+			PocketID:  pocketID,
 			CreatedAt: now,
 			UpdatedAt: now,
 		})
+		if err != nil {
+			return payout, err
+		}
 
-		return transaction, err
+		payout, err := payoutModel.create(sessionContext, payoutID, userID, account, form.Amount, pocketID, transaction.ID)
+		if err != nil {
+			return payout, err
+		}
+
+		err = outboxModel.Add(sessionContext, utils.EVENT_WITHDRAW, []string{user.Username}, transaction)
+
+		return payout, err
 	}
 	data, err := session.WithTransaction(ctx, callback, txnOpts)
-	v, _ := data.(Transaction)
+	v, _ := data.(Payout)
 
 	return v, err
 }
 
-func (m UserModel) Details(userId primitive.ObjectID, ctx context.Context) (transactions []Transaction, err error) {
+// UserDetails is the breakdown of the money of a user: the main balance, the pockets and their total
+type UserDetails struct {
+	Balance      int64         `json:"balance"`
+	Pockets      []Pocket      `json:"pockets"`
+	Total        int64         `json:"total"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+func (m UserModel) Details(userId primitive.ObjectID, ctx context.Context) (details UserDetails, err error) {
 	fmt.Println("User model: Details")
 	userCollection := db.GetCollection(db.DB, "users")
 	var user User
 
 	err = userCollection.FindOne(ctx, bson.M{"id": userId}).Decode(&user)
 	if err != nil {
-		return transactions, err
+		return details, err
 	}
 
-	transactions, err = transactionModel.Retrieve(ctx, user)
+	details.Transactions, err = transactionModel.Retrieve(ctx, user)
+	if err != nil {
+		return details, err
+	}
 
-	return transactions, err
+	details.Pockets, err = pocketModel.List(ctx, user.ID)
+	if err != nil {
+		return details, err
+	}
+
+	details.Balance = user.Balance
+	details.Total = user.Balance
+	for _, pocket := range details.Pockets {
+		details.Total += pocket.Balance
+	}
+
+	return details, nil
 }
 
 func (m UserModel) Transfer(ctx context.Context, userId primitive.ObjectID, form forms.TransferForm) (transaction Transaction, err error) {
 	fmt.Println("User model: Transfer")
-	userCollection := db.GetCollection(db.DB, "users")
 
-	wc := writeconcern.New(writeconcern.WMajority())
-	rc := readconcern.Snapshot()
-	txnOpts := options.Transaction().SetWriteConcern(wc).SetReadConcern(rc)
+	data, err := withTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		return m.transfer(sessionContext, userId, form.To, form.Amount, "", form.Memo, form.Pocket)
+	})
+	value, _ := data.(Transaction)
 
-	session, err := db.DB.StartSession()
+	return value, err
+}
+
+// RejectedError is a transfer refused for a business reason: an unknown recipient, a balance too low, or a refusal
+// of the KYC limits or of the beneficiary restriction. Trying again can't succeed until the situation changes,
+// unlike a failure of the database
+type RejectedError struct {
+	Message string
+}
+
+func (e *RejectedError) Error() string {
+	return e.Message
+}
+
+// rejected returns a *RejectedError with the formatted message
+func rejected(format string, a ...interface{}) error {
+	return &RejectedError{Message: fmt.Sprintf(format, a...)}
+}
+
+// IsRejected reports whether err is a *RejectedError
+func IsRejected(err error) bool {
+	var r *RejectedError
+	return errors.As(err, &r)
+}
+
+// transfer moves amount from the user to the username inside the caller's Mongo transaction and records it.
+// reference links the transaction to what caused it, e.g. "payment_request:<id>", memo is the note of the sender.
+// The money comes from the main balance, or from the pocket with the hex ID pocket when given
+func (m UserModel) transfer(sessionContext mongo.SessionContext, userId primitive.ObjectID, to string, amount int64, reference string, memo string, pocket string) (transaction Transaction, err error) {
+	userCollection := db.GetCollection(db.DB, "users")
+
+	var source, target User
+	err = userCollection.FindOne(sessionContext, bson.M{"id": userId}).Decode(&source)
 	if err != nil {
 		return transaction, err
 	}
-	defer session.EndSession(ctx)
 
-	callback := func(sessionContext mongo.SessionContext) (interface{}, error) {
-		var source, target User
-		err = userCollection.FindOne(ctx, bson.M{"id": userId}).Decode(&source)
-		if err != nil {
-			return transaction, err
-		}
+	err = userCollection.FindOne(sessionContext, userBy("username", to)).Decode(&target)
 
-		err = userCollection.FindOne(ctx, bson.M{"username": form.To}).Decode(&target)
+	if err != nil || target.ErasedAt != 0 {
+		return transaction, rejected("target user not existed")
+	}
 
-		if err != nil {
-			return transaction, errors.New("target user not existed")
-		}
+	if source.ID == target.ID {
+		return transaction, rejected("you can not transfer to yourself")
+	}
 
-		if source.Balance < form.Amount {
-			return transaction, errors.New("your balance is not enough to execute the transaction")
-		}
-		now := time.Now().Unix()
+	if err = beneficiaryModel.checkTransfer(sessionContext, source.ID, target.ID); err != nil {
+		return transaction, err
+	}
+
+	if err = kycModel.checkOutgoing(sessionContext, source, amount); err != nil {
+		return transaction, err
+	}
+	if err = kycModel.checkIncoming(sessionContext, target, amount, false); err != nil {
+		return transaction, err
+	}
 
-		sourceUpdate, err := userCollection.UpdateOne(sessionContext, bson.M{"id": source.ID}, bson.M{"$set": bson.M{"balance": source.Balance - form.Amount, "updatedat": now}})
+	now := time.Now().Unix()
+	balance := source.Balance
+	pocketID := ""
 
+	if pocket != "" {
+		sourcePocket, err := pocketModel.debit(sessionContext, source.ID, pocket, amount)
 		if err != nil {
-			return sourceUpdate, errors.New("internal server error")
+			return transaction, err
+		}
+		balance = sourcePocket.Balance
+		pocketID = sourcePocket.ID.Hex()
+	} else {
+		if source.Balance < amount {
+			return transaction, rejected("your balance is not enough to execute the transaction")
 		}
 
-		targetUpdate, err := userCollection.UpdateOne(sessionContext, bson.M{"id": target.ID}, bson.M{"$set": bson.M{"balance": target.Balance + form.Amount, "updatedat": now}})
+		_, err = userCollection.UpdateOne(sessionContext, bson.M{"id": source.ID}, bson.M{"$set": bson.M{"balance": source.Balance - amount, "updatedat": now}})
 
 		if err != nil {
-			return targetUpdate, errors.New("internal server error")
+			return transaction, errors.New("internal server error")
 		}
-		
-		transaction, err = transactionModel.Create(sessionContext, forms.CreateTransactionForm{
-			From:      source.Username,
-			To:        form.To,
-			Amount:    form.Amount,
-			Balance:   source.Balance,
-			Type:      utils.TRANSFER,
-			CreatedAt: now,
-			UpdatedAt: now,
-		})
-		
+	}
+
+	_, err = userCollection.UpdateOne(sessionContext, bson.M{"id": target.ID}, bson.M{"$set": bson.M{"balance": target.Balance + amount, "updatedat": now}})
+
+	if err != nil {
+		return transaction, errors.New("internal server error")
+	}
+
+	transaction, err = transactionModel.Create(sessionContext, forms.CreateTransactionForm{
+		From:      source.Username,
+		To:        target.Username,
+		Amount:    amount,
+		Balance:   balance,
+		Type:      utils.TRANSFER,
+		Reference: reference,
+		PocketID:  pocketID,
+		Memo:      memo,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
 		return transaction, err
 	}
-	data, err := session.WithTransaction(ctx, callback, txnOpts)
-	value, _ := data.(Transaction)
 
-	return value, err
-}
\ No newline at end of file
+	err = outboxModel.Add(sessionContext, utils.EVENT_TRANSFER, []string{source.Username, target.Username}, transaction)
+	if err != nil {
+		return transaction, err
+	}
+
+	err = cashbackModel.apply(sessionContext, utils.TRANSFER, source.ID, amount, transaction.ID)
+
+	return transaction, err
+}
@@ -0,0 +1,82 @@
+//go:build all
+// +build all
+
+package models
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/utils"
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var testEnvOnce sync.Once
+
+// loadTestEnv reads the .env file of the application. The tests run in models/, so the relative paths
+// of the key files are made relative to the root of the repository
+func loadTestEnv() {
+	testEnvOnce.Do(func() {
+		godotenv.Load("../.env")
+		for _, name := range []string{"ENCRYPTION_KEY_FILE", "JWT_KEY_DIR"} {
+			if path := os.Getenv(name); path != "" && !filepath.IsAbs(path) {
+				os.Setenv(name, filepath.Join("..", path))
+			}
+		}
+	})
+}
+
+// newTestUser registers a user with a random username, the balance and no KYC limits
+func newTestUser(t *testing.T, balance int64) User {
+	t.Helper()
+	loadTestEnv()
+
+	token, err := generateHexToken(3)
+	require.NoError(t, err)
+
+	user, err := userModel.Register(forms.RegisterForm{Username: token[:5], Name: "Test User", Password: "Test-password-1"})
+	require.NoError(t, err)
+
+	_, err = db.GetCollection(db.DB, "users").UpdateOne(context.Background(), bson.M{"id": user.ID},
+		bson.M{"$set": bson.M{"balance": balance, "kyclevel": utils.KYC_ADDRESS}})
+	require.NoError(t, err)
+
+	user.Balance = balance
+	user.KYCLevel = utils.KYC_ADDRESS
+	return user
+}
+
+// testBalance reads the main balance of the user
+func testBalance(t *testing.T, userID interface{}) int64 {
+	t.Helper()
+
+	var user User
+	err := db.GetCollection(db.DB, "users").FindOne(context.Background(), bson.M{"id": userID}).Decode(&user)
+	require.NoError(t, err)
+	return user.Balance
+}
+
+// newFullTestUser registers a user at KYC level 0 whose balance is at the limit of the level,
+// so they can't receive any money
+func newFullTestUser(t *testing.T) User {
+	t.Helper()
+	loadTestEnv()
+
+	limits, err := kycLimits(0)
+	require.NoError(t, err)
+	require.NotZero(t, limits.BalanceLimit, "the tests need a balance limit at KYC level 0")
+
+	user := newTestUser(t, limits.BalanceLimit)
+	_, err = db.GetCollection(db.DB, "users").UpdateOne(context.Background(), bson.M{"id": user.ID}, bson.M{"$set": bson.M{"kyclevel": 0}})
+	require.NoError(t, err)
+
+	user.KYCLevel = 0
+	return user
+}
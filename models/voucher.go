@@ -0,0 +1,298 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Voucher is a code crediting Amount from the marketing account, up to MaxRedemptions times in total
+// and PerUserLimit times per user
+type Voucher struct {
+	ID             primitive.ObjectID `json:"id"`
+	Code           string             `json:"code"`
+	Amount         int64              `json:"amount"`
+	MaxRedemptions int64              `json:"max_redemptions"`
+	PerUserLimit   int64              `json:"per_user_limit"`
+	Redemptions    int64              `json:"redemptions"`
+	ExpiresAt      int64              `json:"expires_at"`
+	CreatedAt      int64              `json:"created_at"`
+	UpdatedAt      int64              `json:"updated_at"`
+}
+
+// VoucherRedemption ...
+type VoucherRedemption struct {
+	ID            primitive.ObjectID `json:"id"`
+	VoucherID     primitive.ObjectID `json:"voucher_id"`
+	UserID        primitive.ObjectID `json:"-"`
+	TransactionID primitive.ObjectID `json:"transaction_id"`
+	CreatedAt     int64              `json:"created_at"`
+}
+
+// ErrNotMarketing is returned when someone else than the marketing account manages promotions
+var ErrNotMarketing = errors.New("only the marketing account can manage promotions")
+
+// ErrPromotionBudget is returned when the marketing account can not fund a reward
+var ErrPromotionBudget = errors.New("the promotion budget is exhausted, please try again later")
+
+// ErrVoucherNotFound ...
+var ErrVoucherNotFound = errors.New("voucher not found")
+
+// VoucherModel ...
+type VoucherModel struct{}
+
+// marketingAccount returns the user funding vouchers and cashback, its username is MARKETING_USERNAME
+func marketingAccount(ctx context.Context) (user User, err error) {
+	userCollection := db.GetCollection(db.DB, "users")
+
+	username := os.Getenv("MARKETING_USERNAME")
+	if username == "" {
+		return user, errors.New("promotions are not configured")
+	}
+
+	err = userCollection.FindOne(ctx, userBy("username", username)).Decode(&user)
+	if err != nil {
+		return user, errors.New("promotions are not configured")
+	}
+
+	return user, nil
+}
+
+// checkMarketing returns ErrNotMarketing unless the user is the marketing account
+func checkMarketing(ctx context.Context, userID primitive.ObjectID) error {
+	marketing, err := marketingAccount(ctx)
+	if err != nil {
+		return err
+	}
+	if marketing.ID != userID {
+		return ErrNotMarketing
+	}
+	return nil
+}
+
+// reward moves amount from the marketing account to the user as a transaction of rewardType (VOUCHER or CASHBACK),
+// ctx must be the session context of a transaction. The transaction records the balance of the user after the reward
+func reward(ctx context.Context, marketing User, userID primitive.ObjectID, amount int64, rewardType string, reference string) (transaction Transaction, err error) {
+	userCollection := db.GetCollection(db.DB, "users")
+
+	//Balances are read again, the caller may have just changed them
+	err = userCollection.FindOne(ctx, bson.M{"id": marketing.ID}).Decode(&marketing)
+	if err != nil {
+		return transaction, errors.New("something went wrong, please try again later")
+	}
+	if marketing.Balance < amount {
+		return transaction, ErrPromotionBudget
+	}
+
+	var user User
+	err = userCollection.FindOne(ctx, bson.M{"id": userID}).Decode(&user)
+	if err != nil {
+		return transaction, errors.New("something went wrong, please try again later")
+	}
+
+	if err = kycModel.checkIncoming(ctx, user, amount, true); err != nil {
+		return transaction, err
+	}
+
+	now := time.Now().Unix()
+	_, err = userCollection.UpdateOne(ctx, bson.M{"id": marketing.ID}, bson.M{"$set": bson.M{"balance": marketing.Balance - amount, "updatedat": now}})
+	if err != nil {
+		return transaction, errors.New("internal server error")
+	}
+	_, err = userCollection.UpdateOne(ctx, bson.M{"id": user.ID}, bson.M{"$set": bson.M{"balance": user.Balance + amount, "updatedat": now}})
+	if err != nil {
+		return transaction, errors.New("internal server error")
+	}
+
+	transaction, err = transactionModel.Create(ctx, forms.CreateTransactionForm{
+		From:      marketing.Username,
+		To:        user.Username,
+		Amount:    amount,
+		Balance:   user.Balance + amount,
+		Type:      rewardType,
+		Reference: reference,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		return transaction, err
+	}
+
+	err = outboxModel.Add(ctx, utils.EVENT_REWARD, []string{user.Username}, transaction)
+
+	return transaction, err
+}
+
+// EnsureIndexes creates the unique index on the code of the vouchers
+func (m VoucherModel) EnsureIndexes(ctx context.Context) error {
+	voucherCollection := db.GetCollection(db.DB, "vouchers")
+
+	_, err := voucherCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"code": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// Create ...
+func (m VoucherModel) Create(ctx context.Context, userID primitive.ObjectID, form forms.CreateVoucherForm) (voucher Voucher, err error) {
+	fmt.Println("Voucher model: Create")
+	voucherCollection := db.GetCollection(db.DB, "vouchers")
+
+	if err = checkMarketing(ctx, userID); err != nil {
+		return voucher, err
+	}
+
+	code := strings.ToUpper(form.Code)
+	if code == "" {
+		token, err := generateHexToken(5)
+		if err != nil {
+			return voucher, errors.New("something went wrong, please try again later")
+		}
+		code = strings.ToUpper(token)
+	}
+
+	ttl := utils.GetEnvDuration("VOUCHER_TTL", 30*24*time.Hour)
+	if form.ExpiresInHours > 0 {
+		ttl = time.Duration(form.ExpiresInHours) * time.Hour
+	}
+
+	now := time.Now().Unix()
+	voucher = Voucher{
+		ID:             primitive.NewObjectID(),
+		Code:           code,
+		Amount:         form.Amount,
+		MaxRedemptions: form.MaxRedemptions,
+		PerUserLimit:   form.PerUserLimit,
+		ExpiresAt:      time.Now().Add(ttl).Unix(),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if voucher.MaxRedemptions == 0 {
+		voucher.MaxRedemptions = 1
+	}
+	if voucher.PerUserLimit == 0 {
+		voucher.PerUserLimit = 1
+	}
+
+	//The unique index on the code refuses a code already used, see EnsureIndexes
+	_, err = voucherCollection.InsertOne(ctx, voucher)
+	if mongo.IsDuplicateKeyError(err) {
+		return voucher, errors.New("the voucher code is already used")
+	}
+	if err != nil {
+		return voucher, errors.New("error when creating new voucher")
+	}
+
+	return voucher, nil
+}
+
+// List returns the vouchers, newest first
+func (m VoucherModel) List(ctx context.Context, userID primitive.ObjectID, query Query) (vouchers []Voucher, err error) {
+	fmt.Println("Voucher model: List")
+	voucherCollection := db.GetCollection(db.DB, "vouchers")
+
+	if err = checkMarketing(ctx, userID); err != nil {
+		return vouchers, err
+	}
+
+	opts := options.Find().SetSort(bson.M{"createdat": -1}).SetSkip(int64((query.Page - 1) * query.Limit)).SetLimit(int64(query.Limit))
+	results, err := voucherCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return vouchers, errors.New("error when retrieving vouchers")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var voucher Voucher
+		if err = results.Decode(&voucher); err != nil {
+			return vouchers, errors.New("error when decoding voucher")
+		}
+
+		vouchers = append(vouchers, voucher)
+	}
+
+	return vouchers, nil
+}
+
+// Redeem credits the amount of the voucher to the user. The redemption counter is taken with a conditional
+// update in the same Mongo transaction as the credit, so concurrent redemptions never exceed the caps
+func (m VoucherModel) Redeem(ctx context.Context, userID primitive.ObjectID, code string) (transaction Transaction, err error) {
+	fmt.Println("Voucher model: Redeem")
+	voucherCollection := db.GetCollection(db.DB, "vouchers")
+	redemptionCollection := db.GetCollection(db.DB, "voucher_redemptions")
+
+	code = strings.ToUpper(code)
+
+	data, err := withTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		marketing, err := marketingAccount(sessionContext)
+		if err != nil {
+			return transaction, err
+		}
+		if marketing.ID == userID {
+			return transaction, errors.New("the marketing account can not redeem vouchers")
+		}
+
+		var voucher Voucher
+		now := time.Now().Unix()
+		err = voucherCollection.FindOneAndUpdate(sessionContext,
+			bson.M{"code": code, "expiresat": bson.M{"$gt": now}, "$expr": bson.M{"$lt": bson.A{"$redemptions", "$maxredemptions"}}},
+			bson.M{"$inc": bson.M{"redemptions": 1}, "$set": bson.M{"updatedat": now}}).Decode(&voucher)
+		if err == mongo.ErrNoDocuments {
+			err = voucherCollection.FindOne(sessionContext, bson.M{"code": code}).Decode(&voucher)
+			if err == mongo.ErrNoDocuments {
+				return transaction, ErrVoucherNotFound
+			}
+			if err != nil {
+				return transaction, errors.New("something went wrong, please try again later")
+			}
+			if voucher.ExpiresAt <= now {
+				return transaction, errors.New("the voucher has expired")
+			}
+			return transaction, errors.New("the voucher has been fully redeemed")
+		}
+		if err != nil {
+			return transaction, errors.New("something went wrong, please try again later")
+		}
+
+		count, err := redemptionCollection.CountDocuments(sessionContext, bson.M{"voucherid": voucher.ID, "userid": userID})
+		if err != nil {
+			return transaction, errors.New("something went wrong, please try again later")
+		}
+		if count >= voucher.PerUserLimit {
+			return transaction, errors.New("you have already redeemed this voucher")
+		}
+
+		transaction, err := reward(sessionContext, marketing, userID, voucher.Amount, utils.VOUCHER, "voucher:"+voucher.Code)
+		if err != nil {
+			return transaction, err
+		}
+
+		_, err = redemptionCollection.InsertOne(sessionContext, VoucherRedemption{
+			ID:            primitive.NewObjectID(),
+			VoucherID:     voucher.ID,
+			UserID:        userID,
+			TransactionID: transaction.ID,
+			CreatedAt:     now,
+		})
+		if err != nil {
+			return transaction, errors.New("internal server error")
+		}
+
+		return transaction, nil
+	})
+	transaction, _ = data.(Transaction)
+
+	return transaction, err
+}
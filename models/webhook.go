@@ -0,0 +1,320 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/db"
+	"github.com/Massad/gin-boilerplate/forms"
+	"github.com/Massad/gin-boilerplate/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// WebhookEndpoint receives the events of its owner. Secret signs every delivery (HMAC-SHA256)
+type WebhookEndpoint struct {
+	ID        primitive.ObjectID `json:"id"`
+	UserID    primitive.ObjectID `json:"-"`
+	Username  string             `json:"-"`
+	URL       string             `json:"url"`
+	Events    []string           `json:"events"`
+	Secret    string             `json:"-"`
+	CreatedAt int64              `json:"created_at"`
+}
+
+// WebhookAttempt is one HTTP call of a delivery
+type WebhookAttempt struct {
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+// WebhookDelivery is an event to send to one endpoint, with the log of every attempt
+type WebhookDelivery struct {
+	ID            primitive.ObjectID `json:"id"`
+	EventID       primitive.ObjectID `json:"event_id"`
+	EventType     string             `json:"event_type"`
+	EndpointID    primitive.ObjectID `json:"endpoint_id"`
+	UserID        primitive.ObjectID `json:"-"`
+	Body          []byte             `json:"-"`
+	Status        string             `json:"status"`
+	RetryCount    int                `json:"retry_count"`
+	Attempts      []WebhookAttempt   `json:"attempts"`
+	NextAttemptAt int64              `json:"next_attempt_at,omitempty"`
+	CreatedAt     int64              `json:"created_at"`
+	UpdatedAt     int64              `json:"updated_at"`
+}
+
+// ErrWebhookNotFound ...
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// ErrDeliveryNotFound ...
+var ErrDeliveryNotFound = errors.New("delivery not found")
+
+// WebhookModel ...
+type WebhookModel struct{}
+
+// deliveryLease is how long a dispatcher owns a claimed delivery before another one may retry it
+const deliveryLease = 60
+
+// webhookRetryDelay is the wait before the next attempt: base, 2*base, 4*base... capped at 6 hours
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := utils.GetEnvDuration("WEBHOOK_RETRY_BASE", 30*time.Second)
+	for i := 1; i < attempts && delay < 6*time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > 6*time.Hour {
+		delay = 6 * time.Hour
+	}
+	return delay
+}
+
+// Create registers an endpoint, the signing secret is only returned here
+func (m WebhookModel) Create(ctx context.Context, userID primitive.ObjectID, form forms.CreateWebhookForm) (endpoint WebhookEndpoint, err error) {
+	fmt.Println("Webhook model: Create")
+	userCollection := db.GetCollection(db.DB, "users")
+	webhookCollection := db.GetCollection(db.DB, "webhooks")
+
+	var user User
+	err = userCollection.FindOne(ctx, bson.M{"id": userID}).Decode(&user)
+	if err != nil {
+		return endpoint, errors.New("something went wrong, please try again later")
+	}
+
+	secret, err := generateToken(32)
+	if err != nil {
+		return endpoint, errors.New("something went wrong, please try again later")
+	}
+
+	endpoint = WebhookEndpoint{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Username:  user.Username,
+		URL:       form.URL,
+		Events:    form.Events,
+		Secret:    "whsec_" + secret,
+		CreatedAt: time.Now().Unix(),
+	}
+
+	_, err = webhookCollection.InsertOne(ctx, endpoint)
+	if err != nil {
+		return endpoint, errors.New("error when creating new webhook")
+	}
+
+	return endpoint, nil
+}
+
+// List ...
+func (m WebhookModel) List(ctx context.Context, userID primitive.ObjectID) (endpoints []WebhookEndpoint, err error) {
+	fmt.Println("Webhook model: List")
+	webhookCollection := db.GetCollection(db.DB, "webhooks")
+
+	results, err := webhookCollection.Find(ctx, bson.M{"userid": userID})
+	if err != nil {
+		return endpoints, errors.New("error when retrieving webhooks")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var endpoint WebhookEndpoint
+		if err = results.Decode(&endpoint); err != nil {
+			return endpoints, errors.New("error when decoding webhook")
+		}
+
+		endpoints = append(endpoints, endpoint)
+	}
+
+	return endpoints, nil
+}
+
+// Delete removes the endpoint, its pending deliveries are dropped
+func (m WebhookModel) Delete(ctx context.Context, userID primitive.ObjectID, endpointID primitive.ObjectID) error {
+	fmt.Println("Webhook model: Delete")
+	webhookCollection := db.GetCollection(db.DB, "webhooks")
+	deliveryCollection := db.GetCollection(db.DB, "webhook_deliveries")
+
+	result, err := webhookCollection.DeleteOne(ctx, bson.M{"id": endpointID, "userid": userID})
+	if err != nil {
+		return errors.New("internal server error")
+	}
+	if result.DeletedCount == 0 {
+		return ErrWebhookNotFound
+	}
+
+	_, err = deliveryCollection.DeleteMany(ctx, bson.M{"endpointid": endpointID, "status": utils.DELIVERY_PENDING})
+	return err
+}
+
+// Deliveries returns the delivery log of an endpoint, newest first, optionally filtered by status
+func (m WebhookModel) Deliveries(ctx context.Context, userID primitive.ObjectID, endpointID primitive.ObjectID, status string, query Query) (deliveries []WebhookDelivery, err error) {
+	fmt.Println("Webhook model: Deliveries")
+	deliveryCollection := db.GetCollection(db.DB, "webhook_deliveries")
+
+	filter := bson.M{"endpointid": endpointID, "userid": userID}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	opts := options.Find().SetSort(bson.M{"createdat": -1}).SetSkip(int64((query.Page - 1) * query.Limit)).SetLimit(int64(query.Limit))
+	results, err := deliveryCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return deliveries, errors.New("error when retrieving deliveries")
+	}
+
+	defer results.Close(ctx)
+	for results.Next(ctx) {
+		var delivery WebhookDelivery
+		if err = results.Decode(&delivery); err != nil {
+			return deliveries, errors.New("error when decoding delivery")
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+// Replay schedules a delivery again right away with a fresh retry budget, whatever its status (including dead letters)
+func (m WebhookModel) Replay(ctx context.Context, userID primitive.ObjectID, endpointID primitive.ObjectID, deliveryID primitive.ObjectID) error {
+	fmt.Println("Webhook model: Replay")
+	deliveryCollection := db.GetCollection(db.DB, "webhook_deliveries")
+
+	now := time.Now().Unix()
+	result, err := deliveryCollection.UpdateOne(ctx, bson.M{"id": deliveryID, "endpointid": endpointID, "userid": userID}, bson.M{"$set": bson.M{
+		"status":        utils.DELIVERY_PENDING,
+		"retrycount":    0,
+		"nextattemptat": now,
+		"updatedat":     now,
+	}})
+	if err != nil {
+		return errors.New("internal server error")
+	}
+	if result.MatchedCount == 0 {
+		return ErrDeliveryNotFound
+	}
+
+	return nil
+}
+
+// EnsureIndexes creates the unique index keeping a single delivery of an event per endpoint,
+// and the index ClaimDue finds the due deliveries with
+func (m WebhookModel) EnsureIndexes(ctx context.Context) error {
+	deliveryCollection := db.GetCollection(db.DB, "webhook_deliveries")
+
+	_, err := deliveryCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "eventid", Value: 1}, {Key: "endpointid", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextattemptat", Value: 1}},
+		},
+	})
+	return err
+}
+
+// FanOut creates one delivery per endpoint subscribed to the event.
+// Deliveries are keyed by event and endpoint with a unique index, so fanning out the same event twice is harmless
+func (m WebhookModel) FanOut(ctx context.Context, event OutboxEvent) error {
+	webhookCollection := db.GetCollection(db.DB, "webhooks")
+	deliveryCollection := db.GetCollection(db.DB, "webhook_deliveries")
+
+	body, err := event.Envelope()
+	if err != nil {
+		return err
+	}
+
+	results, err := webhookCollection.Find(ctx, bson.M{"username": bson.M{"$in": event.Usernames}, "events": event.Type})
+	if err != nil {
+		return err
+	}
+
+	var endpoints []WebhookEndpoint
+	if err = results.All(ctx, &endpoints); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, endpoint := range endpoints {
+		_, err = deliveryCollection.UpdateOne(ctx,
+			bson.M{"eventid": event.ID, "endpointid": endpoint.ID},
+			bson.M{"$setOnInsert": WebhookDelivery{
+				ID:            primitive.NewObjectID(),
+				EventID:       event.ID,
+				EventType:     event.Type,
+				EndpointID:    endpoint.ID,
+				UserID:        endpoint.UserID,
+				Body:          body,
+				Status:        utils.DELIVERY_PENDING,
+				Attempts:      []WebhookAttempt{},
+				NextAttemptAt: now,
+				CreatedAt:     now,
+				UpdatedAt:     now,
+			}},
+			options.Update().SetUpsert(true))
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ClaimDue leases the next pending delivery whose attempt is due, together with its endpoint.
+// It returns mongo.ErrNoDocuments when there is nothing to send, and ErrWebhookNotFound when
+// the claimed delivery was dropped because its endpoint no longer exists
+func (m WebhookModel) ClaimDue(ctx context.Context) (delivery WebhookDelivery, endpoint WebhookEndpoint, err error) {
+	webhookCollection := db.GetCollection(db.DB, "webhooks")
+	deliveryCollection := db.GetCollection(db.DB, "webhook_deliveries")
+
+	now := time.Now().Unix()
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"nextattemptat": 1}).SetReturnDocument(options.After)
+	err = deliveryCollection.FindOneAndUpdate(ctx,
+		bson.M{"status": utils.DELIVERY_PENDING, "nextattemptat": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"nextattemptat": now + deliveryLease}},
+		opts).Decode(&delivery)
+	if err != nil {
+		return delivery, endpoint, err
+	}
+
+	err = webhookCollection.FindOne(ctx, bson.M{"id": delivery.EndpointID}).Decode(&endpoint)
+	if err == mongo.ErrNoDocuments {
+		//The endpoint was deleted after the fan-out
+		deliveryCollection.DeleteOne(ctx, bson.M{"id": delivery.ID})
+		return delivery, endpoint, ErrWebhookNotFound
+	}
+
+	return delivery, endpoint, err
+}
+
+// RecordAttempt appends the attempt to the delivery log and moves the delivery to its next state:
+// succeeded, retried later with exponential backoff, or dead-lettered after the last attempt
+func (m WebhookModel) RecordAttempt(ctx context.Context, delivery WebhookDelivery, attempt WebhookAttempt) error {
+	deliveryCollection := db.GetCollection(db.DB, "webhook_deliveries")
+
+	attempts := delivery.RetryCount + 1
+	update := bson.M{"updatedat": attempt.CreatedAt}
+
+	switch {
+	case attempt.Error == "" && attempt.StatusCode >= 200 && attempt.StatusCode < 300:
+		update["status"] = utils.DELIVERY_SUCCEEDED
+		update["nextattemptat"] = int64(0)
+	case attempts >= utils.GetEnvInt("WEBHOOK_MAX_ATTEMPTS", 8):
+		update["status"] = utils.DELIVERY_DEAD
+		update["nextattemptat"] = int64(0)
+	default:
+		update["nextattemptat"] = time.Now().Add(webhookRetryDelay(attempts)).Unix()
+	}
+
+	_, err := deliveryCollection.UpdateOne(ctx, bson.M{"id": delivery.ID}, bson.M{
+		"$set":  update,
+		"$inc":  bson.M{"retrycount": 1},
+		"$push": bson.M{"attempts": attempt},
+	})
+	return err
+}
@@ -0,0 +1,20 @@
+package notifiers
+
+import (
+	"context"
+)
+
+// ChannelNotifier routes every message to the adapter of its channel, messages without a channel
+// or on a channel without an adapter go to Default
+type ChannelNotifier struct {
+	Channels map[string]Notifier
+	Default  Notifier
+}
+
+// Send ...
+func (n *ChannelNotifier) Send(ctx context.Context, msg Message) error {
+	if adapter, ok := n.Channels[msg.Channel]; ok {
+		return adapter.Send(ctx, msg)
+	}
+	return n.Default.Send(ctx, msg)
+}
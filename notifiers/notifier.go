@@ -0,0 +1,104 @@
+package notifiers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/utils"
+)
+
+// Channels a message can be sent on
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+	ChannelPush  = "push"
+)
+
+// Message is a single notification addressed to a user. Without a Channel or on ChannelPush, To is the username
+// and the notifier reaches the user its own way, otherwise To is the email address or the phone number to send to
+type Message struct {
+	To      string `json:"to"`
+	Channel string `json:"channel,omitempty"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Notifier delivers messages to users. Production deployments plug in their own implementation with SetNotifier
+type Notifier interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// LogNotifier is meant for local development: it appends every message as a JSON line to Path,
+// or prints it to the standard logger when Path is empty
+type LogNotifier struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// Send ...
+func (n *LogNotifier) Send(ctx context.Context, msg Message) error {
+	if n.Path == "" {
+		log.Printf("notification to %s %s: %s\n%s", msg.Channel, msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+
+	line, err := json.Marshal(struct {
+		Message
+		SentAt int64 `json:"sent_at"`
+	}{msg, time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	file, err := os.OpenFile(n.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("notifier: %w", err)
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+var notifier Notifier
+var notifierOnce sync.Once
+
+// GetNotifier returns the notifier set with SetNotifier, or the one configured from the environment: emails go
+// through SMTP when SMTP_ADDR is set, SMS, push and everything else are written by a LogNotifier until a deployment
+// plugs in its own adapters
+func GetNotifier() Notifier {
+	//The environment is read on first use, once the .env file is loaded
+	notifierOnce.Do(func() {
+		logNotifier := &LogNotifier{Path: os.Getenv("NOTIFIER_LOG_PATH")}
+
+		var email Notifier = logNotifier
+		if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+			email = &SMTPNotifier{
+				Addr:     addr,
+				From:     utils.GetEnvString("SMTP_FROM", "no-reply@localhost"),
+				Username: os.Getenv("SMTP_USERNAME"),
+				Password: os.Getenv("SMTP_PASSWORD"),
+			}
+		}
+
+		notifier = &ChannelNotifier{
+			Channels: map[string]Notifier{ChannelEmail: email, ChannelSMS: logNotifier, ChannelPush: logNotifier},
+			Default:  logNotifier,
+		}
+	})
+	return notifier
+}
+
+// SetNotifier replaces the notifier used by the models
+func SetNotifier(n Notifier) {
+	notifierOnce.Do(func() {})
+	notifier = n
+}
@@ -0,0 +1,49 @@
+package notifiers
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPNotifier sends emails through an SMTP server. It authenticates with PLAIN when Username is set,
+// which net/smtp only allows over TLS or to localhost
+type SMTPNotifier struct {
+	Addr     string //host:port
+	From     string
+	Username string
+	Password string
+}
+
+// Send ...
+func (n *SMTPNotifier) Send(ctx context.Context, msg Message) error {
+	//Header values come from user data, a line break would let them add headers of their own
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return errors.New("notifier: invalid email header")
+	}
+
+	var auth smtp.Auth
+	if n.Username != "" {
+		host, _, err := net.SplitHostPort(n.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", n.Username, n.Password, host)
+	}
+
+	var body strings.Builder
+	body.WriteString("From: " + n.From + "\r\n")
+	body.WriteString("To: " + msg.To + "\r\n")
+	body.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", msg.Subject) + "\r\n")
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	body.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	body.WriteString("\r\n")
+	body.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	//net/smtp takes no context, ctx is not enforced while talking to the server
+	return smtp.SendMail(n.Addr, auth, n.From, []string{msg.To}, []byte(body.String()))
+}
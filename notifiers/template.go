@@ -0,0 +1,137 @@
+package notifiers
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// DefaultLocale is used for the users without a locale, or with one there are no templates for
+const DefaultLocale = "en"
+
+// Template is the text of a notification in one language. Emails get Subject and Body,
+// SMS and push get Short. They are text/template sources executed with the data of the notification
+type Template struct {
+	Subject string
+	Body    string
+	Short   string
+}
+
+// languages there are templates for
+var languages = map[string]bool{"en": true, "fr": true, "es": true}
+
+// templates of every notification type, by language
+var templates = map[string]map[string]Template{
+	"transfer_received": {
+		"en": {
+			Subject: "You received {{.amount}} from {{.from}}",
+			Body:    "Hi {{.name}},\n\n{{.from}} sent you {{.amount}}.{{if .memo}}\nMemo: {{.memo}}{{end}}\n\nThe money is already in your balance.",
+			Short:   "You received {{.amount}} from {{.from}}.",
+		},
+		"fr": {
+			Subject: "Vous avez reçu {{.amount}} de {{.from}}",
+			Body:    "Bonjour {{.name}},\n\n{{.from}} vous a envoyé {{.amount}}.{{if .memo}}\nMessage : {{.memo}}{{end}}\n\nL'argent est déjà sur votre solde.",
+			Short:   "Vous avez reçu {{.amount}} de {{.from}}.",
+		},
+		"es": {
+			Subject: "Has recibido {{.amount}} de {{.from}}",
+			Body:    "Hola {{.name}},\n\n{{.from}} te ha enviado {{.amount}}.{{if .memo}}\nConcepto: {{.memo}}{{end}}\n\nEl dinero ya está en tu saldo.",
+			Short:   "Has recibido {{.amount}} de {{.from}}.",
+		},
+	},
+	"withdrawal_completed": {
+		"en": {
+			Subject: "Your withdrawal of {{.amount}} is complete",
+			Body:    "Hi {{.name}},\n\nYour withdrawal of {{.amount}} to the bank account ending in {{.last4}} is complete. Depending on your bank it may take a little while to show on your statement.",
+			Short:   "Your withdrawal of {{.amount}} to the account ending in {{.last4}} is complete.",
+		},
+		"fr": {
+			Subject: "Votre retrait de {{.amount}} est effectué",
+			Body:    "Bonjour {{.name}},\n\nVotre retrait de {{.amount}} vers le compte bancaire se terminant par {{.last4}} est effectué. Selon votre banque, il peut mettre un peu de temps à apparaître sur votre relevé.",
+			Short:   "Votre retrait de {{.amount}} vers le compte se terminant par {{.last4}} est effectué.",
+		},
+		"es": {
+			Subject: "Tu retirada de {{.amount}} se ha completado",
+			Body:    "Hola {{.name}},\n\nTu retirada de {{.amount}} a la cuenta bancaria terminada en {{.last4}} se ha completado. Según tu banco, puede tardar un poco en aparecer en tu extracto.",
+			Short:   "Tu retirada de {{.amount}} a la cuenta terminada en {{.last4}} se ha completado.",
+		},
+	},
+	"new_device": {
+		"en": {
+			Subject: "New sign-in to your account",
+			Body:    "Hi {{.name}},\n\nYour account was signed in to from a new device:\n\nDevice: {{.device}}\nIP address: {{.ip}}\nTime: {{.time}}\n\nIf this was you, there is nothing to do. Otherwise change your password and sign the device out from your sessions.",
+			Short:   "New sign-in to your account from {{.device}}. Not you? Change your password.",
+		},
+		"fr": {
+			Subject: "Nouvelle connexion à votre compte",
+			Body:    "Bonjour {{.name}},\n\nVotre compte a été utilisé depuis un nouvel appareil :\n\nAppareil : {{.device}}\nAdresse IP : {{.ip}}\nDate : {{.time}}\n\nSi c'était vous, il n'y a rien à faire. Sinon, changez votre mot de passe et déconnectez l'appareil depuis vos sessions.",
+			Short:   "Nouvelle connexion à votre compte depuis {{.device}}. Ce n'était pas vous ? Changez votre mot de passe.",
+		},
+		"es": {
+			Subject: "Nuevo inicio de sesión en tu cuenta",
+			Body:    "Hola {{.name}},\n\nSe ha iniciado sesión en tu cuenta desde un dispositivo nuevo:\n\nDispositivo: {{.device}}\nDirección IP: {{.ip}}\nFecha: {{.time}}\n\nSi has sido tú, no tienes que hacer nada. Si no, cambia tu contraseña y cierra la sesión del dispositivo desde tus sesiones.",
+			Short:   "Nuevo inicio de sesión en tu cuenta desde {{.device}}. ¿No has sido tú? Cambia tu contraseña.",
+		},
+	},
+}
+
+var parsed = map[string]*template.Template{}
+var parsedMu sync.Mutex
+
+// parse returns the parsed template source, missing data renders as an empty string
+func parse(source string) (*template.Template, error) {
+	parsedMu.Lock()
+	defer parsedMu.Unlock()
+
+	if t, ok := parsed[source]; ok {
+		return t, nil
+	}
+	t, err := template.New("").Option("missingkey=zero").Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	parsed[source] = t
+	return t, nil
+}
+
+// Language returns the language of locale there are templates for: "fr-CA" falls back to "fr",
+// and unknown languages to DefaultLocale
+func Language(locale string) string {
+	language := strings.ToLower(strings.SplitN(strings.Replace(locale, "_", "-", 1), "-", 2)[0])
+	if languages[language] {
+		return language
+	}
+	return DefaultLocale
+}
+
+// Render returns the subject and the body of a notification of type kind for the channel,
+// in the language of locale
+func Render(kind string, locale string, channel string, data map[string]string) (subject string, body string, err error) {
+	localized, ok := templates[kind]
+	if !ok {
+		return "", "", fmt.Errorf("notifier: no template for %q", kind)
+	}
+	tmpl := localized[Language(locale)]
+
+	sources := []*string{&subject, &body}
+	if channel == ChannelEmail {
+		subject, body = tmpl.Subject, tmpl.Body
+	} else {
+		subject, body = tmpl.Subject, tmpl.Short
+	}
+
+	for _, source := range sources {
+		t, err := parse(*source)
+		if err != nil {
+			return "", "", err
+		}
+		var out strings.Builder
+		if err = t.Execute(&out, data); err != nil {
+			return "", "", err
+		}
+		*source = out.String()
+	}
+
+	return subject, body, nil
+}
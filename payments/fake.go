@@ -0,0 +1,225 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeProvider is the Provider of local development and tests, it talks to a FakeServer at BaseURL
+type FakeProvider struct {
+	BaseURL   string
+	Secret    string
+	Tolerance time.Duration
+	Client    *http.Client
+}
+
+// Name ...
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+// CreateCheckout ...
+func (p *FakeProvider) CreateCheckout(ctx context.Context, checkout Checkout) (session Session, err error) {
+	body, err := json.Marshal(checkout)
+	if err != nil {
+		return session, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(p.BaseURL, "/")+"/checkouts", bytes.NewReader(body))
+	if err != nil {
+		return session, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return session, fmt.Errorf("payments: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return session, fmt.Errorf("payments: checkout failed with status %d", resp.StatusCode)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&session)
+	return session, err
+}
+
+// VerifyCallback ...
+func (p *FakeProvider) VerifyCallback(header http.Header, body []byte) (callback Callback, err error) {
+	if err = Verify(p.Secret, header.Get(SignatureHeader), body, p.Tolerance); err != nil {
+		return callback, err
+	}
+
+	err = json.Unmarshal(body, &callback)
+	if err != nil || callback.ID == "" || callback.Reference == "" {
+		return callback, fmt.Errorf("payments: invalid callback")
+	}
+
+	return callback, nil
+}
+
+// FakeServer is a payment provider for local development and tests: it creates checkouts, shows a page to pay
+// or decline them, and sends signed callbacks. It keeps everything in memory
+type FakeServer struct {
+	// BaseURL is where the server is reachable, it prefixes the checkout URLs
+	BaseURL string
+	Secret  string
+	Client  *http.Client
+
+	mu        sync.Mutex
+	checkouts map[string]*fakeCheckout
+}
+
+type fakeCheckout struct {
+	Checkout
+	ID     string
+	Status string
+}
+
+var fakeCheckoutPage = template.Must(template.New("checkout").Parse(`<!DOCTYPE html>
+<html><body>
+<h1>Fake checkout</h1>
+<p>{{.Username}} tops up {{.Amount}}, reference {{.Reference}}. Status: {{if .Status}}{{.Status}}{{else}}PENDING{{end}}</p>
+<form method="post" action="/checkouts/{{.ID}}/succeed"><button>Pay</button></form>
+<form method="post" action="/checkouts/{{.ID}}/fail"><button>Decline</button></form>
+</body></html>`))
+
+func fakeID(prefix string) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
+
+// ServeHTTP routes:
+//
+//	POST /checkouts                           creates a checkout from a Checkout body
+//	GET  /checkouts/{id}                      shows the checkout page
+//	POST /checkouts/{id}/succeed or /fail     completes the checkout and sends its callback
+//
+// Completing a checkout again sends its callback again with the same ID, like a provider retrying
+func (s *FakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 0 || parts[0] != "checkouts" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		s.create(w, r)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		checkout, ok := s.find(parts[1])
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fakeCheckoutPage.Execute(w, checkout)
+	case len(parts) == 3 && r.Method == http.MethodPost && (parts[2] == "succeed" || parts[2] == "fail"):
+		status := StatusSucceeded
+		if parts[2] == "fail" {
+			status = StatusFailed
+		}
+		s.complete(w, r, parts[1], status)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *FakeServer) find(id string) (checkout fakeCheckout, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if found := s.checkouts[id]; found != nil {
+		return *found, true
+	}
+	return checkout, false
+}
+
+func (s *FakeServer) create(w http.ResponseWriter, r *http.Request) {
+	var checkout Checkout
+	if err := json.NewDecoder(r.Body).Decode(&checkout); err != nil || checkout.Reference == "" || checkout.Amount <= 0 || checkout.CallbackURL == "" {
+		http.Error(w, "invalid checkout", http.StatusBadRequest)
+		return
+	}
+
+	id := fakeID("chk_")
+	s.mu.Lock()
+	if s.checkouts == nil {
+		s.checkouts = make(map[string]*fakeCheckout)
+	}
+	s.checkouts[id] = &fakeCheckout{Checkout: checkout, ID: id}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(Session{ID: id, URL: strings.TrimRight(s.BaseURL, "/") + "/checkouts/" + id})
+}
+
+func (s *FakeServer) complete(w http.ResponseWriter, r *http.Request, id string, status string) {
+	s.mu.Lock()
+	found := s.checkouts[id]
+	if found != nil && found.Status == "" {
+		found.Status = status
+	}
+	var checkout fakeCheckout
+	if found != nil {
+		checkout = *found
+	}
+	s.mu.Unlock()
+	if found == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	//The event ID derives from the checkout so a resent callback is recognised as the same delivery
+	body, _ := json.Marshal(Callback{
+		ID:         "evt_" + checkout.ID,
+		CheckoutID: checkout.ID,
+		Reference:  checkout.Reference,
+		Amount:     checkout.Amount,
+		Status:     checkout.Status,
+		CreatedAt:  time.Now().Unix(),
+	})
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, checkout.CallbackURL, bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(s.Secret, time.Now().Unix(), body))
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("fake provider: callback of %s failed: %v", checkout.ID, err)
+		http.Error(w, "callback failed, complete the checkout again to retry", http.StatusBadGateway)
+		return
+	}
+	resp.Body.Close()
+	log.Printf("fake provider: callback of %s answered %d", checkout.ID, resp.StatusCode)
+
+	if checkout.ReturnURL != "" {
+		http.Redirect(w, r, checkout.ReturnURL, http.StatusSeeOther)
+		return
+	}
+	fmt.Fprintf(w, "checkout %s is %s, the callback answered %d\n", checkout.ID, checkout.Status, resp.StatusCode)
+}
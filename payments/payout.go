@@ -0,0 +1,165 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/utils"
+)
+
+// Statuses of a payout at the provider
+const (
+	PayoutSubmitted = "SUBMITTED"
+	PayoutSettled   = "SETTLED"
+	PayoutFailed    = "FAILED"
+	PayoutReturned  = "RETURNED"
+)
+
+// ErrUnknownPayout is returned when the provider has no payout with the given ID
+var ErrUnknownPayout = errors.New("unknown payout")
+
+// PayoutRejectedError is returned by SubmitPayout when the provider refuses the order for good. Submitting it
+// again can not succeed, the payout fails. Any other error of SubmitPayout is transient
+type PayoutRejectedError struct {
+	Reason string
+}
+
+func (e *PayoutRejectedError) Error() string {
+	return "payments: payout rejected: " + e.Reason
+}
+
+// PayoutOrder asks a provider to send Amount to a bank account. Reference is our payout ID,
+// providers use it to recognise an order submitted twice
+type PayoutOrder struct {
+	Reference     string `json:"reference"`
+	Amount        int64  `json:"amount"`
+	HolderName    string `json:"holder_name"`
+	BankCode      string `json:"bank_code"`
+	AccountNumber string `json:"account_number"`
+}
+
+// PayoutUpdate is the status of a payout at the provider, Reason explains failures and returns
+type PayoutUpdate struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// PayoutProvider sends withdrawals to bank accounts. Settlement is asynchronous: the status of a payout is polled
+// with PayoutStatus or pushed by the provider in signed callbacks
+type PayoutProvider interface {
+	Name() string
+	// SubmitPayout returns a *PayoutRejectedError when the order is refused for good
+	SubmitPayout(ctx context.Context, order PayoutOrder) (id string, err error)
+	PayoutStatus(ctx context.Context, id string) (PayoutUpdate, error)
+	// VerifyCallback authenticates a callback request and returns what it reports
+	VerifyCallback(header http.Header, body []byte) (PayoutUpdate, error)
+}
+
+// PayoutSimulator is the PayoutProvider of local development and tests. It keeps no state: the outcome and the
+// submission time are encoded in the payout ID, so payouts survive restarts. Payouts settle after Delay, except
+// for account numbers ending in "13", which fail, in "99", which settle and are returned after another Delay,
+// and in "14", which are rejected at submission
+type PayoutSimulator struct {
+	Delay     time.Duration
+	Secret    string
+	Tolerance time.Duration
+}
+
+// Name ...
+func (s *PayoutSimulator) Name() string {
+	return "simulator"
+}
+
+// SubmitPayout ...
+func (s *PayoutSimulator) SubmitPayout(ctx context.Context, order PayoutOrder) (string, error) {
+	if order.Amount <= 0 || order.AccountNumber == "" || order.Reference == "" {
+		return "", &PayoutRejectedError{Reason: "invalid payout order"}
+	}
+	if strings.HasSuffix(order.AccountNumber, "14") {
+		return "", &PayoutRejectedError{Reason: "the bank account does not exist"}
+	}
+
+	outcome := "settle"
+	switch {
+	case strings.HasSuffix(order.AccountNumber, "13"):
+		outcome = "fail"
+	case strings.HasSuffix(order.AccountNumber, "99"):
+		outcome = "return"
+	}
+
+	return fmt.Sprintf("sim_%s_%d_%s", outcome, time.Now().Unix(), order.Reference), nil
+}
+
+// PayoutStatus ...
+func (s *PayoutSimulator) PayoutStatus(ctx context.Context, id string) (update PayoutUpdate, err error) {
+	parts := strings.SplitN(id, "_", 4)
+	if len(parts) != 4 || parts[0] != "sim" {
+		return update, ErrUnknownPayout
+	}
+	submittedAt, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return update, ErrUnknownPayout
+	}
+
+	update.ID = id
+	elapsed := time.Now().Sub(time.Unix(submittedAt, 0))
+	switch {
+	case elapsed < s.Delay:
+		update.Status = PayoutSubmitted
+	case parts[1] == "fail":
+		update.Status = PayoutFailed
+		update.Reason = "the bank account is closed"
+	case parts[1] == "return" && elapsed >= 2*s.Delay:
+		update.Status = PayoutReturned
+		update.Reason = "returned by the bank of the beneficiary"
+	default:
+		update.Status = PayoutSettled
+	}
+
+	return update, nil
+}
+
+// VerifyCallback ...
+func (s *PayoutSimulator) VerifyCallback(header http.Header, body []byte) (update PayoutUpdate, err error) {
+	if err = Verify(s.Secret, header.Get(SignatureHeader), body, s.Tolerance); err != nil {
+		return update, err
+	}
+
+	err = json.Unmarshal(body, &update)
+	if err != nil || update.ID == "" || update.Status == "" {
+		return update, fmt.Errorf("payments: invalid callback")
+	}
+
+	return update, nil
+}
+
+var payoutProvider PayoutProvider
+var payoutProviderOnce sync.Once
+
+// GetPayoutProvider returns the provider set with SetPayoutProvider, by default a PayoutSimulator configured from the environment
+func GetPayoutProvider() PayoutProvider {
+	payoutProviderOnce.Do(func() {
+		if payoutProvider == nil {
+			payoutProvider = &PayoutSimulator{
+				Delay:     utils.GetEnvDuration("PAYOUT_SIMULATOR_DELAY", 30*time.Second),
+				Secret:    os.Getenv("PAYOUT_PROVIDER_SECRET"),
+				Tolerance: utils.GetEnvDuration("PAYMENT_CALLBACK_TOLERANCE", 5*time.Minute),
+			}
+		}
+	})
+	return payoutProvider
+}
+
+// SetPayoutProvider replaces the payout provider used by the models
+func SetPayoutProvider(p PayoutProvider) {
+	payoutProvider = p
+}
@@ -0,0 +1,151 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Massad/gin-boilerplate/utils"
+)
+
+// Statuses a provider reports in its callbacks
+const (
+	StatusSucceeded = "SUCCEEDED"
+	StatusFailed    = "FAILED"
+)
+
+// SignatureHeader carries the signature of a callback: "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">"
+const SignatureHeader = "X-Provider-Signature"
+
+// ErrInvalidSignature is returned for callbacks that are unsigned, tampered with or too old to be trusted
+var ErrInvalidSignature = errors.New("invalid callback signature")
+
+// Checkout asks a provider to collect Amount for the top-up Reference. The provider reports the outcome to CallbackURL
+// and sends the user back to ReturnURL
+type Checkout struct {
+	Reference   string `json:"reference"`
+	Amount      int64  `json:"amount"`
+	Username    string `json:"username"`
+	CallbackURL string `json:"callback_url"`
+	ReturnURL   string `json:"return_url,omitempty"`
+}
+
+// Session is the checkout created by the provider, the user pays at URL
+type Session struct {
+	ID  string `json:"id"`
+	URL string `json:"checkout_url"`
+}
+
+// Callback is the outcome of a checkout. ID identifies the delivery: a provider retrying a callback sends the same ID
+type Callback struct {
+	ID         string `json:"id"`
+	CheckoutID string `json:"checkout_id"`
+	Reference  string `json:"reference"`
+	Amount     int64  `json:"amount"`
+	Status     string `json:"status"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+// Provider collects top-ups. Production deployments plug in their own implementation with SetProvider
+type Provider interface {
+	Name() string
+	CreateCheckout(ctx context.Context, checkout Checkout) (Session, error)
+	// VerifyCallback authenticates a callback request and returns what it reports
+	VerifyCallback(header http.Header, body []byte) (Callback, error)
+}
+
+// Sign returns the SignatureHeader value of body sent at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(signature(secret, timestamp, body)))
+}
+
+func signature(secret string, timestamp int64, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// Verify checks a SignatureHeader value against body. Signatures older than tolerance are rejected,
+// which bounds how long a captured callback can be replayed
+func Verify(secret string, header string, body []byte, tolerance time.Duration) error {
+	if secret == "" {
+		return ErrInvalidSignature
+	}
+
+	var timestamp int64
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp, _ = strconv.ParseInt(kv[1], 10, 64)
+		case "v1":
+			if value, err := hex.DecodeString(kv[1]); err == nil {
+				signatures = append(signatures, value)
+			}
+		}
+	}
+
+	age := time.Since(time.Unix(timestamp, 0))
+	if timestamp == 0 || age > tolerance || age < -tolerance {
+		return ErrInvalidSignature
+	}
+
+	expected := signature(secret, timestamp, body)
+	for _, value := range signatures {
+		if hmac.Equal(value, expected) {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+var provider Provider
+var providerOnce sync.Once
+
+// GetProvider returns the provider set with SetProvider, by default a FakeProvider configured from the environment
+func GetProvider() Provider {
+	//The environment is read on first use, once the .env file is loaded
+	providerOnce.Do(func() {
+		if provider == nil {
+			provider = &FakeProvider{
+				BaseURL:   utils.GetEnvString("PAYMENT_PROVIDER_URL", "http://localhost:9100"),
+				Secret:    os.Getenv("PAYMENT_PROVIDER_SECRET"),
+				Tolerance: utils.GetEnvDuration("PAYMENT_CALLBACK_TOLERANCE", 5*time.Minute),
+			}
+		}
+	})
+	return provider
+}
+
+// SetProvider replaces the provider used by the models
+func SetProvider(p Provider) {
+	provider = p
+}
+
+// CheckProduction returns an error while the providers are the ones of development, the FakeProvider or the
+// PayoutSimulator. Production sets its providers with SetProvider and SetPayoutProvider before it starts
+func CheckProduction() error {
+	if _, ok := GetProvider().(*FakeProvider); ok {
+		return errors.New("payments: the fake provider can not be used in production")
+	}
+	if _, ok := GetPayoutProvider().(*PayoutSimulator); ok {
+		return errors.New("payments: the payout simulator can not be used in production")
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+//go:build all
+// +build all
+
+package payments
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"id":"cs_1","status":"SUCCEEDED"}`)
+	now := time.Now().Unix()
+	timestamp := "t=" + strconv.FormatInt(now, 10)
+	signature := strings.TrimPrefix(Sign("secret", now, body), timestamp+",")
+
+	tests := []struct {
+		name      string
+		secret    string
+		header    string
+		body      []byte
+		tolerance time.Duration
+		valid     bool
+	}{
+		{"valid", "secret", Sign("secret", now, body), body, 5 * time.Minute, true},
+		{"inside the tolerance", "secret", Sign("secret", now-60, body), body, 5 * time.Minute, true},
+		{"too old", "secret", Sign("secret", now-600, body), body, 5 * time.Minute, false},
+		{"from the future", "secret", Sign("secret", now+600, body), body, 5 * time.Minute, false},
+		{"tampered body", "secret", Sign("secret", now, body), []byte(`{"id":"cs_1","status":"FAILED"}`), 5 * time.Minute, false},
+		{"tampered timestamp", "secret", "t=" + strconv.FormatInt(now-1, 10) + "," + signature, body, 5 * time.Minute, false},
+		{"other secret", "secret", Sign("other", now, body), body, 5 * time.Minute, false},
+		{"empty secret", "", Sign("", now, body), body, 5 * time.Minute, false},
+		{"no signature", "secret", timestamp, body, 5 * time.Minute, false},
+		{"no timestamp", "secret", signature, body, 5 * time.Minute, false},
+		{"malformed", "secret", "garbage", body, 5 * time.Minute, false},
+		{"one of several signatures", "secret", Sign("secret", now, body) + ",v1=00", body, 5 * time.Minute, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Verify(test.secret, test.header, test.body, test.tolerance)
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, ErrInvalidSignature, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/Massad/gin-boilerplate/utils"
+)
+
+// BlobStore keeps files, like the identity documents of users, under keys such as "kyc/<user>/<document>"
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// ErrBlobNotFound is returned by Get for unknown keys
+var ErrBlobNotFound = errors.New("file not found")
+
+// ErrInvalidKey is returned for keys that are empty or leave the store, e.g. with ".."
+var ErrInvalidKey = errors.New("invalid file key")
+
+var store BlobStore
+var storeOnce sync.Once
+
+// GetBlobStore returns the store set with SetBlobStore, by default a LocalStore in BLOB_STORE_DIR
+func GetBlobStore() BlobStore {
+	//The environment is read on first use, once the .env file is loaded
+	storeOnce.Do(func() {
+		if store == nil {
+			store = &LocalStore{Root: utils.GetEnvString("BLOB_STORE_DIR", "./data/blobs")}
+		}
+	})
+	return store
+}
+
+// SetBlobStore replaces the blob store, e.g. with a cloud storage one
+func SetBlobStore(s BlobStore) {
+	store = s
+}
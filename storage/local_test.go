@@ -0,0 +1,42 @@
+//go:build all
+// +build all
+
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalStorePath(t *testing.T) {
+	store := &LocalStore{Root: "/data/blobs"}
+
+	tests := []struct {
+		key  string
+		path string
+		err  error
+	}{
+		{"kyc/1/passport.jpg", filepath.FromSlash("/data/blobs/kyc/1/passport.jpg"), nil},
+		{"export.zip", filepath.FromSlash("/data/blobs/export.zip"), nil},
+		{"", "", ErrInvalidKey},
+		{"/", "", ErrInvalidKey},
+		{"../etc/passwd", "", ErrInvalidKey},
+		{"kyc/../../etc/passwd", "", ErrInvalidKey},
+		{"kyc/../export.zip", "", ErrInvalidKey},
+		{"/etc/passwd", "", ErrInvalidKey},
+		{"kyc//passport.jpg", "", ErrInvalidKey},
+		{"kyc/./passport.jpg", "", ErrInvalidKey},
+		{"kyc/", "", ErrInvalidKey},
+		{"..\\etc\\passwd", "", ErrInvalidKey},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			path, err := store.path(test.key)
+			assert.Equal(t, test.err, err)
+			assert.Equal(t, test.path, path)
+		})
+	}
+}
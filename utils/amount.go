@@ -0,0 +1,22 @@
+package utils
+
+import "math"
+
+// MulAmount returns a * b for non-negative amounts, ok is false when the result does not fit in an int64
+func MulAmount(a int64, b int64) (result int64, ok bool) {
+	if a < 0 || b < 0 {
+		return 0, false
+	}
+	if a != 0 && b > math.MaxInt64/a {
+		return 0, false
+	}
+	return a * b, true
+}
+
+// AddAmount returns a + b for non-negative amounts, ok is false when the result does not fit in an int64
+func AddAmount(a int64, b int64) (result int64, ok bool) {
+	if a < 0 || b < 0 || a > math.MaxInt64-b {
+		return 0, false
+	}
+	return a + b, true
+}
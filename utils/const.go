@@ -4,4 +4,179 @@ const (
 	TOP_UP = "TOP_UP"
 	WITHDRAW = "WITHDRAW"
 	TRANSFER = "TRANSFER"
-)
\ No newline at end of file
+	INVOICE_PAYMENT = "INVOICE_PAYMENT"
+	INVOICE_REFUND = "INVOICE_REFUND"
+	MERCHANT_PAYOUT = "MERCHANT_PAYOUT"
+	POCKET_DEPOSIT = "POCKET_DEPOSIT"
+	POCKET_WITHDRAW = "POCKET_WITHDRAW"
+	INTEREST = "INTEREST"
+	VOUCHER = "VOUCHER"
+	CASHBACK = "CASHBACK"
+	WITHDRAW_REVERSAL = "WITHDRAW_REVERSAL"
+	CASHBACK_REVERSAL = "CASHBACK_REVERSAL"
+)
+
+// Wallet events written to the outbox, see models/outbox.go
+const (
+	EVENT_TOP_UP = "wallet.top_up"
+	EVENT_WITHDRAW = "wallet.withdraw"
+	EVENT_TRANSFER = "wallet.transfer"
+	EVENT_INVOICE_PAID = "invoice.paid"
+	EVENT_INVOICE_REFUNDED = "invoice.refunded"
+	EVENT_MERCHANT_PAYOUT = "merchant.payout"
+	EVENT_INTEREST = "wallet.interest"
+	EVENT_REWARD = "wallet.reward"
+	EVENT_WITHDRAW_REVERSED = "wallet.withdraw_reversed"
+	EVENT_WITHDRAW_SETTLED = "wallet.withdraw_settled"
+	EVENT_REWARD_REVERSED = "wallet.reward_reversed"
+)
+
+var EVENTS = []string{EVENT_TOP_UP, EVENT_WITHDRAW, EVENT_TRANSFER, EVENT_INVOICE_PAID, EVENT_INVOICE_REFUNDED, EVENT_MERCHANT_PAYOUT, EVENT_INTEREST, EVENT_REWARD, EVENT_WITHDRAW_REVERSED, EVENT_WITHDRAW_SETTLED, EVENT_REWARD_REVERSED}
+
+// Webhook delivery statuses, DELIVERY_DEAD is the dead letter state after the last failed retry
+const (
+	DELIVERY_PENDING = "PENDING"
+	DELIVERY_SUCCEEDED = "SUCCEEDED"
+	DELIVERY_DEAD = "DEAD"
+)
+
+// Payment request statuses
+const (
+	REQUEST_PENDING = "PENDING"
+	REQUEST_ACCEPTED = "ACCEPTED"
+	REQUEST_DECLINED = "DECLINED"
+	REQUEST_CANCELLED = "CANCELLED"
+	REQUEST_EXPIRED = "EXPIRED"
+)
+
+// Top-up statuses, a top-up is PENDING until the payment provider confirms or declines its checkout
+const (
+	TOP_UP_PENDING = "PENDING"
+	TOP_UP_SUCCEEDED = "SUCCEEDED"
+	TOP_UP_FAILED = "FAILED"
+)
+
+// Payout statuses, the money of a withdrawal is held from PENDING to SETTLED and given back when it is FAILED or RETURNED
+const (
+	PAYOUT_PENDING = "PENDING"
+	PAYOUT_SUBMITTED = "SUBMITTED"
+	PAYOUT_SETTLED = "SETTLED"
+	PAYOUT_FAILED = "FAILED"
+	PAYOUT_RETURNED = "RETURNED"
+)
+
+// Invoice statuses
+const (
+	INVOICE_OPEN = "OPEN"
+	INVOICE_PAID = "PAID"
+	INVOICE_EXPIRED = "EXPIRED"
+	INVOICE_REFUNDED = "REFUNDED"
+)
+
+// Split bill modes and statuses, a share is PAID or has the status of its payment request
+const (
+	SPLIT_EQUAL = "EQUAL"
+	SPLIT_PERCENTAGE = "PERCENTAGE"
+	SPLIT_EXACT = "EXACT"
+	BILL_OPEN = "OPEN"
+	BILL_SETTLED = "SETTLED"
+	BILL_CANCELLED = "CANCELLED"
+	SHARE_PAID = "PAID"
+)
+
+// Batch transfer modes: BEST_EFFORT runs every item on its own in the background,
+// ALL_OR_NOTHING commits the whole batch in one Mongo transaction
+const (
+	BATCH_BEST_EFFORT = "BEST_EFFORT"
+	BATCH_ALL_OR_NOTHING = "ALL_OR_NOTHING"
+)
+
+// Batch transfer statuses, the items of a batch are PENDING, SUCCEEDED or FAILED
+const (
+	BATCH_PENDING = "PENDING"
+	BATCH_PROCESSING = "PROCESSING"
+	BATCH_COMPLETED = "COMPLETED"
+	BATCH_FAILED = "FAILED"
+	BATCH_ITEM_PENDING = "PENDING"
+	BATCH_ITEM_SUCCEEDED = "SUCCEEDED"
+	BATCH_ITEM_FAILED = "FAILED"
+)
+
+// Scopes that API keys and OAuth clients can be granted, user logins have all of them
+const (
+	SCOPE_TRANSACTIONS_READ = "transactions:read"
+	SCOPE_TRANSFERS_WRITE = "transfers:write"
+	SCOPE_TOP_UPS_WRITE = "top-ups:write"
+	SCOPE_WITHDRAWALS_WRITE = "withdrawals:write"
+)
+
+var SCOPES = []string{SCOPE_TRANSACTIONS_READ, SCOPE_TRANSFERS_WRITE, SCOPE_TOP_UPS_WRITE, SCOPE_WITHDRAWALS_WRITE}
+
+// Transaction categories, every transaction gets one from its type and each party can pick another one
+const (
+	CATEGORY_TRANSFERS = "transfers"
+	CATEGORY_SHOPPING = "shopping"
+	CATEGORY_REFUNDS = "refunds"
+	CATEGORY_SALES = "sales"
+	CATEGORY_TOP_UPS = "top_ups"
+	CATEGORY_WITHDRAWALS = "withdrawals"
+	CATEGORY_SAVINGS = "savings"
+	CATEGORY_INTEREST = "interest"
+	CATEGORY_REWARDS = "rewards"
+	CATEGORY_FOOD = "food"
+	CATEGORY_TRANSPORT = "transport"
+	CATEGORY_BILLS = "bills"
+	CATEGORY_HOUSING = "housing"
+	CATEGORY_HEALTH = "health"
+	CATEGORY_ENTERTAINMENT = "entertainment"
+	CATEGORY_TRAVEL = "travel"
+	CATEGORY_GIFTS = "gifts"
+	CATEGORY_OTHER = "other"
+)
+
+var CATEGORIES = []string{CATEGORY_TRANSFERS, CATEGORY_SHOPPING, CATEGORY_REFUNDS, CATEGORY_SALES, CATEGORY_TOP_UPS, CATEGORY_WITHDRAWALS, CATEGORY_SAVINGS, CATEGORY_INTEREST, CATEGORY_REWARDS, CATEGORY_FOOD, CATEGORY_TRANSPORT, CATEGORY_BILLS, CATEGORY_HOUSING, CATEGORY_HEALTH, CATEGORY_ENTERTAINMENT, CATEGORY_TRAVEL, CATEGORY_GIFTS, CATEGORY_OTHER}
+
+// KYC levels, documents and review statuses. A user starts at KYC_NONE, every level needs the documents
+// of the level below it as well, and a review approves or rejects the documents sent for a level
+const (
+	KYC_NONE = 0
+	KYC_IDENTITY = 1
+	KYC_ADDRESS = 2
+	DOCUMENT_ID_FRONT = "id_front"
+	DOCUMENT_ID_BACK = "id_back"
+	DOCUMENT_SELFIE = "selfie"
+	DOCUMENT_PROOF_OF_ADDRESS = "proof_of_address"
+	KYC_PENDING = "PENDING"
+	KYC_APPROVED = "APPROVED"
+	KYC_REJECTED = "REJECTED"
+)
+
+// Data export and erasure statuses. An export is READY to download until it is EXPIRED and its file deleted,
+// an erasure is PENDING until every copy of the username is replaced by the pseudonym
+const (
+	EXPORT_PENDING = "PENDING"
+	EXPORT_PROCESSING = "PROCESSING"
+	EXPORT_READY = "READY"
+	EXPORT_FAILED = "FAILED"
+	EXPORT_EXPIRED = "EXPIRED"
+	ERASURE_PENDING = "PENDING"
+	ERASURE_COMPLETED = "COMPLETED"
+)
+
+// Notification types, channels and delivery statuses. A notification is PENDING until it is SENT,
+// or FAILED after its last retry
+const (
+	NOTIFICATION_TRANSFER_RECEIVED = "transfer_received"
+	NOTIFICATION_WITHDRAWAL_COMPLETED = "withdrawal_completed"
+	NOTIFICATION_NEW_DEVICE = "new_device"
+	NOTIFICATION_EMAIL = "email"
+	NOTIFICATION_SMS = "sms"
+	NOTIFICATION_PUSH = "push"
+	NOTIFICATION_PENDING = "PENDING"
+	NOTIFICATION_SENT = "SENT"
+	NOTIFICATION_FAILED = "FAILED"
+)
+
+var NOTIFICATION_TYPES = []string{NOTIFICATION_TRANSFER_RECEIVED, NOTIFICATION_WITHDRAWAL_COMPLETED, NOTIFICATION_NEW_DEVICE}
+
+var NOTIFICATION_CHANNELS = []string{NOTIFICATION_EMAIL, NOTIFICATION_SMS, NOTIFICATION_PUSH}
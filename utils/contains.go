@@ -0,0 +1,41 @@
+package utils
+
+// Contains ...
+func Contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope ...
+func HasScope(scopes []string, scope string) bool {
+	return Contains(scopes, scope)
+}
+
+// IsValidScope reports whether scope is one of SCOPES
+func IsValidScope(scope string) bool {
+	return Contains(SCOPES, scope)
+}
+
+// IsValidEvent reports whether event is one of EVENTS
+func IsValidEvent(event string) bool {
+	return Contains(EVENTS, event)
+}
+
+// IsValidCategory reports whether category is one of CATEGORIES
+func IsValidCategory(category string) bool {
+	return Contains(CATEGORIES, category)
+}
+
+// IsValidNotificationType reports whether notificationType is one of NOTIFICATION_TYPES
+func IsValidNotificationType(notificationType string) bool {
+	return Contains(NOTIFICATION_TYPES, notificationType)
+}
+
+// IsValidNotificationChannel reports whether channel is one of NOTIFICATION_CHANNELS
+func IsValidNotificationChannel(channel string) bool {
+	return Contains(NOTIFICATION_CHANNELS, channel)
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// GetEnvInt reads an integer from the environment, falling back to defaultValue when unset or invalid
+func GetEnvInt(name string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// GetEnvDuration reads a duration (e.g. "15m", "1h") from the environment, falling back to defaultValue when unset or invalid
+func GetEnvDuration(name string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// GetEnvString reads a string from the environment, falling back to defaultValue when unset
+func GetEnvString(name string, defaultValue string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+)
+
+// privateNetworks can't be reached by outgoing requests on behalf of users: loopback, private, shared (CGNAT),
+// link-local (cloud metadata endpoints), multicast and reserved ranges, in IPv4 and IPv6
+var privateNetworks = func() (networks []*net.IPNet) {
+	for _, cidr := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+		"192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+		"::/128", "::1/128", "64:ff9b::/96", "fc00::/7", "fe80::/10", "ff00::/8",
+	} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// IsPublicIP tells whether ip is an address of the internet, not one of the host or of a private network
+func IsPublicIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	//IPv4-mapped IPv6 addresses are checked as IPv4
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// PublicHostsOnly tells whether outgoing requests on behalf of users are restricted to public addresses.
+// Outside of production WEBHOOK_ALLOW_PRIVATE_HOSTS=true lifts it, to test against local endpoints
+func PublicHostsOnly() bool {
+	return os.Getenv("ENV") == "PRODUCTION" || os.Getenv("WEBHOOK_ALLOW_PRIVATE_HOSTS") != "true"
+}
+
+// CheckPublicHost resolves host and returns an error unless every address it resolves to is public
+func CheckPublicHost(ctx context.Context, host string) error {
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, address := range addresses {
+		if !IsPublicIP(address.IP) {
+			return fmt.Errorf("%s resolves to the non-public address %s", host, address.IP)
+		}
+	}
+	return nil
+}